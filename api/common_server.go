@@ -44,8 +44,10 @@ const (
 	ContentType_LCP_JSON  = "application/vnd.readium.lcp.license.v1.0+json"
 	ContentType_LSD_JSON  = "application/vnd.readium.license.status.v1.0+json"
 	ContentType_TEXT_HTML = "text/html"
+	ContentType_CSV       = "text/csv"
 
-	ContentType_JSON = "application/json"
+	ContentType_JSON             = "application/json"
+	ContentType_MERGE_PATCH_JSON = "application/merge-patch+json"
 
 	ContentType_FORM_URL_ENCODED = "application/x-www-form-urlencoded"
 )
@@ -74,6 +76,9 @@ func CreateServerRouter(tplPath string) ServerRouter {
 	//X-Add-Delay: 2.5s
 	n.Use(delay.Middleware{})
 
+	// tie the log lines and problem responses of a request to its X-Request-ID
+	n.Use(negroni.HandlerFunc(RequestID))
+
 	// possibly useful middlewares:
 	// https://github.com/jeffbmartinez/delay
 
@@ -104,7 +109,7 @@ func CreateServerRouter(tplPath string) ServerRouter {
 	c := cors.New(cors.Options{
 		AllowedOrigins: []string{"*"},
 		AllowedMethods: []string{"PATCH", "HEAD", "POST", "GET", "OPTIONS", "PUT", "DELETE"},
-		AllowedHeaders: []string{"Range", "Content-Type", "Origin", "X-Requested-With", "Accept", "Accept-Language", "Content-Language", "Authorization"},
+		AllowedHeaders: []string{"Range", "Content-Type", "Origin", "X-Requested-With", "Accept", "Accept-Language", "Content-Language", "Authorization", problem.HeaderRequestID},
 		Debug:          false,
 	})
 	n.Use(c)
@@ -119,6 +124,17 @@ func CreateServerRouter(tplPath string) ServerRouter {
 	return sr
 }
 
+// RequestID propagates the X-Request-ID header of a request, or generates one if it is missing or invalid,
+// stores it in the request context and sends it back in the response headers
+func RequestID(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	id := r.Header.Get(problem.HeaderRequestID)
+	if !problem.ValidRequestID(id) {
+		id = problem.NewRequestID()
+	}
+	rw.Header().Set(problem.HeaderRequestID, id)
+	next(rw, r.WithContext(problem.WithRequestID(r.Context(), id)))
+}
+
 func ExtraLogger(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
 
 	log.Print(" << -------------------")
@@ -76,6 +76,9 @@ type FrontendServerInfo struct {
 	RightCopy           int32  `yaml:"right_copy"`
 	MasterRepository    string `yaml:"master_repository"`
 	EncryptedRepository string `yaml:"encrypted_repository"`
+	MaxUploadSize       int64  `yaml:"max_upload_size,omitempty"`
+	// LogLevel is the minimum level of the lines logged by the handlers: debug, info (default), warn or error
+	LogLevel string `yaml:"log_level,omitempty"`
 }
 
 type Auth struct {
@@ -115,6 +118,7 @@ type LicenseStatus struct {
 	Return       bool   `yaml:"return"`
 	RentingDays  int    `yaml:"renting_days" "default 0"`
 	RenewDays    int    `yaml:"renew_days" "default 0"`
+	MaxRenewals  int    `yaml:"max_renewals"`
 	RenewPageUrl string `yaml:"renew_page_url,omitempty"`
 }
 
@@ -30,15 +30,22 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"fmt"
 	"io"
 )
 
-type cbcEncrypter struct{}
+type cbcEncrypter struct {
+	// chunkSize is the size of the blocks read, encrypted and written at once
+	chunkSize int
+}
 
 const (
 	aes256keyLength = 32 // 256 bits
 )
 
+// DefaultChunkSize is the size of the blocks in which a resource is encrypted with AES-CBC
+const DefaultChunkSize = 64 << 10
+
 func (e cbcEncrypter) Signature() string {
 	// W3C padding scheme, not PKCS#7 (see last parameter "insertPadLengthAll" [false] of PaddedReader constructor)
 	return "http://www.w3.org/2001/04/xmlenc#aes256-cbc"
@@ -51,7 +58,7 @@ func (e cbcEncrypter) GenerateKey() (ContentKey, error) {
 
 func (e cbcEncrypter) Encrypt(key ContentKey, r io.Reader, w io.Writer) error {
 
-	r = PaddedReader(r, aes.BlockSize, false)
+	padded := PaddedReader(r, aes.BlockSize, false).(*paddedReader)
 
 	block, err := aes.NewCipher(key)
 	if err != nil {
@@ -69,21 +76,36 @@ func (e cbcEncrypter) Encrypt(key ContentKey, r io.Reader, w io.Writer) error {
 		return err
 	}
 
+	// the padded plaintext is encrypted chunk by chunk, so that the memory used
+	// does not depend on the size of the resource
 	mode := cipher.NewCBCEncrypter(block, iv)
-	buffer := make([]byte, aes.BlockSize)
-	for _, err = io.ReadFull(r, buffer); err == nil; _, err = io.ReadFull(r, buffer) {
-		mode.CryptBlocks(buffer, buffer)
-		_, wErr := w.Write(buffer)
-		if wErr != nil {
-			return wErr
+	buffer := make([]byte, e.bufferSize())
+	for {
+		n, err := io.ReadFull(padded, buffer)
+		// the resource ends with a short chunk of whole blocks once the padding is added;
+		// any other error, including a truncated source, aborts the encryption
+		end := (err == io.EOF || err == io.ErrUnexpectedEOF) && padded.done && n%aes.BlockSize == 0
+		if err != nil && !end {
+			return err
+		}
+		if n > 0 {
+			mode.CryptBlocks(buffer[:n], buffer[:n])
+			if _, wErr := w.Write(buffer[:n]); wErr != nil {
+				return wErr
+			}
+		}
+		if end {
+			return nil
 		}
 	}
+}
 
-	if err == nil || err == io.EOF {
-		return nil
+// bufferSize returns the chunk size, DefaultChunkSize if it is not set
+func (e cbcEncrypter) bufferSize() int {
+	if e.chunkSize == 0 {
+		return DefaultChunkSize
 	}
-
-	return err
+	return e.chunkSize
 }
 
 func (c cbcEncrypter) Decrypt(key ContentKey, r io.Reader, w io.Writer) error {
@@ -108,5 +130,15 @@ func (c cbcEncrypter) Decrypt(key ContentKey, r io.Reader, w io.Writer) error {
 }
 
 func NewAESCBCEncrypter() Encrypter {
-	return cbcEncrypter(struct{}{})
+	return cbcEncrypter{}
+}
+
+// NewAESCBCEncrypterWithChunkSize returns an AES-CBC encrypter reading and encrypting resources
+// in blocks of chunkSize bytes; DefaultChunkSize is used if zero.
+// The chunk size must be a multiple of the AES block size.
+func NewAESCBCEncrypterWithChunkSize(chunkSize int) (Encrypter, error) {
+	if chunkSize < 0 || chunkSize%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("invalid chunk size %d, expected a multiple of %d", chunkSize, aes.BlockSize)
+	}
+	return cbcEncrypter{chunkSize: chunkSize}, nil
 }
@@ -29,6 +29,12 @@ import (
 	"bytes"
 	"crypto/aes"
 	"crypto/sha256"
+	"io"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
 )
 
@@ -95,6 +101,48 @@ func TestFailingReaderForEncryption(t *testing.T) {
 	}
 }
 
+// truncatedReader returns some bytes, then an error
+type truncatedReader struct {
+	data []byte
+	err  error
+}
+
+func (r *truncatedReader) Read(buf []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, r.err
+	}
+	n := copy(buf, r.data)
+	r.data = r.data[n:]
+	if len(r.data) == 0 {
+		return n, r.err
+	}
+	return n, nil
+}
+
+func TestTruncatedReaderForEncryption(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+		err  error
+	}{
+		{"unexpected EOF", 7, io.ErrUnexpectedEOF},
+		{"unexpected EOF on a block boundary", 32, io.ErrUnexpectedEOF},
+		{"read error", 5, io.ErrClosedPipe},
+	}
+	var key [32]byte
+	for _, test := range tests {
+		cbc, err := NewAESCBCEncrypterWithChunkSize(16)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var output bytes.Buffer
+		r := &truncatedReader{data: bytes.Repeat([]byte("x"), test.size), err: test.err}
+		if err := cbc.Encrypt(key[:], r, &output); err != test.err {
+			t.Errorf("%s: expected the error %v, got %v", test.name, test.err, err)
+		}
+	}
+}
+
 func TestDecrypt(t *testing.T) {
 	clear := bytes.NewBufferString("cleartext")
 	key := sha256.Sum256([]byte("password"))
@@ -117,6 +165,99 @@ func TestDecrypt(t *testing.T) {
 	}
 }
 
+func TestChunkSizes(t *testing.T) {
+	clear := bytes.Repeat([]byte("0123456789"), 10000)
+	key := sha256.Sum256([]byte("password"))
+
+	for _, chunkSize := range []int{0, aes.BlockSize, 4096, len(clear) - len(clear)%aes.BlockSize + aes.BlockSize} {
+		cbc, err := NewAESCBCEncrypterWithChunkSize(chunkSize)
+		if err != nil {
+			t.Fatalf("chunk size %d: %s", chunkSize, err)
+		}
+		var cipher bytes.Buffer
+		if err := cbc.Encrypt(key[:], bytes.NewReader(clear), &cipher); err != nil {
+			t.Fatalf("chunk size %d: %s", chunkSize, err)
+		}
+		if expected := aes.BlockSize + (len(clear)/aes.BlockSize+1)*aes.BlockSize; cipher.Len() != expected {
+			t.Errorf("chunk size %d: expected %d bytes, got %d", chunkSize, expected, cipher.Len())
+		}
+		var res bytes.Buffer
+		if err := cbc.(Decrypter).Decrypt(key[:], &cipher, &res); err != nil {
+			t.Fatalf("chunk size %d: %s", chunkSize, err)
+		}
+		if !bytes.Equal(res.Bytes(), clear) {
+			t.Errorf("chunk size %d: expected encryption-decryption to return the original", chunkSize)
+		}
+	}
+
+	// chunk sizes which are not a multiple of the block size are rejected
+	for _, chunkSize := range []int{-16, 1, 100} {
+		if _, err := NewAESCBCEncrypterWithChunkSize(chunkSize); err == nil {
+			t.Errorf("Expected the chunk size %d to be rejected", chunkSize)
+		}
+	}
+}
+
+// LargeTestsEnv is the environment variable which enables the tests on large files
+const LargeTestsEnv = "LCP_LARGE_TESTS"
+
+// TestLargeResourceMemory encrypts a 1GB sparse file and checks that the memory allocated
+// by the encryption does not depend on the size of the resource
+// It only runs if LargeTestsEnv is set, as it writes and reads a 1GB file.
+func TestLargeResourceMemory(t *testing.T) {
+	if testing.Short() || os.Getenv(LargeTestsEnv) == "" {
+		t.Skip("skipping the encryption of a 1GB file, set " + LargeTestsEnv + " to run it")
+	}
+	dir, err := ioutil.TempDir("", "lcp-chunks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "video.mp4")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err = file.Truncate(1 << 30); err != nil {
+		t.Fatal(err)
+	}
+
+	key := sha256.Sum256([]byte("password"))
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	if err = NewAESCBCEncrypter().Encrypt(key[:], file, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 4*DefaultChunkSize {
+		t.Errorf("Expected less than %d bytes to be allocated, got %d", 4*DefaultChunkSize, allocated)
+	}
+}
+
+func BenchmarkChunkedEncrypt(b *testing.B) {
+	clear := make([]byte, 16<<20)
+	key := sha256.Sum256([]byte("password"))
+	for _, chunkSize := range []int{aes.BlockSize, 4096, DefaultChunkSize} {
+		cbc, err := NewAESCBCEncrypterWithChunkSize(chunkSize)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(fmt.Sprintf("chunk-%d", chunkSize), func(b *testing.B) {
+			b.SetBytes(int64(len(clear)))
+			for i := 0; i < b.N; i++ {
+				if err := cbc.Encrypt(key[:], bytes.NewReader(clear), ioutil.Discard); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
 func TestKeyWrap(t *testing.T) {
 	key := []byte{0x00, 0x01, 0x02, 0x03,
 		0x04, 0x05, 0x06, 0x07,
@@ -29,6 +29,7 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"encoding/binary"
+	"errors"
 	"io"
 	"io/ioutil"
 )
@@ -71,6 +72,32 @@ func (e *gcmEncrypter) Encrypt(key ContentKey, r io.Reader, w io.Writer) error {
 	return err
 }
 
+// Decrypt decrypts and authenticates a resource, made of the nonce followed by the sealed data
+func (e *gcmEncrypter) Decrypt(key ContentKey, r io.Reader, w io.Writer) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if len(data) < gcm.NonceSize() {
+		return errors.New("the encrypted data is shorter than the nonce")
+	}
+	clear, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(clear)
+	return err
+}
+
 func NewAESGCMEncrypter() Encrypter {
 	return &gcmEncrypter{}
 }
\ No newline at end of file
@@ -27,7 +27,9 @@ package crypto
 
 import (
 	"crypto/aes"
+	"fmt"
 	"io"
+	"strings"
 )
 //"github.com/readium/readium-lcp-server/config"
 // FOR: config.Config.AES256_CBC_OR_GCM
@@ -54,6 +56,35 @@ func NewAESEncrypter_PUBLICATION_RESOURCES() Encrypter {
 	// }
 }
 
+// AES modes of the encryption of publication resources;
+// CBC is the mode of LCP, GCM provides authenticated encryption for packages which are not distributed with LCP
+const (
+	ModeCBC = "cbc"
+	ModeGCM = "gcm"
+)
+
+// NewAESEncrypterWithMode returns an encrypter of publication resources using an AES mode, CBC by default
+func NewAESEncrypterWithMode(mode string) (Encrypter, error) {
+	switch strings.ToLower(mode) {
+	case "", ModeCBC:
+		return NewAESCBCEncrypter(), nil
+	case ModeGCM:
+		return NewAESGCMEncrypter(), nil
+	}
+	return nil, fmt.Errorf("unknown AES mode %q, expected %s or %s", mode, ModeCBC, ModeGCM)
+}
+
+// NewAESDecrypter returns the decrypter matching the algorithm of an encrypted resource,
+// as written in the manifest or encryption.xml of a package
+func NewAESDecrypter(algorithm string) (Decrypter, error) {
+	for _, encrypter := range []Encrypter{NewAESCBCEncrypter(), NewAESGCMEncrypter()} {
+		if encrypter.Signature() == algorithm {
+			return encrypter.(Decrypter), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown encryption algorithm %s", algorithm)
+}
+
 func NewAESEncrypter_CONTENT_KEY() Encrypter {
 	// default to CBC
 	return NewAESCBCEncrypter()
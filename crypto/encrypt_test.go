@@ -0,0 +1,58 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAESModeRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog"), 100)
+	for _, mode := range []string{ModeCBC, ModeGCM} {
+		encrypter, err := NewAESEncrypterWithMode(mode)
+		if err != nil {
+			t.Fatalf("%s: %s", mode, err)
+		}
+		key, err := encrypter.GenerateKey()
+		if err != nil {
+			t.Fatalf("%s: %s", mode, err)
+		}
+		var encrypted bytes.Buffer
+		if err = encrypter.Encrypt(key, bytes.NewReader(data), &encrypted); err != nil {
+			t.Fatalf("%s: encryption failed, %s", mode, err)
+		}
+
+		decrypter, err := NewAESDecrypter(encrypter.Signature())
+		if err != nil {
+			t.Fatalf("%s: %s", mode, err)
+		}
+		var clear bytes.Buffer
+		if err = decrypter.Decrypt(key, bytes.NewReader(encrypted.Bytes()), &clear); err != nil {
+			t.Fatalf("%s: decryption failed, %s", mode, err)
+		}
+		if !bytes.Equal(clear.Bytes(), data) {
+			t.Errorf("%s: expected encryption-decryption to return the original", mode)
+		}
+	}
+
+	if _, err := NewAESEncrypterWithMode("ctr"); err == nil {
+		t.Error("Expected an unknown mode to be rejected")
+	}
+}
+
+func TestGCMTampering(t *testing.T) {
+	encrypter := NewAESGCMEncrypter()
+	key, _ := encrypter.GenerateKey()
+	var encrypted bytes.Buffer
+	if err := encrypter.Encrypt(key, bytes.NewReader([]byte("authenticated")), &encrypted); err != nil {
+		t.Fatal(err)
+	}
+	tampered := encrypted.Bytes()
+	tampered[len(tampered)-1] ^= 1
+	if err := encrypter.(Decrypter).Decrypt(key, bytes.NewReader(tampered), &bytes.Buffer{}); err == nil {
+		t.Error("Expected a tampered resource to be rejected")
+	}
+}
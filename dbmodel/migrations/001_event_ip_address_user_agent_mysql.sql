@@ -0,0 +1,7 @@
+-- 001: record the ip address and user agent of the events of the lsd server
+-- to be run once on an event table created before this version
+
+ALTER TABLE `event` ADD COLUMN `ip_address` varchar(64) DEFAULT NULL;
+ALTER TABLE `event` ADD COLUMN `user_agent` varchar(255) DEFAULT NULL;
+
+CREATE INDEX `event_ip_address_index` on `event` (`ip_address`);
@@ -18,7 +18,10 @@ CREATE TABLE `event` (
     `type` int NOT NULL,
     `device_id` varchar(255) DEFAULT NULL,
     `license_status_fk` int NOT NULL,
+    `ip_address` varchar(64) DEFAULT NULL,
+    `user_agent` varchar(255) DEFAULT NULL,
     FOREIGN KEY(`license_status_fk`) REFERENCES `license_status` (`id`)
 );
 
-CREATE INDEX `license_status_fk_index` on `event` (`license_status_fk`);
\ No newline at end of file
+CREATE INDEX `license_status_fk_index` on `event` (`license_status_fk`);
+CREATE INDEX `event_ip_address_index` on `event` (`ip_address`);
\ No newline at end of file
@@ -16,6 +16,7 @@ type Package struct {
 	BasePath string   `xml:"-"`
 	Metadata Metadata `xml:"http://www.idpf.org/2007/opf metadata"`
 	Manifest Manifest `xml:"http://www.idpf.org/2007/opf manifest"`
+	Spine    Spine    `xml:"http://www.idpf.org/2007/opf spine"`
 }
 
 // Metadata is the package metadata structure
@@ -47,6 +48,26 @@ type Item struct {
 	Properties string `xml:"properties,attr"`
 }
 
+// Spine lists the manifest items in reading order
+type Spine struct {
+	Itemrefs []Itemref `xml:"http://www.idpf.org/2007/opf itemref"`
+}
+
+// Itemref is the spine item structure
+type Itemref struct {
+	Idref string `xml:"idref,attr"`
+}
+
+// ItemWithID returns the manifest item with the given id
+func (m Manifest) ItemWithID(id string) (Item, bool) {
+	for _, i := range m.Items {
+		if i.Id == id {
+			return i, true
+		}
+	}
+	return Item{}, false
+}
+
 // ItemWithPath looks for the manifest item corresponding to a given path
 func (m Manifest) ItemWithPath(path string) (Item, bool) {
 	for _, i := range m.Items {
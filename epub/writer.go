@@ -34,6 +34,9 @@ import (
 
 type Writer struct {
 	w *zip.Writer
+	// DedupeEncryption removes duplicate entries from encryption.xml before it is written
+	DedupeEncryption bool
+	encryption       *xmlenc.Manifest
 }
 
 func (w *Writer) WriteHeader() error {
@@ -56,17 +59,28 @@ func (w *Writer) Copy(r *Resource) error {
 	return err
 }
 
+// WriteEncryption registers the encryption manifest of the package,
+// which is written as the last resource when the writer is closed
 func (w *Writer) WriteEncryption(enc *xmlenc.Manifest) error {
-	fw, err := w.AddResource(EncryptionFile, zip.Deflate)
-	if err != nil {
-		return err
-	}
-
-	return enc.Write(fw)
-
+	w.encryption = enc
+	return nil
 }
 
 func (w *Writer) Close() error {
+	if w.encryption != nil {
+		if w.DedupeEncryption {
+			w.encryption.Dedupe()
+		}
+		fw, err := w.AddResource(EncryptionFile, zip.Deflate)
+		if err != nil {
+			w.w.Close()
+			return err
+		}
+		if err = w.encryption.Write(fw); err != nil {
+			w.w.Close()
+			return err
+		}
+	}
 	return w.w.Close()
 }
 
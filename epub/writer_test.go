@@ -32,6 +32,8 @@ import (
 	"io/ioutil"
 	"strings"
 	"testing"
+
+	"github.com/readium/readium-lcp-server/xmlenc"
 )
 
 const containerSpec = `<?xml version="1.0" encoding="UTF-8"?><container xmlns="urn:oasis:names:tc:opendocument:xmlns:container" version="1.0">
@@ -110,6 +112,49 @@ func TestWriteBasicEpub(t *testing.T) {
 	testContentsOfFileInZip(t, zr, zip.Deflate, "EPUB/page.xhtml", basicPage)
 }
 
+func TestWriterDedupeEncryption(t *testing.T) {
+	var enc xmlenc.Manifest
+	for _, uri := range []string{"EPUB/page.xhtml", "EPUB/page.xhtml"} {
+		var data xmlenc.Data
+		data.CipherData.CipherReference.URI = xmlenc.URI(uri)
+		enc.Data = append(enc.Data, data)
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.DedupeEncryption = true
+	if err := w.WriteHeader(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteEncryption(&enc); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Could not close the writer, %s", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal("Could not read zip", err)
+	}
+	f, err := findFileInZip(zr, EncryptionFile)
+	if err != nil {
+		t.Fatalf("Could not find %s in file", EncryptionFile)
+	}
+	r, err := f.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	out, err := xmlenc.Read(r)
+	if err != nil {
+		t.Fatalf("Could not read %s, %s", EncryptionFile, err)
+	}
+	if l := len(out.Data); l != 1 {
+		t.Errorf("Expected a single EncryptedData item, got %d", l)
+	}
+}
+
 func testContentsOfFileInZip(t *testing.T, zr *zip.Reader, m uint16, path, expected string) {
 	for _, f := range zr.File {
 		fmt.Println(f.Name)
@@ -26,6 +26,10 @@
 package staticapi
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"strconv"
 
@@ -36,6 +40,7 @@ import (
 	"github.com/readium/readium-lcp-server/frontend/webpurchase"
 	"github.com/readium/readium-lcp-server/frontend/webrepository"
 	"github.com/readium/readium-lcp-server/frontend/webuser"
+	"github.com/readium/readium-lcp-server/logging"
 )
 
 //IServer defines methods for db interaction
@@ -46,6 +51,7 @@ type IServer interface {
 	PurchaseAPI() webpurchase.WebPurchase
 	DashboardAPI() webdashboard.WebDashboard
 	LicenseAPI() weblicense.WebLicense
+	Logger() logging.Logger
 }
 
 // Pagination used to paginate listing
@@ -105,3 +111,75 @@ func PrepareListHeaderResponse(resourceCount int, resourceLink string, paginatio
 	}
 	w.Header().Set("Content-Type", api.ContentType_JSON)
 }
+
+// mergePatch applies a JSON merge patch (RFC 7396) to a JSON document:
+// members of the patch replace those of the document, null members are removed,
+// and members absent from the patch are left untouched.
+func mergePatch(doc []byte, patch []byte) ([]byte, error) {
+	var target, changes interface{}
+	if err := decodeJSONNumbers(doc, &target); err != nil {
+		return nil, err
+	}
+	if err := decodeJSONNumbers(patch, &changes); err != nil {
+		return nil, err
+	}
+	return json.Marshal(mergeValue(target, changes))
+}
+
+// mergeValue merges a patch value into a target value
+func mergeValue(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+			continue
+		}
+		targetObject[name] = mergeValue(targetObject[name], value)
+	}
+	return targetObject
+}
+
+// decodeJSONNumbers decodes a JSON document, keeping numbers as json.Number to avoid losing precision
+func decodeJSONNumbers(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// defaultMaxMemory is the part of a multipart form kept in memory, the rest is stored in temporary files
+const defaultMaxMemory = 32 << 20
+
+// errBodyTooLarge is returned when a request body exceeds its maximum size
+var errBodyTooLarge = errors.New("request body too large")
+
+// limitedReadCloser fails the reads beyond a maximum number of bytes, and records it
+type limitedReadCloser struct {
+	io.ReadCloser
+	remaining int64
+	exceeded  bool
+}
+
+func (l *limitedReadCloser) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		// check that the body really goes on
+		var probe [1]byte
+		if n, _ := l.ReadCloser.Read(probe[:]); n > 0 {
+			l.exceeded = true
+			return 0, errBodyTooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.ReadCloser.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
@@ -10,18 +10,32 @@
 package staticapi
 
 import (
+	"encoding/csv"
 	"encoding/json"
-	"log"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
 	"net/http"
+	"net/url"
+	"path/filepath"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/readium/readium-lcp-server/api"
+	"github.com/readium/readium-lcp-server/config"
 	"github.com/readium/readium-lcp-server/frontend/webpublication"
+	"github.com/readium/readium-lcp-server/pack"
 	"github.com/readium/readium-lcp-server/problem"
 )
 
-// GetPublications returns a list of publications
+// GetPublications returns a list of publications.
+// The optional sort (id, title or created) and order (asc or desc) parameters sort the list;
+// by default, the latest publications come first.
+// The list is sent as JSON, or as CSV if requested by a format=csv parameter or the Accept header.
+// Instead of a page, a client of a large catalog may pass an after parameter, the id of the last publication received:
+// the publications are then listed by increasing id, and the next link of the Link header holds the next cursor.
 func GetPublications(w http.ResponseWriter, r *http.Request, s IServer) {
 	var page int64
 	var perPage int64
@@ -47,6 +61,11 @@ func GetPublications(w http.ResponseWriter, r *http.Request, s IServer) {
 		perPage = 100
 	}
 
+	if r.FormValue("after") != "" {
+		getPublicationsAfter(w, r, s, int(perPage))
+		return
+	}
+
 	if page > 0 {
 		page-- //pagenum starting at 0 in code, but user interface starting at 1
 	}
@@ -56,30 +75,116 @@ func GetPublications(w http.ResponseWriter, r *http.Request, s IServer) {
 		return
 	}
 
+	sort, order := r.FormValue("sort"), r.FormValue("order")
+	if sort == "" && order == "" {
+		sort, order = webpublication.SortID, webpublication.OrderDesc
+	} else if order == "" {
+		order = webpublication.OrderAsc
+	} else if sort == "" {
+		sort = webpublication.SortID
+	}
+	if err = webpublication.ValidateSort(sort, order); err != nil {
+		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusBadRequest)
+		return
+	}
+
 	pubs := make([]webpublication.Publication, 0)
 	//log.Println("ListAll(" + strconv.Itoa(int(per_page)) + "," + strconv.Itoa(int(page)) + ")")
-	fn := s.PublicationAPI().List(int(perPage), int(page))
+	fn := s.PublicationAPI().ListSorted(int(perPage), int(page), sort, order)
 	for it, err := fn(); err == nil; it, err = fn() {
 		pubs = append(pubs, it)
 	}
+	// the links use the page numbers of the user interface, starting at 1
+	var links []string
 	if len(pubs) > 0 {
-		nextPage := strconv.Itoa(int(page) + 1)
-		w.Header().Set("Link", "</publications/?page="+nextPage+">; rel=\"next\"; title=\"next\"")
+		nextPage := strconv.Itoa(int(page) + 2)
+		links = append(links, "</publications/?page="+nextPage+">; rel=\"next\"; title=\"next\"")
+	}
+	if page > 0 {
+		previousPage := strconv.Itoa(int(page))
+		links = append(links, "</publications/?page="+previousPage+">; rel=\"previous\"; title=\"previous\"")
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+	writePublications(w, r, s, pubs)
+}
+
+// getPublicationsAfter lists the publications whose id is greater than the after parameter, by increasing id.
+// Unlike an offset, the cursor is not shifted by the publications added or deleted between two pages.
+func getPublicationsAfter(w http.ResponseWriter, r *http.Request, s IServer, perPage int) {
+	after, err := strconv.ParseInt(r.FormValue("after"), 10, 64)
+	if err != nil || after < 0 {
+		problem.Error(w, r, problem.Problem{Detail: "after must be a publication id"}, http.StatusBadRequest)
+		return
+	}
+	if r.FormValue("page") != "" || r.FormValue("sort") != "" || r.FormValue("order") != "" {
+		problem.Error(w, r, problem.Problem{Detail: "the after parameter cannot be combined with page, sort or order"}, http.StatusBadRequest)
+		return
+	}
+
+	pubs := make([]webpublication.Publication, 0)
+	fn := s.PublicationAPI().ListAfter(after, perPage)
+	for it, err := fn(); err == nil; it, err = fn() {
+		pubs = append(pubs, it)
 	}
-	if page > 1 {
-		previousPage := strconv.Itoa(int(page) - 1)
-		w.Header().Set("Link", "</publications/?page="+previousPage+">; rel=\"previous\"; title=\"previous\"")
+	// a full page may be followed by other publications
+	if len(pubs) > 0 && len(pubs) == perPage {
+		next := strconv.FormatInt(pubs[len(pubs)-1].ID, 10)
+		w.Header().Set("Link", "</publications/?after="+next+">; rel=\"next\"; title=\"next\"")
+	}
+	writePublications(w, r, s, pubs)
+}
+
+// writePublications sends a list of publications as JSON, or as CSV if requested
+func writePublications(w http.ResponseWriter, r *http.Request, s IServer, pubs []webpublication.Publication) {
+	if acceptsCSV(r) {
+		w.Header().Set("Content-Type", api.ContentType_CSV)
+		if err := writePublicationsCSV(w, pubs); err != nil {
+			s.Logger().Error("Error writing the publications as csv: %s", err)
+		}
+		return
 	}
 	w.Header().Set("Content-Type", api.ContentType_JSON)
 
 	enc := json.NewEncoder(w)
-	err = enc.Encode(pubs)
-	if err != nil {
+	if err := enc.Encode(pubs); err != nil {
 		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusBadRequest)
 		return
 	}
 }
 
+// internalError logs an unexpected error of a publication handler and returns it as a problem
+func internalError(w http.ResponseWriter, r *http.Request, s IServer, err error) {
+	s.Logger().Error("[%s] %s %s: %s", problem.RequestID(r.Context()), r.Method, r.URL.Path, err)
+	problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusInternalServerError)
+}
+
+// acceptsCSV checks if a list is requested as CSV, with a format=csv query parameter
+// or a text/csv Accept header; JSON is the default
+func acceptsCSV(r *http.Request) bool {
+	if format := r.FormValue("format"); format != "" {
+		return format == "csv"
+	}
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted)); err == nil && mediaType == api.ContentType_CSV {
+			return true
+		}
+	}
+	return false
+}
+
+// writePublicationsCSV writes a list of publications as CSV, with a header row
+func writePublicationsCSV(w io.Writer, pubs []webpublication.Publication) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "title", "status"})
+	for _, pub := range pubs {
+		cw.Write([]string{strconv.FormatInt(pub.ID, 10), pub.Title, pub.Status})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
 // GetPublication returns a publication from its numeric id, given as part of the calling url
 //
 func GetPublication(w http.ResponseWriter, r *http.Request, s IServer) {
@@ -98,7 +203,7 @@ func GetPublication(w http.ResponseWriter, r *http.Request, s IServer) {
 			w.Header().Set("Content-Type", api.ContentType_JSON)
 			return
 		}
-		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusInternalServerError)
+		internalError(w, r, s, err)
 	} else {
 		switch err {
 		case webpublication.ErrNotFound:
@@ -107,54 +212,104 @@ func GetPublication(w http.ResponseWriter, r *http.Request, s IServer) {
 			}
 		default:
 			{
-				problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusInternalServerError)
+				internalError(w, r, s, err)
 			}
 		}
 	}
 }
 
 // CheckPublicationByTitle check if a publication with this title exist
+// The response is a json object {"exists": bool}, sent with a 200 status in both cases.
 func CheckPublicationByTitle(w http.ResponseWriter, r *http.Request, s IServer) {
-	var title string
-	title = r.URL.Query()["title"][0]
+	title := r.URL.Query().Get("title")
+	if title == "" {
+		problem.Error(w, r, problem.Problem{Detail: "the title parameter is missing"}, http.StatusBadRequest)
+		return
+	}
 
-	log.Println("Check publication stored with name " + string(title))
+	s.Logger().Info("Check publication stored with name %s", title)
 
-	if pub, err := s.PublicationAPI().CheckByTitle(string(title)); err == nil {
-		enc := json.NewEncoder(w)
-		if err = enc.Encode(pub); err == nil {
-			// send a json serialization of the boolean response
-			w.Header().Set("Content-Type", api.ContentType_JSON)
-			return
-		}
-		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusInternalServerError)
-	} else {
-		switch err {
-		case webpublication.ErrNotFound:
-			{
-				log.Println("No publication stored with name " + string(title))
-				//	problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusNotFound)
-			}
-		default:
-			{
-				problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusInternalServerError)
-			}
-		}
+	count, err := s.PublicationAPI().CheckByTitle(string(title))
+	if err != nil && err != webpublication.ErrNotFound {
+		internalError(w, r, s, err)
+		return
+	}
+	if count <= 0 {
+		s.Logger().Info("No publication stored with name %s", title)
 	}
+	// send a json serialization of the boolean response
+	w.Header().Set("Content-Type", api.ContentType_JSON)
+	json.NewEncoder(w).Encode(titleCheck{Exists: count > 0})
+}
+
+// titleCheck is the json response of CheckPublicationByTitle
+type titleCheck struct {
+	Exists bool `json:"exists"`
 }
 
-//DecodeJSONPublication transforms a json string to a User struct
+// SearchPublications returns the publications whose title contains the q parameter, paginated
+// like GetPublications. Only the titles are searched: the publication table of the frontend has no author column.
+func SearchPublications(w http.ResponseWriter, r *http.Request, s IServer) {
+	query := r.FormValue("q")
+	if query == "" {
+		problem.Error(w, r, problem.Problem{Detail: "the search query must not be empty"}, http.StatusBadRequest)
+		return
+	}
+	pagination, err := ExtractPaginationFromRequest(r)
+	if err != nil {
+		problem.Error(w, r, problem.Problem{Detail: "Pagination error"}, http.StatusBadRequest)
+		return
+	}
+
+	pubs := make([]webpublication.Publication, 0)
+	fn := s.PublicationAPI().Search(query, pagination.PerPage, pagination.Page)
+	var pub webpublication.Publication
+	for pub, err = fn(); err == nil; pub, err = fn() {
+		pubs = append(pubs, pub)
+	}
+	if err != webpublication.ErrNotFound {
+		internalError(w, r, s, err)
+		return
+	}
+
+	// the links use the page numbers of the user interface, starting at 1
+	link := "</publications/search?q=" + url.QueryEscape(query) + "&page="
+	var links []string
+	if len(pubs) > 0 {
+		nextPage := strconv.Itoa(pagination.Page + 2)
+		links = append(links, link+nextPage+">; rel=\"next\"; title=\"next\"")
+	}
+	if pagination.Page > 0 {
+		previousPage := strconv.Itoa(pagination.Page)
+		links = append(links, link+previousPage+">; rel=\"previous\"; title=\"previous\"")
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+	w.Header().Set("Content-Type", api.ContentType_JSON)
+
+	enc := json.NewEncoder(w)
+	if err = enc.Encode(pubs); err != nil {
+		internalError(w, r, s, err)
+	}
+}
+
+// DecodeJSONPublication decodes the json body of a request into a publication;
+// the body must be sent as application/json
 func DecodeJSONPublication(r *http.Request) (webpublication.Publication, error) {
-	var dec *json.Decoder
-	if ctype := r.Header["Content-Type"]; len(ctype) > 0 && ctype[0] == api.ContentType_JSON {
-		dec = json.NewDecoder(r.Body)
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != api.ContentType_JSON {
+		return webpublication.Publication{}, errUnsupportedContentType
 	}
 	pub := webpublication.Publication{}
-	err := dec.Decode(&pub)
+	err := json.NewDecoder(r.Body).Decode(&pub)
 	return pub, err
 }
 
+// errUnsupportedContentType is returned when a publication is not sent as JSON
+var errUnsupportedContentType = errors.New("unsupported content type, expected " + api.ContentType_JSON)
+
 // CreatePublication creates a publication in the database
+// and returns it, its location being set in the Location header
 func CreatePublication(w http.ResponseWriter, r *http.Request, s IServer) {
 	var pub webpublication.Publication
 	var err error
@@ -162,25 +317,124 @@ func CreatePublication(w http.ResponseWriter, r *http.Request, s IServer) {
 		problem.Error(w, r, problem.Problem{Detail: "incorrect JSON Publication " + err.Error()}, http.StatusBadRequest)
 		return
 	}
+	if errs := pub.Validate(); len(errs) > 0 {
+		invalidPublication(w, r, errs)
+		return
+	}
 
 	// add publication
-	if err := s.PublicationAPI().Add(pub); err != nil {
+	if pub, err = s.PublicationAPI().Add(pub); err != nil {
+		// the LCP server did not create the content
+		var lcpErr *webpublication.LcpServerError
+		if errors.As(err, &lcpErr) {
+			problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusBadGateway)
+			return
+		}
 		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusBadRequest)
 		return
 	}
 
-	// publication added to db
+	// publication added to db, return it with its new id
+	w.Header().Set("Content-Type", api.ContentType_JSON)
+	w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+strconv.FormatInt(pub.ID, 10))
 	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(pub)
 }
 
-// UploadPublication creates a new publication via a POST request
+// Request headers overriding the packaging options of an upload
+const (
+	HeaderManifestProfile  = "X-LCP-Manifest-Profile"
+	HeaderEncryptionPolicy = "X-LCP-Encryption-Policy"
+	HeaderCompression      = "X-LCP-Compression"
+)
+
+// uploadOptions is the json structure of the "options" multipart field of an upload
+type uploadOptions struct {
+	ManifestProfile  string `json:"manifestProfile"`
+	EncryptionPolicy string `json:"encryptionPolicy"`
+	Compression      string `json:"compression"`
+}
+
+// packOptionsFromRequest extracts the packaging options of an upload
+// from a json "options" multipart field, overridden by X-LCP-* request headers
+func packOptionsFromRequest(r *http.Request) (pack.PackOptions, error) {
+	var opts uploadOptions
+	if field := r.FormValue("options"); field != "" {
+		if err := json.Unmarshal([]byte(field), &opts); err != nil {
+			return pack.PackOptions{}, err
+		}
+	}
+	if value := r.Header.Get(HeaderManifestProfile); value != "" {
+		opts.ManifestProfile = value
+	}
+	if value := r.Header.Get(HeaderEncryptionPolicy); value != "" {
+		opts.EncryptionPolicy = value
+	}
+	if value := r.Header.Get(HeaderCompression); value != "" {
+		opts.Compression = value
+	}
+	packOptions := pack.PackOptions{
+		ManifestProfile:  opts.ManifestProfile,
+		EncryptionPolicy: opts.EncryptionPolicy,
+		Compression:      opts.Compression,
+	}
+	return packOptions, packOptions.Validate()
+}
+
+// isEPUBUpload indicates whether the uploaded file is an EPUB, which is encrypted as is:
+// the packaging options only apply to the publications converted to Readium packages
+func isEPUBUpload(r *http.Request) bool {
+	if r.MultipartForm == nil {
+		return false
+	}
+	files := r.MultipartForm.File["file"]
+	return len(files) > 0 && filepath.Ext(files[0].Filename) == ".epub"
+}
+
+// UploadPublication creates a new publication via a POST request.
+// Uploads larger than the configured maximum size are rejected before the publication is stored,
+// as are packaging options sent with an EPUB.
 func UploadPublication(w http.ResponseWriter, r *http.Request, s IServer) {
+	title := r.URL.Query().Get("title")
+	if title == "" {
+		problem.Error(w, r, problem.Problem{Detail: "the title parameter is missing"}, http.StatusBadRequest)
+		return
+	}
+	if max := config.Config.FrontendServer.MaxUploadSize; max > 0 {
+		// reject a declared length before reading the body
+		if r.ContentLength > max {
+			problem.Error(w, r, problem.Problem{Detail: "the publication exceeds the maximum upload size"}, http.StatusRequestEntityTooLarge)
+			return
+		}
+		// a chunked body is parsed up to the limit
+		body := &limitedReadCloser{ReadCloser: r.Body, remaining: max}
+		r.Body = body
+		if err := r.ParseMultipartForm(defaultMaxMemory); err != nil && err != http.ErrNotMultipart {
+			if body.exceeded {
+				problem.Error(w, r, problem.Problem{Detail: "the publication exceeds the maximum upload size"}, http.StatusRequestEntityTooLarge)
+			} else {
+				problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusBadRequest)
+			}
+			return
+		}
+	}
+
 	var pub webpublication.Publication
-	pub.Title = r.URL.Query()["title"][0]
-	s.PublicationAPI().Upload(r, w, pub)
+	pub.Title = title
+	opts, err := packOptionsFromRequest(r)
+	if err != nil {
+		problem.Error(w, r, problem.Problem{Detail: "invalid packaging options: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	if (opts.ManifestProfile != "" || opts.EncryptionPolicy != "" || opts.Compression != "") && isEPUBUpload(r) {
+		problem.Error(w, r, problem.Problem{Detail: "packaging options do not apply to an EPUB"}, http.StatusBadRequest)
+		return
+	}
+	s.PublicationAPI().Upload(r, w, pub, opts)
 }
 
-// UpdatePublication updates an identified publication (id) in the database
+// UpdatePublication updates the title and status of an identified publication (id) in the database;
+// the fields absent from the body are kept, and changing the id, uuid or master file name is rejected.
 func UpdatePublication(w http.ResponseWriter, r *http.Request, s IServer) {
 	vars := mux.Vars(r)
 	var id int
@@ -202,23 +456,130 @@ func UpdatePublication(w http.ResponseWriter, r *http.Request, s IServer) {
 		case webpublication.ErrNotFound:
 			problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusNotFound)
 		default:
-			problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusInternalServerError)
+			internalError(w, r, s, err)
 		}
 	} else {
-		// publication is found!
-		if err := s.PublicationAPI().Update(webpublication.Publication{
-			ID:     foundPub.ID,
-			Title:  pub.Title,
-			Status: foundPub.Status}); err != nil {
+		// the fields absent from the body are kept, the others must be valid
+		merged := mergePublication(foundPub, pub)
+		errs := append(merged.Validate(), immutableFieldErrors(foundPub, merged)...)
+		if pub.MasterFilename != "" {
+			errs = append(errs, problem.ValidationError{Field: "masterFilename", Message: "the master file name is only set when a publication is created"})
+		}
+		if len(errs) > 0 {
+			invalidPublication(w, r, errs)
+			return
+		}
+		if err := s.PublicationAPI().Update(merged); err != nil {
 			//update failed!
-			problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusInternalServerError)
+			internalError(w, r, s, err)
+			return
+		}
+		//database update ok, return the updated publication
+		updated, err := s.PublicationAPI().Get(foundPub.ID)
+		if err != nil {
+			internalError(w, r, s, err)
+			return
 		}
-		//database update ok
-		w.WriteHeader(http.StatusOK)
-		//return
+		w.Header().Set("Content-Type", api.ContentType_JSON)
+		json.NewEncoder(w).Encode(updated)
 	}
 }
 
+// invalidPublication returns the invalid fields of a publication as a single problem
+func invalidPublication(w http.ResponseWriter, r *http.Request, errs []problem.ValidationError) {
+	problem.Error(w, r, problem.Problem{Detail: "invalid publication", ValidationErrors: errs}, http.StatusBadRequest)
+}
+
+// mergePublication returns the stored publication updated with the fields set in a publication,
+// the fields left empty being kept. The master file name is not stored.
+func mergePublication(found webpublication.Publication, pub webpublication.Publication) webpublication.Publication {
+	if pub.ID != 0 {
+		found.ID = pub.ID
+	}
+	if pub.UUID != "" {
+		found.UUID = pub.UUID
+	}
+	if pub.Title != "" {
+		found.Title = pub.Title
+	}
+	if pub.Status != "" {
+		found.Status = pub.Status
+	}
+	return found
+}
+
+// PatchPublication applies a JSON merge patch (RFC 7396) to an identified publication (id),
+// and returns the updated publication. Fields absent from the patch are left untouched.
+func PatchPublication(w http.ResponseWriter, r *http.Request, s IServer) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		// id is not a number
+		problem.Error(w, r, problem.Problem{Detail: "Publication ID must be an integer"}, http.StatusBadRequest)
+		return
+	}
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != api.ContentType_MERGE_PATCH_JSON {
+		problem.Error(w, r, problem.Problem{Detail: "The patch must be sent as " + api.ContentType_MERGE_PATCH_JSON}, http.StatusUnsupportedMediaType)
+		return
+	}
+	patch, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	foundPub, err := s.PublicationAPI().Get(int64(id))
+	if err != nil {
+		switch err {
+		case webpublication.ErrNotFound:
+			problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusNotFound)
+		default:
+			internalError(w, r, s, err)
+		}
+		return
+	}
+
+	// apply the patch to the json serialization of the stored publication
+	doc, err := json.Marshal(foundPub)
+	if err != nil {
+		internalError(w, r, s, err)
+		return
+	}
+	patched, err := mergePatch(doc, patch)
+	if err != nil {
+		problem.Error(w, r, problem.Problem{Detail: "incorrect JSON merge patch " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	var pub webpublication.Publication
+	if err = json.Unmarshal(patched, &pub); err != nil {
+		problem.Error(w, r, problem.Problem{Detail: "incorrect JSON Publication " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	if errs := append(pub.Validate(), immutableFieldErrors(foundPub, pub)...); len(errs) > 0 {
+		invalidPublication(w, r, errs)
+		return
+	}
+
+	if err = s.PublicationAPI().Update(pub); err != nil {
+		internalError(w, r, s, err)
+		return
+	}
+	w.Header().Set("Content-Type", api.ContentType_JSON)
+	json.NewEncoder(w).Encode(pub)
+}
+
+// immutableFieldErrors checks that the identifiers of an updated publication are those of the stored publication
+func immutableFieldErrors(found webpublication.Publication, pub webpublication.Publication) []problem.ValidationError {
+	var errs []problem.ValidationError
+	if pub.ID != found.ID {
+		errs = append(errs, problem.ValidationError{Field: "id", Message: "the id cannot be changed"})
+	}
+	if pub.UUID != found.UUID {
+		errs = append(errs, problem.ValidationError{Field: "uuid", Message: "the uuid cannot be changed"})
+	}
+	return errs
+}
+
 // DeletePublication removes a publication in the database
 func DeletePublication(w http.ResponseWriter, r *http.Request, s IServer) {
 	vars := mux.Vars(r)
@@ -234,3 +595,35 @@ func DeletePublication(w http.ResponseWriter, r *http.Request, s IServer) {
 	// publication deleted from db
 	w.WriteHeader(http.StatusOK)
 }
+
+// DeletePublications removes a list of publications from the database, in a single transaction.
+// The ids are given as a comma-separated "ids" query parameter, or as a JSON array in the request body.
+// The outcome of each deletion is returned, an unknown publication not aborting the batch.
+func DeletePublications(w http.ResponseWriter, r *http.Request, s IServer) {
+	var ids []int64
+	if param := r.FormValue("ids"); param != "" {
+		for _, field := range strings.Split(param, ",") {
+			id, err := strconv.ParseInt(strings.TrimSpace(field), 10, 64)
+			if err != nil {
+				problem.Error(w, r, problem.Problem{Detail: "Publication ids must be integers"}, http.StatusBadRequest)
+				return
+			}
+			ids = append(ids, id)
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&ids); err != nil {
+		problem.Error(w, r, problem.Problem{Detail: "incorrect JSON array of publication ids " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	if len(ids) == 0 {
+		problem.Error(w, r, problem.Problem{Detail: "No publication id"}, http.StatusBadRequest)
+		return
+	}
+
+	results, err := s.PublicationAPI().DeleteList(ids)
+	if err != nil {
+		internalError(w, r, s, err)
+		return
+	}
+	w.Header().Set("Content-Type", api.ContentType_JSON)
+	json.NewEncoder(w).Encode(results)
+}
@@ -0,0 +1,874 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package staticapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/readium/readium-lcp-server/api"
+	"github.com/readium/readium-lcp-server/config"
+	"github.com/readium/readium-lcp-server/frontend/webdashboard"
+	"github.com/readium/readium-lcp-server/frontend/weblicense"
+	"github.com/readium/readium-lcp-server/frontend/webpublication"
+	"github.com/readium/readium-lcp-server/frontend/webpurchase"
+	"github.com/readium/readium-lcp-server/frontend/webrepository"
+	"github.com/readium/readium-lcp-server/frontend/webuser"
+	"github.com/readium/readium-lcp-server/logging"
+	"github.com/readium/readium-lcp-server/pack"
+	"github.com/readium/readium-lcp-server/problem"
+)
+
+// testPublicationAPI records the calls made by the publication handlers
+type testPublicationAPI struct {
+	webpublication.WebPublication
+	uploaded    bool
+	packOptions pack.PackOptions
+	// stored is the publication returned by Get, updated by Update
+	stored *webpublication.Publication
+	// sort and order are the parameters of the last call to ListSorted, which returns listed
+	sort, order string
+	listed      []webpublication.Publication
+	// after is the cursor of the last call to ListAfter, which also returns listed
+	after int64
+	// query is the parameter of the last call to Search
+	query string
+	// addErr is returned by Add
+	addErr error
+}
+
+func (api *testPublicationAPI) Get(id int64) (webpublication.Publication, error) {
+	if api.stored == nil || api.stored.ID != id {
+		return webpublication.Publication{}, webpublication.ErrNotFound
+	}
+	return *api.stored, nil
+}
+
+func (api *testPublicationAPI) Update(pub webpublication.Publication) error {
+	*api.stored = pub
+	return nil
+}
+
+func (api *testPublicationAPI) Add(pub webpublication.Publication) (webpublication.Publication, error) {
+	if api.addErr != nil {
+		return webpublication.Publication{}, api.addErr
+	}
+	pub.ID, pub.UUID, pub.Status = 7, "uuid", webpublication.StatusOk
+	api.stored = &pub
+	return pub, nil
+}
+
+func (api *testPublicationAPI) DeleteList(ids []int64) ([]webpublication.DeleteResult, error) {
+	var results []webpublication.DeleteResult
+	for _, id := range ids {
+		if api.stored != nil && api.stored.ID == id {
+			results = append(results, webpublication.DeleteResult{ID: id, Deleted: true})
+		} else {
+			results = append(results, webpublication.DeleteResult{ID: id, Error: webpublication.ErrNotFound.Error()})
+		}
+	}
+	return results, nil
+}
+
+func (api *testPublicationAPI) CheckByTitle(title string) (int64, error) {
+	if api.stored != nil && api.stored.Title == title {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func (api *testPublicationAPI) ListSorted(page int, pageNum int, sort string, order string) func() (webpublication.Publication, error) {
+	api.sort, api.order = sort, order
+	listed := api.listed
+	return func() (webpublication.Publication, error) {
+		if len(listed) == 0 {
+			return webpublication.Publication{}, webpublication.ErrNotFound
+		}
+		pub := listed[0]
+		listed = listed[1:]
+		return pub, nil
+	}
+}
+
+func (api *testPublicationAPI) ListAfter(after int64, page int) func() (webpublication.Publication, error) {
+	api.after = after
+	return api.ListSorted(page, 0, webpublication.SortID, webpublication.OrderAsc)
+}
+
+func (api *testPublicationAPI) Search(query string, page int, pageNum int) func() (webpublication.Publication, error) {
+	api.query = query
+	found := []webpublication.Publication{{ID: 1, Title: "Moon Tiger"}, {ID: 2, Title: "The Moonstone"}}
+	return func() (webpublication.Publication, error) {
+		if len(found) == 0 {
+			return webpublication.Publication{}, webpublication.ErrNotFound
+		}
+		pub := found[0]
+		found = found[1:]
+		return pub, nil
+	}
+}
+
+func (api *testPublicationAPI) Upload(r *http.Request, w http.ResponseWriter, pub webpublication.Publication, opts pack.PackOptions) {
+	api.uploaded = true
+	api.packOptions = opts
+}
+
+// testServer only exposes the publication API
+type testServer struct {
+	publications *testPublicationAPI
+	logger       logging.Logger
+}
+
+func (s testServer) RepositoryAPI() webrepository.WebRepository    { return nil }
+func (s testServer) PublicationAPI() webpublication.WebPublication { return s.publications }
+func (s testServer) UserAPI() webuser.WebUser                      { return nil }
+func (s testServer) PurchaseAPI() webpurchase.WebPurchase          { return nil }
+func (s testServer) DashboardAPI() webdashboard.WebDashboard       { return nil }
+func (s testServer) LicenseAPI() weblicense.WebLicense             { return nil }
+func (s testServer) Logger() logging.Logger                        { return s.logger }
+
+func newTestServer() testServer {
+	return testServer{
+		publications: &testPublicationAPI{},
+		logger:       logging.NewLevelLogger(log.New(ioutil.Discard, "", 0), logging.LevelError),
+	}
+}
+
+func TestUploadPublicationOptions(t *testing.T) {
+	s := newTestServer()
+	r := multipartUploadRequest(t, "test.pdf", 100, "")
+	r.Header.Set(HeaderManifestProfile, pack.ManifestProfileLean)
+	r.Header.Set(HeaderEncryptionPolicy, pack.EncryptionPolicySkipAudio)
+	w := httptest.NewRecorder()
+
+	UploadPublication(w, r, s)
+
+	if !s.publications.uploaded {
+		t.Fatalf("Expected the publication to be uploaded, got status %d", w.Code)
+	}
+	opts := s.publications.packOptions
+	if opts.ManifestProfile != pack.ManifestProfileLean {
+		t.Errorf("Expected the %s manifest profile, got %q", pack.ManifestProfileLean, opts.ManifestProfile)
+	}
+	if opts.EncryptionPolicy != pack.EncryptionPolicySkipAudio {
+		t.Errorf("Expected the %s encryption policy, got %q", pack.EncryptionPolicySkipAudio, opts.EncryptionPolicy)
+	}
+}
+
+func TestUploadPublicationOptionsField(t *testing.T) {
+	s := newTestServer()
+	r := multipartUploadRequest(t, "test.lpf", 100, `{"manifestProfile": "lean", "compression": "store"}`)
+	// headers take precedence over the options field
+	r.Header.Set(HeaderManifestProfile, pack.ManifestProfileFull)
+	w := httptest.NewRecorder()
+
+	UploadPublication(w, r, s)
+
+	opts := s.publications.packOptions
+	if opts.ManifestProfile != pack.ManifestProfileFull || opts.Compression != pack.CompressionStore {
+		t.Errorf("Unexpected packaging options %#v", opts)
+	}
+}
+
+func TestUploadPublicationInvalidOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		options  string
+		header   string
+	}{
+		{"unknown policy", "test.pdf", "", "nothing"},
+		{"malformed options field", "test.pdf", `{"compression":`, ""},
+		{"policy header on an EPUB", "test.epub", "", pack.EncryptionPolicySkipAudio},
+		{"options field on an EPUB", "test.epub", `{"compression": "store"}`, ""},
+	}
+	for _, test := range tests {
+		s := newTestServer()
+		r := multipartUploadRequest(t, test.filename, 100, test.options)
+		if test.header != "" {
+			r.Header.Set(HeaderEncryptionPolicy, test.header)
+		}
+		w := httptest.NewRecorder()
+
+		UploadPublication(w, r, s)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", test.name, http.StatusBadRequest, w.Code)
+		}
+		if s.publications.uploaded {
+			t.Errorf("%s: did not expect the publication to be uploaded", test.name)
+		}
+	}
+}
+
+// patchPublication sends a merge patch for the publication 1 of a test server
+func patchPublication(s testServer, contentType string, patch string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("PATCH", "/publications/1", strings.NewReader(patch))
+	r.Header.Set("Content-Type", contentType)
+	r = mux.SetURLVars(r, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+	PatchPublication(w, r, s)
+	return w
+}
+
+func TestPatchPublication(t *testing.T) {
+	tests := []struct {
+		name     string
+		patch    string
+		expected webpublication.Publication
+	}{
+		{"status", `{"status": "ok"}`, webpublication.Publication{ID: 1, UUID: "uuid", Title: "title", Status: webpublication.StatusOk}},
+		{"title", `{"title": "new title"}`, webpublication.Publication{ID: 1, UUID: "uuid", Title: "new title", Status: webpublication.StatusDraft}},
+	}
+	for _, test := range tests {
+		s := newTestServer()
+		s.publications.stored = &webpublication.Publication{ID: 1, UUID: "uuid", Title: "title", Status: webpublication.StatusDraft}
+
+		w := patchPublication(s, "application/merge-patch+json; charset=utf-8", test.patch)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d, %s", test.name, http.StatusOK, w.Code, w.Body.String())
+		}
+		if *s.publications.stored != test.expected {
+			t.Errorf("%s: expected the stored publication %+v, got %+v", test.name, test.expected, *s.publications.stored)
+		}
+		var returned webpublication.Publication
+		if err := json.NewDecoder(w.Body).Decode(&returned); err != nil || returned != test.expected {
+			t.Errorf("%s: expected the updated publication to be returned, got %+v, %v", test.name, returned, err)
+		}
+	}
+}
+
+func TestPatchPublicationInvalid(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		patch       string
+		code        int
+		field       string
+	}{
+		{"content type", "application/json", `{"title": "new title"}`, http.StatusUnsupportedMediaType, ""},
+		{"unknown status", "application/merge-patch+json", `{"status": "published"}`, http.StatusBadRequest, "status"},
+		{"empty title", "application/merge-patch+json", `{"title": null}`, http.StatusBadRequest, "title"},
+		{"long title", "application/merge-patch+json", `{"title": "` + strings.Repeat("t", 256) + `"}`, http.StatusBadRequest, "title"},
+		{"id", "application/merge-patch+json", `{"id": 2}`, http.StatusBadRequest, "id"},
+		{"uuid", "application/merge-patch+json", `{"uuid": "other"}`, http.StatusBadRequest, "uuid"},
+		{"malformed", "application/merge-patch+json", `{"title": `, http.StatusBadRequest, ""},
+	}
+	for _, test := range tests {
+		s := newTestServer()
+		stored := webpublication.Publication{ID: 1, UUID: "uuid", Title: "title", Status: webpublication.StatusDraft}
+		s.publications.stored = &stored
+
+		w := patchPublication(s, test.contentType, test.patch)
+		if w.Code != test.code {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.code, w.Code)
+		}
+		if stored.Title != "title" || stored.Status != webpublication.StatusDraft {
+			t.Errorf("%s: did not expect the publication to be updated, got %+v", test.name, stored)
+		}
+		if test.field == "" {
+			continue
+		}
+		// the invalid fields are reported as those of a created or updated publication
+		var p problem.Problem
+		if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if len(p.ValidationErrors) != 1 || p.ValidationErrors[0].Field != test.field {
+			t.Errorf("%s: expected a validation error on %s, got %+v", test.name, test.field, p.ValidationErrors)
+		}
+	}
+}
+
+// uploadRequest builds a multipart upload request of a publication of the given size
+func uploadRequest(t *testing.T, size int) *http.Request {
+	return multipartUploadRequest(t, "test.epub", size, "")
+}
+
+// multipartUploadRequest builds a multipart upload request of a file of the given name and size,
+// with an options field if not empty
+func multipartUploadRequest(t *testing.T, filename string, size int, options string) *http.Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if options != "" {
+		if err := mw.WriteField("options", options); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fw, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(bytes.Repeat([]byte("x"), size))
+	mw.Close()
+
+	r := httptest.NewRequest("POST", "/publications/upload?title=test", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func TestUploadPublicationMaxSize(t *testing.T) {
+	defer func(max int64) { config.Config.FrontendServer.MaxUploadSize = max }(config.Config.FrontendServer.MaxUploadSize)
+	config.Config.FrontendServer.MaxUploadSize = 1024
+
+	tests := []struct {
+		name     string
+		size     int
+		chunked  bool
+		expected int
+	}{
+		{"declared length over the limit", 2048, false, http.StatusRequestEntityTooLarge},
+		{"streamed body over the limit", 2048, true, http.StatusRequestEntityTooLarge},
+		{"streamed body within the limit", 100, true, http.StatusOK},
+	}
+	for _, test := range tests {
+		s := newTestServer()
+		r := uploadRequest(t, test.size)
+		if test.chunked {
+			r.ContentLength = -1
+		}
+		w := httptest.NewRecorder()
+
+		UploadPublication(w, r, s)
+
+		if w.Code != test.expected {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.expected, w.Code)
+		}
+		if s.publications.uploaded != (test.expected == http.StatusOK) {
+			t.Errorf("%s: unexpected upload %t", test.name, s.publications.uploaded)
+		}
+	}
+}
+
+func TestGetPublicationsSort(t *testing.T) {
+	tests := []struct {
+		query  string
+		status int
+		sort   string
+		order  string
+	}{
+		{"", http.StatusOK, "id", "desc"},
+		{"?sort=title", http.StatusOK, "title", "asc"},
+		{"?sort=created&order=desc", http.StatusOK, "created", "desc"},
+		{"?sort=status", http.StatusBadRequest, "", ""},
+		{"?sort=title&order=up", http.StatusBadRequest, "", ""},
+	}
+	for _, test := range tests {
+		s := newTestServer()
+		r := httptest.NewRequest("GET", "/publications/"+test.query, nil)
+		w := httptest.NewRecorder()
+
+		GetPublications(w, r, s)
+
+		if w.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.query, test.status, w.Code)
+		}
+		if s.publications.sort != test.sort || s.publications.order != test.order {
+			t.Errorf("%s: expected to list by %s %s, got %s %s", test.query, test.sort, test.order, s.publications.sort, s.publications.order)
+		}
+	}
+}
+
+func TestGetPublicationsAfter(t *testing.T) {
+	tests := []struct {
+		query  string
+		listed int
+		status int
+		after  int64
+		link   string
+	}{
+		{"?after=0&per_page=2", 2, http.StatusOK, 0, `</publications/?after=2>; rel="next"; title="next"`},
+		{"?after=2&per_page=2", 1, http.StatusOK, 2, ""},
+		{"?after=x", 0, http.StatusBadRequest, 0, ""},
+		{"?after=-1", 0, http.StatusBadRequest, 0, ""},
+		{"?after=2&page=2", 0, http.StatusBadRequest, 0, ""},
+		{"?after=2&sort=title", 0, http.StatusBadRequest, 0, ""},
+	}
+	for _, test := range tests {
+		s := newTestServer()
+		for i := 0; i < test.listed; i++ {
+			id := test.after + int64(i) + 1
+			s.publications.listed = append(s.publications.listed, webpublication.Publication{ID: id})
+		}
+		w := httptest.NewRecorder()
+		GetPublications(w, httptest.NewRequest("GET", "/publications/"+test.query, nil), s)
+
+		if w.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.query, test.status, w.Code)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		if s.publications.after != test.after {
+			t.Errorf("%s: expected the cursor %d, got %d", test.query, test.after, s.publications.after)
+		}
+		if link := w.Header().Get("Link"); link != test.link {
+			t.Errorf("%s: expected the Link header %q, got %q", test.query, test.link, link)
+		}
+	}
+}
+
+func TestSearchPublications(t *testing.T) {
+	s := newTestServer()
+	r := httptest.NewRequest("GET", "/publications/search?q=moon", nil)
+	w := httptest.NewRecorder()
+
+	SearchPublications(w, r, s)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if s.publications.query != "moon" {
+		t.Errorf("Expected to search for moon, got %q", s.publications.query)
+	}
+	var pubs []webpublication.Publication
+	if err := json.NewDecoder(w.Body).Decode(&pubs); err != nil {
+		t.Fatal(err)
+	}
+	if len(pubs) != 2 {
+		t.Errorf("Expected 2 publications, got %+v", pubs)
+	}
+	if link := w.Header().Get("Link"); link != `</publications/search?q=moon&page=2>; rel="next"; title="next"` {
+		t.Errorf("Expected a link to the next page of the search, got %q", link)
+	}
+
+	// a middle page links to the next and previous pages
+	w = httptest.NewRecorder()
+	SearchPublications(w, httptest.NewRequest("GET", "/publications/search?q=moon&page=2", nil), newTestServer())
+	expected := `</publications/search?q=moon&page=3>; rel="next"; title="next", </publications/search?q=moon&page=1>; rel="previous"; title="previous"`
+	if link := w.Header().Get("Link"); link != expected {
+		t.Errorf("Expected the next and previous links, got %q", link)
+	}
+
+	// an empty query is rejected
+	s = newTestServer()
+	w = httptest.NewRecorder()
+	SearchPublications(w, httptest.NewRequest("GET", "/publications/search?q=", nil), s)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestMissingTitle(t *testing.T) {
+	for _, target := range []string{"/publications/upload", "/publications/upload?title="} {
+		s := newTestServer()
+		w := httptest.NewRecorder()
+
+		UploadPublication(w, httptest.NewRequest("POST", target, nil), s)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", target, http.StatusBadRequest, w.Code)
+		}
+		if s.publications.uploaded {
+			t.Errorf("%s: did not expect the publication to be uploaded", target)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	CheckPublicationByTitle(w, httptest.NewRequest("GET", "/publications/check-by-title", nil), newTestServer())
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+// updatePublication sends a publication in a PUT request for the publication 1 of a test server
+func updatePublication(s testServer, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("PUT", "/publications/1", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r = mux.SetURLVars(r, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+	UpdatePublication(w, r, s)
+	return w
+}
+
+func TestUpdatePublication(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected webpublication.Publication
+	}{
+		{"status", `{"status": "draft"}`, webpublication.Publication{ID: 1, UUID: "uuid", Title: "title", Status: webpublication.StatusDraft}},
+		{"unchanged identifiers", `{"id": 1, "uuid": "uuid", "title": "new title", "status": "error"}`, webpublication.Publication{ID: 1, UUID: "uuid", Title: "new title", Status: webpublication.StatusError}},
+	}
+	for _, test := range tests {
+		s := newTestServer()
+		s.publications.stored = &webpublication.Publication{ID: 1, UUID: "uuid", Title: "title", Status: webpublication.StatusOk}
+
+		w := updatePublication(s, test.body)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d, %s", test.name, http.StatusOK, w.Code, w.Body.String())
+		}
+		if *s.publications.stored != test.expected {
+			t.Errorf("%s: expected the stored publication %+v, got %+v", test.name, test.expected, *s.publications.stored)
+		}
+		var returned webpublication.Publication
+		if err := json.NewDecoder(w.Body).Decode(&returned); err != nil || returned != test.expected {
+			t.Errorf("%s: expected the updated publication to be returned, got %+v, %v", test.name, returned, err)
+		}
+	}
+}
+
+func TestUpdatePublicationInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		field string
+	}{
+		{"uuid", `{"uuid": "new-uuid"}`, "uuid"},
+		{"id", `{"id": 2, "title": "new title"}`, "id"},
+		{"master file", `{"masterFilename": "new.epub"}`, "masterFilename"},
+		{"status", `{"status": "lost"}`, "status"},
+	}
+	for _, test := range tests {
+		s := newTestServer()
+		stored := webpublication.Publication{ID: 1, UUID: "uuid", Title: "title", Status: webpublication.StatusOk}
+		s.publications.stored = &stored
+
+		w := updatePublication(s, test.body)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected status %d, got %d", test.name, http.StatusBadRequest, w.Code)
+		}
+		var p problem.Problem
+		if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if len(p.ValidationErrors) != 1 || p.ValidationErrors[0].Field != test.field {
+			t.Errorf("%s: expected a validation error on %s, got %+v", test.name, test.field, p.ValidationErrors)
+		}
+		if stored.UUID != "uuid" || stored.Status != webpublication.StatusOk {
+			t.Errorf("%s: did not expect the publication to be updated, got %+v", test.name, stored)
+		}
+	}
+}
+
+func TestCreatePublication(t *testing.T) {
+	s := newTestServer()
+	r := httptest.NewRequest("POST", "/api/v1/publications", strings.NewReader(`{"title": "title", "masterFilename": "test.epub"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	CreatePublication(w, r, s)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d, %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	if location := w.Header().Get("Location"); location != "/api/v1/publications/7" {
+		t.Errorf("Expected the location of the publication, got %s", location)
+	}
+	var created webpublication.Publication
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil || created.ID != 7 || created.Title != "title" {
+		t.Errorf("Expected the created publication to be returned, got %+v, %v", created, err)
+	}
+}
+
+func TestPublicationContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		expected    int
+	}{
+		{"text/plain", http.StatusBadRequest},
+		{"", http.StatusBadRequest},
+		{"application/json; charset=utf-8", http.StatusCreated},
+	}
+	for _, test := range tests {
+		s := newTestServer()
+		r := httptest.NewRequest("POST", "/api/v1/publications", strings.NewReader(`{"title": "title"}`))
+		if test.contentType != "" {
+			r.Header.Set("Content-Type", test.contentType)
+		}
+		w := httptest.NewRecorder()
+		CreatePublication(w, r, s)
+
+		if w.Code != test.expected {
+			t.Errorf("%q: expected status %d, got %d", test.contentType, test.expected, w.Code)
+		}
+	}
+}
+
+func TestInvalidPublication(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		fields []string
+	}{
+		{"missing title", "POST", `{"masterFilename": "test.epub"}`, []string{"title"}},
+		{"blank title", "POST", `{"title": "  "}`, []string{"title"}},
+		{"long title", "POST", `{"title": "` + strings.Repeat("a", 256) + `"}`, []string{"title"}},
+		{"negative id", "POST", `{"id": -1, "title": "title"}`, []string{"id"}},
+		{"unknown status", "POST", `{"title": "title", "status": "published"}`, []string{"status"}},
+		{"several fields", "POST", `{"id": -1, "status": "published"}`, []string{"id", "title", "status"}},
+		{"update status", "PUT", `{"status": "published"}`, []string{"status"}},
+		{"update id", "PUT", `{"id": -3, "title": "title"}`, []string{"id", "id"}},
+	}
+	for _, test := range tests {
+		s := newTestServer()
+		s.publications.stored = &webpublication.Publication{ID: 1, UUID: "uuid", Title: "title", Status: webpublication.StatusOk}
+		r := httptest.NewRequest(test.method, "/api/v1/publications", strings.NewReader(test.body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		if test.method == "PUT" {
+			UpdatePublication(w, mux.SetURLVars(r, map[string]string{"id": "1"}), s)
+		} else {
+			CreatePublication(w, r, s)
+		}
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", test.name, http.StatusBadRequest, w.Code)
+			continue
+		}
+		var p problem.Problem
+		if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if len(p.ValidationErrors) != len(test.fields) {
+			t.Errorf("%s: expected errors on %v, got %+v", test.name, test.fields, p.ValidationErrors)
+			continue
+		}
+		for i, field := range test.fields {
+			if p.ValidationErrors[i].Field != field {
+				t.Errorf("%s: expected an error on %s, got %+v", test.name, field, p.ValidationErrors[i])
+			}
+		}
+		if *s.publications.stored != (webpublication.Publication{ID: 1, UUID: "uuid", Title: "title", Status: webpublication.StatusOk}) {
+			t.Errorf("%s: did not expect the publication to be stored, got %+v", test.name, *s.publications.stored)
+		}
+	}
+}
+
+func TestCreatePublicationRejected(t *testing.T) {
+	s := newTestServer()
+	s.publications.addErr = &webpublication.LcpServerError{StatusCode: http.StatusInternalServerError}
+	r := httptest.NewRequest("POST", "/api/v1/publications", strings.NewReader(`{"title": "title", "masterFilename": "test.epub"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	CreatePublication(w, r, s)
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("Expected status %d, got %d", http.StatusBadGateway, w.Code)
+	}
+	if location := w.Header().Get("Location"); location != "" {
+		t.Errorf("Did not expect a location, got %s", location)
+	}
+}
+
+func TestGetPublicationsLinks(t *testing.T) {
+	tests := []struct {
+		query    string
+		listed   int
+		expected []string
+	}{
+		{"?page=1", 1, []string{`</publications/?page=2>; rel="next"`}},
+		{"?page=2", 1, []string{`</publications/?page=3>; rel="next"`, `</publications/?page=1>; rel="previous"`}},
+		{"?page=3", 0, []string{`</publications/?page=2>; rel="previous"`}},
+	}
+	for _, test := range tests {
+		s := newTestServer()
+		s.publications.listed = make([]webpublication.Publication, test.listed)
+		r := httptest.NewRequest("GET", "/publications/"+test.query, nil)
+		w := httptest.NewRecorder()
+
+		GetPublications(w, r, s)
+
+		links := w.Header()["Link"]
+		if len(links) != 1 {
+			t.Fatalf("%s: expected a single Link header, got %q", test.query, links)
+		}
+		if got := strings.Count(links[0], "rel="); got != len(test.expected) {
+			t.Errorf("%s: expected %d relations, got %q", test.query, len(test.expected), links[0])
+		}
+		for _, expected := range test.expected {
+			if !strings.Contains(links[0], expected) {
+				t.Errorf("%s: expected the Link header to contain %s, got %q", test.query, expected, links[0])
+			}
+		}
+	}
+}
+
+func TestDeletePublications(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		status int
+	}{
+		{"query", "/publications?ids=1,2", "", http.StatusOK},
+		{"body", "/publications", "[1, 2]", http.StatusOK},
+		{"invalid id", "/publications?ids=1,a", "", http.StatusBadRequest},
+		{"empty", "/publications", "[]", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		s := newTestServer()
+		s.publications.stored = &webpublication.Publication{ID: 1, UUID: "uuid", Title: "title", Status: webpublication.StatusOk}
+		r := httptest.NewRequest("DELETE", test.target, strings.NewReader(test.body))
+		w := httptest.NewRecorder()
+
+		DeletePublications(w, r, s)
+
+		if w.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d, %s", test.name, test.status, w.Code, w.Body.String())
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		var results []webpublication.DeleteResult
+		if err := json.NewDecoder(w.Body).Decode(&results); err != nil {
+			t.Fatalf("%s: could not decode the summary, %s", test.name, err)
+		}
+		if len(results) != 2 || !results[0].Deleted || results[1].Deleted || results[1].Error == "" {
+			t.Errorf("%s: expected the first publication only to be deleted, got %+v", test.name, results)
+		}
+	}
+}
+
+func TestCheckPublicationByTitle(t *testing.T) {
+	tests := []struct {
+		title    string
+		expected string
+	}{
+		{"title", `{"exists":true}`},
+		{"other", `{"exists":false}`},
+	}
+	for _, test := range tests {
+		s := newTestServer()
+		s.publications.stored = &webpublication.Publication{ID: 1, UUID: "uuid", Title: "title", Status: webpublication.StatusOk}
+		w := httptest.NewRecorder()
+		CheckPublicationByTitle(w, httptest.NewRequest("GET", "/publications/check-by-title?title="+test.title, nil), s)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected status %d, got %d", test.title, http.StatusOK, w.Code)
+		}
+		if body := strings.TrimSpace(w.Body.String()); body != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.title, test.expected, body)
+		}
+		if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("%s: expected a json response, got %s", test.title, contentType)
+		}
+	}
+}
+
+func TestCheckPublicationByTitleLogs(t *testing.T) {
+	tests := []struct {
+		name     string
+		level    logging.Level
+		expected []string
+	}{
+		{"info", logging.LevelInfo, []string{
+			"INFO Check publication stored with name other",
+			"INFO No publication stored with name other",
+		}},
+		{"warn", logging.LevelWarn, nil},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		s := newTestServer()
+		s.logger = logging.NewLevelLogger(log.New(&buf, "", 0), test.level)
+		CheckPublicationByTitle(httptest.NewRecorder(), httptest.NewRequest("GET", "/publications/check-by-title?title=other", nil), s)
+
+		var lines []string
+		if buf.Len() > 0 {
+			lines = strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		}
+		if len(lines) != len(test.expected) {
+			t.Fatalf("%s: expected %d log lines, got %q", test.name, len(test.expected), lines)
+		}
+		for i, line := range lines {
+			if line != test.expected[i] {
+				t.Errorf("%s: expected %q, got %q", test.name, test.expected[i], line)
+			}
+		}
+	}
+}
+
+func TestGetPublicationRequestID(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     string
+		propagated bool
+	}{
+		{"propagated", "req-1234", true},
+		{"generated", "", false},
+		{"too long", strings.Repeat("a", problem.MaxRequestIDLength+1), false},
+		{"unsafe characters", "req\r\nX-Injected: 1", false},
+	}
+	for _, test := range tests {
+		s := newTestServer()
+		r := httptest.NewRequest("GET", "/publications/42", nil)
+		r = mux.SetURLVars(r, map[string]string{"id": "42"})
+		if test.header != "" {
+			r.Header.Set(problem.HeaderRequestID, test.header)
+		}
+		w := httptest.NewRecorder()
+		api.RequestID(w, r, func(w http.ResponseWriter, r *http.Request) {
+			GetPublication(w, r, s)
+		})
+
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("%s: expected status %d, got %d", test.name, http.StatusNotFound, w.Code)
+		}
+		var p problem.Problem
+		if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		id := w.Header().Get(problem.HeaderRequestID)
+		if test.propagated && id != test.header {
+			t.Errorf("%s: expected the response header %s, got %s", test.name, test.header, id)
+		}
+		if !test.propagated && (id == test.header || len(id) != 32) {
+			t.Errorf("%s: expected a generated request id, got %q", test.name, id)
+		}
+		if id == "" || p.RequestID != id {
+			t.Errorf("%s: expected the request id %s in the problem, got %s", test.name, id, p.RequestID)
+		}
+	}
+}
+
+func TestGetPublicationsCSV(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		accept string
+		csv    bool
+	}{
+		{"default", "", "", false},
+		{"format", "?format=csv", "", true},
+		{"accept", "", "text/csv; charset=utf-8, application/json;q=0.5", true},
+		{"json format", "?format=json", "text/csv", false},
+	}
+	for _, test := range tests {
+		s := newTestServer()
+		s.publications.listed = []webpublication.Publication{{ID: 2, Title: "Moby Dick, or the Whale", Status: webpublication.StatusOk}}
+		r := httptest.NewRequest("GET", "/publications/"+test.query, nil)
+		if test.accept != "" {
+			r.Header.Set("Accept", test.accept)
+		}
+		w := httptest.NewRecorder()
+
+		GetPublications(w, r, s)
+
+		contentType := w.Header().Get("Content-Type")
+		if !test.csv {
+			if contentType != "application/json" {
+				t.Errorf("%s: expected a json list, got %s", test.name, contentType)
+			}
+			continue
+		}
+		if contentType != "text/csv" {
+			t.Errorf("%s: expected a csv list, got %s", test.name, contentType)
+		}
+		expected := "id,title,status\n2,\"Moby Dick, or the Whale\",ok\n"
+		if body := w.Body.String(); body != expected {
+			t.Errorf("%s: expected %q, got %q", test.name, expected, body)
+		}
+	}
+}
@@ -132,7 +132,7 @@ export class PublicationFormComponent implements OnInit {
                 this.publicationService.checkByName(this.form.value['title']).then(
                     result => {
                         // if there is no duplicate
-                        if (result === 0) {
+                        if (result === false) {
                             // upload the publication
                             if (this.form.value["type"] === "UPLOAD") {
                                 let options = {url: this.baseUrl + "/publicationUpload?title=" + this.form.value['title']};
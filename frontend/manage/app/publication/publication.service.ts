@@ -40,7 +40,7 @@ export class PublicationService extends CrudService<Publication> {
         }
     }
 
-    checkByName(name: string): Promise<number> {
+    checkByName(name: string): Promise<boolean> {
         var self = this
         return this.http
             .get(
@@ -49,7 +49,7 @@ export class PublicationService extends CrudService<Publication> {
             .toPromise()
             .then(function (response) {
                 let jsonObj = response.json();
-                return jsonObj;
+                return jsonObj.exists;
             })
             .catch(this.handleError);
     }
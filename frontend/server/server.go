@@ -32,6 +32,7 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/claudiu/gocron"
@@ -45,6 +46,7 @@ import (
 	"github.com/readium/readium-lcp-server/frontend/webpurchase"
 	"github.com/readium/readium-lcp-server/frontend/webrepository"
 	"github.com/readium/readium-lcp-server/frontend/webuser"
+	"github.com/readium/readium-lcp-server/logging"
 )
 
 //Server struct contains server info and  db interfaces
@@ -58,6 +60,7 @@ type Server struct {
 	dashboard    webdashboard.WebDashboard
 	license      weblicense.WebLicense
 	purchases    webpurchase.WebPurchase
+	logger       logging.Logger
 }
 
 // HandlerFunc defines a function handled by the server
@@ -92,6 +95,12 @@ func New(
 		license:      licenseAPI,
 		purchases:    purchaseAPI}
 
+	level, err := logging.ParseLevel(config.Config.FrontendServer.LogLevel)
+	if err != nil {
+		log.Println(err.Error() + ", using the info level")
+	}
+	s.logger = logging.NewLevelLogger(log.New(os.Stderr, "", log.LstdFlags), level)
+
 	// Cron, get license status information
 	gocron.Start()
 	gocron.Every(10).Minutes().Do(fetchLicenseStatusesTask, s)
@@ -120,12 +129,16 @@ func New(
 	//
 	s.handleFunc(sr.R, publicationsRoutesPathPrefix, staticapi.CreatePublication).Methods("POST")
 	//
+	s.handleFunc(sr.R, publicationsRoutesPathPrefix, staticapi.DeletePublications).Methods("DELETE")
+	//
 	s.handleFunc(sr.R, "/publicationUpload", staticapi.UploadPublication).Methods("POST")
 	//
 	s.handleFunc(publicationsRoutes, "/check-by-title", staticapi.CheckPublicationByTitle).Methods("GET")
+	s.handleFunc(publicationsRoutes, "/search", staticapi.SearchPublications).Methods("GET")
 	//
 	s.handleFunc(publicationsRoutes, "/{id}", staticapi.GetPublication).Methods("GET")
 	s.handleFunc(publicationsRoutes, "/{id}", staticapi.UpdatePublication).Methods("PUT")
+	s.handleFunc(publicationsRoutes, "/{id}", staticapi.PatchPublication).Methods("PATCH")
 	s.handleFunc(publicationsRoutes, "/{id}", staticapi.DeletePublication).Methods("DELETE")
 	//
 	// user functions
@@ -237,6 +250,11 @@ func (server *Server) LicenseAPI() weblicense.WebLicense {
 	return server.license
 }
 
+//Logger ( staticapi.IServer ) returns the leveled logger of the handlers
+func (server *Server) Logger() logging.Logger {
+	return server.logger
+}
+
 func (server *Server) handleFunc(router *mux.Router, route string, fn HandlerFunc) *mux.Route {
 	return router.HandleFunc(route, func(w http.ResponseWriter, r *http.Request) {
 		fn(w, r, server)
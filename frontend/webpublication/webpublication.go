@@ -28,6 +28,7 @@ import (
 	apilcp "github.com/readium/readium-lcp-server/lcpserver/api"
 	"github.com/readium/readium-lcp-server/license"
 	"github.com/readium/readium-lcp-server/pack"
+	"github.com/readium/readium-lcp-server/problem"
 	uuid "github.com/satori/go.uuid"
 
 	"github.com/Machiel/slugify"
@@ -44,16 +45,48 @@ const (
 // ErrNotFound error trown when publication is not found
 var ErrNotFound = errors.New("Publication not found")
 
+// Sort fields and orders of a list of publications
+const (
+	SortID      = "id"
+	SortTitle   = "title"
+	SortCreated = "created"
+	OrderAsc    = "asc"
+	OrderDesc   = "desc"
+)
+
+// sortColumns maps the sort fields to columns of the publication table;
+// ids are assigned in creation order, there is no creation date in the table
+var sortColumns = map[string]string{
+	SortID:      "id",
+	SortTitle:   "title",
+	SortCreated: "id",
+}
+
+// ValidateSort checks a sort field and order of a list of publications
+func ValidateSort(sort string, order string) error {
+	if _, ok := sortColumns[sort]; !ok {
+		return fmt.Errorf("invalid sort field %q, expected one of id, title, created", sort)
+	}
+	if order != OrderAsc && order != OrderDesc {
+		return fmt.Errorf("invalid sort order %q, expected asc or desc", order)
+	}
+	return nil
+}
+
 // WebPublication interface for publication db interaction
 type WebPublication interface {
 	Get(id int64) (Publication, error)
 	GetByUUID(uuid string) (Publication, error)
-	Add(publication Publication) error
+	Add(publication Publication) (Publication, error)
 	Update(publication Publication) error
 	Delete(id int64) error
+	DeleteList(ids []int64) ([]DeleteResult, error)
 	List(page int, pageNum int) func() (Publication, error)
-	Upload(*http.Request, http.ResponseWriter, Publication)
+	ListSorted(page int, pageNum int, sort string, order string) func() (Publication, error)
+	ListAfter(after int64, page int) func() (Publication, error)
+	Upload(*http.Request, http.ResponseWriter, Publication, pack.PackOptions)
 	CheckByTitle(title string) (int64, error)
+	Search(query string, page int, pageNum int) func() (Publication, error)
 }
 
 // Publication struct defines a publication
@@ -65,6 +98,29 @@ type Publication struct {
 	MasterFilename string `json:"masterFilename,omitempty"`
 }
 
+// maxTitleLength is the size of the title column of the publication table
+const maxTitleLength = 255
+
+// Validate checks the fields of a publication sent by a client and returns the invalid ones:
+// the id cannot be negative, the title is required and the status, if set, must be known.
+func (pub Publication) Validate() []problem.ValidationError {
+	var errs []problem.ValidationError
+	if pub.ID < 0 {
+		errs = append(errs, problem.ValidationError{Field: "id", Message: "the id cannot be negative"})
+	}
+	if strings.TrimSpace(pub.Title) == "" {
+		errs = append(errs, problem.ValidationError{Field: "title", Message: "the title is required"})
+	} else if len(pub.Title) > maxTitleLength {
+		errs = append(errs, problem.ValidationError{Field: "title", Message: fmt.Sprintf("the title cannot be longer than %d bytes", maxTitleLength)})
+	}
+	switch pub.Status {
+	case "", StatusDraft, StatusEncrypting, StatusError, StatusOk:
+	default:
+		errs = append(errs, problem.ValidationError{Field: "status", Message: "unknown status " + pub.Status})
+	}
+	return errs
+}
+
 // PublicationManager helper
 type PublicationManager struct {
 	config config.Configuration
@@ -129,6 +185,9 @@ func (pubManager PublicationManager) CheckByTitle(title string) (int64, error) {
 	defer dbGetByTitle.Close()
 
 	records, err := dbGetByTitle.Query(title)
+	if err != nil {
+		return -1, err
+	}
 	if records.Next() {
 		var res int64
 		err = records.Scan(&res)
@@ -141,13 +200,44 @@ func (pubManager PublicationManager) CheckByTitle(title string) (int64, error) {
 	return -1, ErrNotFound
 }
 
+// Search lists the publications whose title contains a query, case-insensitively, within a given range.
+// Parameters: page = number of items per page; pageNum = page offset (0 for the first page)
+// Note: the authors of the publications are not stored in the frontend database, and can't be searched.
+func (pubManager PublicationManager) Search(query string, page int, pageNum int) func() (Publication, error) {
+
+	dbSearch, err := pubManager.db.Prepare(`SELECT id, uuid, title, status FROM publication
+	WHERE LOWER(title) LIKE ? ESCAPE '!' ORDER BY title, id LIMIT ? OFFSET ?`)
+	if err != nil {
+		return func() (Publication, error) { return Publication{}, err }
+	}
+	defer dbSearch.Close()
+
+	// the wildcards of the query are matched literally; '!' is the escape character,
+	// as a backslash would escape the closing quote of the ESCAPE clause on MySQL
+	pattern := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(strings.ToLower(query))
+	records, err := dbSearch.Query("%"+pattern+"%", page, pageNum*page)
+	if err != nil {
+		return func() (Publication, error) { return Publication{}, err }
+	}
+	return func() (Publication, error) {
+		var pub Publication
+		if records.Next() {
+			err := records.Scan(&pub.ID, &pub.UUID, &pub.Title, &pub.Status)
+			return pub, err
+		}
+		records.Close()
+		return pub, ErrNotFound
+	}
+}
+
 // encryptPublication encrypts an EPUB, PDF or LPF file and provides the resulting file to the LCP server
-func encryptPublication(inputPath string, pub Publication, pubManager PublicationManager) error {
+// Packaging options only apply to PDF and LPF files, which are converted to Readium packages.
+func encryptPublication(inputPath string, pub Publication, pubManager PublicationManager, opts pack.PackOptions) (Publication, error) {
 
 	// generate a new uuid; this will be the content id in the lcp server
 	uid, err := uuid.NewV4()
 	if err != nil {
-		return err
+		return Publication{}, err
 	}
 	contentUUID := uid.String()
 
@@ -179,10 +269,10 @@ func encryptPublication(inputPath string, pub Publication, pubManager Publicatio
 		err = pack.BuildRWPPFromPDF(pub.Title, inputPath, clearWebPubPath)
 		if err != nil {
 			log.Printf("Error building webpub package: %s", err)
-			return err
+			return Publication{}, err
 		}
 		defer os.Remove(clearWebPubPath)
-		encryptedPub, err = encrypt.EncryptWebPubPackage(lcpProfile, clearWebPubPath, outputPath)
+		encryptedPub, err = encrypt.EncryptWebPubPackageWithOptions(lcpProfile, clearWebPubPath, outputPath, opts)
 
 		// process LPF files
 	} else if strings.HasSuffix(inputPath, ".lpf") {
@@ -192,14 +282,14 @@ func encryptPublication(inputPath string, pub Publication, pubManager Publicatio
 		err = pack.BuildRWPPFromLPF(inputPath, clearWebPubPath)
 		if err != nil {
 			log.Printf("Error building webpub package: %s", err)
-			return err
+			return Publication{}, err
 		}
 		defer os.Remove(clearWebPubPath)
-		encryptedPub, err = encrypt.EncryptWebPubPackage(lcpProfile, clearWebPubPath, outputPath)
+		encryptedPub, err = encrypt.EncryptWebPubPackageWithOptions(lcpProfile, clearWebPubPath, outputPath, opts)
 
 		// unknown file
 	} else {
-		return errors.New("Could not match the filename")
+		return Publication{}, errors.New("Could not match the filename")
 	}
 
 	if err != nil {
@@ -207,7 +297,7 @@ func encryptPublication(inputPath string, pub Publication, pubManager Publicatio
 		if _, statErr := os.Stat(inputPath); statErr == nil {
 			os.Remove(inputPath)
 		}
-		return err
+		return Publication{}, err
 	}
 
 	// prepare the import request to the lcp server
@@ -222,23 +312,57 @@ func encryptPublication(inputPath string, pub Publication, pubManager Publicatio
 	lcpPublication.Size = &encryptedPub.Size
 	lcpPublication.ContentType = contentType
 
+	// send the content to the LCP server
+	if err = notifyLcpServer(pubManager.config, lcpPublication); err != nil {
+		return Publication{}, err
+	}
+
+	// store the new publication in the db
+	// the publication uuid is the lcp db content id.
+	pub.UUID = contentUUID
+	pub.Status = StatusOk
+	dbAdd, err := pubManager.db.Prepare("INSERT INTO publication (uuid, title, status) VALUES ( ?, ?, ?)")
+	if err != nil {
+		return Publication{}, err
+	}
+	defer dbAdd.Close()
+
+	result, err := dbAdd.Exec(
+		pub.UUID,
+		pub.Title,
+		pub.Status)
+	if err != nil {
+		return Publication{}, err
+	}
+	pub.ID, err = result.LastInsertId()
+	return pub, err
+}
+
+// LcpServerError is returned when the LCP server does not create a content sent by the frontend
+type LcpServerError struct {
+	StatusCode int
+}
+
+func (e *LcpServerError) Error() string {
+	return fmt.Sprintf("the LCP server answered %d %s to the content import", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// notifyLcpServer sends an encrypted content to the LCP server, which must answer 201
+func notifyLcpServer(c config.Configuration, lcpPublication apilcp.LcpPublication) error {
 	// json encode the payload
 	jsonBody, err := json.Marshal(lcpPublication)
 	if err != nil {
 		return err
 	}
-	// send the content to the LCP server
-	lcpServerConfig := pubManager.config.LcpServer
-	lcpURL := lcpServerConfig.PublicBaseUrl + "/contents/" + contentUUID
+	lcpURL := c.LcpServer.PublicBaseUrl + "/contents/" + lcpPublication.ContentId
 	log.Println("PUT " + lcpURL)
 	req, err := http.NewRequest("PUT", lcpURL, bytes.NewReader(jsonBody))
 	if err != nil {
 		return err
 	}
 	// authenticate
-	lcpUpdateAuth := pubManager.config.LcpUpdateAuth
-	if pubManager.config.LcpUpdateAuth.Username != "" {
-		req.SetBasicAuth(lcpUpdateAuth.Username, lcpUpdateAuth.Password)
+	if c.LcpUpdateAuth.Username != "" {
+		req.SetBasicAuth(c.LcpUpdateAuth.Username, c.LcpUpdateAuth.Password)
 	}
 	// set the payload type
 	req.Header.Add("Content-Type", api.ContentType_LCP_JSON)
@@ -251,32 +375,19 @@ func encryptPublication(inputPath string, pub Publication, pubManager Publicatio
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 
-	if resp.StatusCode != 201 {
+	if resp.StatusCode != http.StatusCreated {
 		// error on creation
-		return err
-	}
-
-	// store the new publication in the db
-	// the publication uuid is the lcp db content id.
-	pub.UUID = contentUUID
-	pub.Status = StatusOk
-	dbAdd, err := pubManager.db.Prepare("INSERT INTO publication (uuid, title, status) VALUES ( ?, ?, ?)")
-	if err != nil {
-		return err
+		return &LcpServerError{StatusCode: resp.StatusCode}
 	}
-	defer dbAdd.Close()
-
-	_, err = dbAdd.Exec(
-		pub.UUID,
-		pub.Title,
-		pub.Status)
-	return err
+	return nil
 }
 
 // Add adds a new publication
 // Encrypts a master File and sends the content to the LCP server
-func (pubManager PublicationManager) Add(pub Publication) error {
+// Returns the created publication, with its id and uuid
+func (pubManager PublicationManager) Add(pub Publication) (Publication, error) {
 
 	// get the path to the master file
 	inputPath := path.Join(
@@ -284,25 +395,29 @@ func (pubManager PublicationManager) Add(pub Publication) error {
 
 	if _, err := os.Stat(inputPath); err != nil {
 		// the master file does not exist
-		return err
+		return Publication{}, err
 	}
 	// encrypt the publication and send the content to the LCP server
-	return encryptPublication(inputPath, pub, pubManager)
+	return encryptPublication(inputPath, pub, pubManager, pack.PackOptions{})
 }
 
 // Upload creates a new publication, named after a POST form parameter.
 // Encrypts a master File and sends the content to the LCP server.
 // A temp file is created then deleted.
-func (pubManager PublicationManager) Upload(r *http.Request, w http.ResponseWriter, pub Publication) {
+func (pubManager PublicationManager) Upload(r *http.Request, w http.ResponseWriter, pub Publication, opts pack.PackOptions) {
 
 	file, header, err := r.FormFile("file")
+	if err != nil {
+		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusBadRequest)
+		return
+	}
 
 	ext := filepath.Ext(header.Filename)
 
 	tmpfile, err := ioutil.TempFile("", "inputpub.*"+ext)
 
 	if err != nil {
-		fmt.Fprintln(w, err)
+		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusInternalServerError)
 		return
 	}
 
@@ -314,7 +429,7 @@ func (pubManager PublicationManager) Upload(r *http.Request, w http.ResponseWrit
 		log.Fatal(err)
 	}
 	// encrypt the publication and send the content to the LCP server
-	if err := encryptPublication(tmpfile.Name(), pub, pubManager); err != nil {
+	if _, err := encryptPublication(tmpfile.Name(), pub, pubManager, opts); err != nil {
 		log.Fatal(err)
 	}
 
@@ -322,8 +437,8 @@ func (pubManager PublicationManager) Upload(r *http.Request, w http.ResponseWrit
 	fmt.Fprintf(w, header.Filename)
 }
 
-// Update updates a publication
-// Only the title is updated
+// Update updates the title and status of a publication.
+// The uuid is the content id of the LCP server, assigned once by encryptPublication, and is not updated.
 func (pubManager PublicationManager) Update(pub Publication) error {
 
 	dbUpdate, err := pubManager.db.Prepare("UPDATE publication SET title=?, status=? WHERE id = ?")
@@ -400,11 +515,78 @@ func (pubManager PublicationManager) Delete(id int64) error {
 	return err
 }
 
-// List lists publications within a given range
+// DeleteResult is the outcome of the deletion of a publication in a batch
+type DeleteResult struct {
+	ID      int64  `json:"id"`
+	Deleted bool   `json:"deleted"`
+	Error   string `json:"error,omitempty"`
+}
+
+// DeleteList deletes a list of publications and their purchases in a single transaction.
+// A publication which cannot be deleted, e.g. an unknown one, does not abort the deletion of the others;
+// the outcome of each deletion is returned in the order of the ids.
+func (pubManager PublicationManager) DeleteList(ids []int64) ([]DeleteResult, error) {
+
+	tx, err := pubManager.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]DeleteResult, 0, len(ids))
+	var titles []string
+	for _, id := range ids {
+		result := DeleteResult{ID: id}
+		var title string
+		err := tx.QueryRow("SELECT title FROM publication WHERE id = ?", id).Scan(&title)
+		if err == nil {
+			if _, err = tx.Exec("DELETE FROM purchase WHERE publication_id = ?", id); err == nil {
+				_, err = tx.Exec("DELETE FROM publication WHERE id = ?", id)
+			}
+		}
+		switch err {
+		case nil:
+			result.Deleted = true
+			titles = append(titles, title)
+		case sql.ErrNoRows:
+			result.Error = ErrNotFound.Error()
+		default:
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	// the master files are only removed once the deletions are committed
+	for _, title := range titles {
+		inputPath := path.Join(pubManager.config.FrontendServer.MasterRepository, title+".epub")
+		if _, err := os.Stat(inputPath); err == nil {
+			if err = os.Remove(inputPath); err != nil {
+				log.Println("Error removing the master file " + inputPath + ": " + err.Error())
+			}
+		}
+	}
+	return results, nil
+}
+
+// List lists publications within a given range, the latest first
 // Parameters: page = number of items per page; pageNum = page offset (0 for the first page)
 func (pubManager PublicationManager) List(page int, pageNum int) func() (Publication, error) {
+	return pubManager.ListSorted(page, pageNum, SortID, OrderDesc)
+}
+
+// ListSorted lists publications within a given range, sorted by a field (see ValidateSort) in a given order.
+// Publications with the same title are sorted by id.
+func (pubManager PublicationManager) ListSorted(page int, pageNum int, sort string, order string) func() (Publication, error) {
+	if err := ValidateSort(sort, order); err != nil {
+		return func() (Publication, error) { return Publication{}, err }
+	}
 
-	dbList, err := pubManager.db.Prepare("SELECT id, uuid, title, status FROM publication ORDER BY id desc LIMIT ? OFFSET ?")
+	// the column and order are validated, they can't be passed as query parameters
+	dbList, err := pubManager.db.Prepare("SELECT id, uuid, title, status FROM publication ORDER BY " +
+		sortColumns[sort] + " " + order + ", id " + order + " LIMIT ? OFFSET ?")
 	if err != nil {
 		return func() (Publication, error) { return Publication{}, err }
 	}
@@ -433,6 +615,31 @@ func (pubManager PublicationManager) List(page int, pageNum int) func() (Publica
 	}
 }
 
+// ListAfter lists the publications whose id is greater than a cursor, by increasing id.
+// Parameters: after = id of the last publication of the previous page (0 for the first page); page = number of items per page
+// Unlike ListSorted, the database does not scan the previous pages, and the pages are stable
+// when publications are added between two calls.
+func (pubManager PublicationManager) ListAfter(after int64, page int) func() (Publication, error) {
+	dbList, err := pubManager.db.Prepare("SELECT id, uuid, title, status FROM publication WHERE id > ? ORDER BY id LIMIT ?")
+	if err != nil {
+		return func() (Publication, error) { return Publication{}, err }
+	}
+	defer dbList.Close()
+	records, err := dbList.Query(after, page)
+	if err != nil {
+		return func() (Publication, error) { return Publication{}, err }
+	}
+	return func() (Publication, error) {
+		var pub Publication
+		if records.Next() {
+			err := records.Scan(&pub.ID, &pub.UUID, &pub.Title, &pub.Status)
+			return pub, err
+		}
+		records.Close()
+		return pub, ErrNotFound
+	}
+}
+
 // Init initializes the publication manager
 // Creates the publication db table.
 func Init(config config.Configuration, db *sql.DB) (i WebPublication, err error) {
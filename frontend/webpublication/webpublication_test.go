@@ -0,0 +1,303 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package webpublication
+
+import (
+	"bytes"
+	"database/sql"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/readium/readium-lcp-server/config"
+	apilcp "github.com/readium/readium-lcp-server/lcpserver/api"
+	"github.com/readium/readium-lcp-server/pack"
+	"github.com/readium/readium-lcp-server/problem"
+)
+
+func TestListSorted(t *testing.T) {
+	var c config.Configuration
+	c.FrontendServer.Database = "sqlite"
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1) // every connection opens its own in-memory database
+	pubs, err := Init(c, db)
+	if err != nil {
+		t.Fatalf("Could not init the publications, %s", err)
+	}
+	for _, title := range []string{"Beta", "Gamma", "Alpha"} {
+		if _, err = db.Exec("INSERT INTO publication (uuid, title, status) VALUES (?, ?, ?)", title, title, StatusOk); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		sort     string
+		order    string
+		expected []string
+	}{
+		{SortTitle, OrderAsc, []string{"Alpha", "Beta", "Gamma"}},
+		{SortTitle, OrderDesc, []string{"Gamma", "Beta", "Alpha"}},
+		{SortCreated, OrderAsc, []string{"Beta", "Gamma", "Alpha"}},
+		{SortID, OrderDesc, []string{"Alpha", "Gamma", "Beta"}},
+	}
+	for _, test := range tests {
+		var titles []string
+		fn := pubs.ListSorted(10, 0, test.sort, test.order)
+		for pub, err := fn(); err == nil; pub, err = fn() {
+			titles = append(titles, pub.Title)
+		}
+		if len(titles) != len(test.expected) {
+			t.Errorf("Expected %v sorted by %s %s, got %v", test.expected, test.sort, test.order, titles)
+			continue
+		}
+		for i := range titles {
+			if titles[i] != test.expected[i] {
+				t.Errorf("Expected %v sorted by %s %s, got %v", test.expected, test.sort, test.order, titles)
+				break
+			}
+		}
+	}
+
+	if _, err = pubs.ListSorted(10, 0, "status", OrderAsc)(); err == nil || err == ErrNotFound {
+		t.Errorf("Expected an invalid sort field to be rejected, got %v", err)
+	}
+}
+
+func TestListAfter(t *testing.T) {
+	var c config.Configuration
+	c.FrontendServer.Database = "sqlite"
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1) // every connection opens its own in-memory database
+	pubs, err := Init(c, db)
+	if err != nil {
+		t.Fatalf("Could not init the publications, %s", err)
+	}
+	insert := func(titles ...string) {
+		for _, title := range titles {
+			if _, err := db.Exec("INSERT INTO publication (uuid, title, status) VALUES (?, ?, ?)", title, title, StatusOk); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	list := func(after int64) (titles []string, last int64) {
+		fn := pubs.ListAfter(after, 2)
+		for pub, err := fn(); err == nil; pub, err = fn() {
+			titles = append(titles, pub.Title)
+			last = pub.ID
+		}
+		return
+	}
+
+	insert("A", "B", "C", "D")
+	first, cursor := list(0)
+	// publications added after the first page must neither shift nor repeat the next pages
+	insert("E", "F")
+	var titles []string
+	titles = append(titles, first...)
+	for page, last := list(cursor); len(page) > 0; page, last = list(last) {
+		titles = append(titles, page...)
+	}
+	if got := strings.Join(titles, ""); got != "ABCDEF" {
+		t.Errorf("Expected ABCDEF listed once, got %s", got)
+	}
+}
+
+func TestNotifyLcpServer(t *testing.T) {
+	tests := []struct {
+		status   int
+		expected error
+	}{
+		{http.StatusCreated, nil},
+		{http.StatusInternalServerError, &LcpServerError{StatusCode: http.StatusInternalServerError}},
+		{http.StatusUnauthorized, &LcpServerError{StatusCode: http.StatusUnauthorized}},
+	}
+	for _, test := range tests {
+		lcp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "PUT" || r.URL.Path != "/contents/content-id" {
+				t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+			}
+			w.WriteHeader(test.status)
+		}))
+		var c config.Configuration
+		c.LcpServer.PublicBaseUrl = lcp.URL
+		err := notifyLcpServer(c, apilcp.LcpPublication{ContentId: "content-id"})
+		lcp.Close()
+
+		if test.expected == nil {
+			if err != nil {
+				t.Errorf("%d: expected no error, got %s", test.status, err)
+			}
+			continue
+		}
+		lcpErr, ok := err.(*LcpServerError)
+		if !ok || *lcpErr != *test.expected.(*LcpServerError) {
+			t.Errorf("%d: expected %v, got %v", test.status, test.expected, err)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	var c config.Configuration
+	c.FrontendServer.Database = "sqlite"
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1) // every connection opens its own in-memory database
+	pubs, err := Init(c, db)
+	if err != nil {
+		t.Fatalf("Could not init the publications, %s", err)
+	}
+	for _, title := range []string{"The Moonstone", "Moon Tiger", "Dune", "100% Moon", "Moon_Light!", "Moon\\Light"} {
+		if _, err = db.Exec("INSERT INTO publication (uuid, title, status) VALUES (?, ?, ?)", title, title, StatusOk); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	search := func(query string, page int, pageNum int) []string {
+		var titles []string
+		fn := pubs.Search(query, page, pageNum)
+		pub, err := fn()
+		for ; err == nil; pub, err = fn() {
+			titles = append(titles, pub.Title)
+		}
+		if err != ErrNotFound {
+			t.Fatalf("Expected the search of %q to end with ErrNotFound, got %v", query, err)
+		}
+		return titles
+	}
+
+	tests := []struct {
+		query    string
+		page     int
+		pageNum  int
+		expected string
+	}{
+		{"moon", 10, 0, "100% Moon,Moon Tiger,Moon\\Light,Moon_Light!,The Moonstone"},
+		{"moon", 2, 1, "Moon\\Light,Moon_Light!"},
+		{"% m", 10, 0, "100% Moon"},
+		{"_l", 10, 0, "Moon_Light!"},
+		{"!", 10, 0, "Moon_Light!"},
+		{"\\", 10, 0, "Moon\\Light"},
+		{"star", 10, 0, ""},
+	}
+	for _, test := range tests {
+		if titles := strings.Join(search(test.query, test.page, test.pageNum), ","); titles != test.expected {
+			t.Errorf("Expected %q to match %q, got %q", test.query, test.expected, titles)
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	var c config.Configuration
+	c.FrontendServer.Database = "sqlite"
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	pubs, err := Init(c, db)
+	if err != nil {
+		t.Fatalf("Could not init the publications, %s", err)
+	}
+	if _, err = db.Exec("INSERT INTO publication (uuid, title, status) VALUES (?, ?, ?)", "uuid", "title", StatusOk); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = pubs.Update(Publication{ID: 1, UUID: "new-uuid", Title: "new title", Status: StatusOk}); err != nil {
+		t.Fatalf("Could not update the publication, %s", err)
+	}
+	pub, err := pubs.Get(1)
+	if err != nil {
+		t.Fatalf("Could not get the publication, %s", err)
+	}
+	// the uuid is the content id of the LCP server
+	if pub.UUID != "uuid" || pub.Title != "new title" {
+		t.Errorf("Expected the new title to be stored with the original uuid, got %+v", pub)
+	}
+}
+
+func TestDeleteList(t *testing.T) {
+	var c config.Configuration
+	c.FrontendServer.Database = "sqlite"
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	pubs, err := Init(c, db)
+	if err != nil {
+		t.Fatalf("Could not init the publications, %s", err)
+	}
+	if _, err = db.Exec("CREATE TABLE purchase (id integer NOT NULL PRIMARY KEY, publication_id integer NOT NULL)"); err != nil {
+		t.Fatal(err)
+	}
+	for _, title := range []string{"one", "two", "three"} {
+		if _, err = db.Exec("INSERT INTO publication (uuid, title, status) VALUES (?, ?, ?)", title, title, StatusOk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = db.Exec("INSERT INTO purchase (publication_id) VALUES (1)"); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := pubs.DeleteList([]int64{1, 42, 3})
+	if err != nil {
+		t.Fatalf("Could not delete the publications, %s", err)
+	}
+	expected := []DeleteResult{{ID: 1, Deleted: true}, {ID: 42, Error: ErrNotFound.Error()}, {ID: 3, Deleted: true}}
+	if len(results) != len(expected) {
+		t.Fatalf("Expected %d results, got %+v", len(expected), results)
+	}
+	for i := range results {
+		if results[i] != expected[i] {
+			t.Errorf("Expected the result %+v, got %+v", expected[i], results[i])
+		}
+	}
+
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM publication").Scan(&count)
+	if count != 1 {
+		t.Errorf("Expected a single publication to be left, got %d", count)
+	}
+	db.QueryRow("SELECT COUNT(*) FROM purchase").Scan(&count)
+	if count != 0 {
+		t.Errorf("Expected the purchases of the publication to be deleted, got %d", count)
+	}
+}
+
+func TestUploadMissingFile(t *testing.T) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("title", "test")
+	mw.Close()
+	r := httptest.NewRequest("POST", "/publications/upload?title=test", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+
+	PublicationManager{}.Upload(r, w, Publication{Title: "test"}, pack.PackOptions{})
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != problem.ContentType_PROBLEM_JSON {
+		t.Errorf("Expected a problem, got the content type %s", contentType)
+	}
+}
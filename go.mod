@@ -1,6 +1,6 @@
 module github.com/readium/readium-lcp-server
 
-go 1.13
+go 1.17
 
 require (
 	github.com/Machiel/slugify v1.0.1
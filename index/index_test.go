@@ -55,3 +55,33 @@ func TestIndexCreation(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestIndexUpdate(t *testing.T) {
+	config.Config.LcpServer.Database = "sqlite" // FIXME
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := Open(db)
+	if err != nil {
+		t.Fatalf("Can't open index, %s", err)
+	}
+
+	if err = idx.Add(Content{Id: "test", EncryptionKey: []byte("1234"), Location: "test.epub", Type: "application/epub+zip"}); err != nil {
+		t.Fatal(err)
+	}
+	updated := Content{Id: "test", EncryptionKey: []byte("5678"), Location: "test.lcpdf", Length: 42, Sha256: "abcd", Type: "application/pdf+lcp"}
+	if err = idx.Update(updated); err != nil {
+		t.Fatal(err)
+	}
+
+	// the content is found by its id, with every field updated
+	c, err := idx.Get("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(c.EncryptionKey) != "5678" || c.Location != updated.Location || c.Length != updated.Length || c.Sha256 != updated.Sha256 || c.Type != updated.Type {
+		t.Errorf("Expected the content to be updated to %+v, got %+v", updated, c)
+	}
+}
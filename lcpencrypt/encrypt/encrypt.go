@@ -40,9 +40,18 @@ func encryptionError(message string) (EncryptionArtifact, error) {
 // EncryptWebPubPackage generates an encrypted output RWPP out of the input RWPP
 // It is called from the test frontend server
 func EncryptWebPubPackage(profile license.EncryptionProfile, inputPath string, outputPath string) (EncryptionArtifact, error) {
+	return EncryptWebPubPackageWithOptions(profile, inputPath, outputPath, pack.PackOptions{})
+}
 
-	// create an AES encrypter for publication resources
-	encrypter := crypto.NewAESEncrypter_PUBLICATION_RESOURCES()
+// EncryptWebPubPackageWithOptions generates an encrypted output RWPP out of the input RWPP,
+// using specific packaging options
+func EncryptWebPubPackageWithOptions(profile license.EncryptionProfile, inputPath string, outputPath string, opts pack.PackOptions) (EncryptionArtifact, error) {
+
+	// create an AES encrypter for publication resources, using the mode set in the options
+	encrypter, err := opts.NewEncrypter()
+	if err != nil {
+		return encryptionError(err.Error())
+	}
 
 	// create a reader on the un-encrypted readium package
 	reader, err := pack.OpenRWPP(inputPath)
@@ -56,12 +65,12 @@ func EncryptWebPubPackage(profile license.EncryptionProfile, inputPath string, o
 	}
 	defer outputFile.Close()
 	// create a writer on the encrypted package
-	writer, err := reader.NewWriter(outputFile)
+	writer, err := reader.NewWriterWithOptions(outputFile, opts)
 	if err != nil {
 		return encryptionError("Unable to create output writer")
 	}
 	// encrypt resources from the input package, return the encryption key
-	encryptionKey, err := pack.Process(profile, encrypter, reader, writer)
+	encryptionKey, _, err := pack.ProcessWithOptions(profile, encrypter, reader, writer, opts)
 	if err != nil {
 		return encryptionError("Unable to encrypt file")
 	}
@@ -158,7 +158,6 @@ func outputExtension(sourceExt string) string {
 }
 
 // buildEncryptedRWPP builds an encrypted Readium package out of an un-encrypted one
-// FIXME: it cannot be used for EPUB as long as Do() and Process() are not merged
 func buildEncryptedRWPP(pub *apilcp.LcpPublication, inputPath string, encrypter crypto.Encrypter, lcpProfile license.EncryptionProfile) error {
 
 	// create a reader on the un-encrypted readium package
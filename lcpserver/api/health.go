@@ -0,0 +1,42 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package apilcp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/readium/readium-lcp-server/api"
+	"github.com/readium/readium-lcp-server/problem"
+)
+
+// ReadyTimeout bounds the time spent pinging the database in Ready
+const ReadyTimeout = 2 * time.Second
+
+// healthStatus is the json response of the health and readiness probes
+type healthStatus struct {
+	Status string `json:"status"`
+}
+
+// Health is a liveness probe: it returns 200 as long as the server answers
+func Health(w http.ResponseWriter, r *http.Request, s Server) {
+	w.Header().Set("Content-Type", api.ContentType_JSON)
+	json.NewEncoder(w).Encode(healthStatus{Status: "ok"})
+}
+
+// Ready is a readiness probe: it pings the license database and returns 503 if it is unreachable
+func Ready(w http.ResponseWriter, r *http.Request, s Server) {
+	ctx, cancel := context.WithTimeout(r.Context(), ReadyTimeout)
+	defer cancel()
+
+	if err := s.DB().PingContext(ctx); err != nil {
+		problem.Error(w, r, problem.Problem{Detail: "the database is unreachable: " + err.Error()}, http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", api.ContentType_JSON)
+	json.NewEncoder(w).Encode(healthStatus{Status: "ok"})
+}
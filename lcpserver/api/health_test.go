@@ -0,0 +1,50 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package apilcp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	w := httptest.NewRecorder()
+	Health(w, httptest.NewRequest("GET", "/health", nil), s)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if body := strings.TrimSpace(w.Body.String()); body != `{"status":"ok"}` {
+		t.Errorf("Expected an ok status, got %s", body)
+	}
+}
+
+func TestReady(t *testing.T) {
+	tests := []struct {
+		name     string
+		closed   bool
+		expected int
+	}{
+		{"open", false, http.StatusOK},
+		{"closed", true, http.StatusServiceUnavailable},
+	}
+	for _, test := range tests {
+		s, cleanup := newTestServer(t)
+		if test.closed {
+			s.DB().Close()
+		}
+		w := httptest.NewRecorder()
+		Ready(w, httptest.NewRequest("GET", "/ready", nil), s)
+		cleanup()
+
+		if w.Code != test.expected {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.expected, w.Code)
+		}
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -178,7 +179,8 @@ func copyZipFiles(out *zip.Writer, in *zip.Reader) error {
 func isWebPub(in *zip.Reader) bool {
 
 	for _, f := range in.File {
-		if f.Name == "manifest.json" {
+		// some tools change the casing of the manifest name
+		if strings.EqualFold(f.Name, "manifest.json") {
 			return true
 		}
 	}
@@ -26,17 +26,25 @@
 package apilcp
 
 import (
+	"archive/zip"
+	"crypto/sha256"
 	"crypto/tls"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/gorilla/mux"
 
 	"github.com/readium/readium-lcp-server/api"
+	"github.com/readium/readium-lcp-server/epub"
 	"github.com/readium/readium-lcp-server/index"
 	"github.com/readium/readium-lcp-server/license"
 	"github.com/readium/readium-lcp-server/pack"
@@ -51,6 +59,7 @@ type Server interface {
 	Licenses() license.Store
 	Certificate() *tls.Certificate
 	Source() *pack.ManualSource
+	DB() *sql.DB
 }
 
 // LcpPublication is a struct for communication with lcp-server
@@ -149,6 +158,18 @@ func AddContent(w http.ResponseWriter, r *http.Request, s Server) {
 	// the input file will be deleted when the function returns
 	defer cleanupTempFile(file)
 
+	// the checksum of the file is computed at ingestion, then served as the digest of the content;
+	// it must match the checksum computed by the caller, if any
+	checksum, err := fileChecksum(file)
+	if err != nil {
+		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusBadRequest)
+		return
+	}
+	if publication.Checksum != nil && *publication.Checksum != "" && !strings.EqualFold(*publication.Checksum, checksum) {
+		problem.Error(w, r, problem.Problem{Detail: "The checksum of the file does not match the checksum of the publication"}, http.StatusBadRequest)
+		return
+	}
+
 	// add the file to the storage, named by contentID, without file extension
 	_, err = s.Store().Add(contentID, file)
 	if err != nil {
@@ -166,15 +187,13 @@ func AddContent(w http.ResponseWriter, r *http.Request, s Server) {
 	if publication.ContentDisposition != nil {
 		c.Location = *publication.ContentDisposition
 		c.Length = *publication.Size
-		c.Sha256 = *publication.Checksum
+		c.Sha256 = checksum
 		c.Type = publication.ContentType
 	} else {
 		problem.Error(w, r, problem.Problem{Detail: "The file name must be set by the caller"}, http.StatusBadRequest)
 		return
 	}
 
-	//todo check hash & length?
-
 	code := http.StatusCreated
 	if err == index.NotFound { //insert into database
 		c.Id = contentID
@@ -242,19 +261,185 @@ func GetContent(w http.ResponseWriter, r *http.Request, s Server) {
 	}
 	// opens the file
 	contentReadCloser, err := item.Contents()
-	defer contentReadCloser.Close()
 	if err != nil { //file probably not found
 		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusBadRequest)
 		return
 	}
+	defer contentReadCloser.Close()
 	// set headers
 	w.Header().Set("Content-Disposition", "attachment; filename="+content.Location)
 	w.Header().Set("Content-Type", content.Type)
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", content.Length))
+	// if the client accepts trailers, the digest of the streamed bytes is sent as a trailer,
+	// the response being chunked, therefore without content length;
+	// otherwise the digest of the checksum computed at ingestion is sent as a header
+	runningDigest := strings.Contains(r.Header.Get("TE"), "trailers")
+	if runningDigest {
+		w.Header().Set("Trailer", "Digest")
+	} else {
+		if digest, err := checksumToDigest(content.Sha256); err == nil {
+			w.Header().Set("Digest", digest)
+		}
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", content.Length))
+	}
 
 	// returns the content of the file to the caller
-	io.Copy(w, contentReadCloser)
+	hasher := sha256.New()
+	io.Copy(io.MultiWriter(w, hasher), contentReadCloser)
 
-	return
+	if runningDigest {
+		w.Header().Set("Digest", "sha-256="+base64.StdEncoding.EncodeToString(hasher.Sum(nil)))
+	}
+}
+
+// fileChecksum computes the hex encoded sha256 checksum of a file, then rewinds it
+func fileChecksum(file io.ReadSeeker) (string, error) {
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// checksumToDigest converts a hex encoded sha256 checksum to an RFC 3230 Digest header value
+func checksumToDigest(checksum string) (string, error) {
+	sum, err := hex.DecodeString(checksum)
+	if err != nil {
+		return "", err
+	}
+	if len(sum) != sha256.Size {
+		return "", errors.New("invalid sha256 checksum")
+	}
+	return "sha-256=" + base64.StdEncoding.EncodeToString(sum), nil
+}
+
+// reencryptRequest is the json payload of ReencryptContent
+type reencryptRequest struct {
+	Profile string `json:"profile"`
+}
+
+// ReencryptContent rewrites a stored Readium package with a new LCP encryption profile,
+// given as "basic", "1.0" or a profile url, and updates its length and checksum in the index.
+// The encrypted resources are kept, as the profile only applies to the license; see pack.Reprofile.
+// The profile of an EPUB is not stored in the package: an EPUB content is answered with 501 Not Implemented.
+// The request is idempotent; the updated content is returned.
+func ReencryptContent(w http.ResponseWriter, r *http.Request, s Server) {
+
+	vars := mux.Vars(r)
+	contentID := vars["content_id"]
+
+	var payload reencryptRequest
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusBadRequest)
+		return
+	}
+	profile, err := license.ParseEncryptionProfile(payload.Profile)
+	if err != nil {
+		problem.Error(w, r, problem.Problem{Detail: err.Error() + " " + payload.Profile}, http.StatusBadRequest)
+		return
+	}
+
+	content, err := s.Index().Get(contentID)
+	if err != nil {
+		if err == index.NotFound {
+			problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusNotFound)
+		} else {
+			problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusInternalServerError)
+		}
+		return
+	}
+	if content.Type == epub.ContentType_EPUB {
+		problem.Error(w, r, problem.Problem{Detail: "The encryption profile of an EPUB is not stored in the package, it cannot be changed"}, http.StatusNotImplemented)
+		return
+	}
+	item, err := s.Store().Get(contentID)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusNotFound)
+		} else {
+			problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if content, err = reprofileItem(s, content, item, profile); err != nil {
+		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", api.ContentType_JSON)
+	json.NewEncoder(w).Encode(content)
+}
+
+// reprofileItem rewrites a stored Readium package with a new encryption profile,
+// and returns its updated index entry. The source and rewritten packages are spooled to temporary files.
+// The index is only updated once the rewritten package is stored; the source package is stored back
+// if the index cannot be updated, so that the index entry keeps matching the stored package.
+func reprofileItem(s Server, content index.Content, item storage.Item, profile license.EncryptionProfile) (index.Content, error) {
+	source, err := ioutil.TempFile("", "reprofile-source-*")
+	if err != nil {
+		return content, err
+	}
+	defer cleanupTempFile(source)
+	contents, err := item.Contents()
+	if err != nil {
+		return content, err
+	}
+	size, err := io.Copy(source, contents)
+	contents.Close()
+	if err != nil {
+		return content, err
+	}
+
+	zipReader, err := zip.NewReader(source, size)
+	if err != nil {
+		return content, err
+	}
+	reader, err := pack.NewRWPPReader(zipReader)
+	if err != nil {
+		return content, err
+	}
+	output, err := ioutil.TempFile("", "reprofile-output-*")
+	if err != nil {
+		return content, err
+	}
+	defer cleanupTempFile(output)
+
+	// the checksum is computed while the package is written
+	hasher := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(output, hasher)}
+	if err = pack.Reprofile(reader, counter, profile); err != nil {
+		return content, err
+	}
+	if _, err = output.Seek(0, io.SeekStart); err != nil {
+		return content, err
+	}
+	if _, err = s.Store().Add(content.Id, output); err != nil {
+		return content, err
+	}
+
+	updated := content
+	updated.Length = counter.count
+	updated.Sha256 = hex.EncodeToString(hasher.Sum(nil))
+	if err = s.Index().Update(updated); err != nil {
+		if _, seekErr := source.Seek(0, io.SeekStart); seekErr == nil {
+			s.Store().Add(content.Id, source)
+		}
+		return content, err
+	}
+	return updated, nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w     io.Writer
+	count int64
+}
 
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.count += int64(n)
+	return n, err
 }
@@ -0,0 +1,369 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package apilcp
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/readium/readium-lcp-server/config"
+	"github.com/readium/readium-lcp-server/crypto"
+	"github.com/readium/readium-lcp-server/epub"
+	"github.com/readium/readium-lcp-server/index"
+	"github.com/readium/readium-lcp-server/license"
+	"github.com/readium/readium-lcp-server/pack"
+	"github.com/readium/readium-lcp-server/storage"
+)
+
+// testServer is a minimal lcp server used by handler tests
+type testServer struct {
+	store storage.Store
+	idx   index.Index
+	db    *sql.DB
+}
+
+func (s testServer) Store() storage.Store          { return s.store }
+func (s testServer) Index() index.Index            { return s.idx }
+func (s testServer) Licenses() license.Store       { return nil }
+func (s testServer) Certificate() *tls.Certificate { return nil }
+func (s testServer) Source() *pack.ManualSource    { return nil }
+func (s testServer) DB() *sql.DB                   { return s.db }
+
+// newTestServer creates a server with an in-memory index and a temporary file storage
+func newTestServer(t *testing.T) (testServer, func()) {
+	config.Config.LcpServer.Database = "sqlite" // FIXME
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := index.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "lcp-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testServer{store: storage.NewFileSystem(dir, ""), idx: idx, db: db}, func() { os.RemoveAll(dir) }
+}
+
+// addTestContent adds a content through AddContent, as an encryption tool would
+func addTestContent(t *testing.T, s testServer, contentID string, data []byte, checksum string) *httptest.ResponseRecorder {
+	file, err := ioutil.TempFile("", "lcp-content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write(data)
+	file.Close()
+	defer os.Remove(file.Name())
+
+	size := int64(len(data))
+	location := "book.epub"
+	publication := LcpPublication{ContentKey: []byte("1234"), Output: file.Name(), Size: &size, ContentDisposition: &location, ContentType: "application/epub+zip"}
+	if checksum != "" {
+		publication.Checksum = &checksum
+	}
+	body, err := json.Marshal(publication)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("PUT", "/contents/"+contentID, bytes.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{"content_id": contentID})
+	w := httptest.NewRecorder()
+	AddContent(w, req, s)
+	return w
+}
+
+func TestAddContentChecksum(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	data := []byte("encrypted publication")
+	sum := sha256.Sum256(data)
+
+	// the checksum is computed at ingestion
+	if w := addTestContent(t, s, "content1", data, ""); w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d, %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	content, err := s.Index().Get("content1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content.Sha256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("Expected the checksum to be computed at ingestion, got %s", content.Sha256)
+	}
+
+	// a checksum which does not match the file is rejected
+	if w := addTestContent(t, s, "content2", data, hex.EncodeToString(make([]byte, sha256.Size))); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	if _, err = s.Index().Get("content2"); err != index.NotFound {
+		t.Errorf("Did not expect the content to be indexed, got %v", err)
+	}
+}
+
+func TestGetContentDigest(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	data := []byte("encrypted publication")
+	sum := sha256.Sum256(data)
+	expectedDigest := "sha-256=" + base64.StdEncoding.EncodeToString(sum[:])
+
+	if w := addTestContent(t, s, "content1", data, hex.EncodeToString(sum[:])); w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d, %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	get := func(te string) *http.Response {
+		req := httptest.NewRequest("GET", "/contents/content1", nil)
+		if te != "" {
+			req.Header.Set("TE", te)
+		}
+		req = mux.SetURLVars(req, map[string]string{"content_id": "content1"})
+		w := httptest.NewRecorder()
+		GetContent(w, req, s)
+		return w.Result()
+	}
+
+	res := get("")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+	if digest := res.Header.Get("Digest"); digest != expectedDigest {
+		t.Errorf("Expected digest %s, got %s", expectedDigest, digest)
+	}
+	if res.Trailer.Get("Digest") != "" {
+		t.Error("Did not expect a digest trailer")
+	}
+
+	// a running digest is sent as a trailer only
+	res = get("trailers")
+	body, _ := ioutil.ReadAll(res.Body)
+	if !bytes.Equal(body, data) {
+		t.Errorf("Unexpected body %s", body)
+	}
+	if digest := res.Header.Get("Digest"); digest != "" {
+		t.Errorf("Did not expect a digest header, got %s", digest)
+	}
+	if digest := res.Trailer.Get("Digest"); digest != expectedDigest {
+		t.Errorf("Expected trailer digest %s, got %s", expectedDigest, digest)
+	}
+}
+
+// memItem is an item of memStore
+type memItem struct {
+	key  string
+	data []byte
+}
+
+func (item memItem) Key() string       { return item.key }
+func (item memItem) PublicURL() string { return "" }
+func (item memItem) Contents() (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(item.data)), nil
+}
+
+// memStore is an in-memory storage
+type memStore map[string][]byte
+
+func (store memStore) Add(key string, r io.ReadSeeker) (storage.Item, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	store[key] = data
+	return memItem{key, data}, nil
+}
+
+func (store memStore) Get(key string) (storage.Item, error) {
+	data, ok := store[key]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return memItem{key, data}, nil
+}
+
+func (store memStore) Remove(key string) error {
+	delete(store, key)
+	return nil
+}
+
+func (store memStore) List() ([]storage.Item, error) {
+	var items []storage.Item
+	for key, data := range store {
+		items = append(items, memItem{key, data})
+	}
+	return items, nil
+}
+
+// encryptedTestPackage builds a Readium package encrypted with the basic profile
+func encryptedTestPackage(t *testing.T) []byte {
+	var source bytes.Buffer
+	zw := zip.NewWriter(&source)
+	for name, body := range map[string]string{
+		pack.ManifestLocation: `{"metadata": {"title": "reencrypt"}, "readingOrder": [{"href": "publication.pdf", "type": "application/pdf"}]}`,
+		"publication.pdf":     "%PDF-1.7",
+	} {
+		fw, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(body))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(source.Bytes()), int64(source.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := pack.NewRWPPReader(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output bytes.Buffer
+	writer, err := reader.NewWriter(&output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = pack.Process(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer); err != nil {
+		t.Fatal(err)
+	}
+	return output.Bytes()
+}
+
+func TestReencryptContent(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+	store := memStore{}
+	s.store = store
+
+	data := encryptedTestPackage(t)
+	store["content1"] = data
+	if err := s.Index().Add(index.Content{Id: "content1", EncryptionKey: []byte("1234"), Location: "book.lcpdf", Length: int64(len(data)), Type: "application/pdf+lcp"}); err != nil {
+		t.Fatal(err)
+	}
+
+	reencrypt := func(contentID string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/contents/"+contentID+"/reencrypt", strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"content_id": contentID})
+		w := httptest.NewRecorder()
+		ReencryptContent(w, req, s)
+		return w
+	}
+
+	if w := reencrypt("content1", `{"profile": "http://example.com/unknown"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown profile to be rejected, got %d", w.Code)
+	}
+	if w := reencrypt("unknown", `{"profile": "1.0"}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown content to be rejected, got %d", w.Code)
+	}
+
+	var reencrypted []byte
+	for i := 0; i < 2; i++ {
+		w := reencrypt("content1", `{"profile": "1.0"}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d, %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		if reencrypted != nil && !bytes.Equal(reencrypted, store["content1"]) {
+			t.Error("Expected the re-encryption to be idempotent")
+		}
+		reencrypted = store["content1"]
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(reencrypted), int64(len(reencrypted)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := pack.NewRWPPReader(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resources := reader.Resources()
+	if len(resources) != 1 || !resources[0].Encrypted() {
+		t.Fatalf("Expected the publication to stay encrypted")
+	}
+	manifest, err := zr.Open(pack.ManifestLocation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifestData, _ := ioutil.ReadAll(manifest)
+	if !bytes.Contains(manifestData, []byte(license.V1Profile.String())) || bytes.Contains(manifestData, []byte(license.BasicProfile.String())) {
+		t.Errorf("Expected the publication to be marked with the 1.0 profile, got %s", manifestData)
+	}
+
+	content, err := s.Index().Get("content1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(reencrypted)
+	if content.Length != int64(len(reencrypted)) || content.Sha256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("Expected the length and checksum of the content to be updated, got %+v", content)
+	}
+}
+
+// failingUpdateIndex is an index whose updates fail
+type failingUpdateIndex struct {
+	index.Index
+}
+
+func (idx failingUpdateIndex) Update(c index.Content) error { return errors.New("update failed") }
+
+func TestReencryptContentRollback(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+	store := memStore{}
+	s.store = store
+
+	data := encryptedTestPackage(t)
+	store["content1"] = data
+	if err := s.Index().Add(index.Content{Id: "content1", EncryptionKey: []byte("1234"), Location: "book.lcpdf", Length: int64(len(data)), Type: "application/pdf+lcp"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Index().Add(index.Content{Id: "content2", EncryptionKey: []byte("1234"), Location: "book.epub", Type: epub.ContentType_EPUB}); err != nil {
+		t.Fatal(err)
+	}
+	store["content2"] = []byte("epub")
+
+	reencrypt := func(contentID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/contents/"+contentID+"/reencrypt", strings.NewReader(`{"profile": "1.0"}`))
+		req = mux.SetURLVars(req, map[string]string{"content_id": contentID})
+		w := httptest.NewRecorder()
+		ReencryptContent(w, req, s)
+		return w
+	}
+
+	// the profile of an EPUB cannot be changed
+	if w := reencrypt("content2"); w.Code != http.StatusNotImplemented {
+		t.Errorf("Expected status %d for an EPUB, got %d", http.StatusNotImplemented, w.Code)
+	}
+
+	// the stored package is restored if the index cannot be updated
+	s.idx = failingUpdateIndex{s.idx}
+	if w := reencrypt("content1"); w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status %d, got %d", http.StatusInternalServerError, w.Code)
+	}
+	if !bytes.Equal(store["content1"], data) {
+		t.Error("Expected the source package to be stored back")
+	}
+}
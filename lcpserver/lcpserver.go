@@ -138,7 +138,7 @@ func main() {
 
 	HandleSignals()
 	parsedPort := strconv.Itoa(config.Config.LcpServer.Port)
-	s := lcpserver.New(":"+parsedPort, readonly, &idx, &store, &lst, &cert, packager, authenticator)
+	s := lcpserver.New(":"+parsedPort, readonly, db, &idx, &store, &lst, &cert, packager, authenticator)
 	if readonly {
 		log.Println("License server running in readonly mode on port " + parsedPort)
 	} else {
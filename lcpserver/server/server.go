@@ -27,6 +27,7 @@ package lcpserver
 
 import (
 	"crypto/tls"
+	"database/sql"
 	"net/http"
 	"time"
 
@@ -49,6 +50,7 @@ type Server struct {
 	lst      *license.Store
 	cert     *tls.Certificate
 	source   pack.ManualSource
+	db       *sql.DB
 }
 
 func (s *Server) Store() storage.Store {
@@ -71,7 +73,11 @@ func (s *Server) Source() *pack.ManualSource {
 	return &s.source
 }
 
-func New(bindAddr string, readonly bool, idx *index.Index, st *storage.Store, lst *license.Store, cert *tls.Certificate, packager *pack.Packager, basicAuth *auth.BasicAuth) *Server {
+func (s *Server) DB() *sql.DB {
+	return s.db
+}
+
+func New(bindAddr string, readonly bool, db *sql.DB, idx *index.Index, st *storage.Store, lst *license.Store, cert *tls.Certificate, packager *pack.Packager, basicAuth *auth.BasicAuth) *Server {
 
 	sr := api.CreateServerRouter("")
 
@@ -89,12 +95,17 @@ func New(bindAddr string, readonly bool, idx *index.Index, st *storage.Store, ls
 		lst:      lst,
 		cert:     cert,
 		source:   pack.ManualSource{},
+		db:       db,
 	}
 
 	// Route.PathPrefix: http://www.gorillatoolkit.org/pkg/mux#Route.PathPrefix
 	// Route.Subrouter: http://www.gorillatoolkit.org/pkg/mux#Route.Subrouter
 	// Router.StrictSlash: http://www.gorillatoolkit.org/pkg/mux#Router.StrictSlash
 
+	// liveness and readiness probes, without authentication
+	s.handleFunc(sr.R, "/health", apilcp.Health).Methods("GET")
+	s.handleFunc(sr.R, "/ready", apilcp.Ready).Methods("GET")
+
 	// methods related to EPUB encrypted content
 
 	contentRoutesPathPrefix := "/contents"
@@ -110,6 +121,8 @@ func New(bindAddr string, readonly bool, idx *index.Index, st *storage.Store, ls
 	if !readonly {
 		// put content to the storage
 		s.handlePrivateFunc(contentRoutes, "/{content_id}", apilcp.AddContent, basicAuth).Methods("PUT")
+		// re-encrypt a content under a new lcp profile
+		s.handlePrivateFunc(contentRoutes, "/{content_id}/reencrypt", apilcp.ReencryptContent, basicAuth).Methods("POST")
 		// generate a license for given content
 		s.handlePrivateFunc(contentRoutes, "/{content_id}/license", apilcp.GenerateLicense, basicAuth).Methods("POST")
 		// deprecated, from a typo in the lcp server spec
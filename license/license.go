@@ -10,6 +10,7 @@ import (
 	"crypto/rand"
 	"crypto/tls"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"reflect"
@@ -100,9 +101,10 @@ type LicenseReport struct {
 // EncryptionProfile is an enum of possible encryption profiles
 type EncryptionProfile int
 
-// Declare typed constants for Encryption Profile
+// Declare typed constants for Encryption Profile;
+// the zero value of an EncryptionProfile is not a valid profile
 const (
-	BasicProfile EncryptionProfile = iota
+	BasicProfile EncryptionProfile = iota + 1
 	V1Profile
 )
 
@@ -121,6 +123,40 @@ func (profile EncryptionProfile) String() string {
 	return profileURL
 }
 
+// ErrUnknownProfile is returned when an encryption profile is not known
+var ErrUnknownProfile = errors.New("Unknown encryption profile")
+
+// ErrProfileNotSet is returned when an encryption profile has its zero value
+var ErrProfileNotSet = errors.New("The encryption profile is not set")
+
+// Validate checks that an encryption profile is set and known
+func (profile EncryptionProfile) Validate() error {
+	switch profile {
+	case BasicProfile, V1Profile:
+		return nil
+	case 0:
+		return ErrProfileNotSet
+	}
+	return ErrUnknownProfile
+}
+
+// ParseEncryptionProfile returns the encryption profile matching a name,
+// as used in the configuration ("basic" or "1.0"), or a profile url
+func ParseEncryptionProfile(name string) (EncryptionProfile, error) {
+	for _, profile := range []EncryptionProfile{BasicProfile, V1Profile} {
+		if name == profile.String() {
+			return profile, nil
+		}
+	}
+	switch name {
+	case "basic":
+		return BasicProfile, nil
+	case "1.0":
+		return V1Profile, nil
+	}
+	return 0, ErrUnknownProfile
+}
+
 // SetLicenseProfile sets the license profile from config
 func SetLicenseProfile(l *License) {
 
@@ -28,6 +28,7 @@ package licensestatuses
 import (
 	"time"
 
+	"github.com/readium/readium-lcp-server/status"
 	"github.com/readium/readium-lcp-server/transactions"
 )
 
@@ -36,14 +37,8 @@ type Updated struct {
 	Status  *time.Time `json:"status,omitempty"`
 }
 
-type Link struct {
-	Rel       string `json:"rel"`
-	Href      string `json:"href"`
-	Type      string `json:"type,omitempty"`
-	Title     string `json:"title,omitempty"`
-	Profile   string `json:"profile,omitempty"`
-	Templated bool   `json:"templated,omitempty" "default false"`
-}
+// Link is a link of a license status document, see status.Link
+type Link = status.Link
 
 type PotentialRights struct {
 	End *time.Time `json:"end,omitempty"`
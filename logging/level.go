@@ -0,0 +1,98 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package logging
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Level is the severity of a log line
+type Level int
+
+// Log levels, from the most verbose
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"DEBUG", "INFO", "WARN", "ERROR"}
+
+func (level Level) String() string {
+	if level < LevelDebug || level > LevelError {
+		return fmt.Sprintf("LEVEL(%d)", int(level))
+	}
+	return levelNames[level]
+}
+
+// ParseLevel returns the level matching a name, e.g. "info" or "ERROR";
+// an empty name gives LevelInfo
+func ParseLevel(name string) (Level, error) {
+	if name == "" {
+		return LevelInfo, nil
+	}
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", name)
+}
+
+// Logger writes log lines with a level
+type Logger interface {
+	Debug(format string, args ...interface{})
+	Info(format string, args ...interface{})
+	Warn(format string, args ...interface{})
+	Error(format string, args ...interface{})
+}
+
+// LevelLogger is a Logger writing the lines of its level and above, prefixed by their level
+type LevelLogger struct {
+	out   *log.Logger
+	level Level
+}
+
+// NewLevelLogger returns a logger writing to out the lines of a level and above
+func NewLevelLogger(out *log.Logger, level Level) *LevelLogger {
+	return &LevelLogger{out: out, level: level}
+}
+
+// DefaultLogger returns a logger writing the info, warning and error lines
+// to the standard error, as the standard logger does
+func DefaultLogger() *LevelLogger {
+	return NewLevelLogger(log.New(os.Stderr, "", log.LstdFlags), LevelInfo)
+}
+
+func (l *LevelLogger) output(level Level, format string, args ...interface{}) {
+	if level < l.level {
+		return
+	}
+	l.out.Output(3, level.String()+" "+fmt.Sprintf(format, args...))
+}
+
+// Debug writes a debug line
+func (l *LevelLogger) Debug(format string, args ...interface{}) {
+	l.output(LevelDebug, format, args...)
+}
+
+// Info writes an info line
+func (l *LevelLogger) Info(format string, args ...interface{}) {
+	l.output(LevelInfo, format, args...)
+}
+
+// Warn writes a warning line
+func (l *LevelLogger) Warn(format string, args ...interface{}) {
+	l.output(LevelWarn, format, args...)
+}
+
+// Error writes an error line
+func (l *LevelLogger) Error(format string, args ...interface{}) {
+	l.output(LevelError, format, args...)
+}
@@ -11,6 +11,7 @@ import (
 	"errors"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
@@ -83,6 +84,14 @@ func GetLicenseStatusDocument(w http.ResponseWriter, r *http.Request, s Server)
 		return
 	}
 
+	// the events of the license status may be paginated
+	page, perPage, err := eventsPagination(r)
+	if err != nil {
+		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusBadRequest)
+		logging.WriteToFile(complianceTestNumber, LICENSE_STATUS, strconv.Itoa(http.StatusBadRequest), err.Error())
+		return
+	}
+
 	currentDateTime := time.Now().UTC().Truncate(time.Second)
 
 	// if a rights end date is set, check if the license has expired
@@ -110,6 +119,9 @@ func GetLicenseStatusDocument(w http.ResponseWriter, r *http.Request, s Server)
 		return
 	}
 
+	if links := eventsLinks(r, page, perPage, len(licenseStatus.Events)); links != "" {
+		w.Header().Set("Link", links)
+	}
 	w.Header().Set("Content-Type", api.ContentType_LSD_JSON)
 
 	// the device count must not be sent in json to the caller
@@ -203,6 +215,9 @@ func RegisterDevice(w http.ResponseWriter, r *http.Request, s Server) {
 
 		// create a registered event
 		event := makeEvent(status.STATUS_ACTIVE, deviceName, deviceID, licenseStatus.Id)
+		// the origin of registrations is recorded for fraud detection
+		event.IpAddress = clientIP(r)
+		event.UserAgent = r.UserAgent()
 		err = s.Transactions().Add(*event, status.STATUS_ACTIVE_INT)
 		if err != nil {
 			problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusInternalServerError)
@@ -410,6 +425,20 @@ func LendingRenewal(w http.ResponseWriter, r *http.Request, s Server) {
 		return
 	}
 
+	// check that the maximum number of renewals is not reached
+	canRenew, err := s.Transactions().CanRenew(licenseStatus.Id, config.Config.LicenseStatus.MaxRenewals)
+	if err != nil {
+		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusInternalServerError)
+		logging.WriteToFile(complianceTestNumber, RENEW_LICENSE, strconv.Itoa(http.StatusInternalServerError), err.Error())
+		return
+	}
+	if !canRenew {
+		msg = "The maximum number of renewals has been reached; renew forbidden"
+		problem.Error(w, r, problem.Problem{Detail: msg}, http.StatusForbidden)
+		logging.WriteToFile(complianceTestNumber, RENEW_LICENSE, strconv.Itoa(http.StatusForbidden), msg)
+		return
+	}
+
 	// check if the license contains a date end property
 	var currentEnd time.Time
 	if licenseStatus.CurrentEndLicense == nil || (*licenseStatus.CurrentEndLicense).IsZero() {
@@ -636,11 +665,10 @@ func ListRegisteredDevices(w http.ResponseWriter, r *http.Request, s Server) {
 		return
 	}
 
-	registeredDevicesList := transactions.RegisteredDevicesList{Devices: make([]transactions.Device, 0), Id: licenseStatus.LicenseRef}
-
-	fn := s.Transactions().ListRegisteredDevices(licenseStatus.Id)
-	for it, err := fn(); err == nil; it, err = fn() {
-		registeredDevicesList.Devices = append(registeredDevicesList.Devices, it)
+	registeredDevicesList, err := s.Transactions().BuildRegisteredDevicesList(licenseStatus.Id, licenseStatus.LicenseRef)
+	if err != nil {
+		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusInternalServerError)
+		return
 	}
 
 	enc := json.NewEncoder(w)
@@ -651,6 +679,54 @@ func ListRegisteredDevices(w http.ResponseWriter, r *http.Request, s Server) {
 	}
 }
 
+// GetDeviceHistory sends the events of a device for a given license, ordered by timestamp,
+// to help understand why a device cannot register, return or renew the license.
+// parameters:
+//	key: license id
+//	device: device id
+//
+func GetDeviceHistory(w http.ResponseWriter, r *http.Request, s Server) {
+	w.Header().Set("Content-Type", api.ContentType_JSON)
+
+	vars := mux.Vars(r)
+	licenseID := vars["key"]
+	deviceID := vars["device"]
+
+	licenseStatus, err := s.LicenseStatuses().GetByLicenseId(licenseID)
+	if err != nil {
+		if licenseStatus == nil {
+			problem.NotFoundHandler(w, r)
+			return
+		}
+
+		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	history := struct {
+		Id       string               `json:"id"`
+		DeviceId string               `json:"device_id"`
+		Events   []transactions.Event `json:"events"`
+	}{Id: licenseStatus.LicenseRef, DeviceId: deviceID, Events: make([]transactions.Event, 0)}
+
+	fn := s.Transactions().GetDeviceHistory(licenseStatus.Id, deviceID)
+	var event transactions.Event
+	for event, err = fn(); err == nil; event, err = fn() {
+		history.Events = append(history.Events, event)
+	}
+	if err != transactions.NotFound {
+		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	err = enc.Encode(history)
+	if err != nil {
+		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusInternalServerError)
+		return
+	}
+}
+
 // LendingCancellation cancels (before use) or revokes (after use)  a license.
 // parameters:
 //	key: license id
@@ -815,12 +891,16 @@ func makeLicenseStatus(license license.License, ls *licensestatuses.LicenseStatu
 	ls.DeviceCount = &count
 }
 
-// getEvents gets the events from database for the license status
+// getEvents gets the events from database for the license status;
+// only a page of events is fetched if perPage is positive, page starting at 1
 //
-func getEvents(ls *licensestatuses.LicenseStatus, s Server) error {
+func getEvents(ls *licensestatuses.LicenseStatus, s Server, page int, perPage int) error {
 	events := make([]transactions.Event, 0)
 
 	fn := s.Transactions().GetByLicenseStatusId(ls.Id)
+	if perPage > 0 {
+		fn = s.Transactions().GetByLicenseStatusIdPaged(ls.Id, perPage, (page-1)*perPage)
+	}
 	var err error
 	var event transactions.Event
 	for event, err = fn(); err == nil; event, err = fn() {
@@ -848,40 +928,37 @@ func makeLinks(ls *licensestatuses.LicenseStatus) {
 	renewAvailable := config.Config.LicenseStatus.Renew && licenseHasRightsEnd
 	renewPageUrl := config.Config.LicenseStatus.RenewPageUrl
 
-	links := new([]licensestatuses.Link)
+	var links status.Links
 
 	// if the link template to the license is set
 	if licenseLinkURL != "" {
 		licenseLinkURLReal := strings.Replace(licenseLinkURL, "{license_id}", ls.LicenseRef, -1)
-		link := licensestatuses.Link{Href: licenseLinkURLReal, Rel: "license", Type: api.ContentType_LCP_JSON, Templated: false}
-		*links = append(*links, link)
+		link := status.LicenseLink(lcpBaseURL, ls.LicenseRef)
+		link.Href = licenseLinkURLReal
+		links = append(links, link)
 		// default template
 	} else {
-		link := licensestatuses.Link{Href: lcpBaseURL + "/licenses/" + ls.LicenseRef, Rel: "license", Type: api.ContentType_LCP_JSON, Templated: false}
-		*links = append(*links, link)
+		links = append(links, status.LicenseLink(lcpBaseURL, ls.LicenseRef))
 	}
 	// if register is set
 	if registerAvailable {
-		link := licensestatuses.Link{Href: lsdBaseURL + "/licenses/" + ls.LicenseRef + "/register{?id,name}", Rel: "register", Type: api.ContentType_LSD_JSON, Templated: true}
-		*links = append(*links, link)
+		links = append(links, status.RegisterLink(lsdBaseURL, ls.LicenseRef))
 	}
 	// if return is set
 	if returnAvailable {
-		link := licensestatuses.Link{Href: lsdBaseURL + "/licenses/" + ls.LicenseRef + "/return{?id,name}", Rel: "return", Type: api.ContentType_LSD_JSON, Templated: true}
-		*links = append(*links, link)
+		links = append(links, status.ReturnLink(lsdBaseURL, ls.LicenseRef))
 	}
 
 	// if renew is set and HTML renew page is set
 	if renewAvailable && renewPageUrl != "" {
-		link := licensestatuses.Link{Href: renewPageUrl, Rel: "renew", Type: api.ContentType_TEXT_HTML}
-		*links = append(*links, link)
+		link := status.Link{Href: renewPageUrl, Rel: status.REL_RENEW, Type: api.ContentType_TEXT_HTML}
+		links = append(links, link)
 	} else if renewAvailable {
 		// this is the usual case, i.e. a simple renew link
-		link := licensestatuses.Link{Href: lsdBaseURL + "/licenses/" + ls.LicenseRef + "/renew{?end,id,name}", Rel: "renew", Type: api.ContentType_LSD_JSON, Templated: true}
-		*links = append(*links, link)
+		links = append(links, status.RenewLink(lsdBaseURL, ls.LicenseRef))
 	}
 
-	ls.Links = *links
+	ls.Links = links
 }
 
 // makeEvent creates an event and fill it
@@ -897,6 +974,16 @@ func makeEvent(status string, deviceName string, deviceID string, licenseStatusF
 	return &event
 }
 
+// clientIP returns the ip address of the client which sent a request, without the port
+//
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 // decodeJsonLicenseStatus decodes license status json to the object
 //
 func decodeJsonLicenseStatus(r *http.Request, ls *licensestatuses.LicenseStatus) error {
@@ -967,7 +1054,8 @@ func updateLicense(timeEnd time.Time, licenseID string) (int, error) {
 	return 0, err
 }
 
-// fillLicenseStatus fills the localized 'message' field, the 'links' and 'event' objects in the license status
+// fillLicenseStatus fills the localized 'message' field, the 'links' and 'event' objects in the license status.
+// The links are restricted to the actions allowed to the device identified by the request, if any.
 //
 func fillLicenseStatus(ls *licensestatuses.LicenseStatus, r *http.Request, s Server) error {
 	// add the localized message
@@ -975,8 +1063,69 @@ func fillLicenseStatus(ls *licensestatuses.LicenseStatus, r *http.Request, s Ser
 	localization.LocalizeMessage(acceptLanguages, &ls.Message, ls.Status)
 	// add the links
 	makeLinks(ls)
-	// add the events
-	err := getEvents(ls, s)
+	// keep the actions allowed to the device, if the request identifies one
+	if deviceID := r.FormValue("id"); deviceID != "" {
+		deviceStatus, err := s.Transactions().CheckDeviceStatus(ls.Id, deviceID)
+		if err != nil {
+			return err
+		}
+		ls.Links = status.Links(ls.Links).KeepActions(status.AllowedActions(deviceStatus))
+	}
+	// add the events, paginated if requested
+	page, perPage, err := eventsPagination(r)
+	if err != nil {
+		return err
+	}
+	err = getEvents(ls, s, page, perPage)
 
 	return err
 }
+
+// eventsPagination extracts the page and per_page parameters used to paginate the events of a license status.
+// If neither is set, perPage is 0 and all events are listed; else page defaults to 1 and per_page to 100.
+//
+func eventsPagination(r *http.Request) (page int, perPage int, err error) {
+	rPage, rPerPage := r.FormValue("page"), r.FormValue("per_page")
+	if rPage == "" && rPerPage == "" {
+		return 0, 0, nil
+	}
+
+	page, perPage = 1, 100
+	if rPage != "" {
+		if page, err = strconv.Atoi(rPage); err != nil {
+			return
+		}
+	}
+	if rPerPage != "" {
+		if perPage, err = strconv.Atoi(rPerPage); err != nil {
+			return
+		}
+	}
+	if page < 1 || perPage < 1 {
+		err = errors.New("page and per_page must be positive integers")
+	}
+	return
+}
+
+// eventsLinks returns the Link header of a page of events: a next link if the page is full,
+// and a previous link if it is not the first one. The other query parameters of the request are kept.
+//
+func eventsLinks(r *http.Request, page int, perPage int, count int) string {
+	if perPage == 0 {
+		return ""
+	}
+	pageLink := func(page int, rel string) string {
+		query := r.URL.Query()
+		query.Set("page", strconv.Itoa(page))
+		query.Set("per_page", strconv.Itoa(perPage))
+		return "<" + r.URL.Path + "?" + query.Encode() + ">; rel=\"" + rel + "\"; title=\"" + rel + "\""
+	}
+	var links []string
+	if count == perPage {
+		links = append(links, pageLink(page+1, "next"))
+	}
+	if page > 1 {
+		links = append(links, pageLink(page-1, "previous"))
+	}
+	return strings.Join(links, ", ")
+}
@@ -0,0 +1,38 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package apilsd
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEventsLinks(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		count    int
+		expected string
+	}{
+		{"not paginated", "", 3, ""},
+		{"first full page", "?per_page=2", 2,
+			`</licenses/1/status?page=2&per_page=2>; rel="next"; title="next"`},
+		{"middle page", "?id=device&page=2&per_page=2", 2,
+			`</licenses/1/status?id=device&page=3&per_page=2>; rel="next"; title="next", ` +
+				`</licenses/1/status?id=device&page=1&per_page=2>; rel="previous"; title="previous"`},
+		{"last page", "?page=3&per_page=2", 1,
+			`</licenses/1/status?page=2&per_page=2>; rel="previous"; title="previous"`},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/licenses/1/status"+test.query, nil)
+		page, perPage, err := eventsPagination(r)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if links := eventsLinks(r, page, perPage, test.count); links != test.expected {
+			t.Errorf("%s: expected the links %q, got %q", test.name, test.expected, links)
+		}
+	}
+}
@@ -72,6 +72,7 @@ func New(bindAddr string, readonly bool, complianceMode bool, goofyMode bool, ls
 	}
 
 	s.handlePrivateFunc(licenseRoutes, "/{key}/registered", apilsd.ListRegisteredDevices, basicAuth).Methods("GET")
+	s.handlePrivateFunc(licenseRoutes, "/{key}/devices/{device}/history", apilsd.GetDeviceHistory, basicAuth).Methods("GET")
 	if !readonly {
 		s.handleFunc(licenseRoutes, "/{key}/register", apilsd.RegisterDevice).Methods("POST")
 		s.handleFunc(licenseRoutes, "/{key}/return", apilsd.LendingReturn).Methods("PUT")
@@ -0,0 +1,252 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/readium/readium-lcp-server/rwpm"
+)
+
+// audioMediaTypes maps the extensions of supported audio files to their media type
+var audioMediaTypes = map[string]string{
+	".mp3":  "audio/mpeg",
+	".m4a":  "audio/mp4",
+	".m4b":  "audio/mp4",
+	".aac":  "audio/aac",
+	".ogg":  "audio/ogg",
+	".opus": "audio/opus",
+	".flac": "audio/flac",
+	".wav":  "audio/wav",
+}
+
+// BuildRWPPFromAudio builds a Readium Package (rwpp) which embeds a single audio file.
+// The duration of the audiobook is computed from the header of MP3 and MP4 files;
+// if title is empty, the base name of the audio file is used.
+func BuildRWPPFromAudio(title string, inputPath string, outputPath string) (err error) {
+
+	mediaType, ok := audioMediaTypes[strings.ToLower(filepath.Ext(inputPath))]
+	if !ok {
+		return fmt.Errorf("unsupported audio file %s", filepath.Base(inputPath))
+	}
+	if title == "" {
+		title = filepath.Base(inputPath)
+	}
+
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer inputFile.Close()
+	stat, err := inputFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	// create the rwpp
+	f, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// a close error must not be masked by a prior nil
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	return buildRWPPFromAudio(title, mediaType, filepath.Ext(inputPath), inputFile, stat.Size(), f)
+}
+
+// buildRWPPFromAudio writes into output a Readium Package which embeds the audio file read from input
+func buildRWPPFromAudio(title, mediaType, ext string, input io.ReaderAt, size int64, output io.Writer) error {
+
+	duration, _ := audioDuration(input, size, mediaType)
+	href := "publication" + strings.ToLower(ext)
+	manifest, err := rwpm.NewAudiobookManifest(title).AddTrack(href, mediaType, duration).Build()
+	if err != nil {
+		return err
+	}
+
+	// audio files are compressed already, store the file as is
+	zipWriter := zip.NewWriter(output)
+	writer, err := zipWriter.CreateHeader(&zip.FileHeader{
+		Name:   href,
+		Method: zip.Store,
+	})
+	if err != nil {
+		return closeZipWriter(zipWriter, err)
+	}
+
+	_, err = io.Copy(writer, io.NewSectionReader(input, 0, size))
+	if err != nil {
+		return closeZipWriter(zipWriter, err)
+	}
+
+	// inject a Readium manifest into the zip output
+	manifestWriter, err := zipWriter.Create(ManifestLocation)
+	if err != nil {
+		return closeZipWriter(zipWriter, err)
+	}
+
+	encoder := json.NewEncoder(manifestWriter)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(manifest)
+	return closeZipWriter(zipWriter, err)
+}
+
+// audioDuration returns the duration in seconds of an audio file, computed from its header.
+// It returns false if the duration cannot be computed.
+func audioDuration(r io.ReaderAt, size int64, mediaType string) (float64, bool) {
+	switch mediaType {
+	case "audio/mpeg":
+		return mp3Duration(r, size)
+	case "audio/mp4":
+		return mp4Duration(r, size)
+	}
+	return 0, false
+}
+
+// MPEG audio layer III tables, indexed by version: MPEG 1, then MPEG 2 and 2.5
+var (
+	mp3Bitrates = [2][15]int{
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	}
+	mp3SamplesPerFrame = [2]int{1152, 576}
+)
+
+// mp3Duration computes the duration of an MP3 file from the frame count of its Xing or Info header,
+// or from the bitrate of its first frame if the file has a constant bitrate.
+func mp3Duration(r io.ReaderAt, size int64) (float64, bool) {
+
+	// skip an ID3v2 tag
+	var offset int64
+	header := make([]byte, 10)
+	if _, err := r.ReadAt(header, 0); err == nil && bytes.HasPrefix(header, []byte("ID3")) {
+		// the tag size is a 28 bits "synchsafe" integer
+		offset = 10 + (int64(header[6])<<21 | int64(header[7])<<14 | int64(header[8])<<7 | int64(header[9]))
+		if header[5]&0x10 != 0 {
+			// a footer follows the tag
+			offset += 10
+		}
+	}
+
+	// read the first frame
+	frame := make([]byte, 48)
+	if _, err := r.ReadAt(frame, offset); err != nil {
+		return 0, false
+	}
+	if frame[0] != 0xFF || frame[1]&0xE0 != 0xE0 || (frame[1]>>1)&3 != 1 {
+		// not an MPEG audio layer III frame
+		return 0, false
+	}
+
+	var version int
+	var sampleRates [3]int
+	switch (frame[1] >> 3) & 3 {
+	case 3:
+		version, sampleRates = 0, [3]int{44100, 48000, 32000}
+	case 2:
+		version, sampleRates = 1, [3]int{22050, 24000, 16000}
+	case 0:
+		version, sampleRates = 1, [3]int{11025, 12000, 8000}
+	default:
+		return 0, false
+	}
+	bitrateIndex, sampleRateIndex := frame[2]>>4, (frame[2]>>2)&3
+	if bitrateIndex == 0 || bitrateIndex == 15 || sampleRateIndex == 3 {
+		return 0, false
+	}
+	sampleRate := sampleRates[sampleRateIndex]
+
+	// the Xing header follows the side information of the first frame
+	mono := frame[3]>>6 == 3
+	xingOffset := 4 + 32
+	switch {
+	case version == 0 && mono, version == 1 && !mono:
+		xingOffset = 4 + 17
+	case version == 1 && mono:
+		xingOffset = 4 + 9
+	}
+	tag := string(frame[xingOffset : xingOffset+4])
+	if (tag == "Xing" || tag == "Info") && frame[xingOffset+7]&1 != 0 {
+		frames := binary.BigEndian.Uint32(frame[xingOffset+8:])
+		return float64(frames) * float64(mp3SamplesPerFrame[version]) / float64(sampleRate), true
+	}
+
+	bitrate := mp3Bitrates[version][bitrateIndex] * 1000
+	return float64(size-offset) * 8 / float64(bitrate), true
+}
+
+// mp4Duration reads the duration of an MP4 file from the movie header box (moov/mvhd)
+func mp4Duration(r io.ReaderAt, size int64) (float64, bool) {
+	moov, moovSize, ok := findMP4Box(r, 0, size, "moov")
+	if !ok {
+		return 0, false
+	}
+	mvhd, mvhdSize, ok := findMP4Box(r, moov, moovSize, "mvhd")
+	if !ok || mvhdSize < 32 {
+		return 0, false
+	}
+
+	box := make([]byte, 32)
+	if _, err := r.ReadAt(box, mvhd); err != nil {
+		return 0, false
+	}
+	var timescale uint32
+	var duration uint64
+	if box[0] == 1 {
+		// version 1 uses 64 bits dates and duration
+		timescale = binary.BigEndian.Uint32(box[20:])
+		duration = binary.BigEndian.Uint64(box[24:])
+	} else {
+		timescale = binary.BigEndian.Uint32(box[12:])
+		duration = uint64(binary.BigEndian.Uint32(box[16:]))
+	}
+	if timescale == 0 {
+		return 0, false
+	}
+	return float64(duration) / float64(timescale), true
+}
+
+// findMP4Box looks for a box of a given type among the boxes stored in [start, start+length),
+// and returns the offset and size of its content
+func findMP4Box(r io.ReaderAt, start, length int64, boxType string) (int64, int64, bool) {
+	header := make([]byte, 16)
+	for offset := start; offset+8 <= start+length; {
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return 0, 0, false
+		}
+		boxSize, headerSize := int64(binary.BigEndian.Uint32(header)), int64(8)
+		switch boxSize {
+		case 0:
+			// the box extends to the end of its container
+			boxSize = start + length - offset
+		case 1:
+			// a 64 bits size follows the type
+			if _, err := r.ReadAt(header[8:], offset+8); err != nil {
+				return 0, 0, false
+			}
+			boxSize, headerSize = int64(binary.BigEndian.Uint64(header[8:])), 16
+		}
+		if boxSize < headerSize || offset+boxSize > start+length {
+			return 0, 0, false
+		}
+		if string(header[4:8]) == boxType {
+			return offset + headerSize, boxSize - headerSize, true
+		}
+		offset += boxSize
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,121 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testMP3 builds a constant bitrate MP3 file: MPEG 1 layer III, 128 kbps, 44100 Hz, stereo,
+// preceded by an ID3v2 tag. If xingFrames is not zero, the first frame holds a Xing header.
+func testMP3(frames int, xingFrames uint32) []byte {
+	var b bytes.Buffer
+	b.Write([]byte{'I', 'D', '3', 4, 0, 0, 0, 0, 1, 0}) // 128 bytes tag
+	b.Write(make([]byte, 128))
+	for i := 0; i < frames; i++ {
+		frame := make([]byte, 417)
+		copy(frame, []byte{0xFF, 0xFB, 0x90, 0x00})
+		if i == 0 && xingFrames != 0 {
+			copy(frame[36:], "Xing")
+			binary.BigEndian.PutUint32(frame[40:], 1)
+			binary.BigEndian.PutUint32(frame[44:], xingFrames)
+		}
+		b.Write(frame)
+	}
+	return b.Bytes()
+}
+
+// testMP4 builds a minimal MP4 file with a movie header
+func testMP4(timescale, duration uint32) []byte {
+	box := func(boxType string, content []byte) []byte {
+		b := make([]byte, 8, 8+len(content))
+		binary.BigEndian.PutUint32(b, uint32(8+len(content)))
+		copy(b[4:], boxType)
+		return append(b, content...)
+	}
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:], timescale)
+	binary.BigEndian.PutUint32(mvhd[16:], duration)
+
+	var b bytes.Buffer
+	b.Write(box("ftyp", []byte("M4A \x00\x00\x00\x00")))
+	b.Write(box("moov", box("mvhd", mvhd)))
+	b.Write(box("mdat", make([]byte, 64)))
+	return b.Bytes()
+}
+
+func TestAudioDuration(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      []byte
+		mediaType string
+		expected  float64
+	}{
+		{"cbr mp3", testMP3(100, 0), "audio/mpeg", 100 * 417 * 8 / 128000.0},
+		{"xing mp3", testMP3(10, 1000), "audio/mpeg", 1000 * 1152 / 44100.0},
+		{"mp4", testMP4(1000, 60500), "audio/mp4", 60.5},
+	}
+	for _, test := range tests {
+		duration, ok := audioDuration(bytes.NewReader(test.data), int64(len(test.data)), test.mediaType)
+		if !ok || math.Abs(duration-test.expected) > 0.01 {
+			t.Errorf("%s: expected a duration of %f, got %f", test.name, test.expected, duration)
+		}
+	}
+
+	if _, ok := audioDuration(bytes.NewReader([]byte("not audio")), 9, "audio/mpeg"); ok {
+		t.Error("Expected no duration for an invalid file")
+	}
+}
+
+func TestBuildRWPPFromAudio(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	inputPath := filepath.Join(dir, "book.MP3")
+	if err = ioutil.WriteFile(inputPath, testMP3(10, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outputPath := filepath.Join(dir, "output.lcpau")
+	if err = BuildRWPPFromAudio("A book", inputPath, outputPath); err != nil {
+		t.Fatalf("Could not build the package, %s", err)
+	}
+
+	reader, err := OpenRWPP(outputPath)
+	if err != nil {
+		t.Fatalf("Expected a valid package, got %s", err)
+	}
+	if kind := reader.Kind(); kind != KindAudiobook {
+		t.Errorf("Expected an audiobook, got %s", kind)
+	}
+	if title := reader.manifest.Metadata.Title.Text(); title != "A book" {
+		t.Errorf("Expected the title A book, got %s", title)
+	}
+	if l := len(reader.manifest.ReadingOrder); l != 1 {
+		t.Fatalf("Expected a single track, got %d", l)
+	}
+	track := reader.manifest.ReadingOrder[0]
+	if track.Href != "publication.mp3" || track.Type != "audio/mpeg" {
+		t.Errorf("Expected publication.mp3 as audio/mpeg, got %s as %s", track.Href, track.Type)
+	}
+	if duration := reader.manifest.Metadata.Duration; math.Abs(duration-26.12) > 0.01 {
+		t.Errorf("Expected a duration of 26.12s, got %f", duration)
+	}
+	if file := reader.file("publication.mp3"); file == nil || file.Method != NoCompression {
+		t.Error("Expected the audio file to be stored")
+	}
+
+	if err = BuildRWPPFromAudio("", filepath.Join(dir, "book.txt"), outputPath); err == nil {
+		t.Error("Expected an unsupported audio file to be rejected")
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+)
+
+// ByteRangeIndexName is the conventional name of a byte range index side-file
+const ByteRangeIndexName = "byte-ranges.json"
+
+// ByteRange locates the data of an encrypted resource in a package
+type ByteRange struct {
+	Offset         int64 `json:"offset"`
+	CompressedSize int64 `json:"compressedSize"`
+	OriginalSize   int64 `json:"originalSize"`
+}
+
+// ByteRangeIndex maps the href of encrypted resources to their location in a package,
+// for random access by streaming readers
+type ByteRangeIndex map[string]ByteRange
+
+// Write serializes the index as json
+func (index ByteRangeIndex) Write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(index)
+}
+
+// ByteRangeIndex returns the location of encrypted resources in the package,
+// if requested in the options. It is only available once the writer is closed.
+func (writer *RWPPWriter) ByteRangeIndex() ByteRangeIndex {
+	return writer.byteRanges
+}
+
+// byteRangeTracker records the location of the entries of a zip archive as they are created,
+// so that the archive needs neither to be buffered nor re-read to be indexed
+type byteRangeTracker struct {
+	zipWriter *zip.Writer
+	output    *countingWriter
+	entries   map[string]trackedEntry
+}
+
+// trackedEntry is the header of an entry, whose sizes are final once the entry is written,
+// and the offset of its data in the archive
+type trackedEntry struct {
+	header *zip.FileHeader
+	offset int64
+}
+
+// newTrackedZipWriter returns a zip writer on w and a tracker of its entries
+func newTrackedZipWriter(w io.Writer) (*zip.Writer, *byteRangeTracker) {
+	output := &countingWriter{Writer: w}
+	zipWriter := zip.NewWriter(output)
+	return zipWriter, &byteRangeTracker{zipWriter: zipWriter, output: output, entries: map[string]trackedEntry{}}
+}
+
+// created records the data offset of an entry whose header has just been written;
+// a nil tracker records nothing
+func (tracker *byteRangeTracker) created(header *zip.FileHeader) error {
+	if tracker == nil {
+		return nil
+	}
+	// the local header is buffered by the zip writer
+	if err := tracker.zipWriter.Flush(); err != nil {
+		return err
+	}
+	tracker.entries[header.Name] = trackedEntry{header: header, offset: tracker.output.count}
+	return nil
+}
+
+// index locates the given resources, once the entries are written
+func (tracker *byteRangeTracker) index(originalSizes map[string]int64) ByteRangeIndex {
+	index := ByteRangeIndex{}
+	for name, originalSize := range originalSizes {
+		entry, ok := tracker.entries[name]
+		if !ok {
+			continue
+		}
+		index[name] = ByteRange{
+			Offset:         entry.offset,
+			CompressedSize: int64(entry.header.CompressedSize64),
+			OriginalSize:   originalSize,
+		}
+	}
+	return index
+}
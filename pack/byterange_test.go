@@ -0,0 +1,102 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/readium/readium-lcp-server/crypto"
+	"github.com/readium/readium-lcp-server/license"
+)
+
+func TestByteRangeIndex(t *testing.T) {
+	for _, manifestFirst := range []bool{false, true} {
+		reader, err := OpenRWPP("./samples/basic.lcpdf")
+		if err != nil {
+			t.Fatalf("Expected to be able to open basic.lcpdf, got %s", err)
+		}
+
+		opts := PackOptions{ByteRangeIndex: true, ManifestFirst: manifestFirst}
+		var b bytes.Buffer
+		writer, err := reader.NewWriterWithOptions(&b, opts)
+		if err != nil {
+			t.Fatalf("Could not build a writer, %s", err)
+		}
+		if _, _, err = ProcessWithOptions(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer, opts); err != nil {
+			t.Fatalf("Could not process the package, %s", err)
+		}
+
+		index := writer.(*RWPPWriter).ByteRangeIndex()
+		if len(index) != 1 {
+			t.Fatalf("Expected a single encrypted resource in the index, got %v", index)
+		}
+		checkByteRanges(t, reader, b.Bytes(), index)
+
+		var out bytes.Buffer
+		if err = index.Write(&out); err != nil {
+			t.Fatalf("Could not write the index, %s", err)
+		}
+		var decoded ByteRangeIndex
+		if err = json.Unmarshal(out.Bytes(), &decoded); err != nil || len(decoded) != 1 {
+			t.Errorf("Could not decode the index, %v", err)
+		}
+	}
+}
+
+// TestByteRangeIndexDeflated locates encrypted resources deflated in the package, with resources copied in between
+func TestByteRangeIndexDeflated(t *testing.T) {
+	chapter := bytes.Repeat([]byte("<p>chapter</p>"), 1000)
+	for _, manifestFirst := range []bool{false, true} {
+		reader := openTestRWPP(t,
+			testEntry{name: ManifestLocation, method: Deflate, body: []byte(`{"metadata": {"title": "deflated"}, "readingOrder": [{"href": "c1.html", "type": "text/html"}, {"href": "c2.html", "type": "text/html"}], "resources": [{"href": "cover.jpg", "type": "image/jpeg"}]}`)},
+			testEntry{name: "c1.html", method: Deflate, body: chapter},
+			testEntry{name: "cover.jpg", method: NoCompression, body: []byte("cover")},
+			testEntry{name: "c2.html", method: Deflate, body: chapter},
+		)
+		opts := PackOptions{ByteRangeIndex: true, ManifestFirst: manifestFirst}
+		var b bytes.Buffer
+		writer, err := reader.NewWriterWithOptions(&b, opts)
+		if err != nil {
+			t.Fatalf("Could not build a writer, %s", err)
+		}
+		if _, _, err = ProcessWithOptions(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer, opts); err != nil {
+			t.Fatalf("Could not process the package, %s", err)
+		}
+
+		index := writer.(*RWPPWriter).ByteRangeIndex()
+		if len(index) != 2 {
+			t.Fatalf("Expected two encrypted resources in the index, got %v", index)
+		}
+		checkByteRanges(t, reader, b.Bytes(), index)
+	}
+}
+
+// checkByteRanges checks that the byte ranges of a package point to the raw data of its entries
+func checkByteRanges(t *testing.T, reader *RWPPReader, data []byte, index ByteRangeIndex) {
+	for _, file := range openTestZip(t, data).File {
+		byteRange, ok := index[file.Name]
+		if !ok {
+			continue
+		}
+		if byteRange.OriginalSize != int64(reader.file(file.Name).UncompressedSize64) {
+			t.Errorf("Expected an original size of %d, got %d", reader.file(file.Name).UncompressedSize64, byteRange.OriginalSize)
+		}
+		raw, err := file.OpenRaw()
+		if err != nil {
+			t.Fatalf("Could not open %s, %s", file.Name, err)
+		}
+		expected := make([]byte, file.CompressedSize64)
+		if _, err = io.ReadFull(raw, expected); err != nil {
+			t.Fatalf("Could not read %s, %s", file.Name, err)
+		}
+		end := byteRange.Offset + byteRange.CompressedSize
+		if end > int64(len(data)) || !bytes.Equal(data[byteRange.Offset:end], expected) {
+			t.Errorf("Expected the byte range of %s to point to its compressed data", file.Name)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/readium/readium-lcp-server/rwpm"
+)
+
+// CoverLink returns the link explicitly marked as the cover of the publication,
+// or nil if there is none. The cover may be a remote resource referenced by an absolute url,
+// which is not part of the package: it is never encrypted and its link is kept as is.
+func (reader *RWPPReader) CoverLink() *rwpm.Link {
+	cover, err := reader.manifest.Cover()
+	if err != nil {
+		return nil
+	}
+	return &cover
+}
+
+// GuessCover returns the first image of the publication as a candidate cover,
+// if its dimensions are portrait-like. It returns nil if there is no candidate.
+// Only the image header is decoded, remote images are skipped.
+// This heuristic ignores the explicit cover, see CoverLink.
+func (reader *RWPPReader) GuessCover() (*rwpm.Link, error) {
+	var candidate *rwpm.Link
+	for _, links := range [][]rwpm.Link{reader.manifest.ReadingOrder, reader.manifest.Resources} {
+		for i := range links {
+			if strings.HasPrefix(links[i].Type, "image/") && !isExternal(links[i].Href) {
+				candidate = &links[i]
+				break
+			}
+		}
+		if candidate != nil {
+			break
+		}
+	}
+	if candidate == nil {
+		return nil, nil
+	}
+
+	file := reader.file(candidate.Href)
+	if file == nil {
+		return nil, errors.New("image not found in the package: " + candidate.Href)
+	}
+	config, err := imageConfig(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Height <= config.Width {
+		return nil, nil
+	}
+
+	cover := *candidate
+	cover.Width = config.Width
+	cover.Height = config.Height
+	return &cover, nil
+}
@@ -0,0 +1,174 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/readium/readium-lcp-server/crypto"
+	"github.com/readium/readium-lcp-server/license"
+)
+
+// testPNG returns a blank png image of the given dimensions
+func testPNG(t *testing.T, width, height int) []byte {
+	var b bytes.Buffer
+	if err := png.Encode(&b, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatalf("Could not encode a png image, %s", err)
+	}
+	return b.Bytes()
+}
+
+const coverTestManifest = `{
+	"metadata": {"title": "cover"},
+	"readingOrder": [
+		{"href": "page1.png", "type": "image/png"},
+		{"href": "page2.png", "type": "image/png"}
+	]
+}`
+
+func TestGuessCover(t *testing.T) {
+	reader := openTestRWPP(t,
+		testEntry{name: ManifestLocation, method: Deflate, body: []byte(coverTestManifest)},
+		testEntry{name: "page1.png", method: NoCompression, body: testPNG(t, 60, 90)},
+		testEntry{name: "page2.png", method: NoCompression, body: testPNG(t, 60, 90)},
+	)
+
+	if reader.CoverLink() != nil {
+		t.Error("Did not expect an explicit cover")
+	}
+	cover, err := reader.GuessCover()
+	if err != nil {
+		t.Fatalf("Could not guess the cover, %s", err)
+	}
+	if cover == nil {
+		t.Fatal("Expected a candidate cover")
+	}
+	if cover.Href != "page1.png" {
+		t.Errorf("Expected page1.png as the cover, got %s", cover.Href)
+	}
+	if cover.Width != 60 || cover.Height != 90 {
+		t.Errorf("Expected a 60x90 cover, got %dx%d", cover.Width, cover.Height)
+	}
+}
+
+func TestGuessCoverLandscape(t *testing.T) {
+	reader := openTestRWPP(t,
+		testEntry{name: ManifestLocation, method: Deflate, body: []byte(coverTestManifest)},
+		testEntry{name: "page1.png", method: NoCompression, body: testPNG(t, 90, 60)},
+		testEntry{name: "page2.png", method: NoCompression, body: testPNG(t, 60, 90)},
+	)
+
+	cover, err := reader.GuessCover()
+	if err != nil {
+		t.Fatalf("Could not guess the cover, %s", err)
+	}
+	if cover != nil {
+		t.Errorf("Did not expect a landscape image to be a candidate cover, got %s", cover.Href)
+	}
+}
+
+func TestCoverLink(t *testing.T) {
+	manifest := `{
+		"metadata": {"title": "cover"},
+		"readingOrder": [{"href": "page1.png", "type": "image/png"}],
+		"resources": [{"href": "cover.jpg", "type": "image/jpeg", "rel": "cover"}]
+	}`
+	reader := openTestRWPP(t,
+		testEntry{name: ManifestLocation, method: Deflate, body: []byte(manifest)},
+	)
+
+	cover := reader.CoverLink()
+	if cover == nil || cover.Href != "cover.jpg" {
+		t.Errorf("Expected cover.jpg as the explicit cover, got %v", cover)
+	}
+}
+
+func TestSetImageDimensions(t *testing.T) {
+	manifest := `{
+		"metadata": {"title": "dimensions"},
+		"readingOrder": [
+			{"href": "page1.png", "type": "image/png"},
+			{"href": "page2.png", "type": "image/png", "width": 10, "height": 20}
+		],
+		"resources": [{"href": "style.css", "type": "text/css"}]
+	}`
+	reader := openTestRWPP(t,
+		testEntry{name: ManifestLocation, method: Deflate, body: []byte(manifest)},
+		testEntry{name: "page1.png", method: NoCompression, body: testPNG(t, 60, 90)},
+		testEntry{name: "page2.png", method: NoCompression, body: testPNG(t, 60, 90)},
+		testEntry{name: "style.css", method: Deflate, body: []byte("body {}")},
+	)
+
+	reader.SetImageDimensions()
+
+	var b bytes.Buffer
+	writer, err := reader.NewWriter(&b)
+	if err != nil {
+		t.Fatalf("Could not build a writer, %s", err)
+	}
+	if _, err = Process(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer); err != nil {
+		t.Fatalf("Could not process the package, %s", err)
+	}
+
+	out := readOutputManifest(t, b.Bytes())
+	if page := out.ReadingOrder[0]; page.Width != 60 || page.Height != 90 {
+		t.Errorf("Expected page1.png to be 60x90, got %dx%d", page.Width, page.Height)
+	}
+	// explicit dimensions are kept
+	if page := out.ReadingOrder[1]; page.Width != 10 || page.Height != 20 {
+		t.Errorf("Expected page2.png to be 10x20, got %dx%d", page.Width, page.Height)
+	}
+	if style := out.Resources[0]; style.Width != 0 || style.Height != 0 {
+		t.Errorf("Did not expect dimensions on style.css, got %dx%d", style.Width, style.Height)
+	}
+}
+
+func TestRemoteCover(t *testing.T) {
+	manifest := `{
+		"metadata": {"title": "remote cover"},
+		"readingOrder": [{"href": "chapter.html", "type": "text/html"}],
+		"resources": [{"href": "https://example.com/cover.jpg", "type": "image/jpeg", "rel": "cover"}]
+	}`
+	reader := openTestRWPP(t,
+		testEntry{name: ManifestLocation, method: Deflate, body: []byte(manifest)},
+		testEntry{name: "chapter.html", method: Deflate, body: []byte("<p>chapter</p>")},
+	)
+	reader.EncryptAncillary = true
+
+	cover := reader.CoverLink()
+	if cover == nil || cover.Href != "https://example.com/cover.jpg" {
+		t.Fatalf("Expected the remote cover, got %v", cover)
+	}
+	if guess, err := reader.GuessCover(); err != nil || guess != nil {
+		t.Errorf("Did not expect a remote image to be a candidate cover, got %v, %v", guess, err)
+	}
+	resources := reader.Resources()
+	if len(resources) != 1 || resources[0].Path() != "chapter.html" {
+		t.Fatalf("Expected the chapter as the only resource, got %d resources", len(resources))
+	}
+
+	var b bytes.Buffer
+	writer, err := reader.NewWriter(&b)
+	if err != nil {
+		t.Fatalf("Could not build a writer, %s", err)
+	}
+	if _, err = Process(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer); err != nil {
+		t.Fatalf("Could not process the package, %s", err)
+	}
+
+	out := readOutputManifest(t, b.Bytes())
+	if len(out.Resources) != 1 || out.Resources[0].Href != "https://example.com/cover.jpg" {
+		t.Fatalf("Expected the remote cover to be kept, got %v", out.Resources)
+	}
+	if isEncryptedLink(out.Resources[0]) {
+		t.Error("Did not expect the remote cover to be marked as encrypted")
+	}
+	if !isEncryptedLink(out.ReadingOrder[0]) {
+		t.Error("Expected the chapter to be encrypted")
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"github.com/readium/readium-lcp-server/rwpm"
+	"github.com/readium/readium-lcp-server/xmlenc"
+)
+
+// lcpScheme identifies the resources encrypted with LCP
+const lcpScheme = "http://readium.org/2014/01/lcp"
+
+// XMLEncFromManifest builds the encryption.xml structure matching the encrypted properties of a Readium manifest.
+// Every encrypted link of the reading order and resources, including alternates and children, is listed once.
+func XMLEncFromManifest(p rwpm.Publication) xmlenc.Manifest {
+	var m xmlenc.Manifest
+	seen := map[string]bool{}
+
+	var walk func(links []rwpm.Link)
+	walk = func(links []rwpm.Link) {
+		for _, link := range links {
+			if link.Properties != nil && link.Properties.Encrypted != nil && !seen[link.Href] {
+				seen[link.Href] = true
+				addEncryptedLink(&m, link)
+			}
+			walk(link.Alternate)
+			walk(link.Children)
+		}
+	}
+	walk(p.ReadingOrder)
+	walk(p.Resources)
+	return m
+}
+
+// addEncryptedLink converts the encrypted property of a link into an EncryptedData item
+func addEncryptedLink(m *xmlenc.Manifest, link rwpm.Link) {
+	encrypted := link.Properties.Encrypted
+
+	var compression *xmlenc.Compression
+	if encrypted.Compression != "" || encrypted.OriginalLength > 0 {
+		method := NoCompression
+		if encrypted.Compression == CompressionDeflate {
+			method = Deflate
+		}
+		compression = &xmlenc.Compression{Method: method, OriginalLength: uint64(encrypted.OriginalLength)}
+	}
+
+	data := m.AddData(link.Href, encrypted.Algorithm, compression)
+	if encrypted.Scheme == lcpScheme {
+		data.KeyInfo = lcpKeyInfo()
+	}
+}
+
+// EncryptedFromXMLEnc converts an EncryptedData item of encryption.xml into the encrypted property of a link,
+// the reverse of XMLEncFromManifest. The profile is not part of encryption.xml and is left empty.
+func EncryptedFromXMLEnc(data xmlenc.Data) rwpm.Encrypted {
+	encrypted := rwpm.Encrypted{Algorithm: string(data.Method.Algorithm)}
+	if data.KeyInfo != nil && data.KeyInfo.RetrievalMethod.Type == lcpKeyType {
+		encrypted.Scheme = lcpScheme
+	}
+	if data.Properties != nil {
+		for _, property := range data.Properties.Properties {
+			if property.Compression.Method == xmlenc.DeflateMethod {
+				encrypted.Compression = CompressionDeflate
+			}
+			if property.Compression.OriginalLength > 0 {
+				encrypted.OriginalLength = int(property.Compression.OriginalLength)
+			}
+		}
+	}
+	return encrypted
+}
+
+// lcpKeyType is the type of the LCP content key retrieval method
+const lcpKeyType = "http://readium.org/2014/01/lcp#EncryptedContentKey"
+
+// lcpKeyInfo references the content key of the LCP license
+func lcpKeyInfo() *xmlenc.KeyInfo {
+	keyInfo := &xmlenc.KeyInfo{}
+	keyInfo.RetrievalMethod.URI = "license.lcpl#/encryption/content_key"
+	keyInfo.RetrievalMethod.Type = lcpKeyType
+	return keyInfo
+}
@@ -0,0 +1,53 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/readium/readium-lcp-server/rwpm"
+)
+
+const encryptedManifest = `{
+	"metadata": {"title": "encrypted"},
+	"readingOrder": [
+		{"href": "chapter 1.html", "type": "text/html",
+			"properties": {"encrypted": {"scheme": "http://readium.org/2014/01/lcp", "profile": "http://readium.org/lcp/basic-profile",
+				"algorithm": "http://www.w3.org/2001/04/xmlenc#aes256-cbc", "compression": "deflate", "original-length": 1234}},
+			"alternate": [{"href": "chapter1.pdf", "type": "application/pdf",
+				"properties": {"encrypted": {"scheme": "http://readium.org/2014/01/lcp", "algorithm": "http://www.w3.org/2001/04/xmlenc#aes256-cbc"}}}]},
+		{"href": "chapter2.html", "type": "text/html"}
+	],
+	"resources": [
+		{"href": "cover.jpg", "type": "image/jpeg",
+			"properties": {"encrypted": {"scheme": "http://readium.org/2014/01/lcp", "algorithm": "http://www.w3.org/2001/04/xmlenc#aes256-cbc", "original-length": 42}}},
+		{"href": "chapter 1.html", "type": "text/html",
+			"properties": {"encrypted": {"scheme": "http://readium.org/2014/01/lcp", "algorithm": "http://www.w3.org/2001/04/xmlenc#aes256-cbc"}}}
+	]
+}`
+
+func TestXMLEncFromManifest(t *testing.T) {
+	var publication rwpm.Publication
+	if err := json.Unmarshal([]byte(encryptedManifest), &publication); err != nil {
+		t.Fatal(err)
+	}
+
+	m := XMLEncFromManifest(publication)
+	var b bytes.Buffer
+	if err := m.Write(&b); err != nil {
+		t.Fatalf("Could not write encryption.xml, %s", err)
+	}
+
+	golden, err := ioutil.ReadFile("./samples/encryption.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bytes.TrimSpace(b.Bytes()), bytes.TrimSpace(golden)) {
+		t.Errorf("Expected the golden encryption.xml, got\n%s", b.Bytes())
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/readium/readium-lcp-server/epub"
+	"github.com/readium/readium-lcp-server/epub/opf"
+	"github.com/readium/readium-lcp-server/license"
+	"github.com/readium/readium-lcp-server/rwpm"
+	"github.com/readium/readium-lcp-server/xmlenc"
+)
+
+// ConvertEPUBToRWPP builds a Readium package (rwppPath) from an EPUB, encrypted or not (epubPath), without re-encrypting it.
+// The reading order is the spine of the OPF, the other items of the OPF manifest become resources,
+// and the encrypted properties of the links are derived from encryption.xml.
+// The resources are copied verbatim, and the license of the EPUB is moved to the root of the package.
+func ConvertEPUBToRWPP(epubPath string, rwppPath string) (err error) {
+
+	// open the epub file
+	epubFile, err := zip.OpenReader(epubPath)
+	if err != nil {
+		return err
+	}
+	defer epubFile.Close()
+
+	reader, err := NewEPUBReader(&epubFile.Reader)
+	if err != nil {
+		return err
+	}
+	ep := reader.epub
+	if len(ep.Package) == 0 {
+		return errors.New("the EPUB has no package document")
+	}
+
+	files := make(map[string]*zip.File, len(epubFile.File))
+	for _, file := range epubFile.File {
+		files[file.Name] = file
+	}
+
+	// the encryption profile is only known from the license
+	var profile string
+	if file, ok := files[epub.LicenseFile]; ok {
+		if profile, err = licenseProfile(file); err != nil {
+			return err
+		}
+	}
+
+	manifest := manifestFromOPF(ep.Package[0], ep.Encryption, profile)
+
+	// marshal the Readium manifest
+	rwpJSON, err := json.MarshalIndent(manifest, "", " ")
+	if err != nil {
+		return err
+	}
+
+	// create the rwpp file
+	rwppFile, err := os.Create(rwppPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// a close error must not be masked by a prior nil
+		if closeErr := rwppFile.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	// create a zip writer on the rwpp
+	zipWriter := zip.NewWriter(rwppFile)
+
+	// add the Readium manifest to the rwpp
+	man, err := zipWriter.Create(ManifestLocation)
+	if err != nil {
+		return closeZipWriter(zipWriter, err)
+	}
+	if _, err = man.Write(rwpJSON); err != nil {
+		return closeZipWriter(zipWriter, err)
+	}
+
+	// copy the license, renamed
+	if file, ok := files[epub.LicenseFile]; ok {
+		renamed := *file
+		renamed.Name = LicenseLocation
+		if err = copyZipEntry(zipWriter, &renamed); err != nil {
+			return closeZipWriter(zipWriter, err)
+		}
+	}
+
+	// copy the publication resources, compressed or not
+	copied := map[string]bool{}
+	for _, links := range [][]rwpm.Link{manifest.ReadingOrder, manifest.Resources} {
+		for _, link := range links {
+			file, ok := files[link.Href]
+			if !ok || copied[link.Href] {
+				continue
+			}
+			copied[link.Href] = true
+			if err = copyZipEntry(zipWriter, file); err != nil {
+				return closeZipWriter(zipWriter, err)
+			}
+		}
+	}
+	return closeZipWriter(zipWriter, nil)
+}
+
+// manifestFromOPF builds a Readium manifest from an OPF package and the encryption.xml of the EPUB.
+// The hrefs of the links are the names of the zip entries.
+func manifestFromOPF(p opf.Package, encryption *xmlenc.Manifest, profile string) rwpm.Publication {
+	var manifest rwpm.Publication
+	manifest.Context = rwpm.MultiString{rwpm.ContextURL}
+	manifest.Metadata.ConformsTo = rwpm.MultiString{rwpm.ProfileEPUB}
+	manifest.Metadata.Identifier = p.Metadata.Isbn
+	manifest.Metadata.Title.SetDefault(p.Metadata.Title)
+	if p.Metadata.Author != "" {
+		manifest.Metadata.Author.AddName(p.Metadata.Author)
+	}
+
+	link := func(item opf.Item) rwpm.Link {
+		l := rwpm.Link{Href: opfItemPath(p.BasePath, item.Href), Type: item.MediaType}
+		for _, property := range strings.Fields(item.Properties) {
+			switch property {
+			case "nav":
+				l.AddRel("contents")
+			case "cover-image":
+				l.AddRel("cover")
+			}
+		}
+		if encryption != nil {
+			if data, ok := encryption.DataForFile(l.Href); ok {
+				encrypted := EncryptedFromXMLEnc(data)
+				encrypted.Profile = profile
+				l.Properties = &rwpm.Properties{Encrypted: &encrypted}
+			}
+		}
+		return l
+	}
+
+	inSpine := map[string]bool{}
+	for _, itemref := range p.Spine.Itemrefs {
+		if item, ok := p.Manifest.ItemWithID(itemref.Idref); ok && !inSpine[item.Id] {
+			inSpine[item.Id] = true
+			manifest.ReadingOrder = append(manifest.ReadingOrder, link(item))
+		}
+	}
+	for _, item := range p.Manifest.Items {
+		if !inSpine[item.Id] {
+			manifest.Resources = append(manifest.Resources, link(item))
+		}
+	}
+	return manifest
+}
+
+// opfItemPath returns the name of the zip entry of an OPF item, whose href is relative to the OPF
+// and may be percent-encoded
+func opfItemPath(basePath string, href string) string {
+	if unescaped, err := url.PathUnescape(href); err == nil {
+		href = unescaped
+	}
+	return path.Join(basePath, href)
+}
+
+// licenseProfile returns the encryption profile of an LCP license
+func licenseProfile(file *zip.File) (string, error) {
+	r, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	var lic license.License
+	if err = json.NewDecoder(r).Decode(&lic); err != nil {
+		return "", err
+	}
+	return lic.Encryption.Profile, nil
+}
@@ -0,0 +1,144 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/readium/readium-lcp-server/crypto"
+	"github.com/readium/readium-lcp-server/epub"
+	"github.com/readium/readium-lcp-server/license"
+	"github.com/readium/readium-lcp-server/rwpm"
+)
+
+func TestConvertEPUBToRWPP(t *testing.T) {
+	const testLicense = `{"encryption": {"profile": "http://readium.org/lcp/basic-profile"}}`
+
+	// build an encrypted EPUB
+	data := buildTestZip(t,
+		testEntry{name: "mimetype", method: NoCompression, body: []byte(epub.ContentType_EPUB)},
+		testEntry{name: epub.ContainerFile, method: Deflate, body: []byte(testContainer)},
+		testEntry{name: epub.LicenseFile, method: Deflate, body: []byte(testLicense)},
+		testEntry{name: "OPS/package.opf", method: Deflate, body: []byte(testOPF)},
+		testEntry{name: "OPS/nav.xhtml", method: Deflate, body: []byte("<nav/>")},
+		testEntry{name: "OPS/chapter.xhtml", method: Deflate, body: bytes.Repeat([]byte("<p>chapter</p>"), 100)},
+		testEntry{name: "OPS/image.png", method: Deflate, body: []byte("png")},
+		testEntry{name: "OPS/font.otf", method: Deflate, body: []byte("otf")},
+		testEntry{name: "OPS/audio.mp3", method: NoCompression, body: []byte("mp3")},
+	)
+	reader, err := NewEPUBReader(openTestZip(t, data))
+	if err != nil {
+		t.Fatalf("Could not read the EPUB, %s", err)
+	}
+	var b bytes.Buffer
+	writer, err := reader.NewWriter(&b)
+	if err != nil {
+		t.Fatalf("Could not build a writer, %s", err)
+	}
+	if _, err = Process(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer); err != nil {
+		t.Fatalf("Could not process the EPUB, %s", err)
+	}
+
+	dir, err := ioutil.TempDir("", "convert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	epubPath := filepath.Join(dir, "test.epub")
+	rwppPath := filepath.Join(dir, "test.lcpau")
+	if err = ioutil.WriteFile(epubPath, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ConvertEPUBToRWPP(epubPath, rwppPath); err != nil {
+		t.Fatalf("Could not convert the EPUB, %s", err)
+	}
+
+	encrypted, err := epub.Read(openTestZip(t, b.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rwpp, err := OpenRWPP(rwppPath)
+	if err != nil {
+		t.Fatalf("Could not open the converted package, %s", err)
+	}
+	manifest := rwpp.manifest
+
+	// the reading order is the spine
+	if len(manifest.ReadingOrder) != 1 || manifest.ReadingOrder[0].Href != "OPS/chapter.xhtml" || manifest.ReadingOrder[0].Type != epub.ContentType_XHTML {
+		t.Fatalf("Expected the chapter in the reading order, got %+v", manifest.ReadingOrder)
+	}
+	if len(manifest.Resources) != 4 {
+		t.Errorf("Expected 4 resources, got %+v", manifest.Resources)
+	}
+	if nav, err := manifest.NavDoc(); err != nil || nav.Href != "OPS/nav.xhtml" {
+		t.Errorf("Expected the navigation document to be a contents link, got %+v", nav)
+	}
+
+	// the encrypted properties match encryption.xml
+	for _, href := range []string{"OPS/chapter.xhtml", "OPS/image.png", "OPS/font.otf"} {
+		data, ok := encrypted.Encryption.DataForFile(href)
+		if !ok {
+			t.Fatalf("Expected %s to be encrypted in the EPUB", href)
+		}
+		var properties *rwpm.Properties
+		for _, link := range append(manifest.ReadingOrder, manifest.Resources...) {
+			if link.Href == href {
+				properties = link.Properties
+			}
+		}
+		if properties == nil || properties.Encrypted == nil {
+			t.Errorf("Expected %s to be encrypted in the manifest", href)
+			continue
+		}
+		e := *properties.Encrypted
+		expected := EncryptedFromXMLEnc(data)
+		if e.Scheme != lcpScheme || e.Algorithm != expected.Algorithm || e.Compression != expected.Compression ||
+			e.OriginalLength != expected.OriginalLength || e.Profile != license.BasicProfile.String() {
+			t.Errorf("Expected %s to have the encrypted properties %+v, got %+v", href, expected, e)
+		}
+	}
+	for _, link := range manifest.Resources {
+		if link.Href == "OPS/nav.xhtml" && link.Properties != nil {
+			t.Error("Did not expect the navigation document to be encrypted")
+		}
+	}
+
+	// the resources are copied verbatim and the license is moved
+	for _, name := range []string{"OPS/chapter.xhtml", LicenseLocation} {
+		source := name
+		if name == LicenseLocation {
+			source = epub.LicenseFile
+		}
+		if !bytes.Equal(readZipEntry(t, openTestZip(t, b.Bytes()), source), readZipEntry(t, rwpp.zipArchive, name)) {
+			t.Errorf("Expected %s to be copied verbatim", name)
+		}
+	}
+}
+
+// readZipEntry returns the content of a zip entry
+func readZipEntry(t *testing.T, zr *zip.Reader, name string) []byte {
+	for _, file := range zr.File {
+		if file.Name == name {
+			rc, err := file.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+			content, err := ioutil.ReadAll(rc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return content
+		}
+	}
+	t.Fatalf("Could not find %s", name)
+	return nil
+}
@@ -0,0 +1,244 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"archive/zip"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/readium/readium-lcp-server/epub"
+	"github.com/readium/readium-lcp-server/license"
+	"github.com/readium/readium-lcp-server/xmlenc"
+)
+
+// EPUBReader is an EPUB package reader, usable with Process
+type EPUBReader struct {
+	// files indexes the zip entries by name; it is nil if the reader was built from an EPUB object,
+	// whose resources are then read from their contents
+	files map[string]*zip.File
+	epub  epub.Epub
+}
+
+// NewEPUBReader creates a new EPUB package reader.
+// The OPF files are found from META-INF/container.xml,
+// and resources declared in META-INF/encryption.xml are considered as encrypted.
+func NewEPUBReader(zipReader *zip.Reader) (*EPUBReader, error) {
+	ep, err := epub.Read(zipReader)
+	if err != nil {
+		return nil, err
+	}
+
+	// resources are opened lazily from the zip archive
+	for _, resource := range ep.Resource {
+		if closer, ok := resource.Contents.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+	files := make(map[string]*zip.File, len(zipReader.File))
+	for _, file := range zipReader.File {
+		files[file.Name] = file
+	}
+	return &EPUBReader{files: files, epub: ep}, nil
+}
+
+// OpenEPUB opens an EPUB package and returns an EPUB package reader
+func OpenEPUB(name string) (*EPUBReader, error) {
+	zipArchive, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, err
+	}
+	return NewEPUBReader(&zipArchive.Reader)
+}
+
+// Resources returns the resources of the package, excluding the mimetype and encryption.xml files.
+// The publication resources which must stay in clear, like the OPF, navigation document and cover,
+// cannot be encrypted.
+func (reader *EPUBReader) Resources() []Resource {
+	return reader.resources(reader.policy())
+}
+
+// resources returns the resources of the package, under an encryption policy
+func (reader *EPUBReader) resources(policy EncryptionPolicy) []Resource {
+	var resources []Resource
+	for _, resource := range reader.epub.Resource {
+		isEncrypted := false
+		if reader.epub.Encryption != nil {
+			_, isEncrypted = reader.epub.Encryption.DataForFile(resource.Path)
+		}
+		resources = append(resources, &epubResource{
+			resource:    resource,
+			file:        reader.files[resource.Path],
+			isEncrypted: isEncrypted,
+			canEncrypt:  reader.epub.CanEncrypt(resource.Path),
+			policy:      policy,
+		})
+	}
+	return resources
+}
+
+// policy returns the encryption policy of EPUB packages
+func (reader *EPUBReader) policy() EncryptionPolicy {
+	return EPUBEncryptionPolicy
+}
+
+// NewWriter returns a new PackageWriter writing an EPUB to the output file.
+// The encryption.xml entries of the source package are kept.
+func (reader *EPUBReader) NewWriter(writer io.Writer) (PackageWriter, error) {
+	epubWriter := epub.NewWriter(writer)
+	if err := epubWriter.WriteHeader(); err != nil {
+		epubWriter.Close()
+		return nil, err
+	}
+
+	encryption := &xmlenc.Manifest{}
+	if reader.epub.Encryption != nil {
+		encryption.Data = append(encryption.Data, reader.epub.Encryption.Data...)
+	}
+	return &EPUBWriter{writer: epubWriter, encryption: encryption, policy: reader.policy()}, nil
+}
+
+// EPUBEncryptionPolicy is the encryption policy of EPUB packages:
+// every resource is deflated before encryption, except images, audio and video,
+// which are already compressed and must remain streamable.
+var EPUBEncryptionPolicy EncryptionPolicy = epubEncryptionPolicy{}
+
+type epubEncryptionPolicy struct{}
+
+func (epubEncryptionPolicy) ShouldEncrypt(contentType string) bool { return true }
+func (epubEncryptionPolicy) ShouldCompress(contentType string) bool {
+	return !strings.HasPrefix(contentType, "image") && !strings.HasPrefix(contentType, "video") && !strings.HasPrefix(contentType, "audio")
+}
+
+type epubResource struct {
+	isEncrypted bool
+	canEncrypt  bool
+	resource    *epub.Resource
+	// file is the zip entry of the resource, nil if its contents are read from the EPUB object
+	file   *zip.File
+	policy EncryptionPolicy
+}
+
+func (resource *epubResource) Path() string        { return resource.resource.Path }
+func (resource *epubResource) ContentType() string { return resource.resource.ContentType }
+func (resource *epubResource) Size() int64         { return int64(resource.resource.OriginalSize) }
+func (resource *epubResource) Encrypted() bool     { return resource.isEncrypted }
+func (resource *epubResource) CanBeEncrypted() bool {
+	return resource.canEncrypt && resource.policy.ShouldEncrypt(resource.resource.ContentType)
+}
+func (resource *epubResource) CompressBeforeEncryption() bool {
+	return resource.policy.ShouldCompress(resource.resource.ContentType)
+}
+
+// Open opens the zip entry of the resource, or returns the contents of the resource of the EPUB object,
+// which can only be read once
+func (resource *epubResource) Open() (io.ReadCloser, error) {
+	if resource.file != nil {
+		return resource.file.Open()
+	}
+	if rc, ok := resource.resource.Contents.(io.ReadCloser); ok {
+		return rc, nil
+	}
+	return ioutil.NopCloser(resource.resource.Contents), nil
+}
+
+func (resource *epubResource) ModTime() time.Time {
+	if resource.file == nil {
+		return time.Time{}
+	}
+	return resource.file.Modified
+}
+
+func (resource *epubResource) CRC32() uint32 {
+	if resource.file == nil {
+		return 0
+	}
+	return resource.file.CRC32
+}
+
+// CopyTo copies the resource verbatim into a package
+func (resource *epubResource) CopyTo(packageWriter PackageWriter) error {
+	return resource.CopyToWithProgress(packageWriter, nil)
+}
+
+// CopyToWithProgress copies the resource verbatim into a package, reporting the progress of the copy
+func (resource *epubResource) CopyToWithProgress(packageWriter PackageWriter, progress ProgressFunc) error {
+	wc, err := packageWriter.NewFile(resource.Path(), resource.ContentType(), resource.resource.StorageMethod)
+	if err != nil {
+		return err
+	}
+	if expecter, ok := wc.(sizeExpecter); ok {
+		expecter.expectSize(resource.Size())
+	}
+
+	rc, err := resource.Open()
+	if err != nil {
+		wc.Close()
+		return err
+	}
+
+	_, err = io.Copy(wc, newProgressReader(rc, resource.Path(), resource.Size(), progress))
+
+	rCloseError := rc.Close()
+	wCloseError := wc.Close()
+
+	if err != nil {
+		return err
+	}
+	if rCloseError != nil {
+		return rCloseError
+	}
+	return wCloseError
+}
+
+// EPUBWriter is an EPUB package writer, which records the encrypted resources in META-INF/encryption.xml
+type EPUBWriter struct {
+	writer     *epub.Writer
+	encryption *xmlenc.Manifest
+	policy     EncryptionPolicy
+	compressed map[string]bool
+}
+
+// NewFile creates a file in the package.
+// The files which the policy does not compress, like images, audio and video, are stored without compression,
+// whatever the storage method requested.
+func (writer *EPUBWriter) NewFile(path string, contentType string, storageMethod uint16) (io.WriteCloser, error) {
+	if writer.policy.ShouldCompress(contentType) {
+		if writer.compressed == nil {
+			writer.compressed = map[string]bool{}
+		}
+		writer.compressed[path] = true
+	} else {
+		storageMethod = NoCompression
+	}
+
+	w, err := writer.writer.AddResource(path, storageMethod)
+	return newCountingWriteCloser(w, path), err
+}
+
+// MarkAsEncrypted adds the resource to encryption.xml, with its compression method and original size.
+// The profile is not written in the package, but must be a known one.
+func (writer *EPUBWriter) MarkAsEncrypted(path string, originalSize int64, profile license.EncryptionProfile, algorithm string) error {
+	if err := profile.Validate(); err != nil {
+		return err
+	}
+	method := NoCompression
+	if writer.compressed[path] {
+		method = Deflate
+	}
+	data := writer.encryption.AddData(path, algorithm, &xmlenc.Compression{Method: method, OriginalLength: uint64(originalSize)})
+	data.KeyInfo = lcpKeyInfo()
+	return nil
+}
+
+// Close writes encryption.xml and closes the package
+func (writer *EPUBWriter) Close() error {
+	if len(writer.encryption.Data) > 0 {
+		writer.writer.WriteEncryption(writer.encryption)
+	}
+	return writer.writer.Close()
+}
@@ -0,0 +1,163 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/readium/readium-lcp-server/crypto"
+	"github.com/readium/readium-lcp-server/epub"
+	"github.com/readium/readium-lcp-server/license"
+)
+
+const testContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container xmlns="urn:oasis:names:tc:opendocument:xmlns:container" version="1.0">
+<rootfiles><rootfile full-path="OPS/package.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>`
+
+const testOPF = `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="bookid">
+	<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+		<dc:identifier id="bookid">test-epub</dc:identifier>
+		<dc:title>test</dc:title>
+	</metadata>
+	<manifest>
+		<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+		<item id="chapter" href="chapter.xhtml" media-type="application/xhtml+xml"/>
+		<item id="image" href="image.png" media-type="image/png"/>
+		<item id="font" href="font.otf" media-type="font/otf"/>
+		<item id="audio" href="audio.mp3" media-type="audio/mpeg"/>
+	</manifest>
+	<spine><itemref idref="chapter"/></spine>
+</package>`
+
+const testEncryptionXML = `<?xml version="1.0" encoding="UTF-8"?>
+<encryption xmlns="urn:oasis:names:tc:opendocument:xmlns:container" xmlns:enc="http://www.w3.org/2001/04/xmlenc#">
+	<enc:EncryptedData>
+		<enc:EncryptionMethod Algorithm="http://www.w3.org/2001/04/xmlenc#aes256-cbc"/>
+		<enc:CipherData><enc:CipherReference URI="OPS/audio.mp3"/></enc:CipherData>
+	</enc:EncryptedData>
+</encryption>`
+
+func TestEPUBReader(t *testing.T) {
+	chapter := bytes.Repeat([]byte("<p>chapter</p>"), 100)
+	data := buildTestZip(t,
+		testEntry{name: "mimetype", method: NoCompression, body: []byte(epub.ContentType_EPUB)},
+		testEntry{name: epub.ContainerFile, method: Deflate, body: []byte(testContainer)},
+		testEntry{name: epub.EncryptionFile, method: Deflate, body: []byte(testEncryptionXML)},
+		testEntry{name: "OPS/package.opf", method: Deflate, body: []byte(testOPF)},
+		testEntry{name: "OPS/nav.xhtml", method: Deflate, body: []byte("<nav/>")},
+		testEntry{name: "OPS/chapter.xhtml", method: Deflate, body: chapter},
+		testEntry{name: "OPS/image.png", method: Deflate, body: []byte("png")},
+		testEntry{name: "OPS/font.otf", method: Deflate, body: []byte("otf")},
+		testEntry{name: "OPS/audio.mp3", method: NoCompression, body: []byte("already encrypted")},
+	)
+
+	reader, err := NewEPUBReader(openTestZip(t, data))
+	if err != nil {
+		t.Fatalf("Could not read the EPUB, %s", err)
+	}
+
+	// everything but images, audio and video is compressed before encryption
+	expected := map[string]struct{ compress, canEncrypt, encrypted bool }{
+		epub.ContainerFile:  {true, false, false},
+		"OPS/package.opf":   {true, false, false},
+		"OPS/nav.xhtml":     {true, false, false},
+		"OPS/chapter.xhtml": {true, true, false},
+		"OPS/image.png":     {false, true, false},
+		"OPS/font.otf":      {true, true, false},
+		"OPS/audio.mp3":     {false, true, true},
+	}
+	resources := reader.Resources()
+	if len(resources) != len(expected) {
+		t.Fatalf("Expected %d resources, got %d", len(expected), len(resources))
+	}
+	for _, resource := range resources {
+		e, ok := expected[resource.Path()]
+		if !ok {
+			t.Errorf("Unexpected resource %s", resource.Path())
+			continue
+		}
+		if resource.CompressBeforeEncryption() != e.compress || resource.CanBeEncrypted() != e.canEncrypt || resource.Encrypted() != e.encrypted {
+			t.Errorf("Expected %s to be %+v", resource.Path(), e)
+		}
+	}
+
+	var b bytes.Buffer
+	writer, err := reader.NewWriter(&b)
+	if err != nil {
+		t.Fatalf("Could not build a writer, %s", err)
+	}
+	if _, err = Process(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer); err != nil {
+		t.Fatalf("Could not process the EPUB, %s", err)
+	}
+
+	output := openTestZip(t, b.Bytes())
+	ep, err := epub.Read(output)
+	if err != nil {
+		t.Fatalf("Could not read the output EPUB, %s", err)
+	}
+	if ep.Encryption == nil || len(ep.Encryption.Data) != 4 {
+		t.Fatalf("Expected 4 encrypted resources, got %v", ep.Encryption)
+	}
+	for _, path := range []string{"OPS/chapter.xhtml", "OPS/image.png", "OPS/font.otf"} {
+		data, ok := ep.Encryption.DataForFile(path)
+		if !ok {
+			t.Errorf("Expected %s to be encrypted", path)
+			continue
+		}
+		// the compression property is only written for deflated resources
+		if compressed := data.Properties != nil; compressed != expected[path].compress {
+			t.Errorf("Expected %s to have the compression property %t", path, expected[path].compress)
+		}
+	}
+	for _, file := range output.File {
+		switch file.Name {
+		case "OPS/image.png":
+			if file.Method != NoCompression {
+				t.Error("Expected the image to be stored")
+			}
+		case "OPS/audio.mp3", "OPS/nav.xhtml":
+			rc, err := file.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, _ := ioutil.ReadAll(rc)
+			rc.Close()
+			if file.Name == "OPS/audio.mp3" && string(content) != "already encrypted" {
+				t.Error("Did not expect an encrypted resource to be encrypted again")
+			}
+			if file.Name == "OPS/nav.xhtml" && string(content) != "<nav/>" {
+				t.Error("Expected the navigation document to be left in clear")
+			}
+		}
+	}
+}
+
+func TestEPUBResourceModTimeAndCRC32(t *testing.T) {
+	z, err := zip.OpenReader("../test/samples/sample.epub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	reader, err := NewEPUBReader(&z.Reader)
+	if err != nil {
+		t.Fatalf("Could not read the EPUB, %s", err)
+	}
+
+	files := map[string]*zip.File{}
+	for _, file := range z.File {
+		files[file.Name] = file
+	}
+	for _, resource := range reader.Resources() {
+		file := files[resource.Path()]
+		if !resource.ModTime().Equal(file.Modified) || resource.CRC32() != file.CRC32 {
+			t.Errorf("Expected %s to have the time and checksum of its zip entry, got %s and %x", resource.Path(), resource.ModTime(), resource.CRC32())
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"archive/zip"
+	"crypto/aes"
+	"time"
+)
+
+// Throughputs used to estimate the duration of a packaging, in bytes per second,
+// calibrated on AES-CBC encryption and deflate compression on a single core
+const (
+	EstimatedEncryptThroughput = 150 << 20
+	EstimatedDeflateThroughput = 20 << 20
+	EstimatedCopyThroughput    = 500 << 20
+)
+
+// estimatedDeflateRatio is the expected ratio of compressed to original size
+// of a stored resource which is deflated before encryption
+const estimatedDeflateRatio = 0.4
+
+// zipEntryOverhead is the size of the local header, data descriptor and central directory record
+// of a zip entry, excluding its name which is written twice
+const zipEntryOverhead = 30 + 16 + 46
+
+// EstimatePackaging estimates the size of the package built by ProcessWithOptions from a Readium package,
+// and the duration of the packaging, from the sizes of the resources and the compression and encryption
+// applied to each of them. Nothing is encrypted or compressed.
+// A resource deflated before encryption is expected to keep the compressed size it has in the source package.
+func EstimatePackaging(reader *RWPPReader, opts PackOptions) (estimatedBytes int64, estimatedDuration time.Duration) {
+
+	var seconds float64
+	add := func(file *zip.File, size int64, throughput float64) {
+		estimatedBytes += size + zipEntryOverhead + 2*int64(len(file.Name))
+		seconds += float64(file.UncompressedSize64) / throughput
+	}
+
+	policy := opts.encryptionPolicy(reader.policy())
+	for _, resource := range reader.resources(policy) {
+		file := resource.(*rwpResource).file
+		if resource.Encrypted() || !resource.CanBeEncrypted() {
+			add(file, int64(file.CompressedSize64), EstimatedCopyThroughput)
+			continue
+		}
+
+		plaintext := int64(file.UncompressedSize64)
+		throughput := float64(EstimatedEncryptThroughput)
+		if resource.CompressBeforeEncryption() {
+			if file.Method == zip.Deflate {
+				plaintext = int64(file.CompressedSize64)
+			} else {
+				plaintext = int64(float64(plaintext) * estimatedDeflateRatio)
+			}
+			// the resource is deflated then encrypted
+			throughput = 1 / (1/float64(EstimatedDeflateThroughput) + 1/float64(EstimatedEncryptThroughput))
+		}
+		// the ciphertext is made of the IV and the padded plaintext
+		add(file, aes.BlockSize+(plaintext/aes.BlockSize+1)*aes.BlockSize, throughput)
+	}
+
+	// the ancillary resources are copied unless they are encrypted with the reading order
+	if !reader.EncryptAncillary {
+		for _, resource := range reader.ancillaryResources(policy) {
+			add(resource.file, int64(resource.file.CompressedSize64), EstimatedCopyThroughput)
+		}
+	}
+	if file := reader.file(reader.manifestName); file != nil {
+		add(file, int64(file.CompressedSize64), EstimatedCopyThroughput)
+	}
+	if file := reader.file(W3CManifestName); file != nil && !opts.DropW3CManifest {
+		add(file, int64(file.CompressedSize64), EstimatedCopyThroughput)
+	}
+
+	// end of central directory record
+	estimatedBytes += 22
+	estimatedDuration = time.Duration(seconds * float64(time.Second))
+	return
+}
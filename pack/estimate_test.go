@@ -0,0 +1,53 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/readium/readium-lcp-server/crypto"
+	"github.com/readium/readium-lcp-server/license"
+)
+
+func TestEstimatePackaging(t *testing.T) {
+	audio := make([]byte, 200000)
+	rand.New(rand.NewSource(1)).Read(audio)
+	cover := make([]byte, 20000)
+	rand.New(rand.NewSource(2)).Read(cover)
+
+	entries := []testEntry{
+		{name: ManifestLocation, method: Deflate, body: []byte(`{"metadata": {"title": "estimate"},
+			"readingOrder": [{"href": "chapter.html", "type": "text/html"}, {"href": "track.mp3", "type": "audio/mpeg"}],
+			"resources": [{"href": "cover.jpg", "type": "image/jpeg"}]}`)},
+		{name: "chapter.html", method: Deflate, body: bytes.Repeat([]byte("<p>Call me Ishmael.</p>\n"), 5000)},
+		{name: "track.mp3", method: NoCompression, body: audio},
+		{name: "cover.jpg", method: NoCompression, body: cover},
+	}
+
+	for _, opts := range []PackOptions{{}, {EncryptionPolicy: EncryptionPolicySkipAudio}} {
+		reader := openTestRWPP(t, entries...)
+		estimatedBytes, estimatedDuration := EstimatePackaging(reader, opts)
+		if estimatedDuration <= 0 {
+			t.Errorf("Expected a positive duration, got %s", estimatedDuration)
+		}
+
+		var b bytes.Buffer
+		writer, err := reader.NewWriterWithOptions(&b, opts)
+		if err != nil {
+			t.Fatalf("Could not build a writer, %s", err)
+		}
+		if _, _, err = ProcessWithOptions(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer, opts); err != nil {
+			t.Fatalf("Could not process the package, %s", err)
+		}
+
+		// the estimate is within 10% of the actual size
+		actual := int64(b.Len())
+		if estimatedBytes < actual*9/10 || estimatedBytes > actual*11/10 {
+			t.Errorf("Expected an estimate close to %d bytes, got %d", actual, estimatedBytes)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"sort"
+
+	"github.com/readium/readium-lcp-server/rwpm"
+)
+
+// FileIndexLocation is the path of the file index in a Readium package
+const FileIndexLocation = "index.json"
+
+// FileIndexEntry describes a resource of a Readium package in the file index.
+// Size is the size of the zip entry once uncompressed, i.e. the ciphertext size of an encrypted resource.
+type FileIndexEntry struct {
+	Href      string `json:"href"`
+	Type      string `json:"type,omitempty"`
+	Size      int64  `json:"size"`
+	Encrypted bool   `json:"encrypted"`
+}
+
+// FileIndex lists the resources of a Readium package, sorted by href,
+// so that tools can look a resource up without parsing the central directory
+type FileIndex []FileIndexEntry
+
+// ReadFileIndex reads the file index of a Readium package
+func ReadFileIndex(r io.Reader) (FileIndex, error) {
+	var index FileIndex
+	err := json.NewDecoder(r).Decode(&index)
+	return index, err
+}
+
+// entryWriter counts the bytes written to a package entry, and records its size when closed
+type entryWriter struct {
+	*countingWriteCloser
+	sizes map[string]int64
+}
+
+// Close records the size of the entry, and checks it against the expected size
+func (w *entryWriter) Close() error {
+	w.sizes[w.path] = w.count
+	return w.countingWriteCloser.Close()
+}
+
+// buildFileIndex lists the resources written to the package, with the type and encryption of their manifest link
+func buildFileIndex(manifest rwpm.Publication, sizes map[string]int64) FileIndex {
+	links := map[string]rwpm.Link{}
+	var walk func(links []rwpm.Link)
+	walk = func(list []rwpm.Link) {
+		for _, link := range list {
+			if _, ok := links[link.Href]; !ok {
+				links[link.Href] = link
+			}
+			walk(link.Alternate)
+			walk(link.Children)
+		}
+	}
+	walk(manifest.ReadingOrder)
+	walk(manifest.Resources)
+
+	index := make(FileIndex, 0, len(sizes))
+	for href, size := range sizes {
+		link, ok := links[href]
+		if !ok {
+			continue
+		}
+		index = append(index, FileIndexEntry{
+			Href:      href,
+			Type:      link.Type,
+			Size:      size,
+			Encrypted: link.Properties != nil && link.Properties.Encrypted != nil,
+		})
+	}
+	sort.Slice(index, func(i, j int) bool { return index[i].Href < index[j].Href })
+	return index
+}
+
+// writeFileIndex writes the file index into the package, in sync with the manifest
+func (writer *RWPPWriter) writeFileIndex() error {
+	w, err := writer.zipWriter.CreateHeader(&zip.FileHeader{
+		Name:     FileIndexLocation,
+		Method:   zip.Deflate,
+		Modified: writer.options.modified(),
+	})
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(buildFileIndex(writer.manifest, writer.sizes))
+}
@@ -0,0 +1,103 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/readium/readium-lcp-server/crypto"
+	"github.com/readium/readium-lcp-server/license"
+)
+
+func TestFileIndex(t *testing.T) {
+	manifest := `{"metadata": {"title": "index"},
+		"readingOrder": [{"href": "chapter2.html", "type": "text/html"}, {"href": "chapter1.html", "type": "text/html"}],
+		"resources": [{"href": "cover.png", "type": "image/png", "rel": "cover"}]}`
+	reader := openTestRWPP(t,
+		testEntry{name: ManifestLocation, method: Deflate, body: []byte(manifest)},
+		testEntry{name: "chapter1.html", method: Deflate, body: bytes.Repeat([]byte("<p>one</p>"), 50)},
+		testEntry{name: "chapter2.html", method: Deflate, body: []byte("<p>two</p>")},
+		testEntry{name: "cover.png", method: NoCompression, body: testPNG(t, 4, 4)},
+	)
+
+	var b bytes.Buffer
+	writer, err := reader.NewWriterWithOptions(&b, PackOptions{FileIndex: true})
+	if err != nil {
+		t.Fatalf("Could not build a writer, %s", err)
+	}
+	if _, err = Process(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer); err != nil {
+		t.Fatalf("Could not process the package, %s", err)
+	}
+
+	output, err := NewRWPPReader(openTestZip(t, b.Bytes()))
+	if err != nil {
+		t.Fatalf("Could not read the output package, %s", err)
+	}
+	if orphans := output.OrphanEntries(); len(orphans) != 0 {
+		t.Errorf("Expected the index not to be an orphan entry, got %v", orphans)
+	}
+	file := output.file(FileIndexLocation)
+	if file == nil {
+		t.Fatal("Expected a file index in the package")
+	}
+	rc, err := file.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := ReadFileIndex(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatalf("Could not read the file index, %s", err)
+	}
+
+	// the index is sorted by href and lists the resources as well as the reading order
+	output.EncryptAncillary = true
+	resources := output.Resources()
+	if len(index) != len(resources) {
+		t.Fatalf("Expected %d entries in the index, got %d", len(resources), len(index))
+	}
+	expected := []string{"chapter1.html", "chapter2.html", "cover.png"}
+	for i, entry := range index {
+		if entry.Href != expected[i] {
+			t.Errorf("Expected %s at position %d, got %s", expected[i], i, entry.Href)
+		}
+	}
+	for _, resource := range resources {
+		var entry *FileIndexEntry
+		for i := range index {
+			if index[i].Href == resource.Path() {
+				entry = &index[i]
+			}
+		}
+		if entry == nil {
+			t.Errorf("Expected %s in the index", resource.Path())
+			continue
+		}
+		if entry.Type != resource.ContentType() || entry.Size != resource.Size() || entry.Encrypted != resource.Encrypted() {
+			t.Errorf("Expected the index entry %+v to match the resource %s", *entry, resource.Path())
+		}
+	}
+
+	// the index is opt-in
+	b.Reset()
+	reader = openTestRWPP(t,
+		testEntry{name: ManifestLocation, method: Deflate, body: []byte(manifest)},
+		testEntry{name: "chapter1.html", method: Deflate, body: []byte("<p>one</p>")},
+		testEntry{name: "chapter2.html", method: Deflate, body: []byte("<p>two</p>")},
+	)
+	writer, err = reader.NewWriter(&b)
+	if err != nil {
+		t.Fatalf("Could not build a writer, %s", err)
+	}
+	if _, err = Process(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer); err != nil {
+		t.Fatalf("Could not process the package, %s", err)
+	}
+	for _, file := range openTestZip(t, b.Bytes()).File {
+		if file.Name == FileIndexLocation {
+			t.Error("Did not expect a file index by default")
+		}
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"archive/zip"
+	"image"
+	"strings"
+
+	// register the image formats which dimensions can be read
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/readium/readium-lcp-server/rwpm"
+)
+
+// imageConfig decodes the header of an image stored in a zip entry
+func imageConfig(file *zip.File) (image.Config, error) {
+	rc, err := file.Open()
+	if err != nil {
+		return image.Config{}, err
+	}
+	defer rc.Close()
+	config, _, err := image.DecodeConfig(rc)
+	return config, err
+}
+
+// SetImageDimensions sets the width and height of the image links of the manifest
+// (reading order and resources) which lack them, by reading the image headers.
+// Images in an unknown format or missing from the package are left untouched.
+func (reader *RWPPReader) SetImageDimensions() {
+	for _, links := range [][]rwpm.Link{reader.manifest.ReadingOrder, reader.manifest.Resources} {
+		for i := range links {
+			link := &links[i]
+			if !strings.HasPrefix(link.Type, "image/") || (link.Width != 0 && link.Height != 0) {
+				continue
+			}
+			file := reader.file(link.Href)
+			if file == nil {
+				continue
+			}
+			config, err := imageConfig(file)
+			if err != nil {
+				continue
+			}
+			link.Width = config.Width
+			link.Height = config.Height
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/readium/readium-lcp-server/rwpm"
+)
+
+// Media types of the LCP links
+const (
+	ContentTypeLCPLicense = "application/vnd.readium.lcp.license.v1.0+json"
+	ContentTypeLCPStatus  = "application/vnd.readium.license.status.v1.0+json"
+)
+
+// relations and media types of the links injected by InjectLCPLinks
+var (
+	lcpRels      = []string{"license", "status", "hint"}
+	lcpLinkTypes = []string{ContentTypeLCPLicense, ContentTypeLCPStatus, "text/html"}
+)
+
+// InjectLCPLinks adds the license, status and hint links to the manifest of a Readium package,
+// replacing the existing links with the same relations; empty urls are skipped.
+// The other entries of the package are copied as is.
+func InjectLCPLinks(path string, licenseURL, statusURL, hintURL string) (err error) {
+
+	zipArchive, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer zipArchive.Close()
+
+	manifestFile := findManifest(zipArchive.File, nil)
+	if manifestFile == nil {
+		return errors.New("Could not find manifest")
+	}
+
+	var links []rwpm.Link
+	for i, href := range []string{licenseURL, statusURL, hintURL} {
+		if href != "" {
+			links = append(links, rwpm.Link{Href: href, Type: lcpLinkTypes[i], Rel: rwpm.MultiString{lcpRels[i]}})
+		}
+	}
+	manifest, err := injectLinks(manifestFile, links)
+	if err != nil {
+		return err
+	}
+
+	// write the updated package next to the source, then replace the source
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	zipWriter := zip.NewWriter(tmp)
+	for _, file := range zipArchive.File {
+		if file == manifestFile {
+			w, err := zipWriter.CreateHeader(&zip.FileHeader{Name: file.Name, Method: zip.Deflate})
+			if err != nil {
+				return closeZipWriter(zipWriter, err)
+			}
+			if _, err = w.Write(manifest); err != nil {
+				return closeZipWriter(zipWriter, err)
+			}
+			continue
+		}
+		if err = copyZipEntry(zipWriter, file); err != nil {
+			return closeZipWriter(zipWriter, err)
+		}
+	}
+	if err = zipWriter.Close(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	zipArchive.Close()
+	return os.Rename(tmp.Name(), path)
+}
+
+// injectLinks returns a manifest with the LCP links replaced by new links.
+// The manifest is handled as generic json, so that properties unknown to rwpm are kept.
+func injectLinks(manifestFile *zip.File, newLinks []rwpm.Link) ([]byte, error) {
+	r, err := manifestFile.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var manifest map[string]json.RawMessage
+	if err = json.NewDecoder(skipBOM(r)).Decode(&manifest); err != nil {
+		return nil, err
+	}
+
+	var links []json.RawMessage
+	if raw, ok := manifest["links"]; ok {
+		if err = json.Unmarshal(raw, &links); err != nil {
+			return nil, err
+		}
+	}
+
+	kept := make([]json.RawMessage, 0, len(links)+len(newLinks))
+	for _, raw := range links {
+		var link struct {
+			Rel rwpm.MultiString `json:"rel"`
+		}
+		if err = json.Unmarshal(raw, &link); err != nil {
+			return nil, err
+		}
+		if !hasLCPRel(link.Rel) {
+			kept = append(kept, raw)
+		}
+	}
+	for _, link := range newLinks {
+		raw, err := json.Marshal(link)
+		if err != nil {
+			return nil, err
+		}
+		kept = append(kept, raw)
+	}
+
+	if manifest["links"], err = json.Marshal(kept); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(manifest, "", "  ")
+}
+
+// hasLCPRel checks if a list of relations holds one of the relations of the LCP links
+func hasLCPRel(rels rwpm.MultiString) bool {
+	for _, rel := range rels {
+		for _, lcpRel := range lcpRels {
+			if rel == lcpRel {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInjectLCPLinks(t *testing.T) {
+	const manifest = `{"@context": "https://readium.org/webpub-manifest/context.jsonld",
+		"metadata": {"title": "inject", "custom": "kept"},
+		"links": [{"rel": "self", "href": "https://example.com/manifest.json"},
+			{"rel": "license", "href": "https://old.example.com/license"}],
+		"readingOrder": [{"href": "publication.pdf", "type": "application/pdf"}]}`
+	pdf := bytes.Repeat([]byte("%PDF"), 100)
+
+	dir, err := ioutil.TempDir("", "inject")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "book.lcpdf")
+	data := buildTestZip(t,
+		testEntry{name: ManifestLocation, method: Deflate, body: []byte(manifest)},
+		testEntry{name: "publication.pdf", method: NoCompression, body: pdf},
+	)
+	if err = ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = InjectLCPLinks(path, "https://lcp.example.com/licenses/1", "https://lsd.example.com/licenses/1/status", "https://example.com/hint"); err != nil {
+		t.Fatalf("Could not inject the links, %s", err)
+	}
+
+	reader, err := OpenRWPP(path)
+	if err != nil {
+		t.Fatalf("Expected a valid package, got %s", err)
+	}
+	links := reader.manifest.Links
+	if l := len(links); l != 4 {
+		t.Fatalf("Expected 4 links, got %d", l)
+	}
+	if links[0].Href != "https://example.com/manifest.json" {
+		t.Errorf("Expected the self link to be kept, got %s", links[0].Href)
+	}
+	if link, err := reader.manifest.LicenseLink(); err != nil || link.Href != "https://lcp.example.com/licenses/1" || link.Type != ContentTypeLCPLicense {
+		t.Errorf("Expected the license link to be replaced, got %v", link)
+	}
+	if link, err := reader.manifest.StatusLink(); err != nil || link.Href != "https://lsd.example.com/licenses/1/status" {
+		t.Errorf("Expected a status link, got %v", link)
+	}
+	if link, err := reader.manifest.HintLink(); err != nil || link.Href != "https://example.com/hint" {
+		t.Errorf("Expected a hint link, got %v", link)
+	}
+
+	// the other entries are untouched
+	source := openTestZip(t, data).File[1]
+	file := reader.file("publication.pdf")
+	if file == nil || file.Method != source.Method || file.CRC32 != source.CRC32 || file.CompressedSize64 != source.CompressedSize64 {
+		t.Error("Expected publication.pdf to be copied as is")
+	}
+
+	// properties unknown to rwpm are kept
+	r, err := reader.file(ManifestLocation).Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	content, _ := ioutil.ReadAll(r)
+	if !bytes.Contains(content, []byte(`"custom": "kept"`)) {
+		t.Errorf("Expected the custom metadata to be kept, got %s", content)
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"strings"
+
+	"github.com/readium/readium-lcp-server/rwpm"
+)
+
+// Families of publications
+const (
+	KindAudiobook = "audiobook"
+	KindDivina    = "divina"
+	KindPDF       = "pdf"
+	KindEbook     = "ebook"
+)
+
+// kindMimetypes lists the values of the mimetype entry which agree with each family of publications
+var kindMimetypes = map[string][]string{
+	KindAudiobook: {"application/audiobook+zip", "application/audiobook+lcp"},
+	KindDivina:    {"application/divina+zip", "application/divina+lcp"},
+	KindPDF:       {"application/pdf+lcp", "application/webpub+zip"},
+	KindEbook:     {"application/webpub+zip", "application/epub+zip"},
+}
+
+// Kind returns the family of the publication: audiobook, divina, pdf or ebook.
+// It is derived from the conformsTo or @type metadata,
+// with a fallback on the media types of the reading order.
+func (reader *RWPPReader) Kind() string {
+	return manifestKind(reader.manifest)
+}
+
+// manifestKind returns the family of the publication described by a manifest
+func manifestKind(manifest rwpm.Publication) string {
+
+	for _, profile := range manifest.Metadata.ConformsTo {
+		switch profile {
+		case rwpm.ProfileAudiobook:
+			return KindAudiobook
+		case rwpm.ProfileDivina:
+			return KindDivina
+		case rwpm.ProfilePDF:
+			return KindPDF
+		case rwpm.ProfileEPUB:
+			return KindEbook
+		}
+	}
+
+	switch strings.ToLower(manifest.Metadata.Type) {
+	case "http://schema.org/audiobook", "https://schema.org/audiobook":
+		return KindAudiobook
+	case "http://schema.org/comicstory", "https://schema.org/comicstory":
+		return KindDivina
+	}
+
+	// fallback on the media types of the reading order
+	readingOrder := manifest.ReadingOrder
+	if len(readingOrder) == 0 {
+		return KindEbook
+	}
+	if len(readingOrder) == 1 && readingOrder[0].Type == "application/pdf" {
+		return KindPDF
+	}
+	allAudio, allImages := true, true
+	for _, link := range readingOrder {
+		allAudio = allAudio && strings.HasPrefix(link.Type, "audio/")
+		allImages = allImages && strings.HasPrefix(link.Type, "image/")
+	}
+	if allAudio {
+		return KindAudiobook
+	}
+	if allImages {
+		return KindDivina
+	}
+	return KindEbook
+}
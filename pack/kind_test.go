@@ -0,0 +1,45 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/readium/readium-lcp-server/rwpm"
+)
+
+func TestKind(t *testing.T) {
+	cases := []struct {
+		manifest string
+		kind     string
+	}{
+		// explicit profiles
+		{`{"metadata": {"conformsTo": "https://readium.org/webpub-manifest/profiles/audiobook"}}`, KindAudiobook},
+		{`{"metadata": {"conformsTo": ["https://readium.org/webpub-manifest/profiles/divina"]}}`, KindDivina},
+		{`{"metadata": {"conformsTo": "https://readium.org/webpub-manifest/profiles/pdf"}}`, KindPDF},
+		{`{"metadata": {"conformsTo": "https://readium.org/webpub-manifest/profiles/epub"}, "readingOrder": [{"href": "a.mp3", "type": "audio/mpeg"}]}`, KindEbook},
+		// explicit types
+		{`{"metadata": {"@type": "https://schema.org/Audiobook"}}`, KindAudiobook},
+		{`{"metadata": {"@type": "http://schema.org/ComicStory"}}`, KindDivina},
+		// fallback on the reading order
+		{`{"metadata": {}, "readingOrder": [{"href": "a.mp3", "type": "audio/mpeg"}, {"href": "b.aac", "type": "audio/aac"}]}`, KindAudiobook},
+		{`{"metadata": {}, "readingOrder": [{"href": "a.jpg", "type": "image/jpeg"}, {"href": "b.png", "type": "image/png"}]}`, KindDivina},
+		{`{"metadata": {}, "readingOrder": [{"href": "a.pdf", "type": "application/pdf"}]}`, KindPDF},
+		{`{"metadata": {}, "readingOrder": [{"href": "a.html", "type": "text/html"}, {"href": "b.jpg", "type": "image/jpeg"}]}`, KindEbook},
+		{`{"metadata": {}}`, KindEbook},
+	}
+
+	for _, c := range cases {
+		var manifest rwpm.Publication
+		if err := json.Unmarshal([]byte(c.manifest), &manifest); err != nil {
+			t.Fatalf("Could not unmarshal %s, %s", c.manifest, err)
+		}
+		reader := RWPPReader{manifest: manifest}
+		if kind := reader.Kind(); kind != c.kind {
+			t.Errorf("Expected %s for %s, got %s", c.kind, c.manifest, kind)
+		}
+	}
+}
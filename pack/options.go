@@ -0,0 +1,214 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"time"
+
+	"github.com/readium/readium-lcp-server/crypto"
+)
+
+// Manifest profiles
+const (
+	// ManifestProfileFull keeps the whole manifest
+	ManifestProfileFull = "full"
+	// ManifestProfileLean omits editorial metadata from the manifest
+	ManifestProfileLean = "lean"
+)
+
+// Compression of the encrypted resources in the package
+const (
+	// CompressionDeflate deflates encrypted resources in the zip archive
+	CompressionDeflate = "deflate"
+	// CompressionStore stores encrypted resources without compression
+	CompressionStore = "store"
+)
+
+// DefaultContentType is the media type of the resources whose type is unknown
+const DefaultContentType = "application/octet-stream"
+
+// DefaultLeanOmissions lists the metadata fields omitted from a lean manifest
+var DefaultLeanOmissions = []string{
+	"subtitle", "description", "subject", "belongsTo",
+	"publisher", "artist", "author", "colorist", "contributor", "editor", "illustrator",
+	"imprint", "inker", "letterer", "narrator", "penciler", "translator",
+}
+
+// PackOptions gathers the optional settings of a packaging run.
+// The zero value keeps the default behavior.
+type PackOptions struct {
+	// Report requests a per-resource packaging report
+	Report bool
+	// DropW3CManifest avoids copying the W3C manifest of the source package
+	DropW3CManifest bool
+	// ManifestProfile is ManifestProfileFull (default) or ManifestProfileLean
+	ManifestProfile string
+	// LeanOmissions lists the metadata fields omitted from a lean manifest;
+	// DefaultLeanOmissions is used if empty.
+	LeanOmissions []string
+	// EncryptionPolicy names a policy, EncryptionPolicyAll (default) or EncryptionPolicySkipAudio,
+	// applied on top of the EncryptionPolicy of the source package (see NamedEncryptionPolicy)
+	EncryptionPolicy string
+	// Compression is CompressionDeflate (default) or CompressionStore;
+	// it also applies to the PDF embedded by BuildRWPPFromPDFWithOptions.
+	Compression string
+	// ManifestFirst moves the manifest in front of the output package.
+	// As the manifest is only known at the end of the process,
+	// the package is spooled to a temporary file before being written out.
+	ManifestFirst bool
+	// ByteRangeIndex requests an index of the location of encrypted resources in the output package,
+	// available from RWPPWriter.ByteRangeIndex once the writer is closed.
+	// The locations are recorded as the entries are written.
+	ByteRangeIndex bool
+	// Identifier is written into the manifest built by BuildRWPPFromPDFWithOptions
+	Identifier string
+	// Checksum records the SHA-256 hash of the plaintext of each encrypted resource in the manifest,
+	// computed while the resource is encrypted
+	Checksum bool
+	// GzipSideFiles compresses the side-files written next to a package,
+	// like the packaging report and byte range index; GzipExtension is appended to their name.
+	GzipSideFiles bool
+	// Modified is the modification time of the entries created in a Readium package;
+	// DefaultModified is used if zero, so that identical inputs produce identical packages.
+	// Copied entries keep their modification time.
+	Modified time.Time
+	// DefaultContentType is the media type written into the manifest for the resources
+	// whose type is neither declared nor derived from their extension;
+	// the package DefaultContentType is used if empty.
+	DefaultContentType string
+	// CompressionLevel is the level of the deflated entries of a Readium package and of the resources
+	// deflated before encryption, from flate.BestSpeed (1) to flate.BestCompression (9).
+	// If zero, the entries use the default level and the resources deflated before encryption the best compression.
+	CompressionLevel int
+	// RepairContext sets the Readium context of a manifest which has no @context,
+	// so that the repackaged publication is accepted by strict Readium clients
+	RepairContext bool
+	// FileIndex writes FileIndexLocation into a Readium package when it is closed,
+	// listing the href, media type, size and encryption of every resource, sorted by href
+	FileIndex bool
+	// EncryptionMode is the AES mode of the encryption of the resources, crypto.ModeCBC (default) or crypto.ModeGCM;
+	// the algorithm written into the manifest matches the encrypter returned by NewEncrypter.
+	// GCM packages are authenticated but cannot be read by LCP clients.
+	EncryptionMode string
+	// ChunkSize is the size of the blocks in which the resources are read and encrypted with AES-CBC,
+	// a multiple of the AES block size; crypto.DefaultChunkSize is used if zero.
+	// It cannot be set in GCM mode, where each resource is sealed at once.
+	// The memory used by the encryption of a resource does not depend on its size.
+	ChunkSize int
+	// W3CManifest writes a W3C manifest (W3CManifestName) derived from the Readium manifest
+	// into a Readium package which has none, for the interoperability with W3C audiobook players
+	W3CManifest bool
+	// Progress is called by ProcessWithOptions as the bytes of each resource are read,
+	// while the resource is copied or encrypted; nothing is reported if nil
+	Progress ProgressFunc
+}
+
+// Validate checks that the options hold known values
+func (opts PackOptions) Validate() error {
+	switch opts.ManifestProfile {
+	case "", ManifestProfileFull, ManifestProfileLean:
+	default:
+		return fmt.Errorf("unknown manifest profile %q", opts.ManifestProfile)
+	}
+	if _, err := NamedEncryptionPolicy(opts.EncryptionPolicy, DefaultEncryptionPolicy); err != nil {
+		return err
+	}
+	switch opts.Compression {
+	case "", CompressionDeflate, CompressionStore:
+	default:
+		return fmt.Errorf("unknown compression %q", opts.Compression)
+	}
+	if opts.CompressionLevel < 0 || opts.CompressionLevel > flate.BestCompression {
+		return fmt.Errorf("invalid compression level %d, expected 1 to %d, or 0 for the default level", opts.CompressionLevel, flate.BestCompression)
+	}
+	if _, err := opts.NewEncrypter(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// NewEncrypter returns an encrypter of publication resources using the AES mode and chunk size of the options
+func (opts PackOptions) NewEncrypter() (crypto.Encrypter, error) {
+	encrypter, err := crypto.NewAESEncrypterWithMode(opts.EncryptionMode)
+	if err != nil || opts.ChunkSize == 0 {
+		return encrypter, err
+	}
+	if encrypter.Signature() != crypto.NewAESCBCEncrypter().Signature() {
+		return nil, fmt.Errorf("the chunk size only applies to the %s mode", crypto.ModeCBC)
+	}
+	return crypto.NewAESCBCEncrypterWithChunkSize(opts.ChunkSize)
+}
+
+// deflateLevel returns the compression level of the resources deflated before encryption
+func (opts PackOptions) deflateLevel() int {
+	if opts.CompressionLevel == 0 {
+		return flate.BestCompression
+	}
+	return opts.CompressionLevel
+}
+
+// storageMethod returns the zip method of the entries compressed as requested by the options
+func (opts PackOptions) storageMethod() uint16 {
+	if opts.Compression == CompressionStore {
+		return NoCompression
+	}
+	return Deflate
+}
+
+// registerCompressor sets the compression level of the deflated entries of a zip archive
+func (opts PackOptions) registerCompressor(zipWriter *zip.Writer) {
+	if opts.CompressionLevel == 0 {
+		return
+	}
+	level := opts.CompressionLevel
+	zipWriter.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, level)
+	})
+}
+
+// DefaultModified is the modification time of the entries created in a package when none is set in the options.
+// It is the earliest date of the MS-DOS format of the zip entries, 1980-01-01 00:00 UTC:
+// earlier dates, like the Unix epoch, cannot be represented.
+var DefaultModified = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// modified returns the modification time of the entries created in a package
+func (opts PackOptions) modified() time.Time {
+	if opts.Modified.IsZero() {
+		return DefaultModified
+	}
+	return opts.Modified
+}
+
+// contentType returns the media type of a resource: the declared type if any,
+// else the type derived from its extension, else the default content type
+func (opts PackOptions) contentType(name string, contentType string) string {
+	if contentType != "" {
+		return contentType
+	}
+	if mediaType, _, err := mime.ParseMediaType(mime.TypeByExtension(path.Ext(name))); err == nil {
+		return mediaType
+	}
+	if opts.DefaultContentType != "" {
+		return opts.DefaultContentType
+	}
+	return DefaultContentType
+}
+
+// encryptionPolicy resolves the encryption policy of a packaging run,
+// the policy named in the options applied on top of the policy of the source package
+func (opts PackOptions) encryptionPolicy(base EncryptionPolicy) EncryptionPolicy {
+	policy, err := NamedEncryptionPolicy(opts.EncryptionPolicy, base)
+	if err != nil {
+		// the options are validated before use
+		return base
+	}
+	return policy
+}
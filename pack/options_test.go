@@ -0,0 +1,241 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/readium/readium-lcp-server/crypto"
+	"github.com/readium/readium-lcp-server/license"
+)
+
+func TestValidatePackOptions(t *testing.T) {
+	valid := []PackOptions{
+		{},
+		{ManifestProfile: ManifestProfileLean, EncryptionPolicy: EncryptionPolicySkipAudio, Compression: CompressionStore},
+		{CompressionLevel: flate.BestSpeed},
+		{CompressionLevel: flate.BestCompression},
+		{EncryptionMode: crypto.ModeCBC},
+		{EncryptionMode: crypto.ModeGCM},
+		{ChunkSize: 1 << 20},
+	}
+	for _, opts := range valid {
+		if err := opts.Validate(); err != nil {
+			t.Errorf("Expected %#v to be valid, got %s", opts, err)
+		}
+	}
+	invalid := []PackOptions{
+		{ManifestProfile: "tiny"},
+		{EncryptionPolicy: "none"},
+		{Compression: "bzip2"},
+		{CompressionLevel: -1},
+		{CompressionLevel: 10},
+		{EncryptionMode: "ctr"},
+		{ChunkSize: -16},
+		{ChunkSize: 100},
+		{ChunkSize: 1 << 20, EncryptionMode: crypto.ModeGCM},
+	}
+	for _, opts := range invalid {
+		if err := opts.Validate(); err == nil {
+			t.Errorf("Expected %#v to be rejected", opts)
+		}
+	}
+}
+
+func TestEncryptionPolicyAndCompression(t *testing.T) {
+	manifest := `{
+		"metadata": {"title": "policy"},
+		"readingOrder": [
+			{"href": "track.mp3", "type": "audio/mpeg"},
+			{"href": "chapter.html", "type": "text/html"}
+		]
+	}`
+	reader := openTestRWPP(t,
+		testEntry{name: ManifestLocation, method: Deflate, body: []byte(manifest)},
+		testEntry{name: "track.mp3", method: NoCompression, body: []byte("audio")},
+		testEntry{name: "chapter.html", method: Deflate, body: []byte("<html>chapter</html>")},
+	)
+
+	opts := PackOptions{EncryptionPolicy: EncryptionPolicySkipAudio, Compression: CompressionStore}
+	var b bytes.Buffer
+	writer, err := reader.NewWriterWithOptions(&b, opts)
+	if err != nil {
+		t.Fatalf("Could not build a writer, %s", err)
+	}
+	if _, _, err = ProcessWithOptions(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer, opts); err != nil {
+		t.Fatalf("Could not process the package, %s", err)
+	}
+
+	out := readOutputManifest(t, b.Bytes())
+	for _, link := range out.ReadingOrder {
+		encrypted := link.Properties != nil && link.Properties.Encrypted != nil
+		if encrypted != (link.Href == "chapter.html") {
+			t.Errorf("Unexpected encryption state for %s: %v", link.Href, encrypted)
+		}
+	}
+	for _, file := range openTestZip(t, b.Bytes()).File {
+		if file.Name == "chapter.html" && file.Method != NoCompression {
+			t.Errorf("Expected chapter.html to be stored, got method %d", file.Method)
+		}
+	}
+
+	if _, _, err = ProcessWithOptions(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer, PackOptions{Compression: "bzip2"}); err == nil {
+		t.Error("Expected invalid options to be rejected")
+	}
+}
+
+func TestCompressionLevel(t *testing.T) {
+	// text which compresses better with more effort
+	var text bytes.Buffer
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(&text, "<p id=\"p%d\">paragraph %d of chapter %d</p>\n", i, i*7%1000, i%37)
+	}
+
+	sizes := map[int]int{}
+	for _, level := range []int{flate.BestSpeed, flate.BestCompression} {
+		var b bytes.Buffer
+		opts := PackOptions{CompressionLevel: level}
+		if err := buildRWPPFromPDF(pdfInfo{Title: "level"}, bytes.NewReader(text.Bytes()), &b, opts); err != nil {
+			t.Fatalf("Could not build the package at level %d, %s", level, err)
+		}
+		for _, file := range openTestZip(t, b.Bytes()).File {
+			if file.Name == "publication.pdf" {
+				sizes[level] = int(file.CompressedSize64)
+			}
+		}
+	}
+	if sizes[flate.BestCompression] >= sizes[flate.BestSpeed] {
+		t.Errorf("Expected the best compression to be smaller than the best speed, got %d and %d bytes",
+			sizes[flate.BestCompression], sizes[flate.BestSpeed])
+	}
+
+	// the level also applies to the resources deflated before encryption
+	reader := openTestRWPP(t,
+		testEntry{name: ManifestLocation, method: Deflate, body: []byte(`{"metadata": {"title": "level"}, "readingOrder": [{"href": "chapter.html", "type": "text/html"}]}`)},
+		testEntry{name: "chapter.html", method: Deflate, body: text.Bytes()},
+	)
+	reader.Policy = streamingPolicy{}
+	encryptedSizes := map[int]int{}
+	for _, level := range []int{flate.BestSpeed, flate.BestCompression} {
+		var b bytes.Buffer
+		opts := PackOptions{CompressionLevel: level, Compression: CompressionStore}
+		writer, err := reader.NewWriterWithOptions(&b, opts)
+		if err != nil {
+			t.Fatalf("Could not build a writer, %s", err)
+		}
+		if _, _, err = ProcessWithOptions(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer, opts); err != nil {
+			t.Fatalf("Could not process the package at level %d, %s", level, err)
+		}
+		for _, file := range openTestZip(t, b.Bytes()).File {
+			if file.Name == "chapter.html" {
+				encryptedSizes[level] = int(file.CompressedSize64)
+			}
+		}
+	}
+	if encryptedSizes[flate.BestCompression] >= encryptedSizes[flate.BestSpeed] {
+		t.Errorf("Expected the best compression to be smaller than the best speed before encryption, got %d and %d bytes",
+			encryptedSizes[flate.BestCompression], encryptedSizes[flate.BestSpeed])
+	}
+}
+
+func TestEncryptionMode(t *testing.T) {
+	pdf := bytes.Repeat([]byte("%PDF-1.7 "), 1000)
+	tests := []struct {
+		mode      string
+		algorithm string
+	}{
+		{"", "http://www.w3.org/2001/04/xmlenc#aes256-cbc"},
+		{crypto.ModeCBC, "http://www.w3.org/2001/04/xmlenc#aes256-cbc"},
+		{crypto.ModeGCM, "http://www.w3.org/2009/xmlenc11#aes256-gcm"},
+	}
+	for _, test := range tests {
+		reader := openTestRWPP(t,
+			testEntry{name: ManifestLocation, method: Deflate, body: []byte(`{"metadata": {"title": "mode"}, "readingOrder": [{"href": "publication.pdf", "type": "application/pdf"}]}`)},
+			testEntry{name: "publication.pdf", method: Deflate, body: pdf},
+		)
+		opts := PackOptions{EncryptionMode: test.mode}
+		encrypter, err := opts.NewEncrypter()
+		if err != nil {
+			t.Fatalf("%s: could not build an encrypter, %s", test.mode, err)
+		}
+		var b bytes.Buffer
+		writer, err := reader.NewWriterWithOptions(&b, opts)
+		if err != nil {
+			t.Fatalf("%s: could not build a writer, %s", test.mode, err)
+		}
+		key, _, err := ProcessWithOptions(license.BasicProfile, encrypter, reader, writer, opts)
+		if err != nil {
+			t.Fatalf("%s: could not process the package, %s", test.mode, err)
+		}
+
+		manifest := readOutputManifest(t, b.Bytes())
+		algorithm := manifest.ReadingOrder[0].Properties.Encrypted.Algorithm
+		if algorithm != test.algorithm {
+			t.Errorf("%s: expected the algorithm %s, got %s", test.mode, test.algorithm, algorithm)
+		}
+
+		// the resource is decrypted with the algorithm of the manifest
+		decrypter, err := crypto.NewAESDecrypter(algorithm)
+		if err != nil {
+			t.Fatalf("%s: %s", test.mode, err)
+		}
+		var clear bytes.Buffer
+		if err = decrypter.Decrypt(key, bytes.NewReader(readZipEntry(t, openTestZip(t, b.Bytes()), "publication.pdf")), &clear); err != nil {
+			t.Fatalf("%s: could not decrypt the resource, %s", test.mode, err)
+		}
+		if !bytes.Equal(clear.Bytes(), pdf) {
+			t.Errorf("%s: expected the decrypted resource to match the original", test.mode)
+		}
+	}
+}
+
+func TestChunkSize(t *testing.T) {
+	html := bytes.Repeat([]byte("<p>chunked</p>"), 5000)
+	reader := openTestRWPP(t,
+		testEntry{name: ManifestLocation, method: Deflate, body: []byte(`{"metadata": {"title": "chunks"}, "readingOrder": [{"href": "chapter.html", "type": "text/html"}]}`)},
+		testEntry{name: "chapter.html", method: Deflate, body: html},
+	)
+	// the chapter is deflated while it is encrypted
+	reader.Policy = streamingPolicy{}
+
+	opts := PackOptions{ChunkSize: 32, Checksum: true}
+	encrypter, err := opts.NewEncrypter()
+	if err != nil {
+		t.Fatalf("Could not build an encrypter, %s", err)
+	}
+	var b bytes.Buffer
+	writer, err := reader.NewWriterWithOptions(&b, opts)
+	if err != nil {
+		t.Fatalf("Could not build a writer, %s", err)
+	}
+	key, _, err := ProcessWithOptions(license.BasicProfile, encrypter, reader, writer, opts)
+	if err != nil {
+		t.Fatalf("Could not process the package, %s", err)
+	}
+
+	encrypted := readOutputManifest(t, b.Bytes()).ReadingOrder[0].Properties.Encrypted
+	sum := sha256.Sum256(html)
+	if encrypted.Checksum != hex.EncodeToString(sum[:]) {
+		t.Errorf("Expected the checksum of the plaintext, got %s", encrypted.Checksum)
+	}
+
+	var deflated bytes.Buffer
+	if err = crypto.NewAESCBCEncrypter().(crypto.Decrypter).Decrypt(key, bytes.NewReader(readZipEntry(t, openTestZip(t, b.Bytes()), "chapter.html")), &deflated); err != nil {
+		t.Fatalf("Could not decrypt the chapter, %s", err)
+	}
+	clear, err := ioutil.ReadAll(flate.NewReader(&deflated))
+	if err != nil {
+		t.Fatalf("Could not inflate the chapter, %s", err)
+	}
+	if !bytes.Equal(clear, html) {
+		t.Error("Expected the decrypted chapter to match the original")
+	}
+}
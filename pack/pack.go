@@ -5,13 +5,16 @@
 package pack
 
 import (
-	"bytes"
 	"compress/flate"
+	"crypto/aes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
 	"io"
-	"io/ioutil"
 	"log"
-	"net/url"
-	"strings"
+	"time"
 
 	"github.com/readium/readium-lcp-server/crypto"
 	"github.com/readium/readium-lcp-server/epub"
@@ -26,7 +29,7 @@ type PackageReader interface {
 
 type PackageWriter interface {
 	NewFile(path string, contentType string, storageMethod uint16) (io.WriteCloser, error)
-	MarkAsEncrypted(path string, originalSize int64, profile license.EncryptionProfile, algorithm string)
+	MarkAsEncrypted(path string, originalSize int64, profile license.EncryptionProfile, algorithm string) error
 	Close() error
 }
 
@@ -39,10 +42,48 @@ type Resource interface {
 	Encrypted() bool
 	CopyTo(PackageWriter) error
 	Open() (io.ReadCloser, error)
+	// ModTime and CRC32 are the modification time and checksum of the zip entry of the resource,
+	// e.g. for the Last-Modified and ETag headers of an HTTP delivery
+	ModTime() time.Time
+	CRC32() uint32
+}
+
+// policyReader is implemented by the package readers whose resources are encrypted under an EncryptionPolicy
+type policyReader interface {
+	policy() EncryptionPolicy
+	resources(policy EncryptionPolicy) []Resource
+}
+
+// resourcesWithOptions returns the resources of a package, encrypted under the encryption policy
+// resolved from the options and the policy of the reader
+func resourcesWithOptions(reader PackageReader, opts PackOptions) []Resource {
+	if policyReader, ok := reader.(policyReader); ok {
+		return policyReader.resources(opts.encryptionPolicy(policyReader.policy()))
+	}
+	return reader.Resources()
+}
+
+// progressCopier is implemented by the resources which report the progress of their copy
+type progressCopier interface {
+	CopyToWithProgress(PackageWriter, ProgressFunc) error
 }
 
 // Process copies resources from the source to the destination package, after encryption if needed.
 func Process(profile license.EncryptionProfile, encrypter crypto.Encrypter, reader PackageReader, writer PackageWriter) (key crypto.ContentKey, err error) {
+	key, _, err = ProcessWithOptions(profile, encrypter, reader, writer, PackOptions{})
+	return
+}
+
+// ProcessWithOptions copies resources from the source to the destination package, after encryption if needed.
+// A packaging report is returned if requested in the options.
+func ProcessWithOptions(profile license.EncryptionProfile, encrypter crypto.Encrypter, reader PackageReader, writer PackageWriter, opts PackOptions) (key crypto.ContentKey, report *Report, err error) {
+
+	if err = opts.Validate(); err != nil {
+		return
+	}
+	if opts.Report {
+		report = &Report{}
+	}
 
 	// generate an encryption key
 	key, err = encrypter.GenerateKey()
@@ -52,18 +93,40 @@ func Process(profile license.EncryptionProfile, encrypter crypto.Encrypter, read
 	}
 
 	// loop through the resources of the source package, encrypt them if needed, copy them into the dest package
-	for _, resource := range reader.Resources() {
+	for _, resource := range resourcesWithOptions(reader, opts) {
+		start := time.Now()
 		if !resource.Encrypted() && resource.CanBeEncrypted() {
-			err = encryptResource(profile, encrypter, key, resource, writer)
+			var written int64
+			written, err = encryptResource(profile, encrypter, key, resource, writer, opts)
 			if err != nil {
 				log.Println("Error encrypting " + resource.Path() + ": " + err.Error())
 				return
 			}
+			report.add(ResourceReport{
+				Path:           resource.Path(),
+				OriginalSize:   resource.Size(),
+				CiphertextSize: written,
+				Compressed:     resource.CompressBeforeEncryption(),
+				Encrypted:      true,
+				Algorithm:      encrypter.Signature(),
+				Duration:       time.Since(start),
+			})
 		} else {
-			err = resource.CopyTo(writer)
+			if copier, ok := resource.(progressCopier); ok {
+				err = copier.CopyToWithProgress(writer, opts.Progress)
+			} else {
+				err = resource.CopyTo(writer)
+			}
 			if err != nil {
 				return
 			}
+			report.add(ResourceReport{
+				Path:           resource.Path(),
+				OriginalSize:   resource.Size(),
+				CiphertextSize: resource.Size(),
+				Encrypted:      resource.Encrypted(),
+				Duration:       time.Since(start),
+			})
 		}
 	}
 
@@ -72,59 +135,23 @@ func Process(profile license.EncryptionProfile, encrypter crypto.Encrypter, read
 	return
 }
 
-// Do encrypts when necessary the resources of an EPUB package
-// Note: It calls encryptFile
-// It is currently called only for EPUB files
-// FIXME: try to merge Process() and Do()
+// Do encrypts when necessary the resources of an EPUB package.
+// The resources are read from the EPUB object, and processed as those of an EPUBReader
+// under EPUBEncryptionPolicy. The encryption.xml of the output package is returned.
 func Do(encrypter crypto.Encrypter, ep epub.Epub, w io.Writer) (enc *xmlenc.Manifest, key crypto.ContentKey, err error) {
 
-	// generate an encryption key
-	key, err = encrypter.GenerateKey()
+	reader := &EPUBReader{epub: ep}
+	writer, err := reader.NewWriter(w)
 	if err != nil {
-		log.Println("Error generating a key")
 		return
 	}
 
-	ew := epub.NewWriter(w)
-	ew.WriteHeader()
-	if ep.Encryption == nil {
-		ep.Encryption = &xmlenc.Manifest{}
-	}
-
-	for _, res := range ep.Resource {
-		if _, alreadyEncrypted := ep.Encryption.DataForFile(res.Path); !alreadyEncrypted && canEncrypt(res, ep) {
-			toCompress := mustCompressBeforeEncryption(*res, ep)
-			err = encryptFile(encrypter, key, ep.Encryption, res, toCompress, ew)
-			if err != nil {
-				log.Println("Error encrypting " + res.Path + ": " + err.Error())
-				return
-			}
-		} else {
-			err = ew.Copy(res)
-			if err != nil {
-				log.Println("Error copying the file")
-				return
-			}
-		}
-	}
-
-	ew.WriteEncryption(ep.Encryption)
-
-	return ep.Encryption, key, ew.Close()
-}
-
-// mustCompressBeforeEncryption checks is a resource must be compressed before encryption.
-// We don't want to compress files if that might cause streaming issues.
-// The test is applied on the resource media-type; image, video and audio are stored without compression.
-func mustCompressBeforeEncryption(file epub.Resource, ep epub.Epub) bool {
-
-	mimetype := file.ContentType
-
-	if mimetype == "" {
-		return true
+	// the profile is not written into an EPUB, it only has to be a known one
+	key, err = Process(license.BasicProfile, encrypter, reader, writer)
+	if err != nil {
+		return
 	}
-
-	return !strings.HasPrefix(mimetype, "image") && !strings.HasPrefix(mimetype, "video") && !strings.HasPrefix(mimetype, "audio")
+	return writer.(*EPUBWriter).encryption, key, nil
 }
 
 // NoCompression means Store
@@ -133,17 +160,13 @@ const (
 	Deflate       = 8
 )
 
-// canEncrypt checks if a resource should be encrypted
-func canEncrypt(file *epub.Resource, ep epub.Epub) bool {
-	return ep.CanEncrypt(file.Path)
-}
-
 // encryptResource encrypts a resource in a Readium Package
-func encryptResource(profile license.EncryptionProfile, encrypter crypto.Encrypter, key crypto.ContentKey, resource Resource, packageWriter PackageWriter) error {
+// It returns the number of encrypted bytes written to the package.
+func encryptResource(profile license.EncryptionProfile, encrypter crypto.Encrypter, key crypto.ContentKey, resource Resource, packageWriter PackageWriter, opts PackOptions) (int64, error) {
 
-	storageMethod := uint16(Deflate)
+	storageMethod := opts.storageMethod()
 
-	// FIXME: this is currently always set to false
+	// the encryption policy of the source package may require a compression before encryption
 	mustBeCompressedBeforeEncryption := resource.CompressBeforeEncryption()
 
 	if mustBeCompressedBeforeEncryption {
@@ -153,90 +176,142 @@ func encryptResource(profile license.EncryptionProfile, encrypter crypto.Encrypt
 
 	file, err := packageWriter.NewFile(resource.Path(), resource.ContentType(), storageMethod)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	resourceReader, err := resource.Open()
 	if err != nil {
-		return err
+		return 0, err
 	}
-	var reader io.Reader = resourceReader
+	source := newProgressReader(resourceReader, resource.Path(), resource.Size(), opts.Progress)
+
+	// the checksum is computed on the plaintext while it is read
+	var checksum hash.Hash
+	if opts.Checksum {
+		checksum = sha256.New()
+		source = io.TeeReader(source, checksum)
+	}
+	reader := source
+	stopDeflate := func() {}
 
 	if mustBeCompressedBeforeEncryption {
-		var buffer bytes.Buffer
-		deflateWriter, err := flate.NewWriter(&buffer, 9)
+		// the resource is deflated while it is encrypted, without being held in memory
+		pipeReader, pipeWriter := io.Pipe()
+		deflateWriter, err := flate.NewWriter(pipeWriter, opts.deflateLevel())
 		if err != nil {
-			return err
+			resourceReader.Close()
+			return 0, err
+		}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_, err := io.Copy(deflateWriter, source)
+			if err == nil {
+				err = deflateWriter.Close()
+			}
+			pipeWriter.CloseWithError(err)
+		}()
+		// the deflater is stopped before the resource is closed, if the encryption failed
+		stopDeflate = func() {
+			pipeReader.Close()
+			<-done
 		}
+		reader = pipeReader
+	}
 
-		io.Copy(deflateWriter, resourceReader)
-		resourceReader.Close()
-		deflateWriter.Close()
-		reader = ioutil.NopCloser(&buffer)
+	// the size of the ciphertext is known unless the resource is deflated before encryption
+	if expecter, ok := file.(sizeExpecter); ok && !mustBeCompressedBeforeEncryption {
+		if size, known := encryptedSize(encrypter.Signature(), resource.Size()); known {
+			expecter.expectSize(size)
+		}
 	}
 
-	err = encrypter.Encrypt(key, reader, file)
+	counter := &countingWriter{Writer: file}
+	err = encrypter.Encrypt(key, reader, counter)
 
+	stopDeflate()
 	resourceReader.Close()
-	file.Close()
-
-	packageWriter.MarkAsEncrypted(resource.Path(), resource.Size(), profile, encrypter.Signature())
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
 
-	return err
+	if checksumWriter, ok := packageWriter.(checksumRecorder); ok && checksum != nil && err == nil {
+		checksumWriter.setChecksum(resource.Path(), hex.EncodeToString(checksum.Sum(nil)))
+	}
+	if err != nil {
+		return counter.count, err
+	}
+	return counter.count, packageWriter.MarkAsEncrypted(resource.Path(), resource.Size(), profile, encrypter.Signature())
 }
 
-// encryptFile encrypts a file in an EPUB package
-func encryptFile(encrypter crypto.Encrypter, key []byte, m *xmlenc.Manifest, file *epub.Resource, compress bool, w *epub.Writer) error {
-
-	data := xmlenc.Data{}
-	data.Method.Algorithm = xmlenc.URI(encrypter.Signature())
-	data.KeyInfo = &xmlenc.KeyInfo{}
-	data.KeyInfo.RetrievalMethod.URI = "license.lcpl#/encryption/content_key"
-	data.KeyInfo.RetrievalMethod.Type = "http://readium.org/2014/01/lcp#EncryptedContentKey"
+// checksumRecorder is implemented by the package writers which record the checksum of encrypted resources
+type checksumRecorder interface {
+	setChecksum(path string, checksum string)
+}
 
-	uri, err := url.Parse(file.Path)
-	if err != nil {
-		return err
-	}
-	data.CipherData.CipherReference.URI = xmlenc.URI(uri.EscapedPath())
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	io.Writer
+	count int64
+}
 
-	method := NoCompression
-	if compress {
-		method = Deflate
-	}
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.Writer.Write(p)
+	cw.count += int64(n)
+	return n, err
+}
 
-	// set the storage method to Deflate or NoCompression
-	file.StorageMethod = uint16(method)
+// ErrSizeMismatch is returned when the size of an entry written to a package differs from its expected size,
+// e.g. if the encrypted output of a resource is truncated
+var ErrSizeMismatch = errors.New("the size of the entry differs from the expected size")
 
-	data.Properties = &xmlenc.EncryptionProperties{
-		Properties: []xmlenc.EncryptionProperty{
-			{Compression: xmlenc.Compression{Method: method, OriginalLength: file.OriginalSize}},
-		},
-	}
+// sizeExpecter is implemented by the writers returned by NewFile, which check the size of an entry when closed
+type sizeExpecter interface {
+	expectSize(size int64)
+}
 
-	m.Data = append(m.Data, data)
+// countingWriteCloser counts the bytes written to a package entry.
+// Close returns ErrSizeMismatch if a size is expected and the count differs.
+type countingWriteCloser struct {
+	countingWriter
+	path     string
+	expected int64
+}
 
-	input := file.Contents
+// newCountingWriteCloser returns a countingWriteCloser with no expected size
+func newCountingWriteCloser(w io.Writer, path string) *countingWriteCloser {
+	return &countingWriteCloser{countingWriter: countingWriter{Writer: w}, path: path, expected: -1}
+}
 
-	if compress {
-		var buf bytes.Buffer
-		deflateWriter, err := flate.NewWriter(&buf, 9)
-		if err != nil {
-			return err
-		}
-		io.Copy(deflateWriter, file.Contents)
-		deflateWriter.Close()
-		file.ContentsSize = uint64(buf.Len())
+func (cw *countingWriteCloser) expectSize(size int64) {
+	cw.expected = size
+}
 
-		input = ioutil.NopCloser(&buf)
+// Close checks the number of bytes written
+func (cw *countingWriteCloser) Close() error {
+	if cw.expected >= 0 && cw.count != cw.expected {
+		return fmt.Errorf("%s: %d bytes written, %d expected: %w", cw.path, cw.count, cw.expected, ErrSizeMismatch)
 	}
+	return nil
+}
 
-	fw, err := w.AddResource(file.Path, file.StorageMethod)
-	if err != nil {
-		return err
+// encryptedSize returns the size of a resource once encrypted with an algorithm, if known:
+// AES-CBC adds the IV and a padding, AES-GCM adds the nonce and the authentication tag
+func encryptedSize(algorithm string, size int64) (int64, bool) {
+	switch algorithm {
+	case crypto.NewAESCBCEncrypter().Signature():
+		return aes.BlockSize + (size/aes.BlockSize+1)*aes.BlockSize, true
+	case crypto.NewAESGCMEncrypter().Signature():
+		return gcmNonceSize + size + gcmTagSize, true
 	}
-	return encrypter.Encrypt(key, input, fw)
+	return 0, false
 }
 
+// sizes of the standard nonce and tag of AES-GCM
+const (
+	gcmNonceSize = 12
+	gcmTagSize   = 16
+)
+
 // findFile finds a file in an EPUB object
 func findFile(name string, ep epub.Epub) (*epub.Resource, bool) {
 
@@ -8,11 +8,14 @@ import (
 	"archive/zip"
 	"bytes"
 	"compress/flate"
+	"errors"
+	"io"
 	"io/ioutil"
 	"testing"
 
 	"github.com/readium/readium-lcp-server/crypto"
 	"github.com/readium/readium-lcp-server/epub"
+	"github.com/readium/readium-lcp-server/license"
 	"github.com/readium/readium-lcp-server/xmlenc"
 )
 
@@ -160,3 +163,45 @@ func TestPackingWithSpace(t *testing.T) {
 	}
 
 }
+
+// truncatingEncrypter drops the last block of the ciphertext, as a short write would
+type truncatingEncrypter struct {
+	crypto.Encrypter
+}
+
+func (e truncatingEncrypter) Encrypt(key crypto.ContentKey, r io.Reader, w io.Writer) error {
+	var buf bytes.Buffer
+	if err := e.Encrypter.Encrypt(key, r, &buf); err != nil {
+		return err
+	}
+	_, err := w.Write(buf.Bytes()[:buf.Len()-16])
+	return err
+}
+
+func TestTruncatedEntry(t *testing.T) {
+	var buf bytes.Buffer
+	w := newCountingWriteCloser(&buf, "chapter.html")
+	w.expectSize(10)
+	w.Write([]byte("short"))
+	if err := w.Close(); !errors.Is(err, ErrSizeMismatch) {
+		t.Errorf("Expected a size mismatch, got %v", err)
+	}
+
+	// no size is expected by default
+	if err := newCountingWriteCloser(&buf, "chapter.html").Close(); err != nil {
+		t.Errorf("Expected no error, got %s", err)
+	}
+
+	reader := openTestRWPP(t,
+		testEntry{name: ManifestLocation, method: Deflate, body: []byte(`{"metadata": {"title": "truncated"}, "readingOrder": [{"href": "publication.pdf", "type": "application/pdf"}]}`)},
+		testEntry{name: "publication.pdf", method: Deflate, body: bytes.Repeat([]byte("%PDF"), 1000)},
+	)
+	writer, err := reader.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("Could not build a writer, %s", err)
+	}
+	_, err = Process(license.BasicProfile, truncatingEncrypter{crypto.NewAESCBCEncrypter()}, reader, writer)
+	if !errors.Is(err, ErrSizeMismatch) {
+		t.Errorf("Expected the truncated resource to be detected, got %v", err)
+	}
+}
@@ -0,0 +1,335 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"regexp"
+	"strconv"
+	"time"
+	"unicode/utf16"
+)
+
+// pdfInfo holds the metadata of a PDF file used in its Readium manifest
+type pdfInfo struct {
+	Title        string
+	Author       string
+	CreationDate time.Time
+}
+
+var pdfInfoRef = regexp.MustCompile(`/Info\s+(\d+)\s+(\d+)\s+R`)
+
+// readPDFInfo extracts the title, author and creation date from the information dictionary of a PDF file.
+// The dictionary must not be stored in a compressed object stream.
+// It returns false if no information dictionary is found.
+func readPDFInfo(data []byte) (info pdfInfo, found bool) {
+
+	// the trailer of the last incremental update references the information dictionary
+	refs := pdfInfoRef.FindAllSubmatch(data, -1)
+	if len(refs) == 0 {
+		return info, false
+	}
+	ref := refs[len(refs)-1]
+
+	// the last definition of the object wins
+	obj := regexp.MustCompile(`(?:^|[^0-9])` + string(ref[1]) + `\s+` + string(ref[2]) + `\s+obj\s*<<`)
+	locs := obj.FindAllIndex(data, -1)
+	if len(locs) == 0 {
+		return info, false
+	}
+
+	entries := parsePDFDict(data[locs[len(locs)-1][1]:])
+	info.Title = entries["Title"]
+	info.Author = entries["Author"]
+	info.CreationDate, _ = parsePDFDate(entries["CreationDate"])
+	return info, true
+}
+
+// Sizes of the parts of a PDF file read by readPDFInfoAt
+const (
+	// pdfTailSize is the size of the end of the file, which holds the trailer
+	pdfTailSize = 64 << 10
+	// pdfObjectSize is the size read at the offset of the information dictionary
+	pdfObjectSize = 16 << 10
+	// pdfXrefEntrySize is the size of an entry of a cross-reference table
+	pdfXrefEntrySize = 20
+)
+
+var (
+	pdfStartXref        = regexp.MustCompile(`startxref\s+(\d+)`)
+	pdfXrefSubsection   = regexp.MustCompile(`^\s*(\d+)[ ]+(\d+)[ ]*(?:\r\n|\r|\n)`)
+	pdfXrefSectionStart = []byte("xref")
+)
+
+// readPDFInfoAt extracts the metadata of a PDF file of the given size without reading the whole file.
+// The information dictionary is searched in the tail of the file, which holds the trailer,
+// then read at the offset given by the cross-reference table of the last update.
+// Cross-reference streams are not supported.
+func readPDFInfoAt(r io.ReaderAt, size int64) (pdfInfo, bool) {
+	tail := readPDFAt(r, size-pdfTailSize, pdfTailSize, size)
+	if info, found := readPDFInfo(tail); found {
+		return info, true
+	}
+
+	refs := pdfInfoRef.FindAllSubmatch(tail, -1)
+	xrefs := pdfStartXref.FindAllSubmatch(tail, -1)
+	if len(refs) == 0 || len(xrefs) == 0 {
+		return pdfInfo{}, false
+	}
+	ref := refs[len(refs)-1]
+	number, err := strconv.Atoi(string(ref[1]))
+	if err != nil {
+		return pdfInfo{}, false
+	}
+	xrefOffset, err := strconv.ParseInt(string(xrefs[len(xrefs)-1][1]), 10, 64)
+	if err != nil {
+		return pdfInfo{}, false
+	}
+	offset, ok := pdfObjectOffset(r, size, xrefOffset, number)
+	if !ok {
+		return pdfInfo{}, false
+	}
+	// the object is parsed along with its reference
+	return readPDFInfo(append(readPDFAt(r, offset, pdfObjectSize, size), ref[0]...))
+}
+
+// pdfObjectOffset returns the offset of an object in a PDF file, from a cross-reference table
+// made of subsections of fixed size entries
+func pdfObjectOffset(r io.ReaderAt, size int64, xrefOffset int64, number int) (int64, bool) {
+	if !bytes.HasPrefix(readPDFAt(r, xrefOffset, int64(len(pdfXrefSectionStart)), size), pdfXrefSectionStart) {
+		return 0, false
+	}
+	pos := xrefOffset + int64(len(pdfXrefSectionStart))
+	for {
+		header := readPDFAt(r, pos, 64, size)
+		m := pdfXrefSubsection.FindSubmatchIndex(header)
+		if m == nil {
+			// the trailer follows the last subsection
+			return 0, false
+		}
+		first, _ := strconv.Atoi(string(header[m[2]:m[3]]))
+		count, _ := strconv.Atoi(string(header[m[4]:m[5]]))
+		pos += int64(m[1])
+		if number >= first && number < first+count {
+			fields := bytes.Fields(readPDFAt(r, pos+int64(number-first)*pdfXrefEntrySize, pdfXrefEntrySize, size))
+			// only objects in use have an offset
+			if len(fields) != 3 || string(fields[2]) != "n" {
+				return 0, false
+			}
+			offset, err := strconv.ParseInt(string(fields[0]), 10, 64)
+			return offset, err == nil && offset < size
+		}
+		pos += int64(count) * pdfXrefEntrySize
+	}
+}
+
+// readPDFAt reads up to n bytes of a PDF file of the given size from an offset,
+// the bytes outside of the file being ignored
+func readPDFAt(r io.ReaderAt, offset int64, n int64, size int64) []byte {
+	if offset < 0 {
+		n += offset
+		offset = 0
+	}
+	if offset+n > size {
+		n = size - offset
+	}
+	if n <= 0 {
+		return nil
+	}
+	data := make([]byte, n)
+	read, _ := r.ReadAt(data, offset)
+	return data[:read]
+}
+
+// parsePDFDict returns the string entries of a PDF dictionary, starting after its opening "<<"
+func parsePDFDict(data []byte) map[string]string {
+	entries := map[string]string{}
+	var key string
+	for i := 0; i < len(data); {
+		switch c := data[i]; {
+		case c == '>' && i+1 < len(data) && data[i+1] == '>':
+			return entries
+		case c == '/':
+			j := i + 1
+			for j < len(data) && !isPDFDelimiter(data[j]) {
+				j++
+			}
+			if key == "" {
+				key = string(data[i+1 : j])
+			} else {
+				// a name value
+				key = ""
+			}
+			i = j
+		case c == '(':
+			value, n := parsePDFLiteralString(data[i:])
+			if key != "" {
+				entries[key] = decodePDFText(value)
+			}
+			key = ""
+			i += n
+		case c == '<' && i+1 < len(data) && data[i+1] == '<':
+			// nested dictionaries are skipped
+			depth := 0
+			for ; i+1 < len(data); i++ {
+				if data[i] == '<' && data[i+1] == '<' {
+					depth++
+					i++
+				} else if data[i] == '>' && data[i+1] == '>' {
+					depth--
+					i++
+					if depth == 0 {
+						break
+					}
+				}
+			}
+			i++
+			key = ""
+		case c == '<':
+			j := bytes.IndexByte(data[i:], '>')
+			if j < 0 {
+				return entries
+			}
+			value, err := hex.DecodeString(string(bytes.Join(bytes.Fields(data[i+1:i+j]), nil)))
+			if key != "" && err == nil {
+				entries[key] = decodePDFText(value)
+			}
+			key = ""
+			i += j + 1
+		case c == '[':
+			// arrays are skipped
+			j := bytes.IndexByte(data[i:], ']')
+			if j < 0 {
+				return entries
+			}
+			key = ""
+			i += j + 1
+		case isPDFWhitespace(c):
+			i++
+		default:
+			// other values (numbers, booleans, references) are skipped
+			j := i + 1
+			for j < len(data) && !isPDFDelimiter(data[j]) {
+				j++
+			}
+			key = ""
+			i = j
+		}
+	}
+	return entries
+}
+
+// parsePDFLiteralString decodes a literal string starting with "(",
+// and returns its value and the number of bytes consumed
+func parsePDFLiteralString(data []byte) ([]byte, int) {
+	var value []byte
+	depth := 0
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch c {
+		case '(':
+			depth++
+			if depth == 1 {
+				continue
+			}
+		case ')':
+			depth--
+			if depth == 0 {
+				return value, i + 1
+			}
+		case '\\':
+			i++
+			if i >= len(data) {
+				return value, i
+			}
+			switch e := data[i]; e {
+			case 'n':
+				value = append(value, '\n')
+			case 'r':
+				value = append(value, '\r')
+			case 't':
+				value = append(value, '\t')
+			case 'b':
+				value = append(value, '\b')
+			case 'f':
+				value = append(value, '\f')
+			case '\r', '\n':
+				// line continuation
+				if e == '\r' && i+1 < len(data) && data[i+1] == '\n' {
+					i++
+				}
+			default:
+				if e >= '0' && e <= '7' {
+					// octal character code, up to 3 digits
+					j := i
+					for j < len(data) && j < i+3 && data[j] >= '0' && data[j] <= '7' {
+						j++
+					}
+					code, _ := strconv.ParseUint(string(data[i:j]), 8, 8)
+					value = append(value, byte(code))
+					i = j - 1
+				} else {
+					value = append(value, e)
+				}
+			}
+			continue
+		}
+		value = append(value, c)
+	}
+	return value, len(data)
+}
+
+// decodePDFText decodes a PDF text string, encoded in UTF-16BE with a byte order mark,
+// or in PDFDocEncoding, approximated as Latin-1
+func decodePDFText(value []byte) string {
+	if len(value) >= 2 && value[0] == 0xFE && value[1] == 0xFF {
+		units := make([]uint16, 0, len(value)/2)
+		for i := 2; i+1 < len(value); i += 2 {
+			units = append(units, uint16(value[i])<<8|uint16(value[i+1]))
+		}
+		return string(utf16.Decode(units))
+	}
+	runes := make([]rune, len(value))
+	for i, b := range value {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+var pdfDate = regexp.MustCompile(`^D:(\d{4})(\d{2})?(\d{2})?(\d{2})?(\d{2})?(\d{2})?(?:([Z+-])(\d{2})?'?(\d{2})?'?)?`)
+
+// parsePDFDate parses a PDF date, formatted as D:YYYYMMDDHHmmSSOHH'mm'
+func parsePDFDate(s string) (time.Time, bool) {
+	m := pdfDate.FindStringSubmatch(s)
+	if m == nil {
+		return time.Time{}, false
+	}
+	field := func(i int, def int) int {
+		if m[i] == "" {
+			return def
+		}
+		v, _ := strconv.Atoi(m[i])
+		return v
+	}
+	location := time.UTC
+	if m[7] == "+" || m[7] == "-" {
+		offset := field(8, 0)*3600 + field(9, 0)*60
+		if m[7] == "-" {
+			offset = -offset
+		}
+		location = time.FixedZone("", offset)
+	}
+	return time.Date(field(1, 0), time.Month(field(2, 1)), field(3, 1), field(4, 0), field(5, 0), field(6, 0), 0, location), true
+}
+
+func isPDFWhitespace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == 0
+}
+
+func isPDFDelimiter(c byte) bool {
+	return isPDFWhitespace(c) || bytes.IndexByte([]byte("()<>[]{}/%"), c) >= 0
+}
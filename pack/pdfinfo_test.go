@@ -0,0 +1,156 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPDFWithInfo = `%PDF-1.4
+1 0 obj << /Type /Catalog /Pages 2 0 R >> endobj
+2 0 obj << /Type /Pages /Kids [] /Count 0 >> endobj
+11 0 obj
+<< /Producer 5 0 R /Trapped /False /Title <FEFF004C00E9006F> /Author (Jules \(J.\) Verne\051)
+   /CreationDate (D:20200315103000+02'00') >>
+endobj
+trailer << /Size 12 /Root 1 0 R /Info 11 0 R >>
+%%EOF
+`
+
+func TestReadPDFInfo(t *testing.T) {
+	info, found := readPDFInfo([]byte(testPDFWithInfo))
+	if !found {
+		t.Fatal("Expected an information dictionary")
+	}
+	if info.Title != "Léo" {
+		t.Errorf("Expected the title Léo, got %q", info.Title)
+	}
+	if info.Author != "Jules (J.) Verne)" {
+		t.Errorf("Expected the author Jules (J.) Verne), got %q", info.Author)
+	}
+	expected := time.Date(2020, 3, 15, 8, 30, 0, 0, time.UTC)
+	if !info.CreationDate.Equal(expected) {
+		t.Errorf("Expected the creation date %s, got %s", expected, info.CreationDate)
+	}
+
+	if _, found = readPDFInfo([]byte("%PDF-1.4\ntrailer << /Size 1 >>\n%%EOF")); found {
+		t.Error("Expected no information dictionary")
+	}
+}
+
+func TestParsePDFDate(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected time.Time
+	}{
+		{"D:2019", time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"D:20191231235959Z", time.Date(2019, 12, 31, 23, 59, 59, 0, time.UTC)},
+		{"D:20191231120000-05'30'", time.Date(2019, 12, 31, 17, 30, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		date, ok := parsePDFDate(test.in)
+		if !ok || !date.Equal(test.expected) {
+			t.Errorf("Expected %s to be parsed as %s, got %s", test.in, test.expected, date)
+		}
+	}
+	if _, ok := parsePDFDate("2019-12-31"); ok {
+		t.Error("Expected an invalid date to be rejected")
+	}
+}
+
+func TestBuildRWPPFromPDFMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	withInfo := filepath.Join(dir, "with-info.pdf")
+	if err = ioutil.WriteFile(withInfo, []byte(testPDFWithInfo), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outputPath := filepath.Join(dir, "output.lcpdf")
+	if err = BuildRWPPFromPDF("", withInfo, outputPath); err != nil {
+		t.Fatalf("Could not build the package, %s", err)
+	}
+	reader, err := OpenRWPP(outputPath)
+	if err != nil {
+		t.Fatalf("Expected a valid package, got %s", err)
+	}
+	metadata := reader.manifest.Metadata
+	if title := metadata.Title.Text(); title != "Léo" {
+		t.Errorf("Expected the title Léo, got %s", title)
+	}
+	if author := metadata.Author.Name(); author != "Jules (J.) Verne)" {
+		t.Errorf("Expected the author Jules (J.) Verne), got %s", author)
+	}
+	if expected := time.Date(2020, 3, 15, 8, 30, 0, 0, time.UTC); !metadata.Modified.Equal(expected) {
+		t.Errorf("Expected the modification date %s, got %s", expected, metadata.Modified)
+	}
+
+	withoutInfo := filepath.Join(dir, "no-info.pdf")
+	if err = ioutil.WriteFile(withoutInfo, []byte("%PDF-1.4\n%%EOF\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = BuildRWPPFromPDF("", withoutInfo, outputPath); err != nil {
+		t.Fatalf("Could not build the package, %s", err)
+	}
+	if reader, err = OpenRWPP(outputPath); err != nil {
+		t.Fatalf("Expected a valid package, got %s", err)
+	}
+	if title := reader.manifest.Metadata.Title.Text(); title != "no-info.pdf" {
+		t.Errorf("Expected the file name as title, got %s", title)
+	}
+	if len(reader.manifest.Metadata.Author) != 0 {
+		t.Errorf("Expected no author, got %v", reader.manifest.Metadata.Author)
+	}
+}
+
+func TestReadPDFInfoAt(t *testing.T) {
+	// the information dictionary is far from the end of the file, and found from the cross-reference table
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n")
+	offsets := []int{}
+	for _, obj := range []string{
+		"1 0 obj << /Type /Catalog /Pages 2 0 R >> endobj\n",
+		"2 0 obj << /Type /Pages /Kids [] /Count 0 >> endobj\n",
+		"3 0 obj << /Title (Far away) /Author (Someone) >> endobj\n",
+	} {
+		offsets = append(offsets, pdf.Len())
+		pdf.WriteString(obj)
+	}
+	pdf.WriteString("% " + strings.Repeat("padding ", pdfTailSize/4) + "\n")
+	xref := pdf.Len()
+	pdf.WriteString("xref\n0 4\n0000000000 65535 f \n")
+	for _, offset := range offsets {
+		fmt.Fprintf(&pdf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&pdf, "trailer << /Size 4 /Root 1 0 R /Info 3 0 R >>\nstartxref\n%d\n%%%%EOF\n", xref)
+
+	data := pdf.Bytes()
+	info, found := readPDFInfoAt(bytes.NewReader(data), int64(len(data)))
+	if !found || info.Title != "Far away" || info.Author != "Someone" {
+		t.Errorf("Expected the information dictionary to be found from the cross-reference table, got %+v", info)
+	}
+
+	// the information dictionary is in the tail of the file
+	info, found = readPDFInfoAt(strings.NewReader(testPDFWithInfo), int64(len(testPDFWithInfo)))
+	if !found || info.Title != "Léo" {
+		t.Errorf("Expected the information dictionary to be found in the tail, got %+v", info)
+	}
+
+	// an object which is not in use has no offset
+	freed := bytes.Replace(data, []byte(fmt.Sprintf("%010d 00000 n", offsets[2])), []byte(fmt.Sprintf("%010d 00001 f", 0)), 1)
+	if _, found = readPDFInfoAt(bytes.NewReader(freed), int64(len(freed))); found {
+		t.Error("Expected no information dictionary for a free object")
+	}
+}
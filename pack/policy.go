@@ -0,0 +1,54 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EncryptionPolicy decides, by media type, which resources of a Readium package are encrypted,
+// and which are deflated before being encrypted
+type EncryptionPolicy interface {
+	ShouldEncrypt(contentType string) bool
+	ShouldCompress(contentType string) bool
+}
+
+// DefaultEncryptionPolicy encrypts every resource, without compressing it first
+var DefaultEncryptionPolicy EncryptionPolicy = defaultEncryptionPolicy{}
+
+type defaultEncryptionPolicy struct{}
+
+func (defaultEncryptionPolicy) ShouldEncrypt(contentType string) bool  { return true }
+func (defaultEncryptionPolicy) ShouldCompress(contentType string) bool { return false }
+
+// Named encryption policies, selected by PackOptions.EncryptionPolicy
+const (
+	// EncryptionPolicyAll encrypts every resource which can be encrypted
+	EncryptionPolicyAll = "all"
+	// EncryptionPolicySkipAudio leaves audio resources in clear
+	EncryptionPolicySkipAudio = "skip-audio"
+)
+
+// NamedEncryptionPolicy returns the encryption policy selected by name, applied on top of a base policy:
+// a resource is only encrypted if both policies encrypt it. The empty name selects the base policy.
+func NamedEncryptionPolicy(name string, base EncryptionPolicy) (EncryptionPolicy, error) {
+	switch name {
+	case "", EncryptionPolicyAll:
+		return base, nil
+	case EncryptionPolicySkipAudio:
+		return skipAudioPolicy{base}, nil
+	}
+	return nil, fmt.Errorf("unknown encryption policy %q", name)
+}
+
+// skipAudioPolicy leaves audio resources in clear, and follows its base policy for the others
+type skipAudioPolicy struct {
+	EncryptionPolicy
+}
+
+func (policy skipAudioPolicy) ShouldEncrypt(contentType string) bool {
+	return !strings.HasPrefix(contentType, "audio/") && policy.EncryptionPolicy.ShouldEncrypt(contentType)
+}
@@ -0,0 +1,153 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/readium/readium-lcp-server/crypto"
+	"github.com/readium/readium-lcp-server/license"
+)
+
+// streamingPolicy leaves audio in clear and compresses HTML before encryption
+type streamingPolicy struct{}
+
+func (streamingPolicy) ShouldEncrypt(contentType string) bool {
+	return !strings.HasPrefix(contentType, "audio/")
+}
+func (streamingPolicy) ShouldCompress(contentType string) bool { return contentType == "text/html" }
+
+func TestEncryptionPolicy(t *testing.T) {
+	const manifest = `{"metadata": {"title": "policy"}, "readingOrder": [
+		{"href": "track.mp3", "type": "audio/mpeg"},
+		{"href": "chapter.html", "type": "text/html"}]}`
+
+	reader := openTestRWPP(t,
+		testEntry{name: ManifestLocation, method: Deflate, body: []byte(manifest)},
+		testEntry{name: "track.mp3", method: NoCompression, body: []byte("mp3")},
+		testEntry{name: "chapter.html", method: Deflate, body: bytes.Repeat([]byte("<p/>"), 100)},
+	)
+	reader.Policy = streamingPolicy{}
+
+	for _, resource := range reader.Resources() {
+		audio := resource.ContentType() == "audio/mpeg"
+		if resource.CanBeEncrypted() == audio || resource.CompressBeforeEncryption() == audio {
+			t.Errorf("Expected the policy to apply to %s", resource.Path())
+		}
+	}
+
+	var b bytes.Buffer
+	writer, err := reader.NewWriter(&b)
+	if err != nil {
+		t.Fatalf("Could not build a writer, %s", err)
+	}
+	if _, err = Process(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer); err != nil {
+		t.Fatalf("Could not process the package, %s", err)
+	}
+
+	output := readOutputManifest(t, b.Bytes())
+	if l := len(output.ReadingOrder); l != 2 {
+		t.Fatalf("Expected 2 items in the reading order, got %d", l)
+	}
+	if isEncryptedLink(output.ReadingOrder[0]) {
+		t.Error("Expected the audio track to be left in clear")
+	}
+	if !isEncryptedLink(output.ReadingOrder[1]) {
+		t.Fatal("Expected the chapter to be encrypted")
+	}
+	if compression := output.ReadingOrder[1].Properties.Encrypted.Compression; compression != CompressionDeflate {
+		t.Errorf("Expected the chapter to be compressed before encryption, got %q", compression)
+	}
+}
+
+func TestDefaultEncryptionPolicy(t *testing.T) {
+	reader, err := OpenRWPP("./samples/basic.lcpdf")
+	if err != nil {
+		t.Fatalf("Expected to be able to open basic.lcpdf, got %s", err)
+	}
+	for _, resource := range reader.Resources() {
+		if !resource.CanBeEncrypted() || resource.CompressBeforeEncryption() {
+			t.Errorf("Expected %s to be encrypted without compression", resource.Path())
+		}
+	}
+}
+
+func TestNamedEncryptionPolicy(t *testing.T) {
+	// compresses HTML, and encrypts everything but images
+	base := htmlPolicy{}
+
+	policy, err := NamedEncryptionPolicy(EncryptionPolicySkipAudio, base)
+	if err != nil {
+		t.Fatalf("Expected the skip-audio policy, got %s", err)
+	}
+	tests := []struct {
+		contentType string
+		encrypt     bool
+		compress    bool
+	}{
+		{"audio/mpeg", false, false},
+		{"image/png", false, false},
+		{"text/html", true, true},
+		{"application/pdf", true, false},
+	}
+	for _, test := range tests {
+		if encrypt := policy.ShouldEncrypt(test.contentType); encrypt != test.encrypt {
+			t.Errorf("Expected ShouldEncrypt(%s) to be %v, got %v", test.contentType, test.encrypt, encrypt)
+		}
+		if compress := policy.ShouldCompress(test.contentType); compress != test.compress {
+			t.Errorf("Expected ShouldCompress(%s) to be %v, got %v", test.contentType, test.compress, compress)
+		}
+	}
+
+	for _, name := range []string{"", EncryptionPolicyAll} {
+		if policy, err := NamedEncryptionPolicy(name, base); err != nil || policy != base {
+			t.Errorf("Expected %q to select the base policy, got %v, %v", name, policy, err)
+		}
+	}
+	if _, err := NamedEncryptionPolicy("none", base); err == nil {
+		t.Error("Expected an unknown policy to be rejected")
+	}
+}
+
+type htmlPolicy struct{}
+
+func (htmlPolicy) ShouldEncrypt(contentType string) bool {
+	return !strings.HasPrefix(contentType, "image/")
+}
+func (htmlPolicy) ShouldCompress(contentType string) bool { return contentType == "text/html" }
+
+func TestEncryptionPolicyOption(t *testing.T) {
+	const manifest = `{"metadata": {"title": "policy"}, "readingOrder": [
+		{"href": "track.mp3", "type": "audio/mpeg"},
+		{"href": "cover.png", "type": "image/png"},
+		{"href": "chapter.html", "type": "text/html"}]}`
+
+	reader := openTestRWPP(t,
+		testEntry{name: ManifestLocation, method: Deflate, body: []byte(manifest)},
+		testEntry{name: "track.mp3", method: NoCompression, body: []byte("mp3")},
+		testEntry{name: "cover.png", method: NoCompression, body: []byte("png")},
+		testEntry{name: "chapter.html", method: Deflate, body: bytes.Repeat([]byte("<p/>"), 100)},
+	)
+	reader.Policy = htmlPolicy{}
+
+	// the option and the policy of the reader both apply
+	opts := PackOptions{EncryptionPolicy: EncryptionPolicySkipAudio}
+	var b bytes.Buffer
+	writer, err := reader.NewWriterWithOptions(&b, opts)
+	if err != nil {
+		t.Fatalf("Could not build a writer, %s", err)
+	}
+	if _, _, err = ProcessWithOptions(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer, opts); err != nil {
+		t.Fatalf("Could not process the package, %s", err)
+	}
+
+	for _, link := range readOutputManifest(t, b.Bytes()).ReadingOrder {
+		if isEncryptedLink(link) != (link.Href == "chapter.html") {
+			t.Errorf("Unexpected encryption state for %s", link.Href)
+		}
+	}
+}
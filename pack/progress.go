@@ -0,0 +1,40 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import "io"
+
+// ProgressFunc is called as the bytes of a resource are copied or encrypted into a package.
+// bytesDone increases from 0 to bytesTotal, the size of the resource;
+// for a zip entry copied as is, both count the compressed bytes of the entry.
+type ProgressFunc func(resourcePath string, bytesDone, bytesTotal int64)
+
+// progressReader reports the bytes read through it
+type progressReader struct {
+	io.Reader
+	path     string
+	done     int64
+	total    int64
+	progress ProgressFunc
+}
+
+// newProgressReader returns a reader reporting its progress, or the reader itself if progress is nil.
+// The progress is first reported with no bytes read.
+func newProgressReader(r io.Reader, path string, total int64, progress ProgressFunc) io.Reader {
+	if progress == nil {
+		return r
+	}
+	progress(path, 0, total)
+	return &progressReader{Reader: r, path: path, total: total, progress: progress}
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.Reader.Read(p)
+	if n > 0 {
+		pr.done += int64(n)
+		pr.progress(pr.path, pr.done, pr.total)
+	}
+	return n, err
+}
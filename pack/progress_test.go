@@ -0,0 +1,71 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/readium/readium-lcp-server/crypto"
+	"github.com/readium/readium-lcp-server/license"
+)
+
+func TestProgress(t *testing.T) {
+	const manifest = `{"metadata": {"title": "progress"}, "readingOrder": [
+		{"href": "track.mp3", "type": "audio/mpeg"},
+		{"href": "chapter.html", "type": "text/html"}]}`
+
+	mp3 := bytes.Repeat([]byte("mp3"), 50000)
+	html := bytes.Repeat([]byte("<p>progress</p>"), 10000)
+	reader := openTestRWPP(t,
+		testEntry{name: ManifestLocation, method: Deflate, body: []byte(manifest)},
+		testEntry{name: "track.mp3", method: NoCompression, body: mp3},
+		testEntry{name: "chapter.html", method: Deflate, body: html},
+	)
+
+	type call struct{ done, total int64 }
+	calls := map[string][]call{}
+	opts := PackOptions{
+		// the audio track is copied, the chapter is encrypted
+		EncryptionPolicy: EncryptionPolicySkipAudio,
+		Progress: func(resourcePath string, bytesDone, bytesTotal int64) {
+			calls[resourcePath] = append(calls[resourcePath], call{bytesDone, bytesTotal})
+		},
+	}
+
+	var b bytes.Buffer
+	writer, err := reader.NewWriterWithOptions(&b, opts)
+	if err != nil {
+		t.Fatalf("Could not build a writer, %s", err)
+	}
+	if _, _, err = ProcessWithOptions(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer, opts); err != nil {
+		t.Fatalf("Could not process the package, %s", err)
+	}
+
+	for path, size := range map[string]int{"track.mp3": len(mp3), "chapter.html": len(html)} {
+		reported := calls[path]
+		if len(reported) < 3 {
+			t.Fatalf("Expected the progress of %s to be reported periodically, got %d calls", path, len(reported))
+		}
+		if reported[0].done != 0 {
+			t.Errorf("Expected the progress of %s to start at 0, got %d", path, reported[0].done)
+		}
+		for i := 1; i < len(reported); i++ {
+			if reported[i].done <= reported[i-1].done {
+				t.Errorf("Expected increasing byte counts for %s, got %d after %d", path, reported[i].done, reported[i-1].done)
+			}
+			if reported[i].total != reported[0].total {
+				t.Errorf("Expected a constant total for %s, got %d and %d", path, reported[i].total, reported[0].total)
+			}
+		}
+		last := reported[len(reported)-1]
+		if last.done != last.total || last.total != int64(size) {
+			t.Errorf("Expected the progress of %s to end at %d, got %d of %d", path, size, last.done, last.total)
+		}
+	}
+	if _, ok := calls[ManifestLocation]; ok {
+		t.Error("Expected no progress to be reported for the manifest")
+	}
+}
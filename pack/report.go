@@ -0,0 +1,45 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// ReportName is the conventional name of a packaging report side-file
+const ReportName = "packaging-report.json"
+
+// ResourceReport describes how a single resource was packaged
+type ResourceReport struct {
+	Path           string        `json:"path"`
+	OriginalSize   int64         `json:"originalSize"`
+	CiphertextSize int64         `json:"ciphertextSize"`
+	Compressed     bool          `json:"compressed"`
+	Encrypted      bool          `json:"encrypted"`
+	Algorithm      string        `json:"algorithm,omitempty"`
+	Duration       time.Duration `json:"duration"`
+}
+
+// Report is a machine-readable description of a packaging run
+type Report struct {
+	Resources []ResourceReport `json:"resources"`
+}
+
+// add appends a resource report, if reporting is active
+func (report *Report) add(resourceReport ResourceReport) {
+	if report == nil {
+		return
+	}
+	report.Resources = append(report.Resources, resourceReport)
+}
+
+// Write serializes the report as json
+func (report *Report) Write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
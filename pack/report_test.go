@@ -0,0 +1,102 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/readium/readium-lcp-server/crypto"
+	"github.com/readium/readium-lcp-server/license"
+)
+
+const reportTestManifest = `{
+	"metadata": {"title": "report"},
+	"readingOrder": [
+		{"href": "chapter1.html", "type": "text/html"},
+		{"href": "chapter2.html", "type": "text/html", "properties": {"encrypted": {"scheme": "http://readium.org/2014/01/lcp"}}}
+	]
+}`
+
+func TestPackagingReport(t *testing.T) {
+	reader := openTestRWPP(t,
+		testEntry{name: ManifestLocation, method: Deflate, body: []byte(reportTestManifest)},
+		testEntry{name: "chapter1.html", method: Deflate, body: []byte("<html>one</html>")},
+		testEntry{name: "chapter2.html", method: NoCompression, body: []byte("already encrypted")},
+	)
+
+	var b bytes.Buffer
+	writer, err := reader.NewWriter(&b)
+	if err != nil {
+		t.Fatalf("Could not build a writer, %s", err)
+	}
+
+	encrypter := crypto.NewAESEncrypter_PUBLICATION_RESOURCES()
+	_, report, err := ProcessWithOptions(license.BasicProfile, encrypter, reader, writer, PackOptions{Report: true})
+	if err != nil {
+		t.Fatalf("Could not process the package, %s", err)
+	}
+	if report == nil {
+		t.Fatal("Expected a packaging report")
+	}
+	if l := len(report.Resources); l != 2 {
+		t.Fatalf("Expected %d resources in the report, got %d", 2, l)
+	}
+
+	first := report.Resources[0]
+	if first.Path != "chapter1.html" || !first.Encrypted || first.Algorithm != encrypter.Signature() {
+		t.Errorf("Expected chapter1.html to be reported as encrypted, got %#v", first)
+	}
+	if first.OriginalSize != 16 {
+		t.Errorf("Expected an original size of %d, got %d", 16, first.OriginalSize)
+	}
+	// AES-CBC adds a 16 bytes IV and pads to the next block
+	if first.CiphertextSize != 48 {
+		t.Errorf("Expected a ciphertext size of %d, got %d", 48, first.CiphertextSize)
+	}
+	if first.Compressed {
+		t.Errorf("Did not expect chapter1.html to be compressed")
+	}
+
+	second := report.Resources[1]
+	if second.Path != "chapter2.html" || !second.Encrypted || second.Algorithm != "" {
+		t.Errorf("Expected chapter2.html to be reported as a copy of an encrypted resource, got %#v", second)
+	}
+	if second.CiphertextSize != second.OriginalSize {
+		t.Errorf("Expected a copied resource to keep its size, got %d and %d", second.OriginalSize, second.CiphertextSize)
+	}
+
+	var out bytes.Buffer
+	if err = report.Write(&out); err != nil {
+		t.Fatalf("Could not write the report, %s", err)
+	}
+	var decoded Report
+	if err = json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("Could not decode the report, %s", err)
+	}
+	if len(decoded.Resources) != 2 {
+		t.Errorf("Expected the serialized report to list every resource")
+	}
+}
+
+func TestNoPackagingReportByDefault(t *testing.T) {
+	reader, err := OpenRWPP("./samples/basic.lcpdf")
+	if err != nil {
+		t.Fatalf("Expected to be able to open basic.lcpdf, got %s", err)
+	}
+	var b bytes.Buffer
+	writer, err := reader.NewWriter(&b)
+	if err != nil {
+		t.Fatalf("Could not build a writer, %s", err)
+	}
+	_, report, err := ProcessWithOptions(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer, PackOptions{})
+	if err != nil {
+		t.Fatalf("Could not process the package, %s", err)
+	}
+	if report != nil {
+		t.Errorf("Did not expect a packaging report")
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"io"
+
+	"github.com/readium/readium-lcp-server/license"
+	"github.com/readium/readium-lcp-server/rwpm"
+)
+
+// Reprofile rewrites an encrypted Readium package with a new LCP encryption profile.
+// The encrypted resources are copied as is, as the profile only applies to the license,
+// and marked as encrypted with the new profile, keeping their algorithm, compression, original length and checksum.
+// Reprofiling a package twice with the same profile gives the same manifest.
+func Reprofile(reader *RWPPReader, w io.Writer, profile license.EncryptionProfile) error {
+
+	if err := profile.Validate(); err != nil {
+		return err
+	}
+	writer, err := reader.NewWriter(w)
+	if err != nil {
+		return err
+	}
+	rwppWriter := writer.(*RWPPWriter)
+
+	// the encrypted properties of the source package, found in the reading order or the resources
+	encrypted := map[string]rwpm.Encrypted{}
+	var walk func(links []rwpm.Link)
+	walk = func(links []rwpm.Link) {
+		for _, link := range links {
+			if link.Properties != nil && link.Properties.Encrypted != nil {
+				if _, ok := encrypted[link.Href]; !ok {
+					encrypted[link.Href] = *link.Properties.Encrypted
+				}
+			}
+			walk(link.Alternate)
+		}
+	}
+	walk(reader.manifest.ReadingOrder)
+	walk(reader.manifest.Resources)
+
+	for _, resource := range reader.Resources() {
+		if err = resource.CopyTo(writer); err != nil {
+			return err
+		}
+	}
+
+	for path, e := range encrypted {
+		if e.Compression == CompressionDeflate {
+			if rwppWriter.compressed == nil {
+				rwppWriter.compressed = map[string]bool{}
+			}
+			rwppWriter.compressed[path] = true
+		}
+		if e.Checksum != "" {
+			rwppWriter.setChecksum(path, e.Checksum)
+		}
+		if err = writer.MarkAsEncrypted(path, int64(e.OriginalLength), profile, e.Algorithm); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
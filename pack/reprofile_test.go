@@ -0,0 +1,66 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/readium/readium-lcp-server/crypto"
+	"github.com/readium/readium-lcp-server/license"
+)
+
+func TestReprofile(t *testing.T) {
+	reader := openTestRWPP(t,
+		testEntry{name: ManifestLocation, method: Deflate, body: []byte(`{"metadata": {"title": "reprofile"}, "readingOrder": [{"href": "chapter.html", "type": "text/html"}, {"href": "track.mp3", "type": "audio/mpeg"}]}`)},
+		testEntry{name: "chapter.html", method: Deflate, body: bytes.Repeat([]byte("<p>chapter</p>"), 100)},
+		testEntry{name: "track.mp3", method: NoCompression, body: []byte("mp3")},
+	)
+	var packaged bytes.Buffer
+	writer, err := reader.NewWriterWithOptions(&packaged, PackOptions{Checksum: true})
+	if err != nil {
+		t.Fatalf("Could not build a writer, %s", err)
+	}
+	if _, _, err = ProcessWithOptions(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer, PackOptions{Checksum: true}); err != nil {
+		t.Fatalf("Could not process the package, %s", err)
+	}
+	before := readOutputManifest(t, packaged.Bytes())
+
+	var reprofiled []byte
+	for i := 0; i < 2; i++ {
+		encrypted, err := NewRWPPReader(openTestZip(t, packaged.Bytes()))
+		if err != nil {
+			t.Fatalf("Could not read the package, %s", err)
+		}
+		var output bytes.Buffer
+		if err = Reprofile(encrypted, &output, license.V1Profile); err != nil {
+			t.Fatalf("Could not reprofile the package, %s", err)
+		}
+		if reprofiled != nil && !bytes.Equal(reprofiled, output.Bytes()) {
+			t.Error("Expected reprofiling to be idempotent")
+		}
+		reprofiled = output.Bytes()
+		packaged = output
+	}
+
+	after := readOutputManifest(t, reprofiled)
+	if len(after.ReadingOrder) != len(before.ReadingOrder) {
+		t.Fatalf("Expected %d links, got %d", len(before.ReadingOrder), len(after.ReadingOrder))
+	}
+	for i, link := range after.ReadingOrder {
+		if !isEncryptedLink(link) {
+			t.Errorf("Expected %s to stay encrypted", link.Href)
+			continue
+		}
+		e, source := *link.Properties.Encrypted, *before.ReadingOrder[i].Properties.Encrypted
+		if e.Profile != license.V1Profile.String() {
+			t.Errorf("Expected %s to be marked with the new profile, got %s", link.Href, e.Profile)
+		}
+		source.Profile = e.Profile
+		if e != source {
+			t.Errorf("Expected the encrypted properties of %s to be kept, expected %+v, got %+v", link.Href, source, e)
+		}
+	}
+}
@@ -6,11 +6,20 @@ package pack
 
 import (
 	"archive/zip"
+	"bufio"
+	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"log"
 	"os"
-	"text/template"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/readium/readium-lcp-server/license"
 	"github.com/readium/readium-lcp-server/rwpm"
@@ -20,12 +29,53 @@ import (
 type RWPPReader struct {
 	manifest   rwpm.Publication
 	zipArchive *zip.Reader
+	// files indexes the zip entries by name, built once when the package is opened
+	files map[string]*zip.File
+	// manifestName is the name of the manifest entry, with the casing found in the package
+	manifestName string
+	// EncryptAncillary requests the encryption of the resources and alternates,
+	// in addition to the reading order
+	EncryptAncillary bool
+	// Policy decides which resources are encrypted and compressed before encryption;
+	// DefaultEncryptionPolicy is used if nil.
+	Policy EncryptionPolicy
 }
 
 // RWPPWriter is a REadium Package writer
 type RWPPWriter struct {
-	manifest  rwpm.Publication
-	zipWriter *zip.Writer
+	manifest     rwpm.Publication
+	manifestName string
+	zipWriter    *zip.Writer
+	options      PackOptions
+	// sourceLinks indexes the reading order of the source package by href,
+	// so that the links keep their properties and alternates when added back;
+	// sourcePositions gives the position of each href in this reading order
+	sourceLinks     map[string]rwpm.Link
+	sourcePositions map[string]int
+	// ancillary lists the hrefs of resources and alternates written to the package
+	// which must not be added to the reading order
+	ancillary map[string]bool
+	// output and spool are only set when the manifest must come first:
+	// the package is spooled to a temporary file, then rewritten into the output
+	output io.Writer
+	spool  *os.File
+	// tracker records the location of the entries, for the byte range index
+	tracker *byteRangeTracker
+	// originalSizes records the plaintext size of encrypted resources, for the byte range index
+	originalSizes map[string]int64
+	byteRanges    ByteRangeIndex
+	// policy is the encryption policy of the source package;
+	// compressed lists the resources deflated before encryption
+	policy     EncryptionPolicy
+	compressed map[string]bool
+	// checksums records the plaintext hash of encrypted resources, if requested in the options
+	checksums map[string]string
+	// sizes records the size of the entries written for the resources, for the file index
+	sizes map[string]int64
+	// licenseJSON is the LCP license written at LicenseLocation when the package is closed
+	licenseJSON []byte
+	// hasW3CManifest is set if the W3C manifest of the source package has been copied
+	hasW3CManifest bool
 }
 
 // NopWriteCloser object
@@ -35,87 +85,260 @@ type NopWriteCloser struct {
 
 // NewWriter returns a new PackageWriter writing a RWP to the output file
 func (reader *RWPPReader) NewWriter(writer io.Writer) (PackageWriter, error) {
+	return reader.NewWriterWithOptions(writer, PackOptions{})
+}
 
-	zipWriter := zip.NewWriter(writer)
+// NewWriterWithOptions returns a new PackageWriter writing a RWP to the output file, using packaging options
+func (reader *RWPPReader) NewWriterWithOptions(writer io.Writer, opts PackOptions) (PackageWriter, error) {
 
-	files := map[string]*zip.File{}
-	for _, file := range reader.zipArchive.File {
-		files[file.Name] = file
+	// the manifest can only be written once all resources are known;
+	// if it must come first, the package is spooled to a temporary file, then rewritten
+	var spool *os.File
+	output := writer
+	if opts.ManifestFirst {
+		var err error
+		if spool, err = ioutil.TempFile("", "rwpp-*.zip"); err != nil {
+			return nil, err
+		}
+		writer = spool
+	}
+	// the entries are located while they are written, unless the package is rewritten
+	var zipWriter *zip.Writer
+	var tracker *byteRangeTracker
+	if opts.ByteRangeIndex && !opts.ManifestFirst {
+		zipWriter, tracker = newTrackedZipWriter(writer)
+	} else {
+		zipWriter = zip.NewWriter(writer)
+	}
+	opts.registerCompressor(zipWriter)
+	abort := func(err error) error {
+		err = closeZipWriter(zipWriter, err)
+		if spool != nil {
+			spool.Close()
+			os.Remove(spool.Name())
+		}
+		return err
 	}
 
-	// copy immediately the W3C manifest if it exists in the source package
-	if w3cmanFile, ok := files[W3CManifestName]; ok {
-		fw, err := zipWriter.Create(W3CManifestName)
-		if err != nil {
-			return nil, err
+	// copy immediately the W3C manifest if it exists in the source package,
+	// unless it must be dropped from the output
+	hasW3CManifest := false
+	if w3cmanFile, ok := reader.files[W3CManifestName]; ok && !opts.DropW3CManifest {
+		if err := copyZipEntryTracked(zipWriter, w3cmanFile, nil, tracker); err != nil {
+			return nil, abort(err)
 		}
-		file, err := w3cmanFile.Open()
-		_, err = io.Copy(fw, file)
-		file.Close()
+		hasW3CManifest = true
 	}
 
-	// copy immediately the ancilliary resources from the source manifest as they should not be encrypted
-	// FIXME: this doesn't seem to be the best location for such zip to zip copy
-	for _, manifestResource := range reader.manifest.Resources {
-		sourceFile := files[manifestResource.Href]
-		fw, err := zipWriter.Create(sourceFile.Name)
-		if err != nil {
-			return nil, err
+	// the reading order is processed later on, and must not reference missing files
+	sourceLinks := map[string]rwpm.Link{}
+	sourcePositions := map[string]int{}
+	for _, link := range reader.manifest.ReadingOrder {
+		if reader.files[link.Href] == nil {
+			return nil, abort(fmt.Errorf("reading order item %s is missing from the package", link.Href))
+		}
+		sourceLinks[link.Href] = link
+		if _, ok := sourcePositions[link.Href]; !ok {
+			sourcePositions[link.Href] = len(sourcePositions)
+		}
+	}
+
+	// missing resources are skipped by ancillaryResources
+	for _, link := range reader.manifest.Resources {
+		if reader.files[link.Href] == nil && !isExternal(link.Href) {
+			log.Println("Warning: resource " + link.Href + " is missing from the package")
+		}
+	}
+
+	// the ancillary resources are either processed with the reading order,
+	// or copied immediately as they should not be encrypted
+	policy := opts.encryptionPolicy(reader.policy())
+	ancillary := map[string]bool{}
+	sizes := map[string]int64{}
+	for _, resource := range reader.ancillaryResources(policy) {
+		if reader.EncryptAncillary {
+			ancillary[resource.Path()] = true
+			continue
+		}
+		if err := copyZipEntryTracked(zipWriter, resource.file, nil, tracker); err != nil {
+			return nil, abort(err)
 		}
-		file, err := sourceFile.Open()
-		_, err = io.Copy(fw, file)
-		file.Close()
+		sizes[resource.Path()] = resource.Size()
 	}
 
 	manifest := reader.manifest
 	manifest.ReadingOrder = nil
+	manifest.Resources = cloneLinks(reader.manifest.Resources)
+	if opts.RepairContext && len(manifest.Context) == 0 {
+		manifest.Context = rwpm.MultiString{rwpm.ContextURL}
+	}
 
-	return &RWPPWriter{
-		zipWriter: zipWriter,
-		manifest:  manifest,
-	}, nil
+	rwppWriter := &RWPPWriter{
+		zipWriter:       zipWriter,
+		manifest:        manifest,
+		manifestName:    writtenManifestName(reader.manifestName),
+		options:         opts,
+		sourceLinks:     sourceLinks,
+		sourcePositions: sourcePositions,
+		ancillary:       ancillary,
+		policy:          policy,
+		sizes:           sizes,
+		hasW3CManifest:  hasW3CManifest,
+		tracker:         tracker,
+	}
+	if spool != nil {
+		rwppWriter.output = output
+		rwppWriter.spool = spool
+	}
+	return rwppWriter, nil
 }
 
 // Resources returns a list of all resources which should be encrypted
 // FIXME: the name of this function isn't great.
-// Note: the current design choice is to leave ancillaty resources (in "resources") non-encrypted
-// FIXME: also encrypt "resources" and "alternates"
+// Note: ancillary resources (in "resources" and "alternates") are left non-encrypted,
+// unless EncryptAncillary is set.
+// The resources of the reading order come first, in the order of the manifest.
 func (reader *RWPPReader) Resources() []Resource {
-	// index files by name to avoid multiple linear searches
-	files := map[string]*zip.File{}
-	for _, file := range reader.zipArchive.File {
-		files[file.Name] = file
-	}
+	return reader.resources(reader.policy())
+}
+
+// resources returns the resources which should be encrypted, under an encryption policy
+func (reader *RWPPReader) resources(policy EncryptionPolicy) []Resource {
 
 	// list files from the reading order; keep their type and encryption status
 	var resources []Resource
 	for _, manifestResource := range reader.manifest.ReadingOrder {
 		isEncrypted := manifestResource.Properties != nil && manifestResource.Properties.Encrypted != nil
-		resources = append(resources, &rwpResource{file: files[manifestResource.Href], isEncrypted: isEncrypted, contentType: manifestResource.Type})
+		resources = append(resources, &rwpResource{file: reader.files[manifestResource.Href], isEncrypted: isEncrypted, contentType: manifestResource.Type, policy: policy})
 	}
 
+	if reader.EncryptAncillary {
+		for _, resource := range reader.ancillaryResources(policy) {
+			resources = append(resources, resource)
+		}
+	}
+
+	return resources
+}
+
+// ancillaryResources lists the alternates of the reading order, then the resources and their alternates.
+// Hrefs shared with the reading order or already listed are skipped, as are remote hrefs
+// and hrefs missing from the package.
+func (reader *RWPPReader) ancillaryResources(policy EncryptionPolicy) []*rwpResource {
+	seen := map[string]bool{}
+	for _, link := range reader.manifest.ReadingOrder {
+		seen[link.Href] = true
+	}
+
+	var resources []*rwpResource
+	var walk func(links []rwpm.Link, self bool)
+	walk = func(links []rwpm.Link, self bool) {
+		for _, link := range links {
+			if self && !seen[link.Href] && !isExternal(link.Href) && reader.files[link.Href] != nil {
+				seen[link.Href] = true
+				isEncrypted := link.Properties != nil && link.Properties.Encrypted != nil
+				resources = append(resources, &rwpResource{file: reader.files[link.Href], isEncrypted: isEncrypted, contentType: link.Type, policy: policy})
+			}
+			walk(link.Alternate, true)
+		}
+	}
+	walk(reader.manifest.ReadingOrder, false)
+	walk(reader.manifest.Resources, true)
 	return resources
 }
 
+// file returns the zip entry of the package with the given name, or nil
+func (reader *RWPPReader) file(name string) *zip.File {
+	return reader.files[name]
+}
+
+// FileCount returns the number of entries in the package
+func (reader *RWPPReader) FileCount() int {
+	return len(reader.zipArchive.File)
+}
+
+// LicenseLocation is the path of the license in a Readium package
+const LicenseLocation = "license.lcpl"
+
+// containerFiles are the package entries which are not publication resources
+var containerFiles = map[string]bool{
+	ManifestLocation:  true,
+	W3CManifestName:   true,
+	LicenseLocation:   true,
+	FileIndexLocation: true,
+	"mimetype":        true,
+}
+
+// OrphanEntries returns the names of the zip entries which are referenced
+// neither by the manifest (reading order, resources and their alternates and children, links)
+// nor known as container files.
+func (reader *RWPPReader) OrphanEntries() []string {
+	referenced := map[string]bool{}
+	for _, href := range reader.manifest.AllHrefs() {
+		referenced[stripFragment(href)] = true
+	}
+	for _, link := range reader.manifest.Links {
+		referenced[stripFragment(link.Href)] = true
+	}
+
+	var orphans []string
+	for _, file := range reader.zipArchive.File {
+		if strings.HasSuffix(file.Name, "/") || containerFiles[file.Name] || file.Name == reader.manifestName || referenced[file.Name] {
+			continue
+		}
+		orphans = append(orphans, file.Name)
+	}
+	return orphans
+}
+
+// policy returns the encryption policy of the reader
+func (reader *RWPPReader) policy() EncryptionPolicy {
+	if reader.Policy == nil {
+		return DefaultEncryptionPolicy
+	}
+	return reader.Policy
+}
+
 type rwpResource struct {
 	isEncrypted bool
 	contentType string
 	file        *zip.File
+	policy      EncryptionPolicy
 }
 
-func (resource *rwpResource) Path() string                   { return resource.file.Name }
-func (resource *rwpResource) ContentType() string            { return resource.contentType }
-func (resource *rwpResource) Size() int64                    { return int64(resource.file.UncompressedSize64) }
-func (resource *rwpResource) Encrypted() bool                { return resource.isEncrypted }
-func (resource *rwpResource) Open() (io.ReadCloser, error)   { return resource.file.Open() }
-func (resource *rwpResource) CompressBeforeEncryption() bool { return false }
-func (resource *rwpResource) CanBeEncrypted() bool           { return true }
+func (resource *rwpResource) Path() string                 { return resource.file.Name }
+func (resource *rwpResource) ContentType() string          { return resource.contentType }
+func (resource *rwpResource) Size() int64                  { return int64(resource.file.UncompressedSize64) }
+func (resource *rwpResource) Encrypted() bool              { return resource.isEncrypted }
+func (resource *rwpResource) Open() (io.ReadCloser, error) { return resource.file.Open() }
+func (resource *rwpResource) ModTime() time.Time           { return resource.file.Modified }
+func (resource *rwpResource) CRC32() uint32                { return resource.file.CRC32 }
+func (resource *rwpResource) CompressBeforeEncryption() bool {
+	return resource.policy.ShouldCompress(resource.contentType)
+}
+func (resource *rwpResource) CanBeEncrypted() bool {
+	return resource.policy.ShouldEncrypt(resource.contentType)
+}
 
+// CopyTo copies the resource verbatim into a package.
+// The zip entry is copied as is if the destination is a Readium package.
 func (resource *rwpResource) CopyTo(packageWriter PackageWriter) error {
+	return resource.CopyToWithProgress(packageWriter, nil)
+}
+
+// CopyToWithProgress copies the resource verbatim into a package, reporting the progress of the copy
+func (resource *rwpResource) CopyToWithProgress(packageWriter PackageWriter, progress ProgressFunc) error {
+	if writer, ok := packageWriter.(*RWPPWriter); ok {
+		return writer.copyResource(resource.file, resource.contentType, progress)
+	}
+
 	wc, err := packageWriter.NewFile(resource.Path(), resource.contentType, resource.file.Method)
 	if err != nil {
 		return err
 	}
+	if expecter, ok := wc.(sizeExpecter); ok {
+		expecter.expectSize(resource.Size())
+	}
 
 	rc, err := resource.file.Open()
 	if err != nil {
@@ -123,7 +346,7 @@ func (resource *rwpResource) CopyTo(packageWriter PackageWriter) error {
 	}
 	defer rc.Close()
 
-	_, err = io.Copy(wc, rc)
+	_, err = io.Copy(wc, newProgressReader(rc, resource.Path(), resource.Size(), progress))
 
 	rCloseError := rc.Close()
 	wCloseError := wc.Close()
@@ -139,6 +362,52 @@ func (resource *rwpResource) CopyTo(packageWriter PackageWriter) error {
 	return wCloseError
 }
 
+// copyZipEntry copies a zip entry from a zip archive to another,
+// preserving its name, storage method and modification time.
+// The compressed bytes are copied as is, without being decompressed and recompressed.
+func copyZipEntry(dst *zip.Writer, src *zip.File) error {
+	return copyZipEntryWithProgress(dst, src, nil)
+}
+
+// copyZipEntryWithProgress copies a zip entry as copyZipEntry does, reporting the progress of the copy
+func copyZipEntryWithProgress(dst *zip.Writer, src *zip.File, progress ProgressFunc) error {
+	return copyZipEntryTracked(dst, src, progress, nil)
+}
+
+// copyZipEntryTracked copies a zip entry as copyZipEntryWithProgress does, its location being recorded by a tracker
+func copyZipEntryTracked(dst *zip.Writer, src *zip.File, progress ProgressFunc, tracker *byteRangeTracker) error {
+	// unlike CreateHeader, CreateRaw does not derive the MS-DOS time from Modified
+	header := &zip.FileHeader{
+		Name:               src.Name,
+		Method:             src.Method,
+		Modified:           src.Modified,
+		ModifiedTime:       src.ModifiedTime,
+		ModifiedDate:       src.ModifiedDate,
+		CRC32:              src.CRC32,
+		CompressedSize64:   src.CompressedSize64,
+		UncompressedSize64: src.UncompressedSize64,
+	}
+	w, err := dst.CreateRaw(header)
+	if err != nil {
+		return err
+	}
+	if err = tracker.created(header); err != nil {
+		return err
+	}
+
+	r, err := src.OpenRaw()
+	if err != nil {
+		return err
+	}
+
+	// a truncated source entry ends early without error
+	n, err := io.Copy(w, newProgressReader(r, src.Name, int64(src.CompressedSize64), progress))
+	if err == nil && uint64(n) != src.CompressedSize64 {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
 // Close closes a NopWriteCloser
 func (nc *NopWriteCloser) Close() error {
 	return nil
@@ -147,94 +416,600 @@ func (nc *NopWriteCloser) Close() error {
 // NewFile creates a header for the input file and adds it (with its media type) to the reading order
 func (writer *RWPPWriter) NewFile(path string, contentType string, storageMethod uint16) (io.WriteCloser, error) {
 
-	w, err := writer.zipWriter.CreateHeader(&zip.FileHeader{
-		Name:   path,
-		Method: storageMethod,
-	})
+	header := &zip.FileHeader{
+		Name:     path,
+		Method:   storageMethod,
+		Modified: writer.options.modified(),
+	}
+	w, err := writer.zipWriter.CreateHeader(header)
+	if err == nil {
+		err = writer.tracker.created(header)
+	}
 
-	writer.manifest.ReadingOrder = append(writer.manifest.ReadingOrder, rwpm.Link{
-		Href: path,
-		Type: contentType,
-	})
+	writer.addToReadingOrder(path, contentType)
+	if writer.policy != nil && writer.policy.ShouldCompress(contentType) {
+		if writer.compressed == nil {
+			writer.compressed = map[string]bool{}
+		}
+		writer.compressed[path] = true
+	}
 
-	return &NopWriteCloser{w}, err
+	return &entryWriter{countingWriteCloser: newCountingWriteCloser(w, path), sizes: writer.sizes}, err
 }
 
-// MarkAsEncrypted marks a resource as encrypted (with an lcp profile and algorithm), in the manifest
-// FIXME: currently only looks into the reading order. Add "resources" and "alternates"
-func (writer *RWPPWriter) MarkAsEncrypted(path string, originalSize int64, profile license.EncryptionProfile, algorithm string) {
+// copyResource copies a zip entry from a source package and adds it (with its media type) to the reading order
+func (writer *RWPPWriter) copyResource(src *zip.File, contentType string, progress ProgressFunc) error {
+	writer.addToReadingOrder(src.Name, contentType)
+	writer.sizes[src.Name] = int64(src.UncompressedSize64)
 
-	for i, resource := range writer.manifest.ReadingOrder {
-		if path == resource.Href {
-			if resource.Properties == nil {
-				writer.manifest.ReadingOrder[i].Properties = new(rwpm.Properties)
-			}
+	return copyZipEntryTracked(writer.zipWriter, src, progress, writer.tracker)
+}
 
-			writer.manifest.ReadingOrder[i].Properties.Encrypted = &rwpm.Encrypted{
-				Scheme:    "http://readium.org/2014/01/lcp",
-				Profile:   profile.String(),
-				Algorithm: algorithm,
-			}
+// addToReadingOrder appends a link to the reading order, unless the resource is an ancillary one.
+// The link of the source package is reused if it exists; an unknown type is replaced by a default one.
+// The order of the source reading order is restored by sortReadingOrder when the manifest is written.
+func (writer *RWPPWriter) addToReadingOrder(path string, contentType string) {
+	if writer.ancillary[path] {
+		return
+	}
+	link, ok := writer.sourceLinks[path]
+	if !ok {
+		link = rwpm.Link{Href: path}
+	}
+	link.Type = writer.options.contentType(path, contentType)
+	link.Alternate = cloneLinks(link.Alternate)
+	writer.manifest.ReadingOrder = append(writer.manifest.ReadingOrder, link)
+}
+
+// MarkAsEncrypted marks a resource as encrypted (with an lcp profile, algorithm and original size), in the manifest.
+// Every link to the resource is marked, in the reading order, resources and alternates.
+// An error is returned if the profile is not a known one.
+func (writer *RWPPWriter) MarkAsEncrypted(path string, originalSize int64, profile license.EncryptionProfile, algorithm string) error {
+	if err := profile.Validate(); err != nil {
+		return err
+	}
 
-			break
+	// the original length lets players restore the plaintext length of compressed resources
+	encrypted := rwpm.Encrypted{
+		Scheme:         lcpScheme,
+		Profile:        profile.String(),
+		Algorithm:      algorithm,
+		OriginalLength: int(originalSize),
+	}
+	if writer.compressed[path] {
+		encrypted.Compression = CompressionDeflate
+	}
+	encrypted.Checksum = writer.checksums[path]
+	contentType := writer.options.contentType(path, "")
+	markLinks(writer.manifest.ReadingOrder, path, contentType, encrypted)
+	markLinks(writer.manifest.Resources, path, contentType, encrypted)
+
+	if writer.options.ByteRangeIndex {
+		if writer.originalSizes == nil {
+			writer.originalSizes = map[string]int64{}
 		}
+		writer.originalSizes[path] = originalSize
+	}
+	return nil
+}
+
+// setChecksum records the plaintext hash of a resource, before it is marked as encrypted
+func (writer *RWPPWriter) setChecksum(path string, checksum string) {
+	if writer.checksums == nil {
+		writer.checksums = map[string]string{}
+	}
+	writer.checksums[path] = checksum
+}
+
+// cloneLinks copies a list of links and their alternates,
+// so that they can be modified without altering the source manifest
+func cloneLinks(links []rwpm.Link) []rwpm.Link {
+	if links == nil {
+		return nil
+	}
+	clone := make([]rwpm.Link, len(links))
+	for i, link := range links {
+		clone[i] = link
+		clone[i].Alternate = cloneLinks(link.Alternate)
+	}
+	return clone
+}
+
+// markLinks sets the encrypted properties of the links to a resource, including alternates,
+// and their type if it is missing
+func markLinks(links []rwpm.Link, path string, contentType string, encrypted rwpm.Encrypted) {
+	for i := range links {
+		if links[i].Href == path {
+			if links[i].Type == "" {
+				links[i].Type = contentType
+			}
+			// properties may be shared with the source manifest
+			var properties rwpm.Properties
+			if links[i].Properties != nil {
+				properties = *links[i].Properties
+			}
+			e := encrypted
+			properties.Encrypted = &e
+			links[i].Properties = &properties
+		}
+		markLinks(links[i].Alternate, path, contentType, encrypted)
 	}
 }
 
 // ManifestLocation is the path if the Readium manifest in a package
 const ManifestLocation = "manifest.json"
 
+// sortReadingOrder restores the order of the source reading order, which gives the sequence
+// of the publication and must not depend on the order in which the resources are written.
+// Resources absent from the source reading order follow, in the order they were written.
+func (writer *RWPPWriter) sortReadingOrder() {
+	position := func(link rwpm.Link) int {
+		if i, ok := writer.sourcePositions[link.Href]; ok {
+			return i
+		}
+		return len(writer.sourcePositions)
+	}
+	readingOrder := writer.manifest.ReadingOrder
+	sort.SliceStable(readingOrder, func(i, j int) bool { return position(readingOrder[i]) < position(readingOrder[j]) })
+}
+
+// writtenManifestName returns the name of the manifest written from a source package:
+// the casing of the source is kept, a manifest found elsewhere is written at ManifestLocation
+func writtenManifestName(sourceName string) string {
+	if strings.EqualFold(sourceName, ManifestLocation) {
+		return sourceName
+	}
+	return ManifestLocation
+}
+
 func (writer *RWPPWriter) writeManifest() error {
-	w, err := writer.zipWriter.Create(ManifestLocation)
+	name := writer.manifestName
+	if name == "" {
+		name = ManifestLocation
+	}
+	w, err := writer.zipWriter.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: writer.options.modified(),
+	})
 	if err != nil {
 		return err
 	}
 
+	manifest := writer.manifest
+	if writer.licenseJSON != nil {
+		manifest.Links = licenseLinks(manifest.Links)
+	}
+	if writer.options.ManifestProfile == ManifestProfileLean {
+		omissions := writer.options.LeanOmissions
+		if len(omissions) == 0 {
+			omissions = DefaultLeanOmissions
+		}
+		for _, field := range omissions {
+			omitMetadata(&manifest.Metadata, field)
+		}
+	}
+
 	encoder := json.NewEncoder(w)
-	return encoder.Encode(writer.manifest)
+	return encoder.Encode(manifest)
+}
+
+// writeW3CManifest writes a W3C manifest derived from the Readium manifest
+func (writer *RWPPWriter) writeW3CManifest() error {
+	w, err := writer.zipWriter.CreateHeader(&zip.FileHeader{
+		Name:     W3CManifestName,
+		Method:   zip.Deflate,
+		Modified: writer.options.modified(),
+	})
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(generateW3CManifest(writer.manifest))
+}
+
+// omitMetadata clears a metadata field, identified by its json name
+func omitMetadata(metadata *rwpm.Metadata, field string) {
+	switch field {
+	case "subtitle":
+		metadata.Subtitle = nil
+	case "sortAs":
+		metadata.SortAs = ""
+	case "description":
+		metadata.Description = ""
+	case "subject":
+		metadata.Subject = nil
+	case "belongsTo":
+		metadata.BelongsTo = nil
+	case "publisher":
+		metadata.Publisher = nil
+	case "artist":
+		metadata.Artist = nil
+	case "author":
+		metadata.Author = nil
+	case "colorist":
+		metadata.Colorist = nil
+	case "contributor":
+		metadata.Contributor = nil
+	case "editor":
+		metadata.Editor = nil
+	case "illustrator":
+		metadata.Illustrator = nil
+	case "imprint":
+		metadata.Imprint = nil
+	case "inker":
+		metadata.Inker = nil
+	case "letterer":
+		metadata.Letterer = nil
+	case "narrator":
+		metadata.Narrator = nil
+	case "penciler":
+		metadata.Penciler = nil
+	case "translator":
+		metadata.Translator = nil
+	}
+}
+
+// SetLicense sets the LCP license of the package, written at LicenseLocation when the writer is closed,
+// and referenced from the links of the manifest with the license relation
+func (writer *RWPPWriter) SetLicense(licenseJSON []byte) {
+	writer.licenseJSON = append([]byte(nil), licenseJSON...)
+}
+
+// writeLicense writes the license of the package, if set
+func (writer *RWPPWriter) writeLicense() error {
+	if writer.licenseJSON == nil {
+		return nil
+	}
+	w, err := writer.zipWriter.CreateHeader(&zip.FileHeader{
+		Name:     LicenseLocation,
+		Method:   zip.Deflate,
+		Modified: writer.options.modified(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(writer.licenseJSON)
+	return err
+}
+
+// licenseLinks returns the links of a manifest with the license link replaced by a link to the license of the package
+func licenseLinks(links []rwpm.Link) []rwpm.Link {
+	updated := make([]rwpm.Link, 0, len(links)+1)
+	for _, link := range links {
+		if !hasRel(link.Rel, "license") {
+			updated = append(updated, link)
+		}
+	}
+	return append(updated, rwpm.Link{Href: LicenseLocation, Type: ContentTypeLCPLicense, Rel: rwpm.MultiString{"license"}})
+}
+
+// hasRel checks if a link has a relation
+func hasRel(rels rwpm.MultiString, rel string) bool {
+	for _, r := range rels {
+		if r == rel {
+			return true
+		}
+	}
+	return false
+}
+
+// dedupeLinks keeps a single link per href, at the position of the first one,
+// with the properties of the last one, e.g. when a file has been added twice to the package
+func dedupeLinks(links []rwpm.Link) []rwpm.Link {
+	positions := map[string]int{}
+	deduped := links[:0:0]
+	for _, link := range links {
+		if i, ok := positions[link.Href]; ok {
+			deduped[i] = link
+			continue
+		}
+		positions[link.Href] = len(deduped)
+		deduped = append(deduped, link)
+	}
+	return deduped
 }
 
 // Close closes a Readium Package Writer
 func (writer *RWPPWriter) Close() error {
-	err := writer.writeManifest()
+	if writer.spool != nil {
+		defer os.Remove(writer.spool.Name())
+		defer writer.spool.Close()
+	}
+	writer.sortReadingOrder()
+	writer.manifest.ReadingOrder = dedupeLinks(writer.manifest.ReadingOrder)
+	err := writer.writeLicense()
 	if err != nil {
 		return err
 	}
+	if err = writer.writeManifest(); err != nil {
+		return err
+	}
+	if writer.options.W3CManifest && !writer.hasW3CManifest {
+		if err = writer.writeW3CManifest(); err != nil {
+			return err
+		}
+	}
+	if writer.options.FileIndex {
+		if err = writer.writeFileIndex(); err != nil {
+			return err
+		}
+	}
+
+	if err = writer.zipWriter.Close(); err != nil {
+		return err
+	}
+	if writer.spool != nil {
+		return writer.writeManifestFirst()
+	}
+	if writer.tracker != nil {
+		writer.byteRanges = writer.tracker.index(writer.originalSizes)
+	}
+	return nil
+}
+
+// writeManifestFirst rewrites the package spooled to a temporary file into the output, with the manifest first
+func (writer *RWPPWriter) writeManifestFirst() error {
+	info, err := writer.spool.Stat()
+	if err != nil {
+		return err
+	}
+	tracker, err := moveManifestFirst(writer.spool, info.Size(), writer.output)
+	if err != nil {
+		return err
+	}
+	if writer.options.ByteRangeIndex {
+		writer.byteRanges = tracker.index(writer.originalSizes)
+	}
+	return nil
+}
+
+// MoveManifestFirst rewrites a Readium package with the manifest as its first entry,
+// for readers which expect to access the metadata early in the archive.
+// Entries are copied without being decompressed or recompressed.
+func MoveManifestFirst(r io.ReaderAt, size int64, w io.Writer) error {
+	_, err := moveManifestFirst(r, size, w)
+	return err
+}
+
+// moveManifestFirst rewrites a package as MoveManifestFirst does, and returns the location of its entries
+func moveManifestFirst(r io.ReaderAt, size int64, w io.Writer) (*byteRangeTracker, error) {
+	zipReader, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := findManifest(zipReader.File, nil)
+	if manifest == nil {
+		return nil, errors.New("Could not find manifest")
+	}
+	var others []*zip.File
+	for _, file := range zipReader.File {
+		if file != manifest {
+			others = append(others, file)
+		}
+	}
+
+	zipWriter, tracker := newTrackedZipWriter(w)
+	for _, file := range append([]*zip.File{manifest}, others...) {
+		if err = copyZipEntryTracked(zipWriter, file, nil, tracker); err != nil {
+			return nil, err
+		}
+	}
+	return tracker, zipWriter.Close()
+}
+
+// MaxEntryCount is the maximum number of entries accepted in a Readium package;
+// it guards against pathological archives which would exhaust the memory.
+var MaxEntryCount = 100000
+
+// ErrTooManyEntries is returned when a package holds more than MaxEntryCount entries
+var ErrTooManyEntries = errors.New("The package holds too many entries")
+
+// ErrUnsafeEntries is returned when entries of a package would escape the root of the archive once extracted
+var ErrUnsafeEntries = errors.New("The package holds entries outside of the archive root")
+
+// unsafeEntries returns the names of the zip entries whose path is absolute
+// or escapes the root of the archive once cleaned, e.g. "../../etc/passwd" or "/etc/passwd"
+func unsafeEntries(files []*zip.File) []string {
+	var unsafe []string
+	for _, file := range files {
+		name := strings.Replace(file.Name, "\\", "/", -1)
+		cleaned := path.Clean(name)
+		if path.IsAbs(name) || cleaned == ".." || strings.HasPrefix(cleaned, "../") || hasVolumeName(name) {
+			unsafe = append(unsafe, file.Name)
+		}
+	}
+	return unsafe
+}
 
-	return writer.zipWriter.Close()
+// hasVolumeName checks if a path starts with a Windows drive letter, e.g. "C:"
+func hasVolumeName(name string) bool {
+	return len(name) >= 2 && name[1] == ':' && ('a' <= name[0] && name[0] <= 'z' || 'A' <= name[0] && name[0] <= 'Z')
 }
 
 // NewRWPPReader creates a new Readium Package reader
 func NewRWPPReader(zipReader *zip.Reader) (*RWPPReader, error) {
+	return NewRWPPReaderWithValidation(zipReader, false)
+}
+
+// NewRWPPReaderWithValidation creates a new Readium Package reader.
+// If strict is set, the manifest must have a Readium context and a non-empty reading order,
+// and declare only known Readium profiles; reading order media types unsupported by LCP are logged as warnings.
+func NewRWPPReaderWithValidation(zipReader *zip.Reader, strict bool) (*RWPPReader, error) {
+	return NewRWPPReaderWithManifestPaths(zipReader, strict, nil)
+}
+
+// NewRWPPReaderWithManifestPaths creates a new Readium Package reader whose manifest is looked for
+// at a list of candidate paths, ManifestLocation if the list is empty.
+// If none is found, the first JSON entry which parses as a Readium manifest is used.
+// The hrefs of the manifest are zip entry names, wherever the manifest is found.
+// A package written from the reader has its manifest at ManifestLocation.
+func NewRWPPReaderWithManifestPaths(zipReader *zip.Reader, strict bool, manifestPaths []string) (*RWPPReader, error) {
+
+	if len(zipReader.File) > MaxEntryCount {
+		return nil, ErrTooManyEntries
+	}
+	if unsafe := unsafeEntries(zipReader.File); len(unsafe) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrUnsafeEntries, strings.Join(unsafe, ", "))
+	}
 
 	// find and parse the manifest
+	file := findManifest(zipReader.File, manifestPaths)
+	if file == nil {
+		if isNestedPackage(zipReader) {
+			return nil, ErrNestedPackage
+		}
+		return nil, errors.New("Could not find manifest")
+	}
+
+	fileReader, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
 	var manifest rwpm.Publication
-	var found bool
-	for _, file := range zipReader.File {
-		if file.Name == ManifestLocation {
-			found = true
+	decoder := json.NewDecoder(skipBOM(fileReader))
+	err = decoder.Decode(&manifest)
+	fileReader.Close()
+	if err != nil {
+		return nil, err
+	}
+	if strict {
+		if err = validateManifest(manifest); err != nil {
+			return nil, err
+		}
+		for _, warning := range manifest.ValidateLCPMediaTypes() {
+			log.Println("Warning: " + warning)
+		}
+	}
 
-			fileReader, err := file.Open()
-			if err != nil {
-				return nil, err
-			}
-			decoder := json.NewDecoder(fileReader)
+	// index files by name to avoid multiple linear searches
+	files := make(map[string]*zip.File, len(zipReader.File))
+	for _, f := range zipReader.File {
+		files[f.Name] = f
+	}
+
+	return &RWPPReader{zipArchive: zipReader, files: files, manifest: manifest, manifestName: file.Name}, nil
+}
+
+// validateManifest checks the context, reading order and profiles of a Readium manifest
+func validateManifest(manifest rwpm.Publication) error {
+	readiumContext := false
+	for _, context := range manifest.Context {
+		readiumContext = readiumContext || context == rwpm.ContextURL
+	}
+	if !readiumContext {
+		return fmt.Errorf("invalid manifest: the context %v is not a Readium context", []string(manifest.Context))
+	}
+	if len(manifest.ReadingOrder) == 0 {
+		return errors.New("invalid manifest: the reading order is empty")
+	}
+	for _, profile := range manifest.Metadata.ConformsTo {
+		switch profile {
+		case rwpm.ProfileAudiobook, rwpm.ProfileDivina, rwpm.ProfileEPUB, rwpm.ProfilePDF:
+		default:
+			return fmt.Errorf("invalid manifest: unknown profile %s", profile)
+		}
+	}
+	return nil
+}
 
-			err = decoder.Decode(&manifest)
-			fileReader.Close()
-			if err != nil {
-				return nil, err
+// findManifest returns the manifest entry of a package, looking for the candidate paths in order,
+// ManifestLocation if none is given, and matching their name case-insensitively.
+// An exact match is preferred if several entries match.
+// If no candidate is found, the first JSON entry which parses as a Readium manifest is returned;
+// the W3C manifest is skipped, as its reading order would parse as well.
+func findManifest(files []*zip.File, candidates []string) *zip.File {
+	if len(candidates) == 0 {
+		candidates = []string{ManifestLocation}
+	}
+	for _, candidate := range candidates {
+		var found *zip.File
+		for _, file := range files {
+			if file.Name == candidate {
+				return file
 			}
-			break
+			if found == nil && strings.EqualFold(file.Name, candidate) {
+				found = file
+			}
+		}
+		if found != nil {
+			return found
 		}
 	}
+	for _, file := range files {
+		if strings.EqualFold(path.Ext(file.Name), ".json") && file.Name != W3CManifestName && isManifest(file) {
+			return file
+		}
+	}
+	return nil
+}
 
-	if !found {
-		return nil, errors.New("Could not find manifest")
+// isManifest checks if a zip entry parses as a Readium manifest with a reading order
+func isManifest(file *zip.File) bool {
+	r, err := file.Open()
+	if err != nil {
+		return false
+	}
+	defer r.Close()
+	var manifest rwpm.Publication
+	if err = json.NewDecoder(skipBOM(r)).Decode(&manifest); err != nil {
+		return false
 	}
+	return len(manifest.ReadingOrder) > 0
+}
+
+// ErrNestedPackage is returned when a package has been zipped inside another zip archive
+var ErrNestedPackage = errors.New("Could not find manifest: the package seems to be nested inside another zip archive")
 
-	return &RWPPReader{zipArchive: zipReader, manifest: manifest}, nil
+// MaxNestedPackageSize is the maximum size of the single entry of an archive
+// inspected to detect a nested package; a larger entry is not inspected.
+var MaxNestedPackageSize int64 = 1 << 30
 
+// isNestedPackage checks if a zip archive contains a single entry which is itself a Readium package.
+// The entry is spooled to a temporary file, as a zip archive is read from its end.
+func isNestedPackage(zipReader *zip.Reader) bool {
+	if len(zipReader.File) != 1 {
+		return false
+	}
+	file := zipReader.File[0]
+	if file.UncompressedSize64 > uint64(MaxNestedPackageSize) {
+		return false
+	}
+	rc, err := file.Open()
+	if err != nil {
+		return false
+	}
+	defer rc.Close()
+
+	spool, err := ioutil.TempFile("", "nested-*.zip")
+	if err != nil {
+		return false
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	// the declared size of the entry may be wrong
+	n, err := io.Copy(spool, io.LimitReader(rc, MaxNestedPackageSize+1))
+	if err != nil || n > MaxNestedPackageSize {
+		return false
+	}
+	innerReader, err := zip.NewReader(spool, n)
+	if err != nil {
+		return false
+	}
+	return findManifest(innerReader.File, nil) != nil
+}
+
+// utf8BOM is the byte order mark some tools write at the start of utf-8 files
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// skipBOM returns a reader which skips a leading utf-8 byte order mark
+func skipBOM(r io.Reader) io.Reader {
+	buffered := bufio.NewReader(r)
+	if start, err := buffered.Peek(len(utf8BOM)); err == nil && bytes.Equal(start, utf8BOM) {
+		buffered.Discard(len(utf8BOM))
+	}
+	return buffered
 }
 
 // OpenRWPP opens a Readium Package and returns a zip reader + a manifest
@@ -248,69 +1023,118 @@ func OpenRWPP(name string) (*RWPPReader, error) {
 	return NewRWPPReader(&zipArchive.Reader)
 }
 
-// BuildRWPPFromPDF builds a Readium Package (rwpp) which embeds a PDF file
+// BuildRWPPFromPDF builds a Readium Package (rwpp) which embeds a PDF file.
+// If title is empty, the title, author and creation date are taken from the PDF information dictionary,
+// and the title falls back to the base name of the PDF file.
 func BuildRWPPFromPDF(title string, inputPath string, outputPath string) error {
+	return BuildRWPPFromPDFWithOptions(title, inputPath, outputPath, PackOptions{})
+}
 
-	// crate the rwpp
-	f, err := os.Create(outputPath)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
+// BuildRWPPFromPDFWithOptions builds a Readium Package (rwpp) which embeds a PDF file,
+// using the compression and identifier set in the options.
+// PDF files are usually compressed already, CompressionStore avoids compressing them again.
+func BuildRWPPFromPDFWithOptions(title string, inputPath string, outputPath string, opts PackOptions) (err error) {
 
-	// copy the content of the pdf input file into the zip output, as 'publication.pdf'
-	zipWriter := zip.NewWriter(f)
-	writer, err := zipWriter.Create("publication.pdf")
-	if err != nil {
+	if err = opts.Validate(); err != nil {
 		return err
 	}
+
 	inputFile, err := os.Open(inputPath)
 	if err != nil {
-		zipWriter.Close()
 		return err
 	}
 	defer inputFile.Close()
 
-	_, err = io.Copy(writer, inputFile)
+	info := pdfInfo{Title: title}
+	if title == "" {
+		// the information dictionary is found from the end of the file,
+		// which is then streamed into the package from its start
+		stat, err := inputFile.Stat()
+		if err != nil {
+			return err
+		}
+		info, _ = readPDFInfoAt(inputFile, stat.Size())
+		if info.Title == "" {
+			info.Title = filepath.Base(inputPath)
+		}
+	}
+
+	// create the rwpp
+	f, err := os.Create(outputPath)
 	if err != nil {
-		zipWriter.Close()
 		return err
 	}
+	defer func() {
+		// a close error must not be masked by a prior nil
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
 
-	// inject a Readium manifest into the zip output
-	manifest := `
-	{
-		"@context": [
-			"https://readium.org/webpub-manifest/context.jsonld"
-		],
-		"metadata": {
-			"title": "{{.Title}}"
-		},
-		"readingOrder": [
-			{
-				"href": "publication.pdf",
-				"type": "application/pdf"
-			}
-		]
+	return buildRWPPFromPDF(info, inputFile, f, opts)
+}
+
+// pdfManifest is the Readium manifest of a package embedding a PDF file
+type pdfManifest struct {
+	Context  rwpm.MultiString `json:"@context"`
+	Metadata struct {
+		Identifier string            `json:"identifier,omitempty"`
+		Title      string            `json:"title"`
+		Author     rwpm.Contributors `json:"author,omitempty"`
+		Modified   *time.Time        `json:"modified,omitempty"`
+	} `json:"metadata"`
+	ReadingOrder []rwpm.Link `json:"readingOrder"`
+}
+
+// buildRWPPFromPDF writes into output a Readium Package which embeds the PDF read from input
+func buildRWPPFromPDF(info pdfInfo, input io.Reader, output io.Writer, opts PackOptions) error {
+
+	// copy the content of the pdf input file into the zip output, as 'publication.pdf'
+	zipWriter := zip.NewWriter(output)
+	opts.registerCompressor(zipWriter)
+	writer, err := zipWriter.CreateHeader(&zip.FileHeader{
+		Name:   "publication.pdf",
+		Method: opts.storageMethod(),
+	})
+	if err != nil {
+		return closeZipWriter(zipWriter, err)
 	}
-	`
 
-	manifestWriter, err := zipWriter.Create(ManifestLocation)
+	_, err = io.Copy(writer, input)
 	if err != nil {
-		return err
+		return closeZipWriter(zipWriter, err)
 	}
 
-	tmpl, err := template.New("manifest").Parse(manifest)
+	// inject a Readium manifest into the zip output
+	var manifest pdfManifest
+	manifest.Context = rwpm.MultiString{rwpm.ContextURL}
+	manifest.Metadata.Identifier = opts.Identifier
+	manifest.Metadata.Title = info.Title
+	if info.Author != "" {
+		manifest.Metadata.Author.AddName(info.Author)
+	}
+	if !info.CreationDate.IsZero() {
+		manifest.Metadata.Modified = &info.CreationDate
+	}
+	manifest.ReadingOrder = []rwpm.Link{{Href: "publication.pdf", Type: "application/pdf"}}
+
+	manifestWriter, err := zipWriter.Create(ManifestLocation)
 	if err != nil {
-		zipWriter.Close()
-		return err
+		return closeZipWriter(zipWriter, err)
 	}
 
-	err = tmpl.Execute(manifestWriter, struct{ Title string }{title})
+	encoder := json.NewEncoder(manifestWriter)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(manifest)
+	return closeZipWriter(zipWriter, err)
+}
+
+// closeZipWriter closes a zip writer and returns the first meaningful error:
+// the error passed as a parameter if any, else the error returned by Close.
+func closeZipWriter(zipWriter *zip.Writer, err error) error {
+	closeErr := zipWriter.Close()
 	if err != nil {
-		zipWriter.Close()
 		return err
 	}
-
-	return zipWriter.Close()
+	return closeErr
 }
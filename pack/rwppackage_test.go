@@ -7,10 +7,23 @@ package pack
 import (
 	"archive/zip"
 	"bytes"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
 	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/readium/readium-lcp-server/crypto"
 	"github.com/readium/readium-lcp-server/license"
 	"github.com/readium/readium-lcp-server/rwpm"
 )
@@ -119,3 +132,1265 @@ func TestRWPM(t *testing.T) {
 	manifest.Metadata.Subject.Add(rwpm.Subject{Name: "software", Scheme: "iptc", Code: "04003000"})
 
 }
+
+// testEntry is a zip entry used to build test packages
+type testEntry struct {
+	name   string
+	method uint16
+	body   []byte
+}
+
+// buildTestZip builds an in-memory zip archive from a list of entries
+func buildTestZip(t testing.TB, entries ...testEntry) []byte {
+	var b bytes.Buffer
+	zipWriter := zip.NewWriter(&b)
+	for _, entry := range entries {
+		w, err := zipWriter.CreateHeader(&zip.FileHeader{Name: entry.name, Method: entry.method})
+		if err != nil {
+			t.Fatalf("Could not create zip entry %s, %s", entry.name, err)
+		}
+		if _, err = w.Write(entry.body); err != nil {
+			t.Fatalf("Could not write zip entry %s, %s", entry.name, err)
+		}
+	}
+	if err := zipWriter.Close(); err != nil {
+		t.Fatalf("Could not close zip archive, %s", err)
+	}
+	return b.Bytes()
+}
+
+// openTestZip opens an in-memory zip archive
+func openTestZip(t testing.TB, data []byte) *zip.Reader {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Could not open zip archive, %s", err)
+	}
+	return zr
+}
+
+// openTestRWPP builds an in-memory Readium package and returns a reader on it
+func openTestRWPP(t *testing.T, entries ...testEntry) *RWPPReader {
+	reader, err := NewRWPPReader(openTestZip(t, buildTestZip(t, entries...)))
+	if err != nil {
+		t.Fatalf("Could not read archive, %s", err)
+	}
+	return reader
+}
+
+func TestManifestWithBOM(t *testing.T) {
+	manifest := append([]byte{0xEF, 0xBB, 0xBF}, []byte(`{"metadata": {"title": "bom"}, "readingOrder": [{"href": "publication.pdf", "type": "application/pdf"}]}`)...)
+
+	reader := openTestRWPP(t,
+		testEntry{name: ManifestLocation, method: Deflate, body: manifest},
+		testEntry{name: "publication.pdf", method: Deflate, body: []byte("pdf")},
+	)
+
+	if title := reader.manifest.Metadata.Title.Text(); title != "bom" {
+		t.Errorf("Expected the title to be bom, got %s", title)
+	}
+	if l := len(reader.Resources()); l != 1 {
+		t.Errorf("Expected to get %d resources, got %d", 1, l)
+	}
+}
+
+func TestDropW3CManifest(t *testing.T) {
+	entries := []testEntry{
+		{name: ManifestLocation, method: Deflate, body: []byte(`{"metadata": {"title": "w3c"}, "readingOrder": [{"href": "track.mp3", "type": "audio/mpeg"}]}`)},
+		{name: W3CManifestName, method: Deflate, body: []byte(`{"name": "w3c"}`)},
+		{name: "track.mp3", method: NoCompression, body: []byte("mp3")},
+	}
+
+	for _, drop := range []bool{false, true} {
+		reader := openTestRWPP(t, entries...)
+
+		var b bytes.Buffer
+		writer, err := reader.NewWriterWithOptions(&b, PackOptions{DropW3CManifest: drop})
+		if err != nil {
+			t.Fatalf("Could not build a writer, %s", err)
+		}
+		if err = writer.Close(); err != nil {
+			t.Fatalf("Could not close packageWriter, %s", err)
+		}
+
+		found := false
+		for _, file := range openTestZip(t, b.Bytes()).File {
+			if file.Name == W3CManifestName {
+				found = true
+			}
+		}
+		if found == drop {
+			t.Errorf("Expected the W3C manifest presence to be %t with DropW3CManifest set to %t", !drop, drop)
+		}
+	}
+}
+
+func TestCopyZipEntry(t *testing.T) {
+	modified := time.Date(2020, 03, 05, 10, 00, 00, 0, time.UTC)
+
+	var src bytes.Buffer
+	zipWriter := zip.NewWriter(&src)
+	for _, header := range []zip.FileHeader{
+		{Name: "stored.mp3", Method: zip.Store, Modified: modified},
+		{Name: "deflated.html", Method: zip.Deflate, Modified: modified},
+	} {
+		h := header
+		w, err := zipWriter.CreateHeader(&h)
+		if err != nil {
+			t.Fatalf("Could not create zip entry, %s", err)
+		}
+		w.Write([]byte("content of " + h.Name))
+	}
+	zipWriter.Close()
+
+	var dst bytes.Buffer
+	dstWriter := zip.NewWriter(&dst)
+	for _, file := range openTestZip(t, src.Bytes()).File {
+		if err := copyZipEntry(dstWriter, file); err != nil {
+			t.Fatalf("Could not copy %s, %s", file.Name, err)
+		}
+	}
+	dstWriter.Close()
+
+	copied := openTestZip(t, dst.Bytes()).File
+	if l := len(copied); l != 2 {
+		t.Fatalf("Expected %d copied entries, got %d", 2, l)
+	}
+	for i, method := range []uint16{zip.Store, zip.Deflate} {
+		file := copied[i]
+		if file.Method != method {
+			t.Errorf("Expected %s to keep method %d, got %d", file.Name, method, file.Method)
+		}
+		if !file.Modified.Equal(modified) {
+			t.Errorf("Expected %s to keep its modification time, got %s", file.Name, file.Modified)
+		}
+		rc, err := file.Open()
+		if err != nil {
+			t.Fatalf("Could not open %s, %s", file.Name, err)
+		}
+		data, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		if string(data) != "content of "+file.Name {
+			t.Errorf("Unexpected content for %s: %s", file.Name, data)
+		}
+	}
+}
+
+var errInjected = errors.New("injected failure")
+
+// failingWriter accepts limit bytes then fails
+type failingWriter struct {
+	limit int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		n := w.limit
+		w.limit = 0
+		return n, errInjected
+	}
+	w.limit -= len(p)
+	return len(p), nil
+}
+
+// failingReader returns an error on read
+type failingReader struct{}
+
+func (r failingReader) Read(p []byte) (int, error) {
+	return 0, errInjected
+}
+
+func TestBuildRWPPFromPDFErrors(t *testing.T) {
+	largePDF := bytes.Repeat([]byte("%PDF"), 10000)
+	smallPDF := []byte("%PDF-1.4")
+
+	// the input cannot be read
+	if err := buildRWPPFromPDF(pdfInfo{Title: "title"}, failingReader{}, ioutil.Discard, PackOptions{}); err != errInjected {
+		t.Errorf("Expected the read error to propagate, got %v", err)
+	}
+	// the output fails while the pdf is copied
+	if err := buildRWPPFromPDF(pdfInfo{Title: "title"}, bytes.NewReader(largePDF), &failingWriter{limit: 0}, PackOptions{}); err != errInjected {
+		t.Errorf("Expected the copy error to propagate, got %v", err)
+	}
+	// the output fails when the zip central directory is flushed
+	if err := buildRWPPFromPDF(pdfInfo{Title: "title"}, bytes.NewReader(smallPDF), &failingWriter{limit: 100}, PackOptions{}); err != errInjected {
+		t.Errorf("Expected the close error to propagate, got %v", err)
+	}
+	// no failure
+	var b bytes.Buffer
+	if err := buildRWPPFromPDF(pdfInfo{Title: "title"}, bytes.NewReader(smallPDF), &b, PackOptions{}); err != nil {
+		t.Fatalf("Did not expect an error, got %s", err)
+	}
+	if _, err := NewRWPPReader(openTestZip(t, b.Bytes())); err != nil {
+		t.Errorf("Expected a valid package, got %s", err)
+	}
+}
+
+func TestNewWriterCopyError(t *testing.T) {
+	reader := openTestRWPP(t,
+		testEntry{name: ManifestLocation, method: Deflate, body: []byte(`{"metadata": {"title": "w3c"}, "readingOrder": [{"href": "track.mp3", "type": "audio/mpeg"}]}`)},
+		testEntry{name: W3CManifestName, method: NoCompression, body: bytes.Repeat([]byte(" "), 10000)},
+		testEntry{name: "track.mp3", method: NoCompression, body: []byte("mp3")},
+	)
+
+	if _, err := reader.NewWriter(&failingWriter{limit: 0}); err != errInjected {
+		t.Errorf("Expected the W3C manifest copy error to propagate, got %v", err)
+	}
+}
+
+func TestNestedPackage(t *testing.T) {
+	inner := buildTestZip(t,
+		testEntry{name: ManifestLocation, method: Deflate, body: []byte(`{"metadata": {"title": "nested"}, "readingOrder": [{"href": "publication.pdf", "type": "application/pdf"}]}`)},
+		testEntry{name: "publication.pdf", method: Deflate, body: []byte("pdf")},
+	)
+	outer := buildTestZip(t, testEntry{name: "book.lcpdf", method: Deflate, body: inner})
+
+	if _, err := NewRWPPReader(openTestZip(t, outer)); err != ErrNestedPackage {
+		t.Errorf("Expected a nested package error, got %v", err)
+	}
+
+	// a nested package larger than MaxNestedPackageSize is not inspected
+	defer func(size int64) { MaxNestedPackageSize = size }(MaxNestedPackageSize)
+	MaxNestedPackageSize = int64(len(inner)) - 1
+	if _, err := NewRWPPReader(openTestZip(t, outer)); err == nil || err == ErrNestedPackage {
+		t.Errorf("Expected a missing manifest error above the size limit, got %v", err)
+	}
+	MaxNestedPackageSize = int64(len(inner))
+
+	// a single entry which isn't a package isn't reported as nested
+	single := buildTestZip(t, testEntry{name: "publication.pdf", method: Deflate, body: []byte("pdf")})
+	if _, err := NewRWPPReader(openTestZip(t, single)); err == nil || err == ErrNestedPackage {
+		t.Errorf("Expected a missing manifest error, got %v", err)
+	}
+}
+
+// readOutputManifest reads the Readium manifest of a package written in a buffer
+func readOutputManifest(t *testing.T, data []byte) rwpm.Publication {
+	reader, err := NewRWPPReader(openTestZip(t, data))
+	if err != nil {
+		t.Fatalf("Could not read archive, %s", err)
+	}
+	return reader.manifest
+}
+
+func TestLeanManifest(t *testing.T) {
+	for _, profile := range []string{ManifestProfileFull, ManifestProfileLean} {
+		reader, err := OpenRWPP("./samples/basic.lcpdf")
+		if err != nil {
+			t.Fatalf("Expected to be able to open basic.lcpdf, got %s", err)
+		}
+
+		var b bytes.Buffer
+		writer, err := reader.NewWriterWithOptions(&b, PackOptions{ManifestProfile: profile})
+		if err != nil {
+			t.Fatalf("Could not build a writer, %s", err)
+		}
+		if _, err = Process(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer); err != nil {
+			t.Fatalf("Could not process the package, %s", err)
+		}
+
+		manifest := readOutputManifest(t, b.Bytes())
+		lean := profile == ManifestProfileLean
+		if (manifest.Metadata.Description == "") != lean {
+			t.Errorf("Unexpected description with the %s profile: %q", profile, manifest.Metadata.Description)
+		}
+		if (len(manifest.Metadata.Author) == 0) != lean {
+			t.Errorf("Unexpected author with the %s profile: %v", profile, manifest.Metadata.Author)
+		}
+		if manifest.Metadata.Identifier == "" || manifest.Metadata.Title.Text() == "" {
+			t.Errorf("Expected the identifier and title to be kept with the %s profile", profile)
+		}
+		if len(manifest.ReadingOrder) != 1 {
+			t.Fatalf("Expected the reading order to be kept with the %s profile", profile)
+		}
+		if properties := manifest.ReadingOrder[0].Properties; properties == nil || properties.Encrypted == nil {
+			t.Errorf("Expected the encryption properties to be kept with the %s profile", profile)
+		}
+	}
+}
+
+func TestOrphanEntries(t *testing.T) {
+	manifest := `{
+		"metadata": {"title": "orphans"},
+		"links": [{"rel": "self", "href": "https://example.com/manifest.json"}],
+		"readingOrder": [
+			{"href": "chapter1.html", "type": "text/html", "alternate": [{"href": "chapter1.pdf", "type": "application/pdf"}]}
+		],
+		"resources": [{"href": "style.css", "type": "text/css"}],
+		"toc": [{"href": "chapter1.html#part1"}]
+	}`
+	reader := openTestRWPP(t,
+		testEntry{name: ManifestLocation, method: Deflate, body: []byte(manifest)},
+		testEntry{name: LicenseLocation, method: Deflate, body: []byte("{}")},
+		testEntry{name: "chapter1.html", method: Deflate, body: []byte("<html/>")},
+		testEntry{name: "chapter1.pdf", method: Deflate, body: []byte("%PDF")},
+		testEntry{name: "style.css", method: Deflate, body: []byte("body {}")},
+		testEntry{name: "images/", method: NoCompression},
+		testEntry{name: "images/unused.png", method: NoCompression, body: []byte("png")},
+	)
+
+	orphans := reader.OrphanEntries()
+	if len(orphans) != 1 || orphans[0] != "images/unused.png" {
+		t.Errorf("Expected images/unused.png to be the only orphan, got %v", orphans)
+	}
+}
+
+func TestManifestFirst(t *testing.T) {
+	for _, manifestFirst := range []bool{false, true} {
+		reader, err := OpenRWPP("./samples/basic.lcpdf")
+		if err != nil {
+			t.Fatalf("Expected to be able to open basic.lcpdf, got %s", err)
+		}
+
+		var b bytes.Buffer
+		writer, err := reader.NewWriterWithOptions(&b, PackOptions{ManifestFirst: manifestFirst})
+		if err != nil {
+			t.Fatalf("Could not build a writer, %s", err)
+		}
+		if _, err = Process(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer); err != nil {
+			t.Fatalf("Could not process the package, %s", err)
+		}
+
+		files := openTestZip(t, b.Bytes()).File
+		position := len(files) - 1
+		if manifestFirst {
+			position = 0
+		}
+		if files[position].Name != ManifestLocation {
+			t.Errorf("Expected the manifest at position %d with ManifestFirst %v, got %s", position, manifestFirst, files[position].Name)
+		}
+
+		// the resources must still be readable
+		manifest := readOutputManifest(t, b.Bytes())
+		if len(manifest.ReadingOrder) != 1 || manifest.ReadingOrder[0].Properties == nil || manifest.ReadingOrder[0].Properties.Encrypted == nil {
+			t.Errorf("Expected an encrypted reading order, got %#v", manifest.ReadingOrder)
+		}
+		for _, file := range files {
+			rc, err := file.Open()
+			if err != nil {
+				t.Fatalf("Could not open %s, %s", file.Name, err)
+			}
+			if _, err = ioutil.ReadAll(rc); err != nil {
+				t.Errorf("Could not read %s, %s", file.Name, err)
+			}
+			rc.Close()
+		}
+	}
+}
+
+func TestManifestNameCasing(t *testing.T) {
+	manifest := []byte(`{"metadata": {"title": "casing"}, "readingOrder": [{"href": "chapter.html", "type": "text/html"}]}`)
+	for _, name := range []string{"Manifest.json", "MANIFEST.JSON"} {
+		reader := openTestRWPP(t,
+			testEntry{name: name, method: Deflate, body: manifest},
+			testEntry{name: "chapter.html", method: Deflate, body: []byte("<html/>")},
+		)
+		if reader.manifest.Metadata.Title.Text() != "casing" {
+			t.Errorf("Expected the manifest to be read from %s", name)
+		}
+		if orphans := reader.OrphanEntries(); len(orphans) != 0 {
+			t.Errorf("Did not expect orphans, got %v", orphans)
+		}
+
+		var b bytes.Buffer
+		writer, err := reader.NewWriter(&b)
+		if err != nil {
+			t.Fatalf("Could not build a writer, %s", err)
+		}
+		if _, err = Process(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer); err != nil {
+			t.Fatalf("Could not process the package, %s", err)
+		}
+		var found bool
+		for _, file := range openTestZip(t, b.Bytes()).File {
+			found = found || file.Name == name
+		}
+		if !found {
+			t.Errorf("Expected the writer to reuse the %s casing", name)
+		}
+	}
+}
+
+func TestManifestNameExactCasePreferred(t *testing.T) {
+	reader := openTestRWPP(t,
+		testEntry{name: "Manifest.json", method: Deflate, body: []byte(`{"metadata": {"title": "other"}}`)},
+		testEntry{name: ManifestLocation, method: Deflate, body: []byte(`{"metadata": {"title": "exact"}}`)},
+	)
+	if title := reader.manifest.Metadata.Title.Text(); title != "exact" {
+		t.Errorf("Expected the exact-case manifest to be preferred, got %s", title)
+	}
+	if reader.manifestName != ManifestLocation {
+		t.Errorf("Expected %s as the manifest name, got %s", ManifestLocation, reader.manifestName)
+	}
+}
+
+func TestRawCopy(t *testing.T) {
+	manifest := `{
+		"metadata": {"title": "raw"},
+		"readingOrder": [{"href": "chapter.html", "type": "text/html"}],
+		"resources": [{"href": "style.css", "type": "text/css"}]
+	}`
+	style := bytes.Repeat([]byte("body { margin: 0; padding: 0; }\n"), 200)
+
+	// deflate the source with a non default level, which the writer would not reproduce
+	var src bytes.Buffer
+	zipWriter := zip.NewWriter(&src)
+	zipWriter.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, flate.BestSpeed)
+	})
+	for _, entry := range []testEntry{
+		{name: ManifestLocation, method: Deflate, body: []byte(manifest)},
+		{name: "chapter.html", method: Deflate, body: []byte("<html/>")},
+		{name: "style.css", method: Deflate, body: style},
+	} {
+		w, err := zipWriter.CreateHeader(&zip.FileHeader{Name: entry.name, Method: entry.method})
+		if err != nil {
+			t.Fatalf("Could not create zip entry %s, %s", entry.name, err)
+		}
+		w.Write(entry.body)
+	}
+	zipWriter.Close()
+
+	srcZip := openTestZip(t, src.Bytes())
+	reader, err := NewRWPPReader(srcZip)
+	if err != nil {
+		t.Fatalf("Could not read archive, %s", err)
+	}
+	var b bytes.Buffer
+	writer, err := reader.NewWriter(&b)
+	if err != nil {
+		t.Fatalf("Could not build a writer, %s", err)
+	}
+	if _, err = Process(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer); err != nil {
+		t.Fatalf("Could not process the package, %s", err)
+	}
+
+	var source, copied *zip.File
+	for _, file := range srcZip.File {
+		if file.Name == "style.css" {
+			source = file
+		}
+	}
+	for _, file := range openTestZip(t, b.Bytes()).File {
+		if file.Name == "style.css" {
+			copied = file
+		}
+	}
+	if copied == nil {
+		t.Fatal("Expected style.css to be copied")
+	}
+	if copied.CompressedSize64 != source.CompressedSize64 {
+		t.Errorf("Expected a compressed size of %d, got %d", source.CompressedSize64, copied.CompressedSize64)
+	}
+	rc, err := copied.Open()
+	if err != nil {
+		t.Fatalf("Could not open style.css, %s", err)
+	}
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil || !bytes.Equal(data, style) {
+		t.Errorf("Expected style.css to be readable after a raw copy, got %v", err)
+	}
+}
+
+const ancillaryTestManifest = `{
+	"metadata": {"title": "ancillary"},
+	"readingOrder": [
+		{"href": "chapter.html", "type": "text/html", "alternate": [{"href": "chapter.pdf", "type": "application/pdf"}]}
+	],
+	"resources": [
+		{"href": "cover.jpg", "type": "image/jpeg", "rel": "cover"},
+		{"href": "chapter.html", "type": "text/html"}
+	]
+}`
+
+func packAncillaryTest(t *testing.T, encryptAncillary bool) []byte {
+	reader := openTestRWPP(t,
+		testEntry{name: ManifestLocation, method: Deflate, body: []byte(ancillaryTestManifest)},
+		testEntry{name: "chapter.html", method: Deflate, body: []byte("<html/>")},
+		testEntry{name: "chapter.pdf", method: Deflate, body: []byte("%PDF")},
+		testEntry{name: "cover.jpg", method: NoCompression, body: []byte("jpeg")},
+	)
+	reader.EncryptAncillary = encryptAncillary
+
+	var b bytes.Buffer
+	writer, err := reader.NewWriter(&b)
+	if err != nil {
+		t.Fatalf("Could not build a writer, %s", err)
+	}
+	if _, err = Process(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer); err != nil {
+		t.Fatalf("Could not process the package, %s", err)
+	}
+	if reader.manifest.Resources[0].Properties != nil {
+		t.Error("Did not expect the source manifest to be modified")
+	}
+
+	// each resource must be written once
+	names := map[string]int{}
+	for _, file := range openTestZip(t, b.Bytes()).File {
+		names[file.Name]++
+	}
+	for name, count := range names {
+		if count != 1 {
+			t.Errorf("Expected %s to be written once, got %d", name, count)
+		}
+	}
+	if len(names) != 4 {
+		t.Errorf("Expected 4 entries in the package, got %v", names)
+	}
+	return b.Bytes()
+}
+
+func isEncryptedLink(link rwpm.Link) bool {
+	return link.Properties != nil && link.Properties.Encrypted != nil
+}
+
+func TestEncryptAncillary(t *testing.T) {
+	manifest := readOutputManifest(t, packAncillaryTest(t, true))
+
+	if len(manifest.ReadingOrder) != 1 {
+		t.Fatalf("Expected a single reading order item, got %#v", manifest.ReadingOrder)
+	}
+	chapter := manifest.ReadingOrder[0]
+	if !isEncryptedLink(chapter) {
+		t.Error("Expected chapter.html to be encrypted")
+	}
+	if len(chapter.Alternate) != 1 || !isEncryptedLink(chapter.Alternate[0]) {
+		t.Errorf("Expected the alternate to be kept and encrypted, got %#v", chapter.Alternate)
+	}
+	for _, link := range manifest.Resources {
+		if !isEncryptedLink(link) {
+			t.Errorf("Expected %s to be encrypted in the resources", link.Href)
+		}
+	}
+}
+
+func TestLeaveAncillaryInClear(t *testing.T) {
+	manifest := readOutputManifest(t, packAncillaryTest(t, false))
+
+	if !isEncryptedLink(manifest.ReadingOrder[0]) {
+		t.Error("Expected chapter.html to be encrypted")
+	}
+	if isEncryptedLink(manifest.ReadingOrder[0].Alternate[0]) {
+		t.Error("Expected the alternate to be left in clear")
+	}
+	if isEncryptedLink(manifest.Resources[0]) {
+		t.Error("Expected cover.jpg to be left in clear")
+	}
+}
+
+func TestNewWriterMissingFiles(t *testing.T) {
+	const manifest = `{"metadata": {"title": "missing"},
+		"readingOrder": [{"href": "chapter.html", "type": "text/html"}],
+		"resources": [{"href": "missing.css", "type": "text/css"}]}`
+
+	for _, encryptAncillary := range []bool{false, true} {
+		reader := openTestRWPP(t,
+			testEntry{name: ManifestLocation, method: Deflate, body: []byte(manifest)},
+			testEntry{name: "chapter.html", method: Deflate, body: []byte("<html/>")},
+		)
+		reader.EncryptAncillary = encryptAncillary
+
+		writer, err := reader.NewWriter(ioutil.Discard)
+		if err != nil {
+			t.Fatalf("Expected a missing resource to be skipped, got %s", err)
+		}
+		if _, err = Process(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer); err != nil {
+			t.Fatalf("Could not process the package, %s", err)
+		}
+	}
+
+	reader := openTestRWPP(t,
+		testEntry{name: ManifestLocation, method: Deflate, body: []byte(manifest)},
+	)
+	if _, err := reader.NewWriter(ioutil.Discard); err == nil {
+		t.Error("Expected a missing reading order item to be reported")
+	}
+}
+
+func TestStrictManifestValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		valid    bool
+	}{
+		{"valid", `{"@context": "https://readium.org/webpub-manifest/context.jsonld", "metadata": {"title": "t", "conformsTo": "https://readium.org/webpub-manifest/profiles/pdf"},
+			"readingOrder": [{"href": "publication.pdf", "type": "application/pdf"}]}`, true},
+		{"empty reading order", `{"@context": "https://readium.org/webpub-manifest/context.jsonld", "metadata": {"title": "t"}, "readingOrder": []}`, false},
+		{"bogus context", `{"@context": "https://schema.org", "metadata": {"title": "t"},
+			"readingOrder": [{"href": "publication.pdf", "type": "application/pdf"}]}`, false},
+		{"unknown profile", `{"@context": "https://readium.org/webpub-manifest/context.jsonld", "metadata": {"title": "t", "conformsTo": "https://example.com/profile"},
+			"readingOrder": [{"href": "publication.pdf", "type": "application/pdf"}]}`, false},
+	}
+	for _, test := range tests {
+		data := buildTestZip(t,
+			testEntry{name: ManifestLocation, method: Deflate, body: []byte(test.manifest)},
+			testEntry{name: "publication.pdf", method: Deflate, body: []byte("pdf")},
+		)
+		_, err := NewRWPPReaderWithValidation(openTestZip(t, data), true)
+		if test.valid && err != nil {
+			t.Errorf("%s: expected a valid manifest, got %s", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: expected the manifest to be rejected", test.name)
+		}
+
+		// the default reader is lenient
+		if _, err = NewRWPPReader(openTestZip(t, data)); err != nil {
+			t.Errorf("%s: expected the lenient reader to accept the manifest, got %s", test.name, err)
+		}
+	}
+}
+
+func TestMaxEntryCount(t *testing.T) {
+	defer func(max int) { MaxEntryCount = max }(MaxEntryCount)
+	MaxEntryCount = 1000
+
+	entries := []testEntry{{name: ManifestLocation, method: Deflate, body: []byte(`{"metadata": {"title": "many"}}`)}}
+	for i := 0; i < MaxEntryCount; i++ {
+		entries = append(entries, testEntry{name: fmt.Sprintf("entry%d", i), method: NoCompression})
+	}
+	if _, err := NewRWPPReader(openTestZip(t, buildTestZip(t, entries...))); err != ErrTooManyEntries {
+		t.Errorf("Expected ErrTooManyEntries, got %v", err)
+	}
+	if _, err := NewRWPPReader(openTestZip(t, buildTestZip(t, entries[:MaxEntryCount]...))); err != nil {
+		t.Errorf("Expected %d entries to be accepted, got %s", MaxEntryCount, err)
+	}
+}
+
+func TestOriginalLength(t *testing.T) {
+	manifest := readOutputManifest(t, packAncillaryTest(t, true))
+
+	expected := map[string]int{"chapter.html": len("<html/>"), "chapter.pdf": len("%PDF"), "cover.jpg": len("jpeg")}
+	links := []rwpm.Link{manifest.ReadingOrder[0], manifest.ReadingOrder[0].Alternate[0], manifest.Resources[0]}
+	for _, link := range links {
+		if !isEncryptedLink(link) {
+			t.Fatalf("Expected %s to be encrypted", link.Href)
+		}
+		if length := link.Properties.Encrypted.OriginalLength; length != expected[link.Href] {
+			t.Errorf("Expected %s to have an original length of %d, got %d", link.Href, expected[link.Href], length)
+		}
+	}
+}
+
+func TestPreserveLinks(t *testing.T) {
+	const manifest = `{"metadata": {"title": "links"},
+		"links": [{"rel": "license", "href": "https://lcp.example.com/licenses/1"},
+			{"rel": "status", "href": "https://lsd.example.com/licenses/1/status"},
+			{"rel": "hint", "href": "https://example.com/hint"}],
+		"readingOrder": [{"href": "publication.pdf", "type": "application/pdf"}]}`
+
+	reader := openTestRWPP(t,
+		testEntry{name: ManifestLocation, method: Deflate, body: []byte(manifest)},
+		testEntry{name: "publication.pdf", method: Deflate, body: []byte("%PDF")},
+	)
+	var b bytes.Buffer
+	writer, err := reader.NewWriter(&b)
+	if err != nil {
+		t.Fatalf("Could not build a writer, %s", err)
+	}
+	if _, err = Process(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer); err != nil {
+		t.Fatalf("Could not process the package, %s", err)
+	}
+
+	output := readOutputManifest(t, b.Bytes())
+	for _, get := range []func() (rwpm.Link, error){output.LicenseLink, output.StatusLink, output.HintLink} {
+		if _, err := get(); err != nil {
+			t.Errorf("Expected the link to be preserved, got %s", err)
+		}
+	}
+}
+
+func TestNewWriterCorruptedEntry(t *testing.T) {
+	manifest := `{"metadata": {"title": "corrupted"},
+		"readingOrder": [{"href": "chapter.html", "type": "text/html"}],
+		"resources": [{"href": "style.css", "type": "text/css"}]}`
+	data := buildTestZip(t,
+		testEntry{name: ManifestLocation, method: Deflate, body: []byte(manifest)},
+		testEntry{name: "chapter.html", method: Deflate, body: []byte("<html/>")},
+		testEntry{name: "style.css", method: Deflate, body: []byte("body {}")},
+	)
+
+	// corrupt the signature of the local header of style.css
+	header := []byte("PK\x03\x04")
+	for offset := 0; ; offset++ {
+		i := bytes.Index(data[offset:], header)
+		if i < 0 {
+			t.Fatal("Could not find the local header of style.css")
+		}
+		offset += i
+		if bytes.HasPrefix(data[offset+30:], []byte("style.css")) {
+			data[offset] = 'X'
+			break
+		}
+	}
+
+	reader, err := NewRWPPReader(openTestZip(t, data))
+	if err != nil {
+		t.Fatalf("Could not read archive, %s", err)
+	}
+	if _, err = reader.NewWriter(ioutil.Discard); err == nil {
+		t.Error("Expected the copy of a corrupted entry to fail")
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	const manifest = `{"metadata": {"title": "checksum"}, "readingOrder": [
+		{"href": "chapter.html", "type": "text/html"},
+		{"href": "track.mp3", "type": "audio/mpeg"}]}`
+	chapter := bytes.Repeat([]byte("<p>checksum</p>"), 100)
+	track := []byte("mp3")
+
+	for _, checksum := range []bool{false, true} {
+		reader := openTestRWPP(t,
+			testEntry{name: ManifestLocation, method: Deflate, body: []byte(manifest)},
+			testEntry{name: "chapter.html", method: Deflate, body: chapter},
+			testEntry{name: "track.mp3", method: NoCompression, body: track},
+		)
+		// the chapter is compressed before encryption, the checksum must still be the plaintext one
+		reader.Policy = streamingPolicy{}
+
+		var b bytes.Buffer
+		writer, err := reader.NewWriter(&b)
+		if err != nil {
+			t.Fatalf("Could not build a writer, %s", err)
+		}
+		if _, _, err = ProcessWithOptions(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer, PackOptions{Checksum: checksum}); err != nil {
+			t.Fatalf("Could not process the package, %s", err)
+		}
+
+		output := readOutputManifest(t, b.Bytes())
+		encrypted := output.ReadingOrder[0].Properties.Encrypted
+		expected := ""
+		if checksum {
+			sum := sha256.Sum256(chapter)
+			expected = hex.EncodeToString(sum[:])
+		}
+		if encrypted.Checksum != expected {
+			t.Errorf("Expected the checksum %q, got %q", expected, encrypted.Checksum)
+		}
+	}
+}
+
+// fixedEncrypter encrypts deterministically with a fixed key, for reproducibility tests
+type fixedEncrypter struct{}
+
+func (fixedEncrypter) Signature() string { return "http://example.com/fixed" }
+func (fixedEncrypter) GenerateKey() (crypto.ContentKey, error) {
+	return crypto.ContentKey(bytes.Repeat([]byte{1}, 32)), nil
+}
+func (fixedEncrypter) Encrypt(key crypto.ContentKey, r io.Reader, w io.Writer) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	for i := range data {
+		data[i] ^= key[i%len(key)]
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func TestReproducibleOutput(t *testing.T) {
+	build := func(opts PackOptions) []byte {
+		reader := openTestRWPP(t,
+			testEntry{name: ManifestLocation, method: Deflate, body: []byte(ancillaryTestManifest)},
+			testEntry{name: "chapter.html", method: Deflate, body: []byte("<html/>")},
+			testEntry{name: "chapter.pdf", method: Deflate, body: []byte("%PDF")},
+			testEntry{name: "cover.jpg", method: NoCompression, body: []byte("jpeg")},
+		)
+		var b bytes.Buffer
+		writer, err := reader.NewWriterWithOptions(&b, opts)
+		if err != nil {
+			t.Fatalf("Could not build a writer, %s", err)
+		}
+		if _, _, err = ProcessWithOptions(license.BasicProfile, fixedEncrypter{}, reader, writer, opts); err != nil {
+			t.Fatalf("Could not process the package, %s", err)
+		}
+		return b.Bytes()
+	}
+
+	first := build(PackOptions{})
+	time.Sleep(10 * time.Millisecond)
+	if second := build(PackOptions{}); !bytes.Equal(first, second) {
+		t.Error("Expected identical inputs to produce identical packages")
+	}
+	for _, file := range openTestZip(t, first).File {
+		if file.Name == ManifestLocation && !file.Modified.Equal(DefaultModified) {
+			t.Errorf("Expected the manifest to be dated %s, got %s", DefaultModified, file.Modified)
+		}
+	}
+
+	modified := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, file := range openTestZip(t, build(PackOptions{Modified: modified})).File {
+		if (file.Name == ManifestLocation || file.Name == "chapter.html") && !file.Modified.Equal(modified) {
+			t.Errorf("Expected %s to be dated %s, got %s", file.Name, modified, file.Modified)
+		}
+	}
+}
+
+func TestFileCount(t *testing.T) {
+	reader, err := OpenRWPP("./samples/basic.lcpdf")
+	if err != nil {
+		t.Fatalf("Expected to be able to open basic.lcpdf, got %s", err)
+	}
+	if count := reader.FileCount(); count != 2 {
+		t.Errorf("Expected %d files, got %d", 2, count)
+	}
+}
+
+// BenchmarkPackLargeArchive packs an archive of 2000 entries
+func BenchmarkPackLargeArchive(b *testing.B) {
+	const count = 2000
+	manifest := rwpm.Publication{}
+	manifest.Metadata.Title.SetDefault("large")
+	entries := []testEntry{{name: ManifestLocation, method: Deflate}}
+	for i := 0; i < count; i++ {
+		name := fmt.Sprintf("images/page%04d.jpg", i)
+		manifest.ReadingOrder = append(manifest.ReadingOrder, rwpm.Link{Href: name, Type: "image/jpeg"})
+		entries = append(entries, testEntry{name: name, method: NoCompression, body: []byte(name)})
+	}
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		b.Fatal(err)
+	}
+	entries[0].body = body
+
+	data := buildTestZip(b, entries...)
+	encrypter := crypto.NewAESEncrypter_PUBLICATION_RESOURCES()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		reader, err := NewRWPPReader(openTestZip(b, data))
+		if err != nil {
+			b.Fatal(err)
+		}
+		writer, err := reader.NewWriter(ioutil.Discard)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err = Process(license.BasicProfile, encrypter, reader, writer); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestBuildRWPPFromPDFWithOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	inputPath := filepath.Join(dir, "input.pdf")
+	if err = ioutil.WriteFile(inputPath, bytes.Repeat([]byte("%PDF-1.4 "), 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for compression, method := range map[string]uint16{CompressionStore: NoCompression, CompressionDeflate: Deflate, "": Deflate} {
+		outputPath := filepath.Join(dir, "output.lcpdf")
+		opts := PackOptions{Compression: compression, Identifier: "urn:isbn:9782072703239"}
+		if err = BuildRWPPFromPDFWithOptions(`A "quoted" title`, inputPath, outputPath, opts); err != nil {
+			t.Fatalf("Could not build the package, %s", err)
+		}
+
+		reader, err := OpenRWPP(outputPath)
+		if err != nil {
+			t.Fatalf("Expected a valid package, got %s", err)
+		}
+		if id := reader.manifest.Metadata.Identifier; id != opts.Identifier {
+			t.Errorf("Expected the identifier %s, got %s", opts.Identifier, id)
+		}
+		if title := reader.manifest.Metadata.Title.Text(); title != `A "quoted" title` {
+			t.Errorf("Expected the title to be escaped, got %s", title)
+		}
+		if file := reader.file("publication.pdf"); file == nil || file.Method != method {
+			t.Errorf("Expected the pdf to be stored with method %d", method)
+		}
+	}
+
+	if err = BuildRWPPFromPDFWithOptions("title", inputPath, filepath.Join(dir, "output.lcpdf"), PackOptions{Compression: "bzip2"}); err == nil {
+		t.Error("Expected an unknown compression to be rejected")
+	}
+}
+
+// BenchmarkBuildRWPPFromPDF compares the compressions of an embedded PDF
+func BenchmarkBuildRWPPFromPDF(b *testing.B) {
+	// PDF content is mostly compressed streams
+	pdf := make([]byte, 8<<20)
+	rand.New(rand.NewSource(1)).Read(pdf)
+
+	for _, compression := range []string{CompressionStore, CompressionDeflate} {
+		b.Run(compression, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := buildRWPPFromPDF(pdfInfo{Title: "title"}, bytes.NewReader(pdf), ioutil.Discard, PackOptions{Compression: compression}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestEmptyResourcesOmitted(t *testing.T) {
+	reader := openTestRWPP(t,
+		testEntry{name: ManifestLocation, method: Deflate, body: []byte(`{"metadata": {"title": "empty"}, "readingOrder": [{"href": "publication.pdf", "type": "application/pdf"}], "resources": []}`)},
+		testEntry{name: "publication.pdf", method: Deflate, body: []byte("pdf")},
+	)
+
+	var b bytes.Buffer
+	writer, err := reader.NewWriter(&b)
+	if err != nil {
+		t.Fatalf("Could not build a writer, %s", err)
+	}
+	if _, err = Process(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer); err != nil {
+		t.Fatalf("Could not process the package, %s", err)
+	}
+
+	rc, err := openTestZip(t, b.Bytes()).Open(ManifestLocation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	var manifest map[string]json.RawMessage
+	if err = json.NewDecoder(rc).Decode(&manifest); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := manifest["resources"]; ok {
+		t.Error("Did not expect an empty resources collection in the manifest")
+	}
+}
+
+func TestDefaultContentType(t *testing.T) {
+	manifest := `{
+		"metadata": {"title": "typeless"},
+		"readingOrder": [{"href": "chapter.html", "type": "text/html"}, {"href": "data.unknown1"}],
+		"resources": [{"href": "extra.unknown2"}]
+	}`
+	for _, defaultType := range []string{"", "application/x-custom"} {
+		reader := openTestRWPP(t,
+			testEntry{name: ManifestLocation, method: Deflate, body: []byte(manifest)},
+			testEntry{name: "chapter.html", method: Deflate, body: []byte("<p>chapter</p>")},
+			testEntry{name: "data.unknown1", method: Deflate, body: []byte{0x00, 0x01, 0x02}},
+			testEntry{name: "extra.unknown2", method: Deflate, body: []byte{0x03, 0x04, 0x05}},
+		)
+		reader.EncryptAncillary = true
+
+		var b bytes.Buffer
+		writer, err := reader.NewWriterWithOptions(&b, PackOptions{DefaultContentType: defaultType})
+		if err != nil {
+			t.Fatalf("Could not build a writer, %s", err)
+		}
+		if _, err = Process(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer); err != nil {
+			t.Fatalf("Could not process the package, %s", err)
+		}
+
+		expected := defaultType
+		if expected == "" {
+			expected = DefaultContentType
+		}
+		out := readOutputManifest(t, b.Bytes())
+		if typ := out.ReadingOrder[0].Type; typ != "text/html" {
+			t.Errorf("Expected the declared type to be kept, got %s", typ)
+		}
+		if typ := out.ReadingOrder[1].Type; typ != expected {
+			t.Errorf("Expected the reading order item to have the type %s, got %q", expected, typ)
+		}
+		if typ := out.Resources[0].Type; typ != expected {
+			t.Errorf("Expected the resource to have the type %s, got %q", expected, typ)
+		}
+	}
+}
+
+func TestReadingOrderSequence(t *testing.T) {
+	// the hrefs are neither sorted by name nor by length
+	var hrefs []string
+	for i := 30; i > 0; i-- {
+		hrefs = append(hrefs, fmt.Sprintf("chapter-%d.html", (i*7)%31))
+	}
+	var links []string
+	entries := []testEntry{}
+	for _, href := range hrefs {
+		links = append(links, `{"href": "`+href+`", "type": "text/html"}`)
+		entries = append(entries, testEntry{name: href, method: Deflate, body: []byte("<p>" + href + "</p>")})
+	}
+	manifest := `{"metadata": {"title": "sequence"}, "readingOrder": [` + strings.Join(links, ",") + `]}`
+	entries = append(entries, testEntry{name: ManifestLocation, method: Deflate, body: []byte(manifest)})
+
+	checkSequence := func(name string, data []byte) {
+		output := readOutputManifest(t, data)
+		if len(output.ReadingOrder) != len(hrefs) {
+			t.Fatalf("%s: expected %d links in the reading order, got %d", name, len(hrefs), len(output.ReadingOrder))
+		}
+		for i, link := range output.ReadingOrder {
+			if link.Href != hrefs[i] {
+				t.Errorf("%s: expected %s at position %d of the reading order, got %s", name, hrefs[i], i, link.Href)
+			}
+		}
+	}
+
+	// repackaging keeps the sequence
+	reader := openTestRWPP(t, entries...)
+	var b bytes.Buffer
+	writer, err := reader.NewWriter(&b)
+	if err != nil {
+		t.Fatalf("Could not build a writer, %s", err)
+	}
+	if _, err = Process(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer); err != nil {
+		t.Fatalf("Could not process the package, %s", err)
+	}
+	checkSequence("process", b.Bytes())
+
+	// the sequence does not depend on the order in which the resources are written
+	b.Reset()
+	writer, err = reader.NewWriter(&b)
+	if err != nil {
+		t.Fatalf("Could not build a writer, %s", err)
+	}
+	resources := reader.Resources()
+	for i := len(resources) - 1; i >= 0; i-- {
+		if err = resources[i].CopyTo(writer); err != nil {
+			t.Fatalf("Could not copy %s, %s", resources[i].Path(), err)
+		}
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatalf("Could not close the writer, %s", err)
+	}
+	checkSequence("reversed", b.Bytes())
+}
+
+func TestMarkAsEncryptedProfile(t *testing.T) {
+	tests := []struct {
+		name     string
+		profile  license.EncryptionProfile
+		expected error
+	}{
+		{"basic", license.BasicProfile, nil},
+		{"1.0", license.V1Profile, nil},
+		{"not set", 0, license.ErrProfileNotSet},
+		{"unknown", license.EncryptionProfile(42), license.ErrUnknownProfile},
+	}
+	for _, test := range tests {
+		reader := openTestRWPP(t,
+			testEntry{name: ManifestLocation, method: Deflate, body: []byte(`{"metadata": {"title": "profile"}, "readingOrder": [{"href": "publication.pdf", "type": "application/pdf"}]}`)},
+			testEntry{name: "publication.pdf", method: Deflate, body: []byte("%PDF-1.7")},
+		)
+		var b bytes.Buffer
+		writer, err := reader.NewWriter(&b)
+		if err != nil {
+			t.Fatalf("Could not build a writer, %s", err)
+		}
+		if err = writer.MarkAsEncrypted("publication.pdf", 8, test.profile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES().Signature()); err != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, err)
+		}
+		if _, err = Process(test.profile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer); err != test.expected {
+			t.Errorf("%s: expected the packaging to return %v, got %v", test.name, test.expected, err)
+		}
+		if test.expected != nil {
+			continue
+		}
+		manifest := readOutputManifest(t, b.Bytes())
+		if profile := manifest.ReadingOrder[0].Properties.Encrypted.Profile; profile != test.profile.String() {
+			t.Errorf("%s: expected the profile %s, got %s", test.name, test.profile, profile)
+		}
+	}
+}
+
+func TestManifestPaths(t *testing.T) {
+	const manifest = `{"metadata": {"title": "elsewhere"}, "readingOrder": [{"href": "publication.pdf", "type": "application/pdf"}]}`
+	data := buildTestZip(t,
+		testEntry{name: "index.json", method: Deflate, body: []byte(`[{"href": "publication.pdf"}]`)},
+		// the reading order of a W3C manifest parses as a Readium one
+		testEntry{name: W3CManifestName, method: Deflate, body: []byte(`{"readingOrder": [{"url": "publication.pdf"}]}`)},
+		testEntry{name: "readium.json", method: Deflate, body: []byte(manifest)},
+		testEntry{name: "publication.pdf", method: Deflate, body: []byte("%PDF-1.7")},
+	)
+
+	// the manifest is found at a candidate path, or by scanning the JSON entries
+	for _, paths := range [][]string{{"readium.json"}, {"missing.json", "Readium.json"}, nil} {
+		reader, err := NewRWPPReaderWithManifestPaths(openTestZip(t, data), false, paths)
+		if err != nil {
+			t.Fatalf("%v: could not read the package, %s", paths, err)
+		}
+		if reader.manifestName != "readium.json" {
+			t.Errorf("%v: expected the manifest readium.json, got %s", paths, reader.manifestName)
+		}
+		if title := reader.manifest.Metadata.Title.Text(); title != "elsewhere" {
+			t.Errorf("%v: expected the title elsewhere, got %s", paths, title)
+		}
+	}
+
+	// the written package has its manifest at the canonical location
+	reader, err := NewRWPPReader(openTestZip(t, data))
+	if err != nil {
+		t.Fatalf("Could not read the package, %s", err)
+	}
+	var b bytes.Buffer
+	writer, err := reader.NewWriter(&b)
+	if err != nil {
+		t.Fatalf("Could not build a writer, %s", err)
+	}
+	if _, err = Process(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer); err != nil {
+		t.Fatalf("Could not process the package, %s", err)
+	}
+	names := map[string]bool{}
+	for _, file := range openTestZip(t, b.Bytes()).File {
+		names[file.Name] = true
+	}
+	if !names[ManifestLocation] || names["readium.json"] {
+		t.Errorf("Expected the manifest to be written at %s only, got %v", ManifestLocation, names)
+	}
+
+	// a package without any manifest is still rejected
+	noManifest := buildTestZip(t, testEntry{name: "index.json", method: Deflate, body: []byte(`{"href": "publication.pdf"}`)})
+	if _, err = NewRWPPReader(openTestZip(t, noManifest)); err == nil {
+		t.Error("Expected a package without manifest to be rejected")
+	}
+}
+
+func TestSetLicense(t *testing.T) {
+	reader := openTestRWPP(t,
+		testEntry{name: ManifestLocation, method: Deflate, body: []byte(`{"metadata": {"title": "license"},
+			"links": [{"href": "https://lcp.example.com/licenses/1", "rel": "license"}, {"href": "https://example.com/self", "rel": "self"}],
+			"readingOrder": [{"href": "publication.pdf", "type": "application/pdf"}]}`)},
+		testEntry{name: "publication.pdf", method: Deflate, body: []byte("%PDF-1.7")},
+	)
+	var b bytes.Buffer
+	writer, err := reader.NewWriter(&b)
+	if err != nil {
+		t.Fatalf("Could not build a writer, %s", err)
+	}
+	licenseJSON := []byte(`{"id": "license-1"}`)
+	writer.(*RWPPWriter).SetLicense(licenseJSON)
+	if _, err = Process(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer); err != nil {
+		t.Fatalf("Could not process the package, %s", err)
+	}
+
+	if entry := readZipEntry(t, openTestZip(t, b.Bytes()), LicenseLocation); !bytes.Equal(entry, licenseJSON) {
+		t.Errorf("Expected the license %s, got %s", licenseJSON, entry)
+	}
+	manifest := readOutputManifest(t, b.Bytes())
+	link, err := manifest.LicenseLink()
+	if err != nil {
+		t.Fatalf("Expected a license link, %s", err)
+	}
+	if link.Href != LicenseLocation || link.Type != ContentTypeLCPLicense {
+		t.Errorf("Expected a link to %s, got %s (%s)", LicenseLocation, link.Href, link.Type)
+	}
+	if len(manifest.Links) != 2 {
+		t.Errorf("Expected the license link to replace the source one, got %d links", len(manifest.Links))
+	}
+}
+
+func TestUnsafeEntries(t *testing.T) {
+	manifest := testEntry{name: ManifestLocation, method: Deflate, body: []byte(`{"metadata": {"title": "unsafe"}, "readingOrder": [{"href": "publication.pdf", "type": "application/pdf"}]}`)}
+	pdf := testEntry{name: "publication.pdf", method: Deflate, body: []byte("%PDF-1.7")}
+
+	tests := []struct {
+		name   string
+		entry  string
+		unsafe bool
+	}{
+		{"traversal", "../../etc/passwd", true},
+		{"absolute", "/etc/passwd", true},
+		{"backslashes", `..\..\windows\system.ini`, true},
+		{"drive", "C:/windows/system.ini", true},
+		{"inner traversal", "images/../cover.jpg", false},
+		{"dots in name", "..cover.jpg", false},
+	}
+	for _, test := range tests {
+		data := buildTestZip(t, manifest, pdf, testEntry{name: test.entry, method: Deflate, body: []byte("x")})
+		_, err := NewRWPPReader(openTestZip(t, data))
+		if !test.unsafe {
+			if err != nil {
+				t.Errorf("%s: expected the package to be accepted, got %s", test.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrUnsafeEntries) {
+			t.Errorf("%s: expected the package to be rejected, got %v", test.name, err)
+		} else if !strings.Contains(err.Error(), test.entry) {
+			t.Errorf("%s: expected the error to list %s, got %s", test.name, test.entry, err)
+		}
+	}
+
+	// every offending entry is listed
+	data := buildTestZip(t, manifest, pdf,
+		testEntry{name: "../evil.sh", method: Deflate, body: []byte("x")},
+		testEntry{name: "/tmp/evil.sh", method: Deflate, body: []byte("x")},
+	)
+	if _, err := NewRWPPReader(openTestZip(t, data)); err == nil || !strings.Contains(err.Error(), "../evil.sh, /tmp/evil.sh") {
+		t.Errorf("Expected both entries to be listed, got %v", err)
+	}
+}
+
+func TestDedupeReadingOrder(t *testing.T) {
+	reader := openTestRWPP(t,
+		testEntry{name: ManifestLocation, method: Deflate, body: []byte(`{"metadata": {"title": "dedupe"}, "readingOrder": [
+			{"href": "chapter1.html", "type": "text/html"}, {"href": "chapter2.html", "type": "text/html"}]}`)},
+		testEntry{name: "chapter1.html", method: Deflate, body: []byte("<p>1</p>")},
+		testEntry{name: "chapter2.html", method: Deflate, body: []byte("<p>2</p>")},
+	)
+	var b bytes.Buffer
+	writer, err := reader.NewWriter(&b)
+	if err != nil {
+		t.Fatalf("Could not build a writer, %s", err)
+	}
+
+	// chapter1.html is added twice, as a retry would do, the second time with another type
+	for _, file := range []struct{ path, contentType string }{
+		{"chapter1.html", "text/html"},
+		{"chapter2.html", "text/html"},
+		{"chapter1.html", "application/xhtml+xml"},
+	} {
+		w, err := writer.NewFile(file.path, file.contentType, Deflate)
+		if err != nil {
+			t.Fatalf("Could not create %s, %s", file.path, err)
+		}
+		w.Write([]byte("<p/>"))
+		w.Close()
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatalf("Could not close the writer, %s", err)
+	}
+
+	manifest := readOutputManifest(t, b.Bytes())
+	if l := len(manifest.ReadingOrder); l != 2 {
+		t.Fatalf("Expected 2 items in the reading order, got %d", l)
+	}
+	if first := manifest.ReadingOrder[0]; first.Href != "chapter1.html" || first.Type != "application/xhtml+xml" {
+		t.Errorf("Expected chapter1.html first with the last type, got %s (%s)", first.Href, first.Type)
+	}
+	if second := manifest.ReadingOrder[1]; second.Href != "chapter2.html" {
+		t.Errorf("Expected chapter2.html second, got %s", second.Href)
+	}
+}
+
+func TestResourceModTimeAndCRC32(t *testing.T) {
+	modified := time.Date(2020, 5, 1, 12, 30, 0, 0, time.UTC)
+	entries := map[string][]byte{
+		ManifestLocation:  []byte(`{"metadata": {"title": "caching"}, "readingOrder": [{"href": "publication.pdf", "type": "application/pdf"}]}`),
+		"publication.pdf": []byte("%PDF-1.7"),
+	}
+	var b bytes.Buffer
+	zipWriter := zip.NewWriter(&b)
+	for _, name := range []string{ManifestLocation, "publication.pdf"} {
+		w, err := zipWriter.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(entries[name])
+	}
+	if err := zipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := NewRWPPReader(openTestZip(t, b.Bytes()))
+	if err != nil {
+		t.Fatalf("Could not read the package, %s", err)
+	}
+	resource := reader.Resources()[0]
+	if !resource.ModTime().Equal(modified) {
+		t.Errorf("Expected the modification time %s, got %s", modified, resource.ModTime())
+	}
+	if crc := crc32.ChecksumIEEE(entries["publication.pdf"]); resource.CRC32() != crc {
+		t.Errorf("Expected the checksum %x, got %x", crc, resource.CRC32())
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// GzipExtension is appended to the name of gzipped side-files
+const GzipExtension = ".gz"
+
+// SideFileName returns the name of a side-file, with the gzip extension if requested in the options
+func SideFileName(name string, opts PackOptions) string {
+	if opts.GzipSideFiles {
+		return name + GzipExtension
+	}
+	return name
+}
+
+// WriteFile writes the report in a directory, as ReportName, gzipped if requested in the options.
+// It returns the path of the file.
+func (report *Report) WriteFile(dir string, opts PackOptions) (string, error) {
+	return writeSideFile(filepath.Join(dir, SideFileName(ReportName, opts)), opts.GzipSideFiles, report.Write)
+}
+
+// WriteFile writes the index in a directory, as ByteRangeIndexName, gzipped if requested in the options.
+// It returns the path of the file.
+func (index ByteRangeIndex) WriteFile(dir string, opts PackOptions) (string, error) {
+	return writeSideFile(filepath.Join(dir, SideFileName(ByteRangeIndexName, opts)), opts.GzipSideFiles, index.Write)
+}
+
+// writeSideFile creates a side-file and writes its content, through a gzip writer if requested
+func writeSideFile(path string, gzipped bool, write func(io.Writer) error) (_ string, err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		// a close error must not be masked by a prior nil
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	if !gzipped {
+		return path, write(f)
+	}
+	gzipWriter := gzip.NewWriter(f)
+	if err = write(gzipWriter); err != nil {
+		gzipWriter.Close()
+		return path, err
+	}
+	return path, gzipWriter.Close()
+}
@@ -0,0 +1,71 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReportWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sidefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	report := &Report{Resources: []ResourceReport{
+		{Path: "chapter1.html", OriginalSize: 16, CiphertextSize: 48, Encrypted: true, Duration: time.Millisecond},
+	}}
+	var expected bytes.Buffer
+	if err = report.Write(&expected); err != nil {
+		t.Fatal(err)
+	}
+
+	// plain output by default
+	path, err := report.WriteFile(dir, PackOptions{})
+	if err != nil {
+		t.Fatalf("Could not write the report, %s", err)
+	}
+	if path != filepath.Join(dir, ReportName) {
+		t.Errorf("Expected the report to be written as %s, got %s", ReportName, path)
+	}
+	plain, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plain, expected.Bytes()) {
+		t.Errorf("Expected the plain report, got %s", plain)
+	}
+
+	path, err = report.WriteFile(dir, PackOptions{GzipSideFiles: true})
+	if err != nil {
+		t.Fatalf("Could not write the gzipped report, %s", err)
+	}
+	if path != filepath.Join(dir, ReportName+GzipExtension) {
+		t.Errorf("Expected the report to be written as %s, got %s", ReportName+GzipExtension, path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gzipReader, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("Expected a gzipped report, got %s", err)
+	}
+	decompressed, err := ioutil.ReadAll(gzipReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decompressed, expected.Bytes()) {
+		t.Errorf("Expected the gzipped report to round-trip, got %s", decompressed)
+	}
+}
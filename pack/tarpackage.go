@@ -0,0 +1,158 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"path"
+	"strings"
+)
+
+// Containers of a Readium package, detected from their magic bytes
+const (
+	ContainerZip     = "zip"
+	ContainerTar     = "tar"
+	ContainerTarGz   = "tar.gz"
+	ContainerUnknown = ""
+)
+
+var (
+	zipMagic  = []byte("PK\x03\x04")
+	gzipMagic = []byte{0x1f, 0x8b}
+	// the ustar magic is found at offset 257 of the first tar header
+	tarMagic       = []byte("ustar")
+	tarMagicOffset = 257
+)
+
+// ErrUnknownContainer is returned when a package is neither a zip archive nor a tarball
+var ErrUnknownContainer = errors.New("The package is neither a zip archive nor a tar or tar.gz archive")
+
+// MaxStreamSize is the maximum size of a package read from a stream: the size of a zip archive,
+// or the total uncompressed size of the files of a tarball; MaxStreamEntrySize is the maximum size of a file of a tarball.
+// A package read from a stream is held in memory, and these limits guard against large streams and gzip bombs.
+var (
+	MaxStreamSize      int64 = 1 << 30
+	MaxStreamEntrySize int64 = 512 << 20
+)
+
+// ErrStreamTooLarge is returned when a package read from a stream exceeds MaxStreamSize or MaxStreamEntrySize
+var ErrStreamTooLarge = errors.New("The package is too large to be read from a stream")
+
+// DetectContainer returns the container of a package from its first bytes:
+// zip, tar or tar.gz, or an empty string if it is unknown.
+func DetectContainer(start []byte) string {
+	switch {
+	case bytes.HasPrefix(start, zipMagic):
+		return ContainerZip
+	case bytes.HasPrefix(start, gzipMagic):
+		return ContainerTarGz
+	case len(start) >= tarMagicOffset+len(tarMagic) && bytes.Equal(start[tarMagicOffset:tarMagicOffset+len(tarMagic)], tarMagic):
+		return ContainerTar
+	}
+	return ContainerUnknown
+}
+
+// NewRWPPReaderFromStream creates a new Readium Package reader from a zip, tar or tar.gz stream,
+// the container being detected from its magic bytes. The package is held in memory,
+// and must not exceed MaxStreamSize (see ErrStreamTooLarge).
+// The entries of a tarball are gathered in an in-memory zip archive,
+// so that the resources are enumerated and encrypted as the ones of a zipped package.
+func NewRWPPReaderFromStream(r io.Reader) (*RWPPReader, error) {
+	buffered := bufio.NewReaderSize(r, tarMagicOffset+len(tarMagic))
+	// a short stream is detected from the bytes available
+	start, _ := buffered.Peek(tarMagicOffset + len(tarMagic))
+
+	var data []byte
+	var err error
+	switch DetectContainer(start) {
+	case ContainerZip:
+		data, err = ioutil.ReadAll(io.LimitReader(buffered, MaxStreamSize+1))
+		if err == nil && int64(len(data)) > MaxStreamSize {
+			err = fmt.Errorf("%w: the zip archive is larger than %d bytes", ErrStreamTooLarge, MaxStreamSize)
+		}
+	case ContainerTarGz:
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(buffered); err != nil {
+			return nil, err
+		}
+		data, err = zipFromTar(gz)
+		gz.Close()
+	case ContainerTar:
+		data, err = zipFromTar(buffered)
+	default:
+		return nil, ErrUnknownContainer
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	zipReader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	return NewRWPPReader(zipReader)
+}
+
+// zipFromTar copies the regular files of a tarball into a zip archive.
+// Images, audio and video files are stored, other files are deflated.
+// The size of each file and their total size are bounded by MaxStreamEntrySize and MaxStreamSize.
+func zipFromTar(r io.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+	zipWriter := zip.NewWriter(&buf)
+	tarReader := tar.NewReader(r)
+
+	count := 0
+	var total int64
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, closeZipWriter(zipWriter, err)
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			// directories, links and special files
+			continue
+		}
+		if count++; count > MaxEntryCount {
+			return nil, closeZipWriter(zipWriter, ErrTooManyEntries)
+		}
+		// the sizes are checked before the data of the file is decompressed
+		if header.Size > MaxStreamEntrySize {
+			return nil, closeZipWriter(zipWriter, fmt.Errorf("%w: %s is larger than %d bytes", ErrStreamTooLarge, header.Name, MaxStreamEntrySize))
+		}
+		if total += header.Size; total > MaxStreamSize {
+			return nil, closeZipWriter(zipWriter, fmt.Errorf("%w: the files of the tarball are larger than %d bytes", ErrStreamTooLarge, MaxStreamSize))
+		}
+
+		name := strings.TrimPrefix(path.Clean("/"+header.Name), "/")
+		method := zip.Deflate
+		contentType := mime.TypeByExtension(path.Ext(name))
+		if strings.HasPrefix(contentType, "image/") || strings.HasPrefix(contentType, "audio/") || strings.HasPrefix(contentType, "video/") {
+			method = zip.Store
+		}
+		w, err := zipWriter.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: header.ModTime})
+		if err != nil {
+			return nil, closeZipWriter(zipWriter, err)
+		}
+		if _, err = io.Copy(w, tarReader); err != nil {
+			return nil, closeZipWriter(zipWriter, err)
+		}
+	}
+	if err := zipWriter.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,135 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"testing"
+
+	"github.com/readium/readium-lcp-server/crypto"
+	"github.com/readium/readium-lcp-server/license"
+)
+
+// buildTestTar builds a tarball of the entries, gzipped if requested
+func buildTestTar(t *testing.T, gzipped bool, entries ...testEntry) []byte {
+	var buf bytes.Buffer
+	var gz *gzip.Writer
+	tw := tar.NewWriter(&buf)
+	if gzipped {
+		gz = gzip.NewWriter(&buf)
+		tw = tar.NewWriter(gz)
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: "./", Typeflag: tar.TypeDir, Mode: 0755}); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if err := tw.WriteHeader(&tar.Header{Name: "./" + entry.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(entry.body))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(entry.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gzipped {
+		if err := gz.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestDetectContainer(t *testing.T) {
+	entry := testEntry{name: ManifestLocation, method: Deflate, body: []byte(`{}`)}
+	tests := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"zip", buildTestZip(t, entry), ContainerZip},
+		{"tar", buildTestTar(t, false, entry), ContainerTar},
+		{"tar.gz", buildTestTar(t, true, entry), ContainerTarGz},
+		{"unknown", []byte("%PDF-1.7"), ContainerUnknown},
+	}
+	for _, test := range tests {
+		if container := DetectContainer(test.data); container != test.expected {
+			t.Errorf("%s: expected the container %q, got %q", test.name, test.expected, container)
+		}
+	}
+	if _, err := NewRWPPReaderFromStream(bytes.NewReader([]byte("%PDF-1.7"))); err != ErrUnknownContainer {
+		t.Errorf("Expected an unknown container error, got %v", err)
+	}
+}
+
+func TestPackageFromTarGz(t *testing.T) {
+	data := buildTestTar(t, true,
+		testEntry{name: ManifestLocation, body: []byte(`{"metadata": {"title": "tarball"}, "readingOrder": [{"href": "audio/track.mp3", "type": "audio/mpeg"}], "resources": [{"href": "cover.jpg", "type": "image/jpeg"}]}`)},
+		testEntry{name: "audio/track.mp3", body: bytes.Repeat([]byte("mp3"), 100)},
+		testEntry{name: "cover.jpg", body: []byte("jpg")},
+	)
+
+	reader, err := NewRWPPReaderFromStream(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Could not read the tarball, %s", err)
+	}
+	resources := reader.Resources()
+	if len(resources) != 1 || resources[0].Path() != "audio/track.mp3" || resources[0].Size() != 300 {
+		t.Fatalf("Expected the reading order of the tarball, got %v", resources)
+	}
+
+	var b bytes.Buffer
+	writer, err := reader.NewWriter(&b)
+	if err != nil {
+		t.Fatalf("Could not build a writer, %s", err)
+	}
+	if _, err = Process(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer); err != nil {
+		t.Fatalf("Could not package the tarball, %s", err)
+	}
+
+	manifest := readOutputManifest(t, b.Bytes())
+	if manifest.Metadata.Title.Text() != "tarball" {
+		t.Errorf("Expected the title of the tarball, got %v", manifest.Metadata.Title)
+	}
+	if len(manifest.ReadingOrder) != 1 || !isEncryptedLink(manifest.ReadingOrder[0]) {
+		t.Errorf("Expected the audio track to be encrypted, got %+v", manifest.ReadingOrder)
+	}
+	found := false
+	for _, file := range openTestZip(t, b.Bytes()).File {
+		found = found || file.Name == "cover.jpg"
+	}
+	if !found {
+		t.Error("Expected the cover to be copied from the tarball")
+	}
+}
+
+func TestStreamTooLarge(t *testing.T) {
+	defer func(size, entrySize int64) {
+		MaxStreamSize, MaxStreamEntrySize = size, entrySize
+	}(MaxStreamSize, MaxStreamEntrySize)
+	MaxStreamSize, MaxStreamEntrySize = 1000, 600
+
+	manifest := testEntry{name: ManifestLocation, body: []byte(`{"metadata": {"title": "large"}, "readingOrder": [{"href": "a.mp3", "type": "audio/mpeg"}]}`)}
+	tests := []struct {
+		name     string
+		data     []byte
+		expected error
+	}{
+		{"small tar", buildTestTar(t, false, manifest, testEntry{name: "a.mp3", body: make([]byte, 500)}), nil},
+		{"large entry", buildTestTar(t, true, manifest, testEntry{name: "a.mp3", body: make([]byte, 700)}), ErrStreamTooLarge},
+		{"large total", buildTestTar(t, true, manifest, testEntry{name: "a.mp3", body: make([]byte, 500)}, testEntry{name: "b.mp3", body: make([]byte, 500)}), ErrStreamTooLarge},
+		{"large zip", buildTestZip(t, manifest, testEntry{name: "a.mp3", method: NoCompression, body: make([]byte, 1000)}), ErrStreamTooLarge},
+	}
+	for _, test := range tests {
+		_, err := NewRWPPReaderFromStream(bytes.NewReader(test.data))
+		if !errors.Is(err, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, err)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"archive/zip"
+	"crypto/aes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/readium/readium-lcp-server/crypto"
+	"github.com/readium/readium-lcp-server/license"
+	"github.com/readium/readium-lcp-server/rwpm"
+)
+
+// ContentTypeSMIL is the media type of a media overlay
+const ContentTypeSMIL = "application/smil+xml"
+
+// Verify checks the consistency of a Readium package:
+// local resources referenced by the manifest must be present in the package,
+// and so must be the audio and text targets of media overlays.
+// Resources deflated before encryption must be stored without further compression,
+// and the mimetype entry, if any, must agree with the kind of publication.
+// A manifest without a Readium context is reported, see PackOptions.RepairContext.
+// It returns the problems found, or nil if the package is consistent.
+func (reader *RWPPReader) Verify() []error {
+	errs := reader.QuickVerify()
+	if err := reader.VerifyMimetype(); err != nil {
+		errs = append(errs, err)
+	}
+	return append(errs, reader.VerifyMediaOverlays()...)
+}
+
+// VerifyMimetype checks that the mimetype entry of the package, if any,
+// agrees with the kind of publication derived from the manifest, see Kind
+func (reader *RWPPReader) VerifyMimetype() error {
+	file := reader.file("mimetype")
+	if file == nil {
+		return nil
+	}
+	rc, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("mimetype: %s", err)
+	}
+	defer rc.Close()
+	// a mimetype longer than any known value is reported as a mismatch
+	b, err := ioutil.ReadAll(io.LimitReader(rc, 64))
+	if err != nil {
+		return fmt.Errorf("mimetype: %s", err)
+	}
+
+	kind := reader.Kind()
+	mimetype := string(b)
+	for _, expected := range kindMimetypes[kind] {
+		if mimetype == expected {
+			return nil
+		}
+	}
+	return fmt.Errorf("mimetype %s does not match the kind of publication %s", mimetype, kind)
+}
+
+// QuickVerify runs the checks of Verify which only need the manifest and the central directory
+// of the package: no resource is decompressed, which makes it fast on large packages.
+// Media overlays are not parsed, so their audio and text targets are not checked.
+func (reader *RWPPReader) QuickVerify() []error {
+	var errs []error
+
+	if len(reader.manifest.Context) == 0 {
+		errs = append(errs, ErrMissingContext)
+	}
+
+	for _, href := range reader.localHrefs() {
+		if reader.file(href) == nil {
+			errs = append(errs, fmt.Errorf("%s is referenced by the manifest but missing from the package", href))
+		}
+	}
+
+	for _, links := range [][]rwpm.Link{reader.manifest.ReadingOrder, reader.manifest.Resources} {
+		for _, link := range links {
+			if link.Properties == nil || link.Properties.Encrypted == nil || link.Properties.Encrypted.Compression != CompressionDeflate {
+				continue
+			}
+			if file := reader.file(link.Href); file != nil && file.Method != zip.Store {
+				errs = append(errs, fmt.Errorf("%s is deflated before encryption but not stored", link.Href))
+			}
+		}
+	}
+	return errs
+}
+
+// VerifyEncryption checks that every reading order item marked as encrypted is backed by cipher material:
+// the resource must be present in the package, with a known LCP profile and encryption algorithm,
+// and its size must be the size of a ciphertext of the algorithm. The exact size is checked
+// if the original length is known and the resource was not deflated before encryption.
+// It returns the problems found, or nil if the encryption is consistent.
+func (reader *RWPPReader) VerifyEncryption() []error {
+	var errs []error
+
+	for _, link := range reader.manifest.ReadingOrder {
+		if link.Properties == nil || link.Properties.Encrypted == nil {
+			continue
+		}
+		encrypted := link.Properties.Encrypted
+		file := reader.file(link.Href)
+		if file == nil {
+			errs = append(errs, fmt.Errorf("%s is marked as encrypted but missing from the package", link.Href))
+			continue
+		}
+		if _, err := license.ParseEncryptionProfile(encrypted.Profile); err != nil {
+			errs = append(errs, fmt.Errorf("%s: unknown encryption profile %q", link.Href, encrypted.Profile))
+		}
+		minimum, known := encryptedSize(encrypted.Algorithm, 0)
+		if !known {
+			errs = append(errs, fmt.Errorf("%s: unknown encryption algorithm %q", link.Href, encrypted.Algorithm))
+			continue
+		}
+
+		size := int64(file.UncompressedSize64)
+		if encrypted.Compression != CompressionDeflate && encrypted.OriginalLength > 0 {
+			if expected, _ := encryptedSize(encrypted.Algorithm, int64(encrypted.OriginalLength)); size != expected {
+				errs = append(errs, fmt.Errorf("%s: %d encrypted bytes, %d expected for an original length of %d", link.Href, size, expected, encrypted.OriginalLength))
+			}
+			continue
+		}
+		if size < minimum {
+			errs = append(errs, fmt.Errorf("%s: %d encrypted bytes, at least %d expected", link.Href, size, minimum))
+		} else if encrypted.Algorithm == crypto.NewAESCBCEncrypter().Signature() && size%aes.BlockSize != 0 {
+			errs = append(errs, fmt.Errorf("%s: %d encrypted bytes, not a multiple of the AES block size", link.Href, size))
+		}
+	}
+	return errs
+}
+
+// ErrMissingContext is reported by Verify when the manifest has no @context,
+// which strict Readium clients reject
+var ErrMissingContext = errors.New("warning: the manifest has no @context")
+
+// PackageAndVerify encrypts a Readium package into out with the basic LCP profile and AES encryption,
+// closes out, then reopens it and runs Verify on the result.
+// It returns the reader of the output package and the problems found;
+// a packaging error is returned as a single problem, with a nil reader.
+func PackageAndVerify(reader *RWPPReader, out *os.File, opts PackOptions) (*RWPPReader, []error) {
+	writer, err := reader.NewWriterWithOptions(out, opts)
+	if err != nil {
+		out.Close()
+		return nil, []error{err}
+	}
+	// the package writer is closed by ProcessWithOptions
+	_, _, err = ProcessWithOptions(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer, opts)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	output, err := OpenRWPP(out.Name())
+	if err != nil {
+		return nil, []error{err}
+	}
+	return output, output.Verify()
+}
+
+// QuickVerifyFile opens a Readium package and runs QuickVerify on it.
+// A package which cannot be opened, or whose manifest cannot be parsed, is reported as a single error.
+func QuickVerifyFile(name string) []error {
+	zipArchive, err := zip.OpenReader(name)
+	if err != nil {
+		return []error{err}
+	}
+	defer zipArchive.Close()
+
+	reader, err := NewRWPPReader(&zipArchive.Reader)
+	if err != nil {
+		return []error{err}
+	}
+	return reader.QuickVerify()
+}
+
+// VerifyMediaOverlays checks that the audio and text targets of every media overlay
+// referenced by the manifest resolve within the package, and returns dangling references.
+func (reader *RWPPReader) VerifyMediaOverlays() []error {
+	var errs []error
+
+	for _, overlay := range reader.mediaOverlays() {
+		file := reader.file(overlay)
+		if file == nil {
+			// reported as a missing resource by Verify
+			continue
+		}
+		rc, err := file.Open()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", overlay, err))
+			continue
+		}
+		targets, err := smilTargets(rc)
+		rc.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", overlay, err))
+			continue
+		}
+		for _, target := range targets {
+			if isExternal(target) {
+				continue
+			}
+			resolved := path.Join(path.Dir(overlay), stripFragment(target))
+			if reader.file(resolved) == nil {
+				errs = append(errs, fmt.Errorf("%s: dangling reference to %s", overlay, target))
+			}
+		}
+	}
+	return errs
+}
+
+// localHrefs returns the hrefs of the manifest which should point inside the package,
+// without fragments and without duplicates.
+func (reader *RWPPReader) localHrefs() []string {
+	var hrefs []string
+	seen := map[string]bool{}
+	add := func(href string) {
+		href = stripFragment(href)
+		if href == "" || isExternal(href) || seen[href] {
+			return
+		}
+		seen[href] = true
+		hrefs = append(hrefs, href)
+	}
+
+	for _, href := range reader.manifest.AllHrefs() {
+		add(href)
+	}
+	for _, overlay := range reader.mediaOverlays() {
+		add(overlay)
+	}
+	return hrefs
+}
+
+// mediaOverlays returns the paths of the media overlays referenced by the manifest
+func (reader *RWPPReader) mediaOverlays() []string {
+	var overlays []string
+	seen := map[string]bool{}
+	for _, links := range [][]rwpm.Link{reader.manifest.ReadingOrder, reader.manifest.Resources} {
+		for _, link := range links {
+			var overlay string
+			if link.Properties != nil && link.Properties.MediaOverlay != "" {
+				overlay = link.Properties.MediaOverlay
+			} else if link.Type == ContentTypeSMIL {
+				overlay = link.Href
+			}
+			overlay = stripFragment(overlay)
+			if overlay == "" || isExternal(overlay) || seen[overlay] {
+				continue
+			}
+			seen[overlay] = true
+			overlays = append(overlays, overlay)
+		}
+	}
+	return overlays
+}
+
+// smilTargets returns the src attributes of the audio and text elements of a SMIL document
+func smilTargets(r io.Reader) ([]string, error) {
+	var targets []string
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return targets, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		element, ok := token.(xml.StartElement)
+		if !ok || (element.Name.Local != "audio" && element.Name.Local != "text") {
+			continue
+		}
+		for _, attr := range element.Attr {
+			if attr.Name.Local == "src" && attr.Value != "" {
+				targets = append(targets, attr.Value)
+			}
+		}
+	}
+}
+
+// stripFragment removes the fragment identifier of an href
+func stripFragment(href string) string {
+	if i := strings.IndexByte(href, '#'); i >= 0 {
+		return href[:i]
+	}
+	return href
+}
+
+// isExternal checks if an href is an absolute url
+func isExternal(href string) bool {
+	u, err := url.Parse(href)
+	return err == nil && u.IsAbs()
+}
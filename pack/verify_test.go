@@ -0,0 +1,340 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/readium/readium-lcp-server/crypto"
+	"github.com/readium/readium-lcp-server/license"
+	"github.com/readium/readium-lcp-server/rwpm"
+)
+
+const overlayTestManifest = `{
+	"@context": "https://readium.org/webpub-manifest/context.jsonld",
+	"metadata": {"title": "overlays"},
+	"readingOrder": [
+		{"href": "text/chapter1.xhtml", "type": "application/xhtml+xml", "properties": {"media-overlay": "smil/chapter1.smil"}}
+	],
+	"resources": [
+		{"href": "smil/chapter1.smil", "type": "application/smil+xml"},
+		{"href": "audio/chapter1.mp3", "type": "audio/mpeg"}
+	]
+}`
+
+const overlayTestSMIL = `<?xml version="1.0" encoding="UTF-8"?>
+<smil xmlns="http://www.w3.org/ns/SMIL" version="3.0">
+	<body>
+		<par id="p1">
+			<text src="../text/chapter1.xhtml#p1"/>
+			<audio src="../audio/chapter1.mp3" clipBegin="0:00:00.000" clipEnd="0:00:05.000"/>
+		</par>
+		<par id="p2">
+			<text src="../text/chapter1.xhtml#p2"/>
+			<audio src="../audio/chapter1.mp3" clipBegin="0:00:05.000" clipEnd="0:00:09.000"/>
+		</par>
+	</body>
+</smil>`
+
+func TestVerifyMediaOverlays(t *testing.T) {
+	reader := openTestRWPP(t,
+		testEntry{name: ManifestLocation, method: Deflate, body: []byte(overlayTestManifest)},
+		testEntry{name: "text/chapter1.xhtml", method: Deflate, body: []byte("<html/>")},
+		testEntry{name: "smil/chapter1.smil", method: Deflate, body: []byte(overlayTestSMIL)},
+		testEntry{name: "audio/chapter1.mp3", method: NoCompression, body: []byte("audio")},
+	)
+
+	if errs := reader.Verify(); len(errs) != 0 {
+		t.Errorf("Expected a valid package, got %v", errs)
+	}
+}
+
+func TestVerifyMissingAudioTarget(t *testing.T) {
+	smil := strings.Replace(overlayTestSMIL, `<audio src="../audio/chapter1.mp3" clipBegin="0:00:05.000"`, `<audio src="../audio/missing.mp3" clipBegin="0:00:05.000"`, 1)
+	reader := openTestRWPP(t,
+		testEntry{name: ManifestLocation, method: Deflate, body: []byte(overlayTestManifest)},
+		testEntry{name: "text/chapter1.xhtml", method: Deflate, body: []byte("<html/>")},
+		testEntry{name: "smil/chapter1.smil", method: Deflate, body: []byte(smil)},
+		testEntry{name: "audio/chapter1.mp3", method: NoCompression, body: []byte("audio")},
+	)
+
+	errs := reader.Verify()
+	if len(errs) != 1 {
+		t.Fatalf("Expected a single error, got %v", errs)
+	}
+	if !strings.Contains(errs[0].Error(), "missing.mp3") {
+		t.Errorf("Expected the dangling audio reference to be reported, got %s", errs[0])
+	}
+}
+
+func TestVerifyMissingResource(t *testing.T) {
+	reader := openTestRWPP(t,
+		testEntry{name: ManifestLocation, method: Deflate, body: []byte(overlayTestManifest)},
+		testEntry{name: "text/chapter1.xhtml", method: Deflate, body: []byte("<html/>")},
+		testEntry{name: "audio/chapter1.mp3", method: NoCompression, body: []byte("audio")},
+	)
+
+	errs := reader.Verify()
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "smil/chapter1.smil") {
+		t.Errorf("Expected the missing overlay to be reported once, got %v", errs)
+	}
+}
+
+func TestQuickVerify(t *testing.T) {
+	encryptedManifest := `{
+		"@context": "https://readium.org/webpub-manifest/context.jsonld",
+		"metadata": {"title": "encrypted"},
+		"readingOrder": [{"href": "chapter.html", "type": "text/html", "properties": {"encrypted": {
+			"scheme": "http://readium.org/2014/01/lcp", "algorithm": "http://www.w3.org/2001/04/xmlenc#aes256-cbc", "compression": "deflate"}}}]
+	}`
+	source := buildTestZip(t,
+		testEntry{name: ManifestLocation, method: Deflate, body: []byte(overlayTestManifest)},
+		testEntry{name: "text/chapter1.xhtml", method: Deflate, body: []byte("<html/>")},
+		testEntry{name: "smil/chapter1.smil", method: Deflate, body: []byte(overlayTestSMIL)},
+		testEntry{name: "audio/chapter1.mp3", method: NoCompression, body: []byte("audio")},
+	)
+	reader, err := NewRWPPReader(openTestZip(t, source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var processed bytes.Buffer
+	writer, err := reader.NewWriter(&processed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Process(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer); err != nil {
+		t.Fatalf("Could not process the package, %s", err)
+	}
+
+	tests := []struct {
+		name  string
+		data  []byte
+		valid bool
+	}{
+		{"clean", source, true},
+		{"processed", processed.Bytes(), true},
+		{"missing resource", buildTestZip(t,
+			testEntry{name: ManifestLocation, method: Deflate, body: []byte(overlayTestManifest)},
+			testEntry{name: "text/chapter1.xhtml", method: Deflate, body: []byte("<html/>")},
+			testEntry{name: "audio/chapter1.mp3", method: NoCompression, body: []byte("audio")},
+		), false},
+		{"stored", buildTestZip(t,
+			testEntry{name: ManifestLocation, method: Deflate, body: []byte(encryptedManifest)},
+			testEntry{name: "chapter.html", method: NoCompression, body: []byte("encrypted")},
+		), true},
+		{"deflated twice", buildTestZip(t,
+			testEntry{name: ManifestLocation, method: Deflate, body: []byte(encryptedManifest)},
+			testEntry{name: "chapter.html", method: Deflate, body: []byte("encrypted")},
+		), false},
+	}
+	for _, test := range tests {
+		reader, err := NewRWPPReader(openTestZip(t, test.data))
+		if err != nil {
+			t.Fatalf("%s: could not read the package, %s", test.name, err)
+		}
+		quick, full := reader.QuickVerify(), reader.Verify()
+		if (len(quick) == 0) != test.valid || (len(full) == 0) != test.valid {
+			t.Errorf("%s: expected the validity to be %t, got %v from QuickVerify and %v from Verify", test.name, test.valid, quick, full)
+		}
+	}
+}
+
+func TestQuickVerifyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "package.lcpdf")
+	data := buildTestZip(t, testEntry{name: ManifestLocation, method: Deflate, body: []byte("not json")})
+	if err = ioutil.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if errs := QuickVerifyFile(name); len(errs) != 1 {
+		t.Errorf("Expected an unparseable manifest to be reported, got %v", errs)
+	}
+	if errs := QuickVerifyFile(filepath.Join(dir, "missing.lcpdf")); len(errs) != 1 {
+		t.Errorf("Expected a missing package to be reported, got %v", errs)
+	}
+}
+
+func TestVerifyMimetype(t *testing.T) {
+	const audiobookManifest = `{
+		"@context": "https://readium.org/webpub-manifest/context.jsonld",
+		"metadata": {"title": "audiobook", "conformsTo": "https://readium.org/webpub-manifest/profiles/audiobook"},
+		"readingOrder": [{"href": "track1.mp3", "type": "audio/mpeg"}]
+	}`
+	tests := []struct {
+		mimetype string
+		valid    bool
+	}{
+		{"application/audiobook+zip", true},
+		{"application/audiobook+lcp", true},
+		{"application/webpub+zip", false},
+		{"application/audiobook+zip\n", false},
+	}
+	for _, test := range tests {
+		reader := openTestRWPP(t,
+			testEntry{name: "mimetype", method: NoCompression, body: []byte(test.mimetype)},
+			testEntry{name: ManifestLocation, method: Deflate, body: []byte(audiobookManifest)},
+			testEntry{name: "track1.mp3", method: NoCompression, body: []byte("audio")},
+		)
+		err := reader.VerifyMimetype()
+		if (err == nil) != test.valid {
+			t.Errorf("%q: expected the validity to be %t, got %v", test.mimetype, test.valid, err)
+		}
+		if errs := reader.Verify(); (len(errs) == 0) != test.valid {
+			t.Errorf("%q: expected Verify to agree with VerifyMimetype, got %v", test.mimetype, errs)
+		}
+	}
+}
+
+func TestPackageAndVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		entries []testEntry
+		opts    PackOptions
+		valid   bool
+	}{
+		{"clean", []testEntry{
+			{name: ManifestLocation, method: Deflate, body: []byte(overlayTestManifest)},
+			{name: "text/chapter1.xhtml", method: Deflate, body: []byte("<html/>")},
+			{name: "smil/chapter1.smil", method: Deflate, body: []byte(overlayTestSMIL)},
+			{name: "audio/chapter1.mp3", method: NoCompression, body: []byte("audio")},
+		}, PackOptions{}, true},
+		{"missing resource", []testEntry{
+			{name: ManifestLocation, method: Deflate, body: []byte(overlayTestManifest)},
+			{name: "text/chapter1.xhtml", method: Deflate, body: []byte("<html/>")},
+			{name: "smil/chapter1.smil", method: Deflate, body: []byte(overlayTestSMIL)},
+		}, PackOptions{}, false},
+		{"unknown compression", []testEntry{
+			{name: ManifestLocation, method: Deflate, body: []byte(overlayTestManifest)},
+			{name: "text/chapter1.xhtml", method: Deflate, body: []byte("<html/>")},
+			{name: "smil/chapter1.smil", method: Deflate, body: []byte(overlayTestSMIL)},
+			{name: "audio/chapter1.mp3", method: NoCompression, body: []byte("audio")},
+		}, PackOptions{Compression: "brotli"}, false},
+	}
+	for i, test := range tests {
+		out, err := os.Create(filepath.Join(dir, fmt.Sprintf("output%d.lcpau", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		output, errs := PackageAndVerify(openTestRWPP(t, test.entries...), out, test.opts)
+		if (len(errs) == 0) != test.valid {
+			t.Errorf("%s: expected the validity to be %t, got %v", test.name, test.valid, errs)
+		}
+		if test.valid && (output == nil || !isEncryptedLink(output.manifest.ReadingOrder[0])) {
+			t.Errorf("%s: expected the reader of an encrypted package", test.name)
+		}
+	}
+}
+
+func TestRepairContext(t *testing.T) {
+	const contextlessManifest = `{"metadata": {"title": "contextless"}, "readingOrder": [{"href": "chapter.html", "type": "text/html"}]}`
+	entries := []testEntry{
+		{name: ManifestLocation, method: Deflate, body: []byte(contextlessManifest)},
+		{name: "chapter.html", method: Deflate, body: []byte("<p>chapter</p>")},
+	}
+
+	errs := openTestRWPP(t, entries...).Verify()
+	if len(errs) != 1 || errs[0] != ErrMissingContext {
+		t.Errorf("Expected the missing context to be reported, got %v", errs)
+	}
+
+	for _, repair := range []bool{false, true} {
+		reader := openTestRWPP(t, entries...)
+		var b bytes.Buffer
+		writer, err := reader.NewWriterWithOptions(&b, PackOptions{RepairContext: repair})
+		if err != nil {
+			t.Fatalf("Could not build a writer, %s", err)
+		}
+		if _, err = Process(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer); err != nil {
+			t.Fatalf("Could not process the package, %s", err)
+		}
+
+		output, err := NewRWPPReader(openTestZip(t, b.Bytes()))
+		if err != nil {
+			t.Fatalf("Could not read the output package, %s", err)
+		}
+		repaired := len(output.manifest.Context) == 1 && output.manifest.Context[0] == rwpm.ContextURL
+		if repaired != repair {
+			t.Errorf("Expected the context to be repaired %t, got %v", repair, output.manifest.Context)
+		}
+		if errs := output.Verify(); (len(errs) == 0) != repair {
+			t.Errorf("Expected the repaired package to be valid, got %v", errs)
+		}
+	}
+}
+
+func TestVerifyEncryption(t *testing.T) {
+	const manifest = `{"metadata": {"title": "encryption"}, "readingOrder": [
+		{"href": "publication.pdf", "type": "application/pdf"},
+		{"href": "chapter.html", "type": "text/html"}]}`
+
+	// a healthy package, with a resource deflated before encryption
+	for _, mode := range []string{crypto.ModeCBC, crypto.ModeGCM} {
+		reader := openTestRWPP(t,
+			testEntry{name: ManifestLocation, method: Deflate, body: []byte(manifest)},
+			testEntry{name: "publication.pdf", method: Deflate, body: bytes.Repeat([]byte("%PDF"), 100)},
+			testEntry{name: "chapter.html", method: Deflate, body: bytes.Repeat([]byte("<p/>"), 100)},
+		)
+		reader.Policy = streamingPolicy{}
+		opts := PackOptions{EncryptionMode: mode}
+		encrypter, _ := opts.NewEncrypter()
+		var b bytes.Buffer
+		writer, err := reader.NewWriterWithOptions(&b, opts)
+		if err != nil {
+			t.Fatalf("Could not build a writer, %s", err)
+		}
+		if _, _, err = ProcessWithOptions(license.BasicProfile, encrypter, reader, writer, opts); err != nil {
+			t.Fatalf("Could not process the package, %s", err)
+		}
+		output, err := NewRWPPReader(openTestZip(t, b.Bytes()))
+		if err != nil {
+			t.Fatalf("Could not read the output package, %s", err)
+		}
+		if errs := output.VerifyEncryption(); len(errs) != 0 {
+			t.Errorf("%s: expected a healthy package, got %v", mode, errs)
+		}
+	}
+
+	cbc := crypto.NewAESCBCEncrypter().Signature()
+	tests := []struct {
+		name      string
+		encrypted string
+		body      []byte
+		expected  string
+	}{
+		{"stored empty", `{"profile": "http://readium.org/lcp/basic-profile", "algorithm": "` + cbc + `", "original-length": 8}`, nil, "0 encrypted bytes, 32 expected"},
+		{"empty without length", `{"profile": "http://readium.org/lcp/basic-profile", "algorithm": "` + cbc + `"}`, nil, "at least 32 expected"},
+		{"partial block", `{"profile": "http://readium.org/lcp/basic-profile", "algorithm": "` + cbc + `", "compression": "deflate"}`, make([]byte, 40), "not a multiple"},
+		{"unknown profile", `{"profile": "http://example.com/profile", "algorithm": "` + cbc + `"}`, make([]byte, 32), "unknown encryption profile"},
+		{"unknown algorithm", `{"profile": "http://readium.org/lcp/basic-profile", "algorithm": "rot13"}`, make([]byte, 32), "unknown encryption algorithm"},
+	}
+	for _, test := range tests {
+		reader := openTestRWPP(t,
+			testEntry{name: ManifestLocation, method: Deflate, body: []byte(`{"metadata": {"title": "broken"}, "readingOrder": [
+				{"href": "publication.pdf", "type": "application/pdf", "properties": {"encrypted": ` + test.encrypted + `}}]}`)},
+			testEntry{name: "publication.pdf", method: NoCompression, body: test.body},
+		)
+		errs := reader.VerifyEncryption()
+		if len(errs) != 1 || !strings.Contains(errs[0].Error(), test.expected) {
+			t.Errorf("%s: expected a problem containing %q, got %v", test.name, test.expected, errs)
+		}
+	}
+}
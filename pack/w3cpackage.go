@@ -13,6 +13,8 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -116,9 +118,9 @@ func generateRWPManifest(w3cman rwpm.W3CPublication) (manifest rwpm.Publication)
 
 	//displayW3CMan(w3cman)
 
-	manifest.Context = []string{"https://readium.org/webpub-manifest/context.jsonld"}
+	manifest.Context = []string{rwpm.ContextURL}
 
-	if w3cman.ConformsTo == "https://www.w3/org/TR/audiobooks/" {
+	if w3cman.ConformsTo == rwpm.W3CAudiobookProfile {
 		manifest.Metadata.Type = "https://schema.org/Audiobook"
 	} else {
 		manifest.Metadata.Type = "https://schema.org/CreativeWork"
@@ -166,8 +168,89 @@ func generateRWPManifest(w3cman rwpm.W3CPublication) (manifest rwpm.Publication)
 	return
 }
 
+// mapW3CMultiLanguage maps a multilingual property from a Readium manifest to a W3C manifest,
+// sorted by language; the "und" language gives a literal value
+func mapW3CMultiLanguage(ml rwpm.MultiLanguage) (w3clp rwpm.W3CMultiLanguage) {
+
+	languages := make([]string, 0, len(ml))
+	for language := range ml {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	for _, language := range languages {
+		w3clp = append(w3clp, rwpm.W3CLocalized{Language: language, Value: ml[language]})
+	}
+	return
+}
+
+// mapW3CLinks copies a collection of links (reading order, resources ...)
+// from a Readium manifest to a W3C manifest
+func mapW3CLinks(rwpmLinks []rwpm.Link) (w3clinks []rwpm.W3CLink) {
+
+	for _, rwpml := range rwpmLinks {
+		var w3cl rwpm.W3CLink
+		w3cl.URL = rwpml.Href
+		w3cl.EncodingFormat = rwpml.Type
+		w3cl.Rel = rwpml.Rel
+		if rwpml.Title != "" {
+			w3cl.Name = rwpm.W3CMultiLanguage{{Language: "und", Value: rwpml.Title}}
+		}
+		w3cl.Duration = scToISODuration(rwpml.Duration)
+		w3cl.Alternate = mapW3CLinks(rwpml.Alternate)
+
+		w3clinks = append(w3clinks, w3cl)
+	}
+	return
+}
+
+// generateW3CManifest generates a W3C manifest out of a Readium manifest,
+// the reverse of generateRWPManifest
+func generateW3CManifest(manifest rwpm.Publication) (w3cman rwpm.W3CPublication) {
+
+	w3cman.Context = rwpm.W3CContext
+	audiobook := manifest.Metadata.Type == "https://schema.org/Audiobook"
+	for _, profile := range manifest.Metadata.ConformsTo {
+		audiobook = audiobook || profile == rwpm.ProfileAudiobook
+	}
+	if audiobook {
+		w3cman.Type = rwpm.MultiString{"Audiobook"}
+		w3cman.ConformsTo = rwpm.W3CAudiobookProfile
+	} else {
+		w3cman.Type = rwpm.MultiString{"CreativeWork"}
+	}
+
+	w3cman.ID = manifest.Metadata.Identifier
+	w3cman.Name = mapW3CMultiLanguage(manifest.Metadata.Title)
+	w3cman.Description = manifest.Metadata.Description
+	w3cman.Subject = manifest.Metadata.Subject
+	w3cman.InLanguage = manifest.Metadata.Language
+	w3cman.DatePublished = rwpm.DateOrDatetime(time.Time(manifest.Metadata.Published))
+	w3cman.DateModified = rwpm.DateOrDatetime(manifest.Metadata.Modified)
+	w3cman.Duration = scToISODuration(manifest.Metadata.Duration)
+	w3cman.ReadingProgression = manifest.Metadata.ReadingProgression
+
+	w3cman.Publisher = manifest.Metadata.Publisher
+	w3cman.Artist = manifest.Metadata.Artist
+	w3cman.Author = manifest.Metadata.Author
+	w3cman.Colorist = manifest.Metadata.Colorist
+	w3cman.Contributor = manifest.Metadata.Contributor
+	w3cman.Editor = manifest.Metadata.Editor
+	w3cman.Illustrator = manifest.Metadata.Illustrator
+	w3cman.Inker = manifest.Metadata.Inker
+	w3cman.Letterer = manifest.Metadata.Letterer
+	w3cman.Penciler = manifest.Metadata.Penciler
+	w3cman.ReadBy = manifest.Metadata.Narrator
+	w3cman.Translator = manifest.Metadata.Translator
+
+	w3cman.Links = mapW3CLinks(manifest.Links)
+	w3cman.ReadingOrder = mapW3CLinks(manifest.ReadingOrder)
+	w3cman.Resources = mapW3CLinks(manifest.Resources)
+
+	return
+}
+
 // BuildRWPPFromLPF builds a Readium package (rwpp) from a W3C LPF file (lpfPath)
-func BuildRWPPFromLPF(lpfPath string, rwppPath string) error {
+func BuildRWPPFromLPF(lpfPath string, rwppPath string) (err error) {
 
 	// open the lpf file
 	lpfFile, err := zip.OpenReader(lpfPath)
@@ -185,9 +268,9 @@ func BuildRWPPFromLPF(lpfPath string, rwppPath string) error {
 			if err != nil {
 				return err
 			}
-			defer m.Close()
 			decoder := json.NewDecoder(m)
 			err = decoder.Decode(&w3cManifest)
+			m.Close()
 			if err != nil {
 				return err
 			}
@@ -214,18 +297,28 @@ func BuildRWPPFromLPF(lpfPath string, rwppPath string) error {
 
 	// create the rwpp file
 	rwppFile, err := os.Create(rwppPath)
-	defer rwppFile.Close()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// a close error must not be masked by a prior nil
+		if closeErr := rwppFile.Close(); err == nil {
+			err = closeErr
+		}
+	}()
 
 	// create a zip writer on the rwpp
 	zipWriter := zip.NewWriter(rwppFile)
-	defer zipWriter.Close()
 
 	// Add the Readium manifest to the rwpp
 	man, err := zipWriter.Create(RWPManifestName)
 	if err != nil {
-		return err
+		return closeZipWriter(zipWriter, err)
 	}
 	_, err = man.Write(rwpJSON)
+	if err != nil {
+		return closeZipWriter(zipWriter, err)
+	}
 
 	// Append every lpf resource to the rwpp
 	for _, file := range lpfFile.File {
@@ -238,19 +331,19 @@ func BuildRWPPFromLPF(lpfPath string, rwppPath string) error {
 		writer, err := zipWriter.CreateHeader(&file.FileHeader)
 		// writer, err := zipWriter.Create(file.Name)
 		if err != nil {
-			return err
+			return closeZipWriter(zipWriter, err)
 		}
 		reader, err := file.Open()
 		if err != nil {
-			return err
+			return closeZipWriter(zipWriter, err)
 		}
-		defer reader.Close()
 		_, err = io.Copy(writer, reader)
+		reader.Close()
 		if err != nil {
-			return err
+			return closeZipWriter(zipWriter, err)
 		}
 	}
-	return nil
+	return closeZipWriter(zipWriter, nil)
 }
 
 // newUUID generates a random UUID according to RFC 4122
@@ -268,10 +361,18 @@ func newUUID() (string, error) {
 	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:]), nil
 }
 
+// scToISODuration transforms a number of seconds to an ISO duration, or an empty string if zero
+func scToISODuration(seconds float64) string {
+	if seconds <= 0 {
+		return ""
+	}
+	return "PT" + strconv.FormatFloat(seconds, 'f', -1, 64) + "S"
+}
+
 // isoDurationToSc transforms an ISO duration to a number of seconds
-func isoDurationToSc(iso string) (seconds int, err error) {
+func isoDurationToSc(iso string) (seconds float64, err error) {
 	period, err := period.Parse(iso)
-	seconds = period.Hours()*3600 + period.Minutes()*60 + period.Seconds()
+	seconds = float64(period.Hours()*3600 + period.Minutes()*60 + period.Seconds())
 	return
 }
 
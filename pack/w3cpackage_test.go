@@ -5,10 +5,13 @@
 package pack
 
 import (
+	"bytes"
 	"encoding/json"
 	"os"
 	"testing"
 
+	"github.com/readium/readium-lcp-server/crypto"
+	"github.com/readium/readium-lcp-server/license"
 	"github.com/readium/readium-lcp-server/rwpm"
 )
 
@@ -89,3 +92,67 @@ func TestMapW3CPublication(t *testing.T) {
 	}
 
 }
+
+// TestGenerateW3CManifest tests the W3C manifest written alongside the Readium manifest of an audiobook
+func TestGenerateW3CManifest(t *testing.T) {
+	const manifest = `{"metadata": {"@type": "https://schema.org/Audiobook", "identifier": "id1", "title": "audiotest", "duration": 90},
+		"readingOrder": [
+			{"href": "audio/chapter1.mp3", "type": "audio/mpeg", "title": "Chapter 1", "duration": 60},
+			{"href": "audio/chapter2.mp3", "type": "audio/mpeg", "duration": 30.5}]}`
+
+	for _, generate := range []bool{false, true} {
+		reader := openTestRWPP(t,
+			testEntry{name: ManifestLocation, method: Deflate, body: []byte(manifest)},
+			testEntry{name: "audio/chapter1.mp3", method: NoCompression, body: []byte("mp3 1")},
+			testEntry{name: "audio/chapter2.mp3", method: NoCompression, body: []byte("mp3 2")},
+		)
+		opts := PackOptions{W3CManifest: generate}
+		var b bytes.Buffer
+		writer, err := reader.NewWriterWithOptions(&b, opts)
+		if err != nil {
+			t.Fatalf("Could not build a writer, %s", err)
+		}
+		if _, _, err = ProcessWithOptions(license.BasicProfile, crypto.NewAESEncrypter_PUBLICATION_RESOURCES(), reader, writer, opts); err != nil {
+			t.Fatalf("Could not process the package, %s", err)
+		}
+
+		zr := openTestZip(t, b.Bytes())
+		found := false
+		for _, file := range zr.File {
+			found = found || file.Name == W3CManifestName
+		}
+		if found != generate {
+			t.Fatalf("Expected a W3C manifest to be written: %t, got %t", generate, found)
+		}
+		if !generate {
+			continue
+		}
+
+		var w3cManifest rwpm.W3CPublication
+		if err = json.Unmarshal(readZipEntry(t, zr, W3CManifestName), &w3cManifest); err != nil {
+			t.Fatalf("Could not unmarshal the W3C manifest, %s", err)
+		}
+		if w3cManifest.ConformsTo != rwpm.W3CAudiobookProfile || w3cManifest.Name.Text() != "audiotest" || w3cManifest.ID != "id1" {
+			t.Errorf("W3C metadata badly generated, got %#v", w3cManifest)
+		}
+
+		// the reading order matches the Readium one, and maps back to it
+		readium := readOutputManifest(t, b.Bytes()).ReadingOrder
+		if len(w3cManifest.ReadingOrder) != len(readium) {
+			t.Fatalf("Expected %d items in the W3C reading order, got %d", len(readium), len(w3cManifest.ReadingOrder))
+		}
+		for i, link := range mapLinks(w3cManifest.ReadingOrder) {
+			if link.Href != readium[i].Href || link.Type != readium[i].Type || link.Title != readium[i].Title {
+				t.Errorf("W3C reading order item %d badly generated, got %#v", i, w3cManifest.ReadingOrder[i])
+			}
+		}
+		for i, duration := range []string{"PT60S", "PT30.5S"} {
+			if w3cManifest.ReadingOrder[i].Duration != duration {
+				t.Errorf("Expected the duration %s for item %d, got %s", duration, i, w3cManifest.ReadingOrder[i].Duration)
+			}
+		}
+		if back := generateRWPManifest(w3cManifest); back.Metadata.Duration != 90 || back.Metadata.Type != "https://schema.org/Audiobook" {
+			t.Errorf("Expected the W3C manifest to map back to the Readium metadata, got %#v", back.Metadata)
+		}
+	}
+}
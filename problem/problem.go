@@ -54,6 +54,14 @@ type Problem struct {
 	Detail   string `json:"detail,omitempty"`
 	Instance string `json:"instance,omitempty"`
 	//Additional members
+	RequestID        string            `json:"request_id,omitempty"` // correlation id of the request, see HeaderRequestID
+	ValidationErrors []ValidationError `json:"validation_errors,omitempty"`
+}
+
+// ValidationError reports an invalid field of a request body
+type ValidationError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
 }
 
 const ERROR_BASE_URL = "http://readium.org/license-status-document/error/"
@@ -75,6 +83,9 @@ func Error(w http.ResponseWriter, r *http.Request, problem Problem, status int)
 	w.WriteHeader(status)
 
 	problem.Status = status
+	if problem.RequestID == "" {
+		problem.RequestID = RequestID(r.Context())
+	}
 
 	if problem.Type == "about:blank" || problem.Type == "" { // lookup Title  statusText should match http status
 		localization.LocalizeMessage(acceptLanguages, &problem.Title, http.StatusText(status))
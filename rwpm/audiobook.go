@@ -0,0 +1,65 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package rwpm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ContextURL is the json-ld context of a Readium manifest
+const ContextURL = "https://readium.org/webpub-manifest/context.jsonld"
+
+// AudiobookBuilder builds a minimal audiobook manifest, track by track
+type AudiobookBuilder struct {
+	title  string
+	tracks []Link
+}
+
+// NewAudiobookManifest starts building an audiobook manifest with a title
+func NewAudiobookManifest(title string) *AudiobookBuilder {
+	return &AudiobookBuilder{title: title}
+}
+
+// AddTrack appends an audio track to the reading order; its duration is in seconds
+func (b *AudiobookBuilder) AddTrack(href, mediaType string, duration float64) *AudiobookBuilder {
+	b.tracks = append(b.tracks, Link{Href: href, Type: mediaType, Duration: duration})
+	return b
+}
+
+// Build validates the tracks and returns the audiobook manifest
+func (b *AudiobookBuilder) Build() (Publication, error) {
+	var publication Publication
+
+	if b.title == "" {
+		return publication, errors.New("an audiobook must have a title")
+	}
+	if len(b.tracks) == 0 {
+		return publication, errors.New("an audiobook must have at least one track")
+	}
+
+	var duration float64
+	for i, track := range b.tracks {
+		if track.Href == "" {
+			return publication, fmt.Errorf("track %d has no href", i)
+		}
+		if !strings.HasPrefix(track.Type, "audio/") {
+			return publication, fmt.Errorf("track %s has a non-audio media type %q", track.Href, track.Type)
+		}
+		if track.Duration < 0 {
+			return publication, fmt.Errorf("track %s has a negative duration", track.Href)
+		}
+		duration += track.Duration
+	}
+
+	publication.Context = MultiString{ContextURL}
+	publication.Metadata.Type = "https://schema.org/Audiobook"
+	publication.Metadata.ConformsTo = MultiString{ProfileAudiobook}
+	publication.Metadata.Title.SetDefault(b.title)
+	publication.Metadata.Duration = duration
+	publication.ReadingOrder = append([]Link(nil), b.tracks...)
+	return publication, nil
+}
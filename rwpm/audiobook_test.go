@@ -0,0 +1,45 @@
+package rwpm
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestAudiobookBuilder(t *testing.T) {
+	publication, err := NewAudiobookManifest("Moby Dick").
+		AddTrack("track1.mp3", "audio/mpeg", 120.5).
+		AddTrack("track2.mp3", "audio/mpeg", 60).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(publication)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the zero values of modified and published are not omitted
+	expected := `{"@context":"https://readium.org/webpub-manifest/context.jsonld",` +
+		`"metadata":{"@type":"https://schema.org/Audiobook","conformsTo":"https://readium.org/webpub-manifest/profiles/audiobook",` +
+		`"title":"Moby Dick","modified":"0001-01-01T00:00:00Z","published":"0001-01-01","duration":180.5},` +
+		`"readingOrder":[{"href":"track1.mp3","type":"audio/mpeg","duration":120.5},{"href":"track2.mp3","type":"audio/mpeg","duration":60}]}`
+	if !bytes.Equal(data, []byte(expected)) {
+		t.Errorf("Expected %s, got %s", expected, data)
+	}
+}
+
+func TestAudiobookBuilderValidation(t *testing.T) {
+	builders := map[string]*AudiobookBuilder{
+		"no title":      NewAudiobookManifest("").AddTrack("track1.mp3", "audio/mpeg", 10),
+		"no track":      NewAudiobookManifest("Moby Dick"),
+		"no href":       NewAudiobookManifest("Moby Dick").AddTrack("", "audio/mpeg", 10),
+		"not audio":     NewAudiobookManifest("Moby Dick").AddTrack("track1.html", "text/html", 10),
+		"negative time": NewAudiobookManifest("Moby Dick").AddTrack("track1.mp3", "audio/mpeg", -1),
+	}
+	for name, builder := range builders {
+		if _, err := builder.Build(); err == nil {
+			t.Errorf("Expected an error for a manifest with %s", name)
+		}
+	}
+}
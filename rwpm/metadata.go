@@ -13,6 +13,7 @@ import (
 // Metadata for the default context in WebPub
 type Metadata struct {
 	Type               string        `json:"@type,omitempty"`
+	ConformsTo         MultiString   `json:"conformsTo,omitempty"`
 	Identifier         string        `json:"identifier,omitempty"`
 	Title              MultiLanguage `json:"title"`
 	Subtitle           MultiLanguage `json:"subtitle,omitempty"`
@@ -38,7 +39,7 @@ type Metadata struct {
 	Translator  Contributors `json:"translator,omitempty"`
 	// other descriptive metadata
 	Subject       Subjects `json:"subject,omitempty"`
-	Duration      int      `json:"duration,omitempty"`
+	Duration      float64  `json:"duration,omitempty"`
 	NumberOfPages int      `json:"numberOfPages,omitempty"`
 	Abridged      bool     `json:"abridged,omitempty"`
 	// collections & series
@@ -133,6 +134,8 @@ type Encrypted struct {
 	Algorithm      string `json:"algorithm,omitempty"`
 	Compression    string `json:"compression,omitempty"`
 	OriginalLength int    `json:"original-length,omitempty"`
+	// Checksum is the hex encoded SHA-256 hash of the plaintext resource
+	Checksum string `json:"checksum,omitempty"`
 }
 
 // Subjects is an array of subjects
@@ -6,11 +6,21 @@ package rwpm
 
 import (
 	"errors"
+	"mime"
 	"path"
 	"strings"
 )
 
+// Readium profiles, used in the conformsTo metadata
+const (
+	ProfileAudiobook = "https://readium.org/webpub-manifest/profiles/audiobook"
+	ProfileDivina    = "https://readium.org/webpub-manifest/profiles/divina"
+	ProfileEPUB      = "https://readium.org/webpub-manifest/profiles/epub"
+	ProfilePDF       = "https://readium.org/webpub-manifest/profiles/pdf"
+)
+
 // Publication = Readium manifest
+// Empty collections are omitted when the manifest is serialized, rather than emitted as empty arrays.
 type Publication struct {
 	Context      MultiString `json:"@context,omitempty"`
 	Metadata     Metadata    `json:"metadata"`
@@ -38,7 +48,7 @@ type Link struct {
 	Rel        MultiString `json:"rel,omitempty"`
 	Height     int         `json:"height,omitempty"`
 	Width      int         `json:"width,omitempty"`
-	Duration   int         `json:"duration,omitempty"`
+	Duration   float64     `json:"duration,omitempty"`
 	Bitrate    int         `json:"bitrate,omitempty"`
 	Properties *Properties `json:"properties,omitempty"`
 	Alternate  []Link      `json:"alternate,omitempty"`
@@ -63,6 +73,33 @@ func (publication *Publication) NavDoc() (Link, error) {
 	return publication.searchLinkByRel("contents")
 }
 
+// LicenseLink returns the link to the LCP license of the publication
+func (publication *Publication) LicenseLink() (Link, error) {
+	return publication.linkByRel("license")
+}
+
+// StatusLink returns the link to the LCP status document of the publication
+func (publication *Publication) StatusLink() (Link, error) {
+	return publication.linkByRel("status")
+}
+
+// HintLink returns the link to the LCP passphrase hint of the publication
+func (publication *Publication) HintLink() (Link, error) {
+	return publication.linkByRel("hint")
+}
+
+// linkByRel returns the link of the links collection which has a specific relation
+func (publication *Publication) linkByRel(rel string) (Link, error) {
+	for _, link := range publication.Links {
+		for _, linkRel := range link.Rel {
+			if linkRel == rel {
+				return link, nil
+			}
+		}
+	}
+	return Link{}, errors.New("Can't find " + rel + " in publication links")
+}
+
 // SearchLinkByRel returns the link which has a specific relation
 func (publication *Publication) searchLinkByRel(rel string) (Link, error) {
 	for _, resource := range publication.Resources {
@@ -92,6 +129,61 @@ func (publication *Publication) searchLinkByRel(rel string) (Link, error) {
 	return Link{}, errors.New("Can't find " + rel + " in publication")
 }
 
+// AllHrefs returns the hrefs of the reading order then of the resources, in document order:
+// each link is followed by its alternates, then by its children.
+// Duplicates and templated hrefs are skipped.
+func (publication *Publication) AllHrefs() []string {
+	var hrefs []string
+	seen := map[string]bool{}
+
+	var walk func(links []Link)
+	walk = func(links []Link) {
+		for _, link := range links {
+			if !link.Templated && link.Href != "" && !seen[link.Href] {
+				seen[link.Href] = true
+				hrefs = append(hrefs, link.Href)
+			}
+			walk(link.Alternate)
+			walk(link.Children)
+		}
+	}
+	walk(publication.ReadingOrder)
+	walk(publication.Resources)
+	return hrefs
+}
+
+// lcpMediaTypes lists the media types of the resources LCP can protect in a reading order;
+// a trailing slash stands for any subtype
+var lcpMediaTypes = []string{
+	"audio/", "video/", "image/",
+	"application/pdf", "text/html", "application/xhtml+xml",
+}
+
+// ValidateLCPMediaTypes returns a warning for each reading order entry
+// whose media type is not supported by LCP. Entries without a media type are not checked.
+func (publication *Publication) ValidateLCPMediaTypes() []string {
+	var warnings []string
+	for _, link := range publication.ReadingOrder {
+		if link.Type != "" && !isLCPMediaType(link.Type) {
+			warnings = append(warnings, "the media type "+link.Type+" of "+link.Href+" is not supported by LCP")
+		}
+	}
+	return warnings
+}
+
+// isLCPMediaType checks if a media type, possibly with parameters, is supported by LCP
+func isLCPMediaType(mediaType string) bool {
+	if parsed, _, err := mime.ParseMediaType(mediaType); err == nil {
+		mediaType = parsed
+	}
+	for _, supported := range lcpMediaTypes {
+		if mediaType == supported || (strings.HasSuffix(supported, "/") && strings.HasPrefix(mediaType, supported)) {
+			return true
+		}
+	}
+	return false
+}
+
 // AddLink Adds a link to a publication
 func (publication *Publication) AddLink(linkType string, rel []string, url string, templated bool) {
 	link := Link{
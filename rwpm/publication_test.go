@@ -0,0 +1,135 @@
+package rwpm
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestLinkDimensions(t *testing.T) {
+	const manifest = `{"metadata":{"title":"comic"},"readingOrder":[{"href":"page1.jpg","type":"image/jpeg","height":1600,"width":1200}]}`
+
+	var publication Publication
+	if err := json.Unmarshal([]byte(manifest), &publication); err != nil {
+		t.Fatal(err)
+	}
+	link := publication.ReadingOrder[0]
+	if link.Height != 1600 || link.Width != 1200 {
+		t.Errorf("Expected a 1200x1600 image, got %dx%d", link.Width, link.Height)
+	}
+
+	data, err := json.Marshal(publication.ReadingOrder[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"height":1600,"width":1200`) {
+		t.Errorf("Expected the dimensions to be marshalled, got %s", data)
+	}
+
+	// dimensions are omitted when unknown
+	data, _ = json.Marshal(Link{Href: "page2.jpg"})
+	if strings.Contains(string(data), "height") || strings.Contains(string(data), "width") {
+		t.Errorf("Did not expect dimensions, got %s", data)
+	}
+}
+
+func TestLCPLinks(t *testing.T) {
+	const manifest = `{"metadata":{"title":"protected"},
+		"links":[
+			{"rel":"license","href":"https://lcp.example.com/licenses/1","type":"application/vnd.readium.lcp.license.v1.0+json"},
+			{"rel":["status"],"href":"https://lsd.example.com/licenses/1/status","type":"application/vnd.readium.license.status.v1.0+json"},
+			{"rel":"hint","href":"https://example.com/hint","type":"text/html"}],
+		"readingOrder":[{"href":"publication.pdf","type":"application/pdf"}]}`
+
+	var publication Publication
+	if err := json.Unmarshal([]byte(manifest), &publication); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		get  func() (Link, error)
+		href string
+	}{
+		{publication.LicenseLink, "https://lcp.example.com/licenses/1"},
+		{publication.StatusLink, "https://lsd.example.com/licenses/1/status"},
+		{publication.HintLink, "https://example.com/hint"},
+	}
+	for _, test := range tests {
+		link, err := test.get()
+		if err != nil {
+			t.Errorf("Expected a link to %s, got %s", test.href, err)
+		} else if link.Href != test.href {
+			t.Errorf("Expected a link to %s, got %s", test.href, link.Href)
+		}
+	}
+
+	var empty Publication
+	if _, err := empty.LicenseLink(); err == nil {
+		t.Error("Expected no license link")
+	}
+}
+
+func TestAllHrefs(t *testing.T) {
+	const manifest = `{"metadata":{"title":"nested"},
+		"readingOrder":[
+			{"href":"chapter1.html","type":"text/html",
+				"alternate":[{"href":"chapter1.pdf","type":"application/pdf"}],
+				"children":[{"href":"chapter1.html#s1"},{"href":"section2.html","children":[{"href":"section3.html"}]}]},
+			{"href":"chapter2.html","type":"text/html"}],
+		"resources":[
+			{"href":"style.css","type":"text/css"},
+			{"href":"chapter1.pdf","type":"application/pdf"},
+			{"href":"search{?q}","templated":true},
+			{"href":"cover.jpg","type":"image/jpeg","alternate":[{"href":"cover.webp","type":"image/webp"}]}]}`
+
+	var publication Publication
+	if err := json.Unmarshal([]byte(manifest), &publication); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"chapter1.html", "chapter1.pdf", "chapter1.html#s1", "section2.html", "section3.html",
+		"chapter2.html", "style.css", "cover.jpg", "cover.webp"}
+	hrefs := publication.AllHrefs()
+	if strings.Join(hrefs, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected %v, got %v", expected, hrefs)
+	}
+}
+
+func TestEmptyCollections(t *testing.T) {
+	publication := Publication{
+		ReadingOrder: []Link{{Href: "chapter.html", Type: "text/html"}},
+		Resources:    []Link{},
+		TOC:          []Link{},
+		Links:        []Link{},
+	}
+	publication.Metadata.Title.SetDefault("empty")
+
+	b, err := json.Marshal(publication)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out map[string]json.RawMessage
+	if err = json.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"resources", "toc", "links"} {
+		if _, ok := out[name]; ok {
+			t.Errorf("Did not expect an empty %s collection in %s", name, b)
+		}
+	}
+	if _, ok := out["readingOrder"]; !ok {
+		t.Errorf("Expected the reading order in %s", b)
+	}
+}
+
+func TestValidateLCPMediaTypes(t *testing.T) {
+	publication := Publication{ReadingOrder: []Link{
+		{Href: "chapter1.html", Type: "text/html; charset=utf-8"},
+		{Href: "track1.mp3", Type: "audio/mpeg"},
+		{Href: "data.bin", Type: "application/octet-stream"},
+		{Href: "untyped"},
+	}}
+
+	warnings := publication.ValidateLCPMediaTypes()
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "data.bin") {
+		t.Errorf("Expected a single warning about data.bin, got %v", warnings)
+	}
+}
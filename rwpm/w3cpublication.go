@@ -6,49 +6,75 @@ package rwpm
 
 import (
 	"encoding/json"
+	"time"
 )
 
 // W3CPublication = W3C manifest
 type W3CPublication struct {
-	ConformsTo         string
-	ID                 string
-	URL                string
-	Name               W3CMultiLanguage
-	Publisher          Contributors
-	Artist             Contributors
-	Author             Contributors
-	Colorist           Contributors
-	Contributor        Contributors
-	Creator            Contributors
-	Editor             Contributors
-	Illustrator        Contributors
-	Inker              Contributors
-	Letterer           Contributors
-	Penciler           Contributors
-	ReadBy             Contributors
-	Translator         Contributors
-	InLanguage         MultiString
-	DatePublished      DateOrDatetime
-	DateModified       DateOrDatetime
-	ReadingProgression string
-	Duration           string
-	Description        string    `json:"dcterms:description,omitempty"`
-	Subject            []Subject `json:"dcterms:subject,omitempty"`
-	Links              []W3CLink
-	ReadingOrder       []W3CLink
-	Resources          []W3CLink
+	Context            MultiString      `json:"@context,omitempty"`
+	Type               MultiString      `json:"type,omitempty"`
+	ConformsTo         string           `json:"conformsTo,omitempty"`
+	ID                 string           `json:"id,omitempty"`
+	URL                string           `json:"url,omitempty"`
+	Name               W3CMultiLanguage `json:"name,omitempty"`
+	Publisher          Contributors     `json:"publisher,omitempty"`
+	Artist             Contributors     `json:"artist,omitempty"`
+	Author             Contributors     `json:"author,omitempty"`
+	Colorist           Contributors     `json:"colorist,omitempty"`
+	Contributor        Contributors     `json:"contributor,omitempty"`
+	Creator            Contributors     `json:"creator,omitempty"`
+	Editor             Contributors     `json:"editor,omitempty"`
+	Illustrator        Contributors     `json:"illustrator,omitempty"`
+	Inker              Contributors     `json:"inker,omitempty"`
+	Letterer           Contributors     `json:"letterer,omitempty"`
+	Penciler           Contributors     `json:"penciler,omitempty"`
+	ReadBy             Contributors     `json:"readBy,omitempty"`
+	Translator         Contributors     `json:"translator,omitempty"`
+	InLanguage         MultiString      `json:"inLanguage,omitempty"`
+	DatePublished      DateOrDatetime   `json:"datePublished"`
+	DateModified       DateOrDatetime   `json:"dateModified"`
+	ReadingProgression string           `json:"readingProgression,omitempty"`
+	Duration           string           `json:"duration,omitempty"`
+	Description        string           `json:"dcterms:description,omitempty"`
+	Subject            []Subject        `json:"dcterms:subject,omitempty"`
+	Links              []W3CLink        `json:"links,omitempty"`
+	ReadingOrder       []W3CLink        `json:"readingOrder,omitempty"`
+	Resources          []W3CLink        `json:"resources,omitempty"`
+}
+
+// W3CContext is the context of a W3C manifest
+var W3CContext = MultiString{"https://schema.org", "https://www.w3.org/ns/pub-context"}
+
+// W3CAudiobookProfile is the profile of a W3C audiobook manifest
+const W3CAudiobookProfile = "https://www.w3.org/TR/audiobooks/"
+
+// MarshalJSON marshalls a W3C manifest, omitting the dates which are not set
+func (p W3CPublication) MarshalJSON() ([]byte, error) {
+	type alias W3CPublication
+	out := struct {
+		alias
+		DatePublished *DateOrDatetime `json:"datePublished,omitempty"`
+		DateModified  *DateOrDatetime `json:"dateModified,omitempty"`
+	}{alias: alias(p)}
+	if !time.Time(p.DatePublished).IsZero() {
+		out.DatePublished = &p.DatePublished
+	}
+	if !time.Time(p.DateModified).IsZero() {
+		out.DateModified = &p.DateModified
+	}
+	return json.Marshal(out)
 }
 
 // W3CLink object
 type W3CLink struct {
-	URL            string
-	EncodingFormat string
-	Name           W3CMultiLanguage
-	Description    W3CMultiLanguage
-	Rel            MultiString
-	Integrity      string
-	Duration       string
-	Alternate      []W3CLink
+	URL            string           `json:"url"`
+	EncodingFormat string           `json:"encodingFormat,omitempty"`
+	Name           W3CMultiLanguage `json:"name,omitempty"`
+	Description    W3CMultiLanguage `json:"description,omitempty"`
+	Rel            MultiString      `json:"rel,omitempty"`
+	Integrity      string           `json:"integrity,omitempty"`
+	Duration       string           `json:"duration,omitempty"`
+	Alternate      []W3CLink        `json:"alternate,omitempty"`
 }
 
 // W3CMultiLanguage struct
@@ -99,7 +125,7 @@ func (m W3CMultiLanguage) Text() string {
 		}
 	}
 	if len(m) == 1 {
-		return m.Text()
+		return m[0].Value
 	}
 	return ""
 }
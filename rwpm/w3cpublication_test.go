@@ -7,6 +7,7 @@ package rwpm
 import (
 	"encoding/json"
 	"testing"
+	"time"
 )
 
 type W3CmultiLanguageStruct struct {
@@ -50,3 +51,31 @@ func TestW3CMultiLanguage(t *testing.T) {
 		t.Errorf("Expected string equality, got %#v", string(jstring))
 	}
 }
+
+func TestW3CPublicationMarshal(t *testing.T) {
+	var w3cman W3CPublication
+	w3cman.Name = W3CMultiLanguage{{Language: "und", Value: "name"}}
+	w3cman.ReadingOrder = []W3CLink{{URL: "chapter1.mp3", Duration: "PT60S"}}
+
+	jstring, err := json.Marshal(w3cman)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const expected = `{"name":"name","readingOrder":[{"url":"chapter1.mp3","duration":"PT60S"}]}`
+	if string(jstring) != expected {
+		t.Errorf("Expected %s, got %s", expected, jstring)
+	}
+
+	// dates are written once set
+	w3cman.DatePublished.UnmarshalJSON([]byte(`"2020-05-01"`))
+	if jstring, err = json.Marshal(w3cman); err != nil {
+		t.Fatal(err)
+	}
+	var parsed W3CPublication
+	if err = json.Unmarshal(jstring, &parsed); err != nil {
+		t.Fatal(err)
+	}
+	if !time.Time(parsed.DatePublished).Equal(time.Time(w3cman.DatePublished)) || !time.Time(parsed.DateModified).IsZero() {
+		t.Errorf("Expected the publication date only, got %s", jstring)
+	}
+}
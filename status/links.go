@@ -0,0 +1,91 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package status
+
+import (
+	"github.com/readium/readium-lcp-server/api"
+)
+
+// Link relations of a license status document
+const (
+	REL_LICENSE  = "license"
+	REL_REGISTER = "register"
+	REL_RETURN   = "return"
+	REL_RENEW    = "renew"
+)
+
+// Link is a link of a license status document
+type Link struct {
+	Rel       string `json:"rel"`
+	Href      string `json:"href"`
+	Type      string `json:"type,omitempty"`
+	Title     string `json:"title,omitempty"`
+	Profile   string `json:"profile,omitempty"`
+	Templated bool   `json:"templated,omitempty"`
+}
+
+// Links is the list of links of a license status document
+type Links []Link
+
+// Get returns the first link with a given relation
+func (links Links) Get(rel string) (Link, bool) {
+	for _, link := range links {
+		if link.Rel == rel {
+			return link, true
+		}
+	}
+	return Link{}, false
+}
+
+// KeepActions returns the links which are not related to an action, like the license link,
+// and the links of the given actions, see AllowedActions
+func (links Links) KeepActions(actions []string) Links {
+	kept := make(Links, 0, len(links))
+	for _, link := range links {
+		switch link.Rel {
+		case REL_REGISTER, REL_RETURN, REL_RENEW:
+			for _, action := range actions {
+				if link.Rel == action {
+					kept = append(kept, link)
+					break
+				}
+			}
+		default:
+			kept = append(kept, link)
+		}
+	}
+	return kept
+}
+
+// LicenseLink returns the link to the license, served by the license server
+func LicenseLink(lcpBaseURL string, licenseID string) Link {
+	return Link{Rel: REL_LICENSE, Href: lcpBaseURL + "/licenses/" + licenseID, Type: api.ContentType_LCP_JSON}
+}
+
+// RegisterLink returns the templated link used to register a device
+func RegisterLink(lsdBaseURL string, licenseID string) Link {
+	return Link{Rel: REL_REGISTER, Href: lsdBaseURL + "/licenses/" + licenseID + "/register{?id,name}", Type: api.ContentType_LSD_JSON, Templated: true}
+}
+
+// ReturnLink returns the templated link used to return a license
+func ReturnLink(lsdBaseURL string, licenseID string) Link {
+	return Link{Rel: REL_RETURN, Href: lsdBaseURL + "/licenses/" + licenseID + "/return{?id,name}", Type: api.ContentType_LSD_JSON, Templated: true}
+}
+
+// RenewLink returns the templated link used to renew a license
+func RenewLink(lsdBaseURL string, licenseID string) Link {
+	return Link{Rel: REL_RENEW, Href: lsdBaseURL + "/licenses/" + licenseID + "/renew{?end,id,name}", Type: api.ContentType_LSD_JSON, Templated: true}
+}
+
+// StandardLinks returns the license, register, return and renew links of a license status document,
+// from the public base urls of the license and status servers
+func StandardLinks(lcpBaseURL string, lsdBaseURL string, licenseID string) Links {
+	return Links{
+		LicenseLink(lcpBaseURL, licenseID),
+		RegisterLink(lsdBaseURL, licenseID),
+		ReturnLink(lsdBaseURL, licenseID),
+		RenewLink(lsdBaseURL, licenseID),
+	}
+}
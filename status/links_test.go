@@ -0,0 +1,35 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package status
+
+import (
+	"testing"
+)
+
+func TestStandardLinks(t *testing.T) {
+	links := StandardLinks("https://lcp.example.com", "https://lsd.example.com", "license-1")
+
+	expected := Links{
+		{Rel: "license", Href: "https://lcp.example.com/licenses/license-1", Type: "application/vnd.readium.lcp.license.v1.0+json"},
+		{Rel: "register", Href: "https://lsd.example.com/licenses/license-1/register{?id,name}", Type: "application/vnd.readium.license.status.v1.0+json", Templated: true},
+		{Rel: "return", Href: "https://lsd.example.com/licenses/license-1/return{?id,name}", Type: "application/vnd.readium.license.status.v1.0+json", Templated: true},
+		{Rel: "renew", Href: "https://lsd.example.com/licenses/license-1/renew{?end,id,name}", Type: "application/vnd.readium.license.status.v1.0+json", Templated: true},
+	}
+	if len(links) != len(expected) {
+		t.Fatalf("Expected %d links, got %d", len(expected), len(links))
+	}
+	for i := range expected {
+		if links[i] != expected[i] {
+			t.Errorf("Expected %+v, got %+v", expected[i], links[i])
+		}
+	}
+
+	if link, ok := links.Get(REL_RETURN); !ok || link.Href != expected[2].Href {
+		t.Errorf("Expected to get the return link, got %+v", link)
+	}
+	if _, ok := links.Get("hint"); ok {
+		t.Error("Did not expect a hint link")
+	}
+}
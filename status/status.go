@@ -53,6 +53,21 @@ var EventTypes = map[int]string{
 	EVENT_RENEWED_INT:    "renew",
 }
 
+// AllowedActions returns the actions a device may request next (register, return, renew),
+// given the type of the latest event recorded for the device, see EventTypes.
+// An empty type means that no event was recorded for the device yet.
+// A registered device may register again, which the status server handles as a no-op.
+func AllowedActions(latestType string) []string {
+	switch latestType {
+	case "":
+		return []string{REL_REGISTER, REL_RETURN}
+	case EventTypes[STATUS_ACTIVE_INT], EventTypes[EVENT_RENEWED_INT]:
+		return []string{REL_REGISTER, REL_RETURN, REL_RENEW}
+	}
+	// the license was returned, revoked, cancelled or has expired
+	return []string{}
+}
+
 // GetStatus translates status number to status string
 func GetStatus(statusDB int64, status *string) {
 	resultStr := reverse(strconv.FormatInt(statusDB, 2))
@@ -0,0 +1,45 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package status
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAllowedActions(t *testing.T) {
+	tests := []struct {
+		latestType string
+		expected   []string
+	}{
+		{"", []string{"register", "return"}},
+		{"register", []string{"register", "return", "renew"}},
+		{"renew", []string{"register", "return", "renew"}},
+		{"return", nil},
+		{"revoke", nil},
+		{"cancel", nil},
+		{"expire", nil},
+		{"unknown", nil},
+	}
+	for _, test := range tests {
+		actions := AllowedActions(test.latestType)
+		if strings.Join(actions, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("Expected %v to be allowed after %q, got %v", test.expected, test.latestType, actions)
+		}
+	}
+}
+
+func TestKeepActions(t *testing.T) {
+	links := StandardLinks("https://lcp.example.com", "https://lsd.example.com", "license-1")
+
+	kept := links.KeepActions(AllowedActions("return"))
+	if len(kept) != 1 || kept[0].Rel != REL_LICENSE {
+		t.Errorf("Expected only the license link after a return, got %+v", kept)
+	}
+	kept = links.KeepActions(AllowedActions(""))
+	if len(kept) != 3 || kept[1].Rel != REL_REGISTER || kept[2].Rel != REL_RETURN {
+		t.Errorf("Expected the license, register and return links before a registration, got %+v", kept)
+	}
+}
@@ -8,6 +8,7 @@ package transactions
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"log"
 	"strings"
 	"time"
@@ -22,8 +23,16 @@ type Transactions interface {
 	Get(id int) (Event, error)
 	Add(e Event, eventType int) error
 	GetByLicenseStatusId(licenseStatusFk int) func() (Event, error)
+	GetByLicenseStatusIdPaged(licenseStatusFk int, limit int, offset int) func() (Event, error)
 	CheckDeviceStatus(licenseStatusFk int, deviceId string) (string, error)
+	GetDeviceHistory(licenseStatusFk int, deviceId string) func() (Event, error)
+	DeviceTimeline(licenseStatusFk int, deviceId string) ([]Event, error)
 	ListRegisteredDevices(licenseStatusFk int) func() (Device, error)
+	BuildRegisteredDevicesList(licenseStatusFk int, id string) (RegisteredDevicesList, error)
+	RenewalCount(licenseStatusFk int) (int, error)
+	Count(licenseStatusFk int, typeEvent int) (int, error)
+	CanRenew(licenseStatusFk int, max int) (bool, error)
+	ListEventsByIP(ip string) func() (Event, error)
 }
 
 type RegisteredDevicesList struct {
@@ -44,31 +53,59 @@ type Event struct {
 	Type            string    `json:"type"`
 	DeviceId        string    `json:"id"`
 	LicenseStatusFk int       `json:"-"`
+	// optional, recorded on device registrations; never exposed in status documents
+	IpAddress string `json:"-"`
+	UserAgent string `json:"-"`
 }
 
 type dbTransactions struct {
-	db                    *sql.DB
-	get                   *sql.Stmt
-	add                   *sql.Stmt
-	getbylicensestatusid  *sql.Stmt
-	checkdevicestatus     *sql.Stmt
-	listregistereddevices *sql.Stmt
+	db                       *sql.DB
+	get                      *sql.Stmt
+	add                      *sql.Stmt
+	getbylicensestatusid     *sql.Stmt
+	getpagebylicensestatusid *sql.Stmt
+	checkdevicestatus        *sql.Stmt
+	getdevicehistory         *sql.Stmt
+	listregistereddevices    *sql.Stmt
+	count                    *sql.Stmt
+	listbyip                 *sql.Stmt
+}
+
+// eventColumns lists the columns scanned by scanEvent
+const eventColumns = "id, device_name, timestamp, type, device_id, license_status_fk, ip_address, user_agent"
+
+// scanner is implemented by sql.Row and sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanEvent scans a row selected with eventColumns;
+// the ip address and user agent are null in older events
+func scanEvent(row scanner) (Event, error) {
+	var e Event
+	var typeInt int
+	var ipAddress, userAgent sql.NullString
+
+	err := row.Scan(&e.Id, &e.DeviceName, &e.Timestamp, &typeInt, &e.DeviceId, &e.LicenseStatusFk, &ipAddress, &userAgent)
+	if err == nil {
+		e.Type = status.EventTypes[typeInt]
+		e.IpAddress = ipAddress.String
+		e.UserAgent = userAgent.String
+	}
+	return e, err
 }
 
 // Get returns an event by its id
 //
 func (i dbTransactions) Get(id int) (Event, error) {
 	records, err := i.get.Query(id)
-	var typeInt int
+	if err != nil {
+		return Event{}, err
+	}
 
 	defer records.Close()
 	if records.Next() {
-		var e Event
-		err = records.Scan(&e.Id, &e.DeviceName, &e.Timestamp, &typeInt, &e.DeviceId, &e.LicenseStatusFk)
-		if err == nil {
-			e.Type = status.EventTypes[typeInt]
-		}
-		return e, err
+		return scanEvent(records)
 	}
 
 	return Event{}, NotFound
@@ -76,41 +113,46 @@ func (i dbTransactions) Get(id int) (Event, error) {
 
 // Add adds an event in the database,
 // The parameter eventType corresponds to the field 'type' in table 'event'
+// An empty ip address or user agent is stored as null.
 //
 func (i dbTransactions) Add(e Event, eventType int) error {
-	add, err := i.db.Prepare("INSERT INTO event (device_name, timestamp, type, device_id, license_status_fk) VALUES (?, ?, ?, ?, ?)")
-
-	if err != nil {
-		return err
-	}
-
-	defer add.Close()
-	_, err = add.Exec(e.DeviceName, e.Timestamp, eventType, e.DeviceId, e.LicenseStatusFk)
+	_, err := i.add.Exec(e.DeviceName, e.Timestamp, eventType, e.DeviceId, e.LicenseStatusFk, nullString(e.IpAddress), nullString(e.UserAgent))
 	return err
 }
 
+// nullString maps an empty string to a null value
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
 // GetByLicenseStatusId returns all events by license status id
 //
 func (i dbTransactions) GetByLicenseStatusId(licenseStatusFk int) func() (Event, error) {
 	rows, err := i.getbylicensestatusid.Query(licenseStatusFk)
+	return eventIterator(rows, err)
+}
+
+// GetByLicenseStatusIdPaged returns a page of the events of a license status,
+// in the order they were recorded: at most limit events, starting at offset
+//
+func (i dbTransactions) GetByLicenseStatusIdPaged(licenseStatusFk int, limit int, offset int) func() (Event, error) {
+	rows, err := i.getpagebylicensestatusid.Query(licenseStatusFk, limit, offset)
+	return eventIterator(rows, err)
+}
+
+// eventIterator returns an iterator over the events selected by a query,
+// which closes the rows once exhausted and then returns NotFound
+//
+func eventIterator(rows *sql.Rows, err error) func() (Event, error) {
 	if err != nil {
 		return func() (Event, error) { return Event{}, err }
 	}
 	return func() (Event, error) {
-		var e Event
-		var err error
-		var typeInt int
-
 		if rows.Next() {
-			err = rows.Scan(&e.Id, &e.DeviceName, &e.Timestamp, &typeInt, &e.DeviceId, &e.LicenseStatusFk)
-			if err == nil {
-				e.Type = status.EventTypes[typeInt]
-			}
-		} else {
-			rows.Close()
-			err = NotFound
+			return scanEvent(rows)
 		}
-		return e, err
+		rows.Close()
+		return Event{}, NotFound
 	}
 }
 
@@ -134,6 +176,45 @@ func (i dbTransactions) ListRegisteredDevices(licenseStatusFk int) func() (Devic
 	}
 }
 
+// BuildRegisteredDevicesList returns the devices registered with a license status,
+// ready for json serialization; id is the license id.
+// A device registered several times is listed once, and devices which returned the license are excluded.
+//
+func (i dbTransactions) BuildRegisteredDevicesList(licenseStatusFk int, id string) (RegisteredDevicesList, error) {
+	list := RegisteredDevicesList{Id: id, Devices: make([]Device, 0)}
+
+	// drain the iterator before checking the device statuses, to release the db rows
+	var registered []Device
+	fn := i.ListRegisteredDevices(licenseStatusFk)
+	for {
+		device, err := fn()
+		if err == NotFound {
+			break
+		}
+		if err != nil {
+			return list, err
+		}
+		registered = append(registered, device)
+	}
+
+	listed := map[string]bool{}
+	for _, device := range registered {
+		if listed[device.DeviceId] {
+			continue
+		}
+		deviceStatus, err := i.CheckDeviceStatus(licenseStatusFk, device.DeviceId)
+		if err != nil {
+			return list, err
+		}
+		if deviceStatus == status.EventTypes[status.STATUS_RETURNED_INT] {
+			continue
+		}
+		listed[device.DeviceId] = true
+		list.Devices = append(list.Devices, device)
+	}
+	return list, nil
+}
+
 // CheckDeviceStatus gets the current status of a device
 // if the device has not been recorded in the 'event' table, typeString is empty.
 //
@@ -155,6 +236,67 @@ func (i dbTransactions) CheckDeviceStatus(licenseStatusFk int, deviceId string)
 	return typeString, err
 }
 
+// GetDeviceHistory returns all events of a device for a license status, ordered by timestamp;
+// the latest one gives the status returned by CheckDeviceStatus.
+//
+func (i dbTransactions) GetDeviceHistory(licenseStatusFk int, deviceId string) func() (Event, error) {
+	rows, err := i.getdevicehistory.Query(licenseStatusFk, deviceId)
+	return eventIterator(rows, err)
+}
+
+// DeviceTimeline returns the events of a device for a license status as a slice, ordered by timestamp,
+// their type being mapped through status.EventTypes; a device without events gets an empty slice.
+//
+func (i dbTransactions) DeviceTimeline(licenseStatusFk int, deviceId string) ([]Event, error) {
+	events := []Event{}
+	fn := i.GetDeviceHistory(licenseStatusFk, deviceId)
+	e, err := fn()
+	for ; err == nil; e, err = fn() {
+		events = append(events, e)
+	}
+	if err != NotFound {
+		return nil, err
+	}
+	return events, nil
+}
+
+// RenewalCount returns the number of renew events recorded for a license status
+//
+func (i dbTransactions) RenewalCount(licenseStatusFk int) (int, error) {
+	return i.Count(licenseStatusFk, status.EVENT_RENEWED_INT)
+}
+
+// Count returns the number of events of a given type recorded for a license status,
+// without fetching the events themselves
+//
+func (i dbTransactions) Count(licenseStatusFk int, typeEvent int) (int, error) {
+	var count int
+	err := i.count.QueryRow(licenseStatusFk, typeEvent).Scan(&count)
+	return count, err
+}
+
+// CanRenew checks that the number of renew events recorded for a license status
+// is below a maximum; a maximum of 0 or less means unlimited renewals.
+//
+func (i dbTransactions) CanRenew(licenseStatusFk int, max int) (bool, error) {
+	if max <= 0 {
+		return true, nil
+	}
+	count, err := i.RenewalCount(licenseStatusFk)
+	if err != nil {
+		return false, err
+	}
+	return count < max, nil
+}
+
+// ListEventsByIP returns all events recorded from an ip address, in the order they were recorded,
+// which lets operators spot an ip address registering many devices
+//
+func (i dbTransactions) ListEventsByIP(ip string) func() (Event, error) {
+	rows, err := i.listbyip.Query(ip)
+	return eventIterator(rows, err)
+}
+
 // Open defines scripts for queries & create the 'event' table if it does not exist
 //
 func Open(db *sql.DB) (t Transactions, err error) {
@@ -165,31 +307,127 @@ func Open(db *sql.DB) (t Transactions, err error) {
 			log.Println("Error creating sqlite event table")
 			return
 		}
+		if err = migrateSqliteEvents(db); err != nil {
+			log.Println("Error migrating the sqlite event table")
+			return
+		}
+	} else if err = checkEventColumns(db); err != nil {
+		return
 	}
 
 	// select an event by its id
-	get, err := db.Prepare("SELECT * FROM event WHERE id = ? LIMIT 1")
+	get, err := db.Prepare("SELECT " + eventColumns + " FROM event WHERE id = ? LIMIT 1")
+	if err != nil {
+		return
+	}
+
+	// add an event
+	add, err := db.Prepare("INSERT INTO event (device_name, timestamp, type, device_id, license_status_fk, ip_address, user_agent) VALUES (?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return
+	}
+
+	getbylicensestatusid, err := db.Prepare("SELECT " + eventColumns + " FROM event WHERE license_status_fk = ?")
 	if err != nil {
 		return
 	}
 
-	getbylicensestatusid, err := db.Prepare("SELECT * FROM event WHERE license_status_fk = ?")
+	getpagebylicensestatusid, err := db.Prepare("SELECT " + eventColumns + " FROM event WHERE license_status_fk = ? ORDER BY id LIMIT ? OFFSET ?")
+	if err != nil {
+		return
+	}
 
 	// the status of a device corresponds to the latest event stored in the db.
 	checkdevicestatus, err := db.Prepare(`SELECT type FROM event WHERE license_status_fk = ?
 	AND device_id = ? ORDER BY timestamp DESC LIMIT 1`)
+	if err != nil {
+		return
+	}
+
+	// events recorded at the same time are kept in the order they were added
+	getdevicehistory, err := db.Prepare("SELECT " + eventColumns + " FROM event WHERE license_status_fk = ? AND device_id = ? ORDER BY timestamp, id")
+	if err != nil {
+		return
+	}
 
 	listregistereddevices, err := db.Prepare(`SELECT device_id,
 	device_name, timestamp  FROM event  WHERE license_status_fk = ? AND type = 1`)
+	if err != nil {
+		return
+	}
+
+	// count the events of a given type
+	count, err := db.Prepare("SELECT COUNT(*) FROM event WHERE license_status_fk = ? AND type = ?")
+	if err != nil {
+		return
+	}
 
+	listbyip, err := db.Prepare("SELECT " + eventColumns + " FROM event WHERE ip_address = ? ORDER BY id")
 	if err != nil {
 		return
 	}
 
-	t = dbTransactions{db, get, nil, getbylicensestatusid, checkdevicestatus, listregistereddevices}
+	t = dbTransactions{db, get, add, getbylicensestatusid, getpagebylicensestatusid, checkdevicestatus, getdevicehistory, listregistereddevices, count, listbyip}
 	return
 }
 
+// eventMigration is the migration adding the ip address and user agent columns to the event table,
+// see dbmodel/migrations
+const eventMigration = "001_event_ip_address_user_agent"
+
+// migratedEventColumns are the columns added to the event table by eventMigration
+var migratedEventColumns = []struct {
+	name       string
+	definition string
+}{
+	{"ip_address", "varchar(64) DEFAULT NULL"},
+	{"user_agent", "varchar(255) DEFAULT NULL"},
+}
+
+// migrateSqliteEvents applies eventMigration to a sqlite event table created before it,
+// adding the columns it does not have yet
+func migrateSqliteEvents(db *sql.DB) error {
+	rows, err := db.Query("PRAGMA table_info(event)")
+	if err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, columnType string
+		var defaultValue sql.NullString
+		if err = rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, column := range migratedEventColumns {
+		if existing[column.name] {
+			continue
+		}
+		if _, err = db.Exec("ALTER TABLE event ADD COLUMN " + column.name + " " + column.definition); err != nil {
+			return err
+		}
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS event_ip_address_index on event (ip_address)")
+	return err
+}
+
+// checkEventColumns checks that eventMigration was applied to an event table which is not managed by the server
+func checkEventColumns(db *sql.DB) error {
+	rows, err := db.Query("SELECT ip_address, user_agent FROM event WHERE 1 = 0")
+	if err != nil {
+		return fmt.Errorf("the event table misses the ip_address and user_agent columns, apply the migration dbmodel/migrations/%s: %s", eventMigration, err)
+	}
+	return rows.Close()
+}
+
 const tableDef = "CREATE TABLE IF NOT EXISTS event (" +
 	"id integer PRIMARY KEY," +
 	"device_name varchar(255) DEFAULT NULL," +
@@ -197,6 +435,8 @@ const tableDef = "CREATE TABLE IF NOT EXISTS event (" +
 	"type int NOT NULL," +
 	"device_id varchar(255) DEFAULT NULL," +
 	"license_status_fk int NOT NULL," +
+	"ip_address varchar(64) DEFAULT NULL," +
+	"user_agent varchar(255) DEFAULT NULL," +
 	"FOREIGN KEY(license_status_fk) REFERENCES license_status(id)" +
 	");" +
 	"CREATE INDEX IF NOT EXISTS license_status_fk_index on event (license_status_fk);"
@@ -7,6 +7,7 @@ package transactions
 
 import (
 	"database/sql"
+	"strings"
 	"testing"
 	"time"
 
@@ -40,3 +41,492 @@ func TestTransactionCreation(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+//TestRenewalCount adds renew events up to and beyond a cap
+func TestRenewalCount(t *testing.T) {
+	config.Config.LsdServer.Database = "sqlite" // FIXME
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	trns, err := Open(db)
+	if err != nil {
+		t.Fatalf("Can't open transactions, %s", err)
+	}
+
+	const max = 2
+	timestamp := time.Now().UTC().Truncate(time.Second)
+	// a register event and an event from another license status must not be counted
+	if err = trns.Add(Event{DeviceName: "testdevice", Timestamp: timestamp, DeviceId: "deviceid", LicenseStatusFk: 1}, status.STATUS_ACTIVE_INT); err != nil {
+		t.Fatal(err)
+	}
+	if err = trns.Add(Event{DeviceName: "testdevice", Timestamp: timestamp, DeviceId: "deviceid", LicenseStatusFk: 2}, status.EVENT_RENEWED_INT); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i <= max; i++ {
+		count, err := trns.RenewalCount(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != i {
+			t.Errorf("Expected %d renewals, got %d", i, count)
+		}
+		canRenew, err := trns.CanRenew(1, max)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if canRenew != (i < max) {
+			t.Errorf("Unexpected renewal permission after %d renewals: %v", i, canRenew)
+		}
+
+		e := Event{DeviceName: "testdevice", Timestamp: timestamp, DeviceId: "deviceid", LicenseStatusFk: 1}
+		if err = trns.Add(e, status.EVENT_RENEWED_INT); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if canRenew, err := trns.CanRenew(1, 0); err != nil || !canRenew {
+		t.Errorf("Expected unlimited renewals with no maximum, got %v, %v", canRenew, err)
+	}
+}
+
+//TestBuildRegisteredDevicesList checks that returned devices are excluded from the list
+func TestBuildRegisteredDevicesList(t *testing.T) {
+	config.Config.LsdServer.Database = "sqlite" // FIXME
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	trns, err := Open(db)
+	if err != nil {
+		t.Fatalf("Can't open transactions, %s", err)
+	}
+
+	timestamp := time.Now().UTC().Truncate(time.Second)
+	events := []struct {
+		deviceID  string
+		eventType int
+	}{
+		{"device-a", status.STATUS_ACTIVE_INT},
+		{"device-b", status.STATUS_ACTIVE_INT},
+		{"device-b", status.STATUS_RETURNED_INT},
+		{"device-a", status.STATUS_ACTIVE_INT},
+	}
+	for i, event := range events {
+		e := Event{DeviceName: event.deviceID, Timestamp: timestamp.Add(time.Duration(i) * time.Minute), DeviceId: event.deviceID, LicenseStatusFk: 1}
+		if err = trns.Add(e, event.eventType); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list, err := trns.BuildRegisteredDevicesList(1, "license-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Id != "license-id" {
+		t.Errorf("Expected the license id, got %s", list.Id)
+	}
+	if len(list.Devices) != 1 || list.Devices[0].DeviceId != "device-a" {
+		t.Errorf("Expected device-a to be the only registered device, got %v", list.Devices)
+	}
+
+	// an empty list is serialized as an empty array
+	list, err = trns.BuildRegisteredDevicesList(2, "other-license-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Devices == nil || len(list.Devices) != 0 {
+		t.Errorf("Expected an empty list of devices, got %v", list.Devices)
+	}
+}
+
+//TestCount counts events by license status and type
+func TestCount(t *testing.T) {
+	config.Config.LsdServer.Database = "sqlite" // FIXME
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	trns, err := Open(db)
+	if err != nil {
+		t.Fatalf("Can't open transactions, %s", err)
+	}
+
+	timestamp := time.Now().UTC().Truncate(time.Second)
+	events := []struct {
+		licenseStatusFk int
+		deviceId        string
+		eventType       int
+	}{
+		{1, "device1", status.STATUS_ACTIVE_INT},
+		{1, "device2", status.STATUS_ACTIVE_INT},
+		{1, "device3", status.STATUS_ACTIVE_INT},
+		{1, "device1", status.EVENT_RENEWED_INT},
+		{2, "device4", status.STATUS_ACTIVE_INT},
+	}
+	for _, event := range events {
+		e := Event{DeviceName: "testdevice", Timestamp: timestamp, DeviceId: event.deviceId, LicenseStatusFk: event.licenseStatusFk}
+		if err = trns.Add(e, event.eventType); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		licenseStatusFk int
+		eventType       int
+		expected        int
+	}{
+		{1, status.STATUS_ACTIVE_INT, 3},
+		{1, status.EVENT_RENEWED_INT, 1},
+		{1, status.STATUS_RETURNED_INT, 0},
+		{2, status.STATUS_ACTIVE_INT, 1},
+		{3, status.STATUS_ACTIVE_INT, 0},
+	}
+	for _, test := range tests {
+		count, err := trns.Count(test.licenseStatusFk, test.eventType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != test.expected {
+			t.Errorf("Expected %d events of type %d for license status %d, got %d", test.expected, test.eventType, test.licenseStatusFk, count)
+		}
+	}
+}
+
+//TestAddReusesStatement adds many events through the statement prepared by Open
+func TestAddReusesStatement(t *testing.T) {
+	config.Config.LsdServer.Database = "sqlite" // FIXME
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	trns, err := Open(db)
+	if err != nil {
+		t.Fatalf("Can't open transactions, %s", err)
+	}
+	add := trns.(dbTransactions).add
+	if add == nil {
+		t.Fatal("Expected the insert statement to be prepared by Open")
+	}
+
+	const events = 200
+	timestamp := time.Now().UTC().Truncate(time.Second)
+	for i := 0; i < events; i++ {
+		e := Event{DeviceName: "testdevice", Timestamp: timestamp, DeviceId: "deviceid", LicenseStatusFk: 1}
+		if err = trns.Add(e, status.STATUS_ACTIVE_INT); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if trns.(dbTransactions).add != add {
+		t.Error("Expected the insert statement to be reused")
+	}
+	if count, err := trns.Count(1, status.STATUS_ACTIVE_INT); err != nil || count != events {
+		t.Errorf("Expected %d events, got %d, %v", events, count, err)
+	}
+}
+
+//TestGetByLicenseStatusIdPaged lists the events of a license status page by page
+func TestGetByLicenseStatusIdPaged(t *testing.T) {
+	config.Config.LsdServer.Database = "sqlite" // FIXME
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	trns, err := Open(db)
+	if err != nil {
+		t.Fatalf("Can't open transactions, %s", err)
+	}
+
+	timestamp := time.Now().UTC().Truncate(time.Second)
+	devices := []string{"device1", "device2", "device3", "device4", "device5"}
+	for _, deviceID := range devices {
+		e := Event{DeviceName: "testdevice", Timestamp: timestamp, DeviceId: deviceID, LicenseStatusFk: 1}
+		if err = trns.Add(e, status.STATUS_ACTIVE_INT); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// an event of another license status must not be listed
+	if err = trns.Add(Event{DeviceName: "testdevice", Timestamp: timestamp, DeviceId: "other", LicenseStatusFk: 2}, status.STATUS_ACTIVE_INT); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		limit    int
+		offset   int
+		expected []string
+	}{
+		{2, 0, []string{"device1", "device2"}},
+		{2, 2, []string{"device3", "device4"}},
+		{2, 4, []string{"device5"}},
+		{2, 5, nil},
+		{2, 6, nil},
+		{10, 0, devices},
+	}
+	for _, test := range tests {
+		var got []string
+		fn := trns.GetByLicenseStatusIdPaged(1, test.limit, test.offset)
+		e, err := fn()
+		for ; err == nil; e, err = fn() {
+			got = append(got, e.DeviceId)
+		}
+		if err != NotFound {
+			t.Fatalf("Expected the iterator to end with NotFound, got %v", err)
+		}
+		if len(got) != len(test.expected) {
+			t.Errorf("Expected %v at offset %d, got %v", test.expected, test.offset, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.expected[i] {
+				t.Errorf("Expected %v at offset %d, got %v", test.expected, test.offset, got)
+				break
+			}
+		}
+	}
+
+	// the rows are closed once the iterator is exhausted, the connection can be reused
+	if _, err = trns.Count(1, status.STATUS_ACTIVE_INT); err != nil {
+		t.Errorf("Expected the rows to be released, got %s", err)
+	}
+}
+
+//TestListEventsByIP records registrations from several ip addresses and lists those of one address
+func TestListEventsByIP(t *testing.T) {
+	config.Config.LsdServer.Database = "sqlite" // FIXME
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	trns, err := Open(db)
+	if err != nil {
+		t.Fatalf("Can't open transactions, %s", err)
+	}
+
+	timestamp := time.Now().UTC().Truncate(time.Second)
+	events := []Event{
+		{DeviceName: "testdevice", Timestamp: timestamp, DeviceId: "device1", LicenseStatusFk: 1, IpAddress: "192.0.2.1", UserAgent: "reader/1.0"},
+		{DeviceName: "testdevice", Timestamp: timestamp, DeviceId: "device2", LicenseStatusFk: 2, IpAddress: "192.0.2.2"},
+		{DeviceName: "testdevice", Timestamp: timestamp, DeviceId: "device3", LicenseStatusFk: 3, IpAddress: "192.0.2.1"},
+		// older callers do not set the ip address
+		{DeviceName: "testdevice", Timestamp: timestamp, DeviceId: "device4", LicenseStatusFk: 4},
+	}
+	for _, e := range events {
+		if err = trns.Add(e, status.STATUS_ACTIVE_INT); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []Event
+	fn := trns.ListEventsByIP("192.0.2.1")
+	e, err := fn()
+	for ; err == nil; e, err = fn() {
+		got = append(got, e)
+	}
+	if err != NotFound {
+		t.Fatalf("Expected the iterator to end with NotFound, got %v", err)
+	}
+	if len(got) != 2 || got[0].DeviceId != "device1" || got[1].DeviceId != "device3" {
+		t.Fatalf("Expected the events of device1 and device3, got %+v", got)
+	}
+	if got[0].UserAgent != "reader/1.0" || got[1].UserAgent != "" {
+		t.Errorf("Expected the user agents to be kept, got %q and %q", got[0].UserAgent, got[1].UserAgent)
+	}
+
+	e, err = trns.Get(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.IpAddress != "" || e.UserAgent != "" {
+		t.Errorf("Expected no ip address nor user agent, got %q and %q", e.IpAddress, e.UserAgent)
+	}
+}
+
+//TestEventTableMigration opens transactions on an event table created before the ip address and user agent columns
+func TestEventTableMigration(t *testing.T) {
+	config.Config.LsdServer.Database = "sqlite" // FIXME
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1) // every connection opens its own in-memory database
+	_, err = db.Exec(`CREATE TABLE event (id integer PRIMARY KEY, device_name varchar(255) DEFAULT NULL,
+	timestamp datetime NOT NULL, type int NOT NULL, device_id varchar(255) DEFAULT NULL, license_status_fk int NOT NULL);
+	INSERT INTO event (device_name, timestamp, type, device_id, license_status_fk) VALUES ('testdevice', '2020-01-01 00:00:00', 1, 'deviceid', 1)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trns, err := Open(db)
+	if err != nil {
+		t.Fatalf("Can't open transactions on an existing table, %s", err)
+	}
+	e, err := trns.Get(1)
+	if err != nil {
+		t.Fatalf("Can't read an existing event, %s", err)
+	}
+	if e.DeviceId != "deviceid" || e.IpAddress != "" {
+		t.Errorf("Expected the existing event to be read, got %+v", e)
+	}
+	if err = trns.Add(Event{DeviceName: "testdevice", Timestamp: time.Now().UTC(), DeviceId: "deviceid", LicenseStatusFk: 1, IpAddress: "192.0.2.1"}, status.EVENT_RENEWED_INT); err != nil {
+		t.Fatalf("Can't add an event to a migrated table, %s", err)
+	}
+
+	// opening the migrated table again is harmless
+	if _, err = Open(db); err != nil {
+		t.Errorf("Can't open transactions twice, %s", err)
+	}
+}
+
+//TestEventTableNotMigrated checks that an unmanaged event table without the migrated columns is reported
+func TestEventTableNotMigrated(t *testing.T) {
+	config.Config.LsdServer.Database = "mysql://lsd" // the table is not created nor migrated by the server
+	defer func() { config.Config.LsdServer.Database = "sqlite" }()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1) // every connection opens its own in-memory database
+	_, err = db.Exec(`CREATE TABLE event (id integer PRIMARY KEY, device_name varchar(255) DEFAULT NULL,
+	timestamp datetime NOT NULL, type int NOT NULL, device_id varchar(255) DEFAULT NULL, license_status_fk int NOT NULL)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Open(db); err == nil || !strings.Contains(err.Error(), eventMigration) {
+		t.Fatalf("Expected the missing migration to be reported, got %v", err)
+	}
+
+	for _, column := range migratedEventColumns {
+		if _, err = db.Exec("ALTER TABLE event ADD COLUMN " + column.name + " " + column.definition); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = Open(db); err != nil {
+		t.Errorf("Can't open transactions on a migrated table, %s", err)
+	}
+}
+
+//TestGetDeviceHistory registers, returns and renews the same device and checks the order of its events
+func TestGetDeviceHistory(t *testing.T) {
+	config.Config.LsdServer.Database = "sqlite" // FIXME
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	trns, err := Open(db)
+	if err != nil {
+		t.Fatalf("Can't open transactions, %s", err)
+	}
+
+	timestamp := time.Now().UTC().Truncate(time.Second)
+	// the events are added out of order, the history is ordered by timestamp
+	events := []struct {
+		eventType int
+		offset    time.Duration
+		deviceID  string
+	}{
+		{status.EVENT_RENEWED_INT, 2 * time.Hour, "deviceid"},
+		{status.STATUS_ACTIVE_INT, 0, "deviceid"},
+		{status.STATUS_ACTIVE_INT, time.Minute, "otherdevice"},
+		{status.STATUS_RETURNED_INT, time.Hour, "deviceid"},
+	}
+	for _, e := range events {
+		if err = trns.Add(Event{DeviceName: "testdevice", Timestamp: timestamp.Add(e.offset), DeviceId: e.deviceID, LicenseStatusFk: 1}, e.eventType); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	fn := trns.GetDeviceHistory(1, "deviceid")
+	e, err := fn()
+	for ; err == nil; e, err = fn() {
+		got = append(got, e.Type)
+	}
+	if err != NotFound {
+		t.Fatalf("Expected the iterator to end with NotFound, got %v", err)
+	}
+	expected := []string{"register", "return", "renew"}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected the history %v, got %v", expected, got)
+	}
+
+	// the latest event of the history is the status of the device
+	deviceStatus, err := trns.CheckDeviceStatus(1, "deviceid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deviceStatus != got[len(got)-1] {
+		t.Errorf("Expected the device status %s, got %s", got[len(got)-1], deviceStatus)
+	}
+
+	if _, err = trns.GetDeviceHistory(1, "unknown")(); err != NotFound {
+		t.Errorf("Expected no history for an unknown device, got %v", err)
+	}
+}
+
+//TestSeveralDevicesPerLicenseStatus checks that the event table accepts several events per license status
+func TestSeveralDevicesPerLicenseStatus(t *testing.T) {
+	config.Config.LsdServer.Database = "sqlite" // FIXME
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	trns, err := Open(db)
+	if err != nil {
+		t.Fatalf("Can't open transactions, %s", err)
+	}
+
+	timestamp := time.Now().UTC().Truncate(time.Second)
+	for _, deviceID := range []string{"device1", "device2"} {
+		if err = trns.Add(Event{DeviceName: "testdevice", Timestamp: timestamp, DeviceId: deviceID, LicenseStatusFk: 1}, status.STATUS_ACTIVE_INT); err != nil {
+			t.Fatalf("Can't add an event for %s, %s", deviceID, err)
+		}
+	}
+
+	var got []string
+	fn := trns.GetByLicenseStatusId(1)
+	e, err := fn()
+	for ; err == nil; e, err = fn() {
+		got = append(got, e.DeviceId)
+	}
+	if err != NotFound {
+		t.Fatalf("Expected the iterator to end with NotFound, got %v", err)
+	}
+	if strings.Join(got, ",") != "device1,device2" {
+		t.Errorf("Expected the events of device1 and device2, got %v", got)
+	}
+}
+
+//TestDeviceTimeline checks the chronological order and type names of the timeline of a device
+func TestDeviceTimeline(t *testing.T) {
+	config.Config.LsdServer.Database = "sqlite" // FIXME
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	trns, err := Open(db)
+	if err != nil {
+		t.Fatalf("Can't open transactions, %s", err)
+	}
+
+	timestamp := time.Now().UTC().Truncate(time.Second)
+	for _, e := range []struct {
+		eventType int
+		offset    time.Duration
+	}{
+		{status.STATUS_RETURNED_INT, 2 * time.Hour},
+		{status.EVENT_RENEWED_INT, time.Hour},
+		{status.STATUS_ACTIVE_INT, 0},
+	} {
+		if err = trns.Add(Event{DeviceName: "testdevice", Timestamp: timestamp.Add(e.offset), DeviceId: "deviceid", LicenseStatusFk: 1}, e.eventType); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	timeline, err := trns.DeviceTimeline(1, "deviceid")
+	if err != nil {
+		t.Fatalf("Can't get the device timeline, %s", err)
+	}
+	expected := []string{"register", "renew", "return"}
+	if len(timeline) != len(expected) {
+		t.Fatalf("Expected %d events, got %d", len(expected), len(timeline))
+	}
+	for i, e := range timeline {
+		if e.Type != expected[i] {
+			t.Errorf("Expected the event %d to be %s, got %s", i, expected[i], e.Type)
+		}
+		if i > 0 && e.Timestamp.Before(timeline[i-1].Timestamp) {
+			t.Errorf("Expected the event %d to follow the event %d", i, i-1)
+		}
+	}
+
+	timeline, err = trns.DeviceTimeline(1, "unknown")
+	if err != nil || timeline == nil || len(timeline) != 0 {
+		t.Errorf("Expected an empty timeline for an unknown device, got %v, %v", timeline, err)
+	}
+}
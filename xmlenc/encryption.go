@@ -26,9 +26,13 @@
 package xmlenc
 
 import (
+	"encoding/base64"
 	"encoding/xml"
+	"fmt"
 	"io"
 	"net/url"
+	"sort"
+	"strings"
 
 	"golang.org/x/net/html/charset"
 )
@@ -39,8 +43,17 @@ type Manifest struct {
 	XMLName struct{} `xml:"urn:oasis:names:tc:opendocument:xmlns:container encryption"`
 }
 
-// DataForFile returns the EncryptedData item corresponding to a given path
+// DataForFile returns the EncryptedData item corresponding to a given path.
+// As packages mix raw and percent-encoded names, both the path and the stored URIs are unescaped
+// before being compared; the escaped path is compared to the stored URIs as a fallback.
 func (m Manifest) DataForFile(path string) (Data, bool) {
+	target := unescapeURI(path)
+	for _, datum := range m.Data {
+		if unescapeURI(string(datum.CipherData.CipherReference.URI)) == target {
+			return datum, true
+		}
+	}
+
 	fileUri, err := url.Parse(path)
 	if err != nil {
 		return Data{}, false
@@ -56,6 +69,95 @@ func (m Manifest) DataForFile(path string) (Data, bool) {
 	return Data{}, false
 }
 
+// unescapeURI decodes the percent-encoded characters of a URI, or returns it as is if it is not valid
+func unescapeURI(uri string) string {
+	if unescaped, err := url.PathUnescape(uri); err == nil {
+		return unescaped
+	}
+	return uri
+}
+
+// SortByOrder reorders the EncryptedData items to match an ordered list of paths (e.g. the spine).
+// Items which are not found in the list are moved at the end, in their original order.
+func (m *Manifest) SortByOrder(order []string) {
+	rank := make(map[URI]int, len(order))
+	for i, path := range order {
+		fileUri, err := url.Parse(path)
+		if err != nil {
+			continue
+		}
+		uri := URI(fileUri.EscapedPath())
+		if _, ok := rank[uri]; !ok {
+			rank[uri] = i
+		}
+	}
+
+	position := func(datum Data) int {
+		if i, ok := rank[datum.CipherData.CipherReference.URI]; ok {
+			return i
+		}
+		return len(order)
+	}
+
+	sort.SliceStable(m.Data, func(i, j int) bool {
+		return position(m.Data[i]) < position(m.Data[j])
+	})
+}
+
+// AddData appends an EncryptedData item for a file, with its encryption algorithm
+// and optional compression, and returns it for further changes.
+// The compression properties are only written for deflated files, see CompressionProperties.
+// The returned pointer is only valid until the next item is added.
+func (m *Manifest) AddData(path string, algorithm string, compression *Compression) *Data {
+	uri := path
+	if fileUri, err := url.Parse(path); err == nil {
+		uri = fileUri.EscapedPath()
+	}
+
+	var data Data
+	data.Method.Algorithm = URI(algorithm)
+	data.CipherData.CipherReference.URI = URI(uri)
+	if compression != nil {
+		data.Properties = CompressionProperties(compression.Method, compression.OriginalLength)
+	}
+
+	m.Data = append(m.Data, data)
+	return &m.Data[len(m.Data)-1]
+}
+
+// DeflateMethod is the compression method of the resources deflated before encryption
+const DeflateMethod = 8
+
+// CompressionProperties returns the encryption properties of a resource deflated before encryption,
+// holding its original length. Some reading systems fail on a compression property written for a stored
+// resource, so nil is returned if the method is not DeflateMethod.
+func CompressionProperties(method int, originalLength uint64) *EncryptionProperties {
+	if method != DeflateMethod {
+		return nil
+	}
+	return &EncryptionProperties{
+		Properties: []EncryptionProperty{{Compression: Compression{Method: method, OriginalLength: originalLength}}},
+	}
+}
+
+// Dedupe removes the EncryptedData items referencing a URI already referenced by a previous item,
+// and returns the number of items removed
+func (m *Manifest) Dedupe() int {
+	seen := make(map[URI]bool, len(m.Data))
+	data := m.Data[:0]
+	for _, datum := range m.Data {
+		uri := datum.CipherData.CipherReference.URI
+		if seen[uri] {
+			continue
+		}
+		seen[uri] = true
+		data = append(data, datum)
+	}
+	removed := len(m.Data) - len(data)
+	m.Data = data
+	return removed
+}
+
 // Write writes the encryption XML structure
 func (m Manifest) Write(w io.Writer) error {
 	w.Write([]byte(xml.Header))
@@ -75,6 +177,35 @@ func Read(r io.Reader) (Manifest, error) {
 	return m, err
 }
 
+// ReadStrict parses the encryption XML structure like Read,
+// and returns an error if an EncryptedData item is structurally incomplete, see Validate
+func ReadStrict(r io.Reader) (Manifest, error) {
+	m, err := Read(r)
+	if err != nil {
+		return m, err
+	}
+	if warnings := m.Validate(); len(warnings) > 0 {
+		return m, fmt.Errorf("invalid encryption manifest, %s (%d problems found)", warnings[0], len(warnings))
+	}
+	return m, nil
+}
+
+// Validate checks that every EncryptedData item has an encryption algorithm
+// and a cipher reference (or an inline cipher value), and returns a warning per incomplete item
+func (m Manifest) Validate() []error {
+	var warnings []error
+	for i, datum := range m.Data {
+		uri := datum.CipherData.CipherReference.URI
+		if uri == "" && len(datum.CipherData.Value) == 0 {
+			warnings = append(warnings, fmt.Errorf("EncryptedData item %d has no cipher reference", i))
+		}
+		if datum.Method.Algorithm == "" {
+			warnings = append(warnings, fmt.Errorf("EncryptedData item %d (%s) has no encryption algorithm", i, uri))
+		}
+	}
+	return warnings
+}
+
 //<sequence>
 //<element name="EncryptionMethod" type="xenc:EncryptionMethodType"
 //minOccurs="0"/>
@@ -101,7 +232,48 @@ type CipherReference struct {
 
 type CipherData struct {
 	CipherReference CipherReference `xml:"http://www.w3.org/2001/04/xmlenc# CipherReference"`
-	Value           []byte          `xml:"Value,omitempty"`
+	Value           Base64Binary    `xml:"http://www.w3.org/2001/04/xmlenc# CipherValue,omitempty"`
+}
+
+// MarshalXML encodes the cipher reference and the inline cipher value;
+// the reference is omitted if it is empty and an inline value is present
+func (c CipherData) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	if c.CipherReference.URI != "" || len(c.Value) == 0 {
+		name := xml.Name{Space: "http://www.w3.org/2001/04/xmlenc#", Local: "CipherReference"}
+		if err := e.EncodeElement(c.CipherReference, xml.StartElement{Name: name}); err != nil {
+			return err
+		}
+	}
+	if len(c.Value) > 0 {
+		name := xml.Name{Space: "http://www.w3.org/2001/04/xmlenc#", Local: "CipherValue"}
+		if err := e.EncodeElement(c.Value, xml.StartElement{Name: name}); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// Base64Binary is binary data encoded in base64 in XML documents, like an inline cipher value
+type Base64Binary []byte
+
+// MarshalText encodes the data in base64
+func (b Base64Binary) MarshalText() ([]byte, error) {
+	text := make([]byte, base64.StdEncoding.EncodedLen(len(b)))
+	base64.StdEncoding.Encode(text, b)
+	return text, nil
+}
+
+// UnmarshalText decodes base64 data, ignoring the white spaces used to wrap long values
+func (b *Base64Binary) UnmarshalText(text []byte) error {
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(text)), ""))
+	if err != nil {
+		return err
+	}
+	*b = data
+	return nil
 }
 
 //type DSAKeyValue struct {
@@ -177,3 +349,9 @@ type Data struct {
 	encryptedType
 	Properties *EncryptionProperties `xml:"http://www.w3.org/2001/04/xmlenc# EncryptionProperties,omitempty"`
 }
+
+// HasInlineValue checks if the encrypted data is embedded in the item as a cipher value,
+// rather than referenced by a URI
+func (d Data) HasInlineValue() bool {
+	return len(d.CipherData.Value) > 0
+}
@@ -0,0 +1,281 @@
+// Copyright 2020 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package xmlenc
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+// manifestWithURIs builds a manifest with one EncryptedData item per uri
+func manifestWithURIs(uris ...string) Manifest {
+	var m Manifest
+	for _, uri := range uris {
+		var data Data
+		data.CipherData.CipherReference.URI = URI(uri)
+		m.Data = append(m.Data, data)
+	}
+	return m
+}
+
+// uris returns the cipher references of a manifest
+func uris(m Manifest) []string {
+	var res []string
+	for _, data := range m.Data {
+		res = append(res, string(data.CipherData.CipherReference.URI))
+	}
+	return res
+}
+
+func TestSortByOrder(t *testing.T) {
+	m := manifestWithURIs("OPS/font.otf", "OPS/chapter3.xhtml", "OPS/cover.jpg", "OPS/chapter%201.xhtml", "OPS/chapter2.xhtml")
+
+	spine := []string{"OPS/chapter 1.xhtml", "OPS/chapter2.xhtml", "OPS/chapter3.xhtml"}
+	m.SortByOrder(spine)
+
+	expected := []string{"OPS/chapter%201.xhtml", "OPS/chapter2.xhtml", "OPS/chapter3.xhtml", "OPS/font.otf", "OPS/cover.jpg"}
+	got := uris(m)
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %d items, got %d", len(expected), len(got))
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Expected %s at position %d, got %s", expected[i], i, got[i])
+		}
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	m := manifestWithURIs("OPS/chapter1.xhtml", "OPS/chapter2.xhtml", "OPS/chapter1.xhtml", "OPS/chapter1.xhtml")
+	m.Data[0].Method.Algorithm = "first"
+
+	if removed := m.Dedupe(); removed != 2 {
+		t.Errorf("Expected 2 items to be removed, got %d", removed)
+	}
+	got := uris(m)
+	if len(got) != 2 || got[0] != "OPS/chapter1.xhtml" || got[1] != "OPS/chapter2.xhtml" {
+		t.Errorf("Expected a single item per uri, got %v", got)
+	}
+	if m.Data[0].Method.Algorithm != "first" {
+		t.Error("Expected the first item to be kept")
+	}
+	if removed := m.Dedupe(); removed != 0 {
+		t.Errorf("Expected no item to be removed, got %d", removed)
+	}
+}
+
+func TestAddData(t *testing.T) {
+	var m Manifest
+	data := m.AddData("OPS/chapter 1.xhtml", "http://www.w3.org/2001/04/xmlenc#aes256-cbc", &Compression{Method: 8, OriginalLength: 1234})
+	data.Id = "chapter"
+	m.AddData("OPS/image.png", "http://www.w3.org/2001/04/xmlenc#aes256-cbc", nil)
+
+	if len(m.Data) != 2 || m.Data[0].Id != "chapter" {
+		t.Fatalf("Expected the returned item to be part of the manifest, got %+v", m.Data)
+	}
+	if uri := m.Data[0].CipherData.CipherReference.URI; uri != "OPS/chapter%201.xhtml" {
+		t.Errorf("Expected an escaped uri, got %s", uri)
+	}
+	if m.Data[1].Properties != nil {
+		t.Error("Expected no compression properties")
+	}
+
+	var b bytes.Buffer
+	if err := m.Write(&b); err != nil {
+		t.Fatalf("Could not write the manifest, %s", err)
+	}
+
+	// every element must be in the namespace defined by the OASIS container schema
+	expected := map[string]string{
+		"encryption":           "urn:oasis:names:tc:opendocument:xmlns:container",
+		"EncryptedData":        "http://www.w3.org/2001/04/xmlenc#",
+		"EncryptionMethod":     "http://www.w3.org/2001/04/xmlenc#",
+		"CipherData":           "http://www.w3.org/2001/04/xmlenc#",
+		"CipherReference":      "http://www.w3.org/2001/04/xmlenc#",
+		"EncryptionProperties": "http://www.w3.org/2001/04/xmlenc#",
+		"EncryptionProperty":   "http://www.w3.org/2001/04/xmlenc#",
+		"Compression":          "http://www.idpf.org/2016/encryption#compression",
+	}
+	found := map[string]bool{}
+	decoder := xml.NewDecoder(bytes.NewReader(b.Bytes()))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			found[start.Name.Local] = true
+			if ns, ok := expected[start.Name.Local]; !ok || start.Name.Space != ns {
+				t.Errorf("Expected %s in namespace %s, got %s", start.Name.Local, ns, start.Name.Space)
+			}
+		}
+	}
+	for name := range expected {
+		if !found[name] {
+			t.Errorf("Expected a %s element", name)
+		}
+	}
+
+	read, err := Read(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatalf("Could not read the manifest back, %s", err)
+	}
+	if _, ok := read.DataForFile("OPS/chapter 1.xhtml"); !ok {
+		t.Error("Expected to find the chapter in the manifest read back")
+	}
+}
+
+func TestDataForFileEncoding(t *testing.T) {
+	m := manifestWithURIs("OPS/chapter%201.xhtml", "OPS/image 2.png", "OPS/caf%C3%A9.xhtml", "OPS/naïve.xhtml")
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"OPS/chapter 1.xhtml", "OPS/chapter%201.xhtml"},
+		{"OPS/chapter%201.xhtml", "OPS/chapter%201.xhtml"},
+		{"OPS/image 2.png", "OPS/image 2.png"},
+		{"OPS/image%202.png", "OPS/image 2.png"},
+		{"OPS/café.xhtml", "OPS/caf%C3%A9.xhtml"},
+		{"OPS/na%C3%AFve.xhtml", "OPS/naïve.xhtml"},
+	}
+	for _, test := range tests {
+		data, ok := m.DataForFile(test.path)
+		if !ok {
+			t.Errorf("Expected to find %s", test.path)
+			continue
+		}
+		if uri := string(data.CipherData.CipherReference.URI); uri != test.expected {
+			t.Errorf("Expected %s to resolve to %s, got %s", test.path, test.expected, uri)
+		}
+	}
+
+	if _, ok := m.DataForFile("OPS/chapter2.xhtml"); ok {
+		t.Error("Did not expect to find a missing file")
+	}
+}
+
+func TestReadStrict(t *testing.T) {
+	const header = `<?xml version="1.0" encoding="UTF-8"?>
+<encryption xmlns="urn:oasis:names:tc:opendocument:xmlns:container" xmlns:enc="http://www.w3.org/2001/04/xmlenc#">`
+	const footer = `</encryption>`
+	tests := []struct {
+		name     string
+		data     string
+		warnings int
+	}{
+		{"valid", `<enc:EncryptedData>
+			<enc:EncryptionMethod Algorithm="http://www.w3.org/2001/04/xmlenc#aes256-cbc"/>
+			<enc:CipherData><enc:CipherReference URI="OPS/chapter.xhtml"/></enc:CipherData>
+		</enc:EncryptedData>`, 0},
+		{"missing algorithm", `<enc:EncryptedData>
+			<enc:CipherData><enc:CipherReference URI="OPS/chapter.xhtml"/></enc:CipherData>
+		</enc:EncryptedData>`, 1},
+		{"empty reference", `<enc:EncryptedData>
+			<enc:EncryptionMethod Algorithm="http://www.w3.org/2001/04/xmlenc#aes256-cbc"/>
+			<enc:CipherData><enc:CipherReference URI=""/></enc:CipherData>
+		</enc:EncryptedData>`, 1},
+		{"missing cipher data", `<enc:EncryptedData>
+			<enc:EncryptionMethod Algorithm="http://www.w3.org/2001/04/xmlenc#aes256-cbc"/>
+		</enc:EncryptedData>`, 1},
+		{"empty item", `<enc:EncryptedData/>`, 2},
+	}
+	for _, test := range tests {
+		m, err := Read(strings.NewReader(header + test.data + footer))
+		if err != nil {
+			t.Fatalf("%s: could not read the manifest, %s", test.name, err)
+		}
+		if warnings := m.Validate(); len(warnings) != test.warnings {
+			t.Errorf("%s: expected %d warnings, got %v", test.name, test.warnings, warnings)
+		}
+
+		_, err = ReadStrict(strings.NewReader(header + test.data + footer))
+		if (err == nil) != (test.warnings == 0) {
+			t.Errorf("%s: unexpected strict read result %v", test.name, err)
+		}
+	}
+
+	if _, err := ReadStrict(strings.NewReader(header + "<enc:EncryptedData>")); err == nil {
+		t.Error("Expected a malformed XML document to be rejected")
+	}
+}
+
+func TestInlineCipherValue(t *testing.T) {
+	const encryptionXML = `<?xml version="1.0" encoding="UTF-8"?>
+<encryption xmlns="urn:oasis:names:tc:opendocument:xmlns:container" xmlns:enc="http://www.w3.org/2001/04/xmlenc#">
+	<enc:EncryptedData>
+		<enc:EncryptionMethod Algorithm="http://www.w3.org/2001/04/xmlenc#aes256-cbc"/>
+		<enc:CipherData>
+			<enc:CipherValue>
+				c2VjcmV0IGtleSBtYXRlcmlhbA==
+			</enc:CipherValue>
+		</enc:CipherData>
+	</enc:EncryptedData>
+	<enc:EncryptedData>
+		<enc:EncryptionMethod Algorithm="http://www.w3.org/2001/04/xmlenc#aes256-cbc"/>
+		<enc:CipherData><enc:CipherReference URI="OPS/chapter.xhtml"/></enc:CipherData>
+	</enc:EncryptedData>
+</encryption>`
+
+	m, err := ReadStrict(strings.NewReader(encryptionXML))
+	if err != nil {
+		t.Fatalf("Could not read the manifest, %s", err)
+	}
+	if len(m.Data) != 2 {
+		t.Fatalf("Expected 2 items, got %d", len(m.Data))
+	}
+	if !m.Data[0].HasInlineValue() || string(m.Data[0].CipherData.Value) != "secret key material" {
+		t.Errorf("Expected a decoded inline value, got %q", m.Data[0].CipherData.Value)
+	}
+	if m.Data[1].HasInlineValue() {
+		t.Error("Did not expect an inline value on a cipher reference")
+	}
+
+	var b bytes.Buffer
+	if err = m.Write(&b); err != nil {
+		t.Fatalf("Could not write the manifest, %s", err)
+	}
+	out := b.String()
+	if !strings.Contains(out, "c2VjcmV0IGtleSBtYXRlcmlhbA==") {
+		t.Errorf("Expected the value to be encoded in base64, got %s", out)
+	}
+	if strings.Count(out, "<CipherReference") != 1 {
+		t.Errorf("Expected a single cipher reference, got %s", out)
+	}
+
+	again, err := ReadStrict(&b)
+	if err != nil {
+		t.Fatalf("Could not read the manifest back, %s", err)
+	}
+	if len(again.Data) != 2 || string(again.Data[0].CipherData.Value) != "secret key material" || again.Data[1].CipherData.CipherReference.URI != "OPS/chapter.xhtml" {
+		t.Errorf("Expected the manifest to be unchanged, got %+v", again.Data)
+	}
+}
+
+func TestCompressionProperties(t *testing.T) {
+	properties := CompressionProperties(DeflateMethod, 1234)
+	if properties == nil || len(properties.Properties) != 1 {
+		t.Fatalf("Expected a compression property for a deflated resource, got %v", properties)
+	}
+	if c := properties.Properties[0].Compression; c.Method != DeflateMethod || c.OriginalLength != 1234 {
+		t.Errorf("Expected method 8 and original length 1234, got %+v", c)
+	}
+
+	if properties := CompressionProperties(0, 1234); properties != nil {
+		t.Errorf("Did not expect a compression property for a stored resource, got %v", properties)
+	}
+
+	var m Manifest
+	m.AddData("OPS/image.png", "http://www.w3.org/2001/04/xmlenc#aes256-cbc", &Compression{Method: 0, OriginalLength: 42})
+	var b bytes.Buffer
+	if err := m.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(b.String(), "Compression") {
+		t.Errorf("Did not expect a compression property for a stored resource, got %s", b.String())
+	}
+}